@@ -0,0 +1,166 @@
+// Package aka derives 5G-AKA authentication vectors (TS 33.501 clause 6.1.3.2 and Annex A)
+// from the MILENAGE outputs of a subscriber.
+package aka
+
+import (
+	"backend-webUE/milenage"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"strings"
+)
+
+// FC values of the key derivation function, TS 33.501 Annex A
+const (
+	FcKausf     = 0x6A
+	FcResStar   = 0x6B
+	FcKseaf     = 0x6C
+	FcKamf      = 0x6D
+	FcCkIkPrime = 0x20 // TS 33.402 Annex A.2, used by EAP-AKA'
+)
+
+// KDF is the generic key derivation function of TS 33.220 Annex B.2:
+// HMAC-SHA-256(key, FC || P0 || L0 || P1 || L1 || ...)
+func KDF(key []byte, fc byte, params ...[]byte) []byte {
+	s := []byte{fc}
+	for _, p := range params {
+		length := make([]byte, 2)
+		binary.BigEndian.PutUint16(length, uint16(len(p)))
+		s = append(s, p...)
+		s = append(s, length...)
+	}
+	h := hmac.New(sha256.New, key)
+	h.Write(s)
+	return h.Sum(nil)
+}
+
+// ServingNetworkName builds the serving network name of a PLMN, TS 24.501 clause 9.12.1
+func ServingNetworkName(mcc, mnc string) string {
+	if len(mnc) == 2 {
+		mnc = "0" + mnc
+	}
+	return fmt.Sprintf("5G:mnc%s.mcc%s.3gppnetwork.org", mnc, mcc)
+}
+
+// ValidateServingNetworkName checks the "5G:" prefix of a serving network name
+func ValidateServingNetworkName(snn string) error {
+	if !strings.HasPrefix(snn, "5G:") || len(snn) <= len("5G:") {
+		return fmt.Errorf("serving network name must start with \"5G:\", got %q", snn)
+	}
+	return nil
+}
+
+// RandomRand returns a fresh 128-bit RAND
+func RandomRand() ([]byte, error) {
+	r := make([]byte, milenage.RandLen)
+	if _, err := rand.Read(r); err != nil {
+		return nil, fmt.Errorf("failed to generate RAND: %v", err)
+	}
+	return r, nil
+}
+
+// Av5GAka is a 5G home environment authentication vector together with the anchor keys
+type Av5GAka struct {
+	Rand      []byte
+	Autn      []byte
+	XresStar  []byte
+	HxresStar []byte
+	Kausf     []byte
+	Kseaf     []byte
+}
+
+// Subscriber holds the long term authentication data of a UE
+type Subscriber struct {
+	K   []byte
+	Opc []byte
+	Amf []byte
+}
+
+// sqnXorAk conceals SQN with the anonymity key
+func sqnXorAk(sqn, ak []byte) []byte {
+	out := make([]byte, len(sqn))
+	for i := range sqn {
+		out[i] = sqn[i] ^ ak[i]
+	}
+	return out
+}
+
+// autn computes AUTN = SQN xor AK || AMF || MAC-A together with CK and IK
+func (s *Subscriber) autn(sqn, rand []byte) (autn, res, ck, ik, concealedSqn []byte, err error) {
+	macA, _, err := milenage.F1(s.Opc, s.K, rand, sqn, s.Amf)
+	if err != nil {
+		return nil, nil, nil, nil, nil, err
+	}
+	res, ck, ik, ak, err := milenage.F2345(s.Opc, s.K, rand)
+	if err != nil {
+		return nil, nil, nil, nil, nil, err
+	}
+	concealedSqn = sqnXorAk(sqn, ak)
+	autn = append(append(append([]byte{}, concealedSqn...), s.Amf...), macA...)
+	return autn, res, ck, ik, concealedSqn, nil
+}
+
+// XresStar computes XRES* (RES* on the UE side) from CK, IK, RAND and RES, TS 33.501 Annex A.4
+func XresStar(ck, ik []byte, snn string, rand, res []byte) []byte {
+	key := append(append([]byte{}, ck...), ik...)
+	return KDF(key, FcResStar, []byte(snn), rand, res)[16:]
+}
+
+// HxresStar computes HXRES* as the 128 least significant bits of SHA-256(RAND || XRES*), TS 33.501 Annex A.5
+func HxresStar(rand, xresStar []byte) []byte {
+	sum := sha256.Sum256(append(append([]byte{}, rand...), xresStar...))
+	return sum[16:]
+}
+
+// Generate5GAka computes a 5G-AKA authentication vector for SQN and the serving network name.
+// A random RAND is drawn when rand is nil.
+func (s *Subscriber) Generate5GAka(sqn, rand []byte, snn string) (*Av5GAka, error) {
+	if err := ValidateServingNetworkName(snn); err != nil {
+		return nil, err
+	}
+	if rand == nil {
+		var err error
+		if rand, err = RandomRand(); err != nil {
+			return nil, err
+		}
+	}
+
+	autn, res, ck, ik, concealedSqn, err := s.autn(sqn, rand)
+	if err != nil {
+		return nil, err
+	}
+
+	av := &Av5GAka{
+		Rand: rand,
+		Autn: autn,
+	}
+	av.XresStar = XresStar(ck, ik, snn, rand, res)
+	av.HxresStar = HxresStar(rand, av.XresStar)
+	av.Kausf = KDF(append(append([]byte{}, ck...), ik...), FcKausf, []byte(snn), concealedSqn)
+	av.Kseaf = KDF(av.Kausf, FcKseaf, []byte(snn))
+	return av, nil
+}
+
+// DefaultAbba is the ABBA parameter of the initial registration, TS 33.501 clause A.7.1
+var DefaultAbba = []byte{0x00, 0x00}
+
+// Kamf derives KAMF from KSEAF, TS 33.501 Annex A.7. supi is the IMSI digits of an IMSI-based
+// SUPI or the NAI of a network specific identifier, without the imsi-/nai- prefix.
+func Kamf(kseaf []byte, supi string, abba []byte) []byte {
+	return KDF(kseaf, FcKamf, []byte(supi), abba)
+}
+
+// VerifyResStar recomputes XRES* for RAND and compares it with the RES* returned by the UE
+func (s *Subscriber) VerifyResStar(rand, resStar []byte, snn string) (bool, []byte, error) {
+	if err := ValidateServingNetworkName(snn); err != nil {
+		return false, nil, err
+	}
+	res, ck, ik, _, err := milenage.F2345(s.Opc, s.K, rand)
+	if err != nil {
+		return false, nil, err
+	}
+	xresStar := XresStar(ck, ik, snn, rand, res)
+	return hmac.Equal(xresStar, resStar), xresStar, nil
+}
@@ -0,0 +1,86 @@
+package aka
+
+import (
+	"encoding/hex"
+	"testing"
+)
+
+func unhex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatalf("bad test vector %q: %v", s, err)
+	}
+	return b
+}
+
+// testSubscriber is the subscriber of TS 35.208 test set 1
+func testSubscriber(t *testing.T) *Subscriber {
+	return &Subscriber{
+		K:   unhex(t, "465b5ce8b199b49faa5f0a2ee238a6bc"),
+		Opc: unhex(t, "cd63cb71954a9f4e48a5994e37a02baf"),
+		Amf: unhex(t, "b9b9"),
+	}
+}
+
+const (
+	testRand = "23553cbe9637a89d218ae64dae47bf35"
+	testSqn  = "ff9bb4d0b607"
+	testSnn  = "5G:mnc093.mcc208.3gppnetwork.org"
+)
+
+func TestServingNetworkName(t *testing.T) {
+	if got := ServingNetworkName("208", "93"); got != testSnn {
+		t.Errorf("ServingNetworkName = %q, want %q", got, testSnn)
+	}
+	if got := ServingNetworkName("001", "001"); got != "5G:mnc001.mcc001.3gppnetwork.org" {
+		t.Errorf("ServingNetworkName of a 3 digit MNC = %q", got)
+	}
+	if err := ValidateServingNetworkName("mnc093.mcc208.3gppnetwork.org"); err == nil {
+		t.Errorf("serving network name without 5G: accepted")
+	}
+}
+
+// TestGenerate5GAka checks the TS 33.501 Annex A derivations on the MILENAGE outputs of
+// TS 35.208 test set 1 (RES a54211d5e3ba50bf, AK aa689c648370, CK and IK of the set)
+func TestGenerate5GAka(t *testing.T) {
+	sub := testSubscriber(t)
+	rand := unhex(t, testRand)
+	av, err := sub.Generate5GAka(unhex(t, testSqn), rand, testSnn)
+	if err != nil {
+		t.Fatalf("Generate5GAka: %v", err)
+	}
+
+	kamf := Kamf(av.Kseaf, "208930000000001", DefaultAbba)
+	for _, check := range []struct {
+		name string
+		got  []byte
+		want string
+	}{
+		// SQN xor AK || AMF || MAC-A
+		{"AUTN", av.Autn, "55f328b43577b9b94a9ffac354dfafb3"},
+		// A.4, FC 0x6B over the serving network name, RAND and RES
+		{"XRES*", av.XresStar, "5cc9527f4d21c43bee83a15443acf1c4"},
+		// A.5
+		{"HXRES*", av.HxresStar, "6970075e3c8245fdc2073003cf166279"},
+		// A.2, FC 0x6A over the serving network name and SQN xor AK
+		{"KAUSF", av.Kausf, "f2e35260f85194d4f891504d02111e56689ac23dd393bee3abbcc5bfbc013ef9"},
+		// A.6, FC 0x6C
+		{"KSEAF", av.Kseaf, "cfddde483bd1318a412e98870f556410905be4fb7500abed93ee16af71bbb3fa"},
+		// A.7, FC 0x6D over the IMSI and ABBA 0x0000
+		{"KAMF", kamf, "9d63b519775a92ca861ca6a50d848fa8ebf160ea7b73735a85b33737e73c55b4"},
+	} {
+		if got := hex.EncodeToString(check.got); got != check.want {
+			t.Errorf("%s = %s, want %s", check.name, got, check.want)
+		}
+	}
+
+	ok, xresStar, err := sub.VerifyResStar(rand, av.XresStar, testSnn)
+	if err != nil || !ok || hex.EncodeToString(xresStar) != "5cc9527f4d21c43bee83a15443acf1c4" {
+		t.Errorf("VerifyResStar of XRES* = %v, %x, %v", ok, xresStar, err)
+	}
+	// RES* is bound to the serving network
+	if ok, _, _ := sub.VerifyResStar(rand, av.XresStar, "5G:mnc001.mcc001.3gppnetwork.org"); ok {
+		t.Errorf("RES* verified for another serving network")
+	}
+}
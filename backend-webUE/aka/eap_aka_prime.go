@@ -0,0 +1,110 @@
+package aka
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+)
+
+// Lengths of the EAP-AKA' keys derived from MK, RFC 5448 clause 3.3
+const (
+	kEncrLen = 16
+	kAutLen  = 32
+	kReLen   = 32
+	mskLen   = 64
+	emskLen  = 64
+)
+
+// AvEapAkaPrime is a transformed authentication vector for EAP-AKA' (TS 33.501 clause 6.1.3.1)
+// together with the anchor keys derived from its EMSK
+type AvEapAkaPrime struct {
+	Rand    []byte
+	Autn    []byte
+	Xres    []byte
+	CkPrime []byte
+	IkPrime []byte
+	// AT_KDF_INPUT, the serving network name
+	KdfInput string
+	Msk      []byte
+	Emsk     []byte
+	Kausf    []byte
+	Kseaf    []byte
+}
+
+// CkIkPrime derives CK' and IK' from CK and IK, TS 33.402 Annex A.2 and RFC 5448 clause 3.3
+func CkIkPrime(ck, ik []byte, snn string, concealedSqn []byte) (ckPrime, ikPrime []byte) {
+	key := append(append([]byte{}, ck...), ik...)
+	out := KDF(key, FcCkIkPrime, []byte(snn), concealedSqn)
+	return out[:16], out[16:]
+}
+
+// PRFPrime is the PRF' of RFC 5448 clause 3.4, returning n octets:
+// T1 = HMAC-SHA-256(K, S | 0x01), Tn = HMAC-SHA-256(K, Tn-1 | S | n)
+func PRFPrime(key, s []byte, n int) []byte {
+	var out, t []byte
+	for i := byte(1); len(out) < n; i++ {
+		h := hmac.New(sha256.New, key)
+		h.Write(t)
+		h.Write(s)
+		h.Write([]byte{i})
+		t = h.Sum(nil)
+		out = append(out, t...)
+	}
+	return out[:n]
+}
+
+// eapAkaPrimeKeys are the keys of an EAP-AKA' master key
+type eapAkaPrimeKeys struct {
+	kEncr, kAut, kRe, msk, emsk []byte
+}
+
+// deriveEapAkaPrimeKeys splits MK = PRF'(IK'|CK', "EAP-AKA'"|Identity) into
+// K_encr | K_aut | K_re | MSK | EMSK, RFC 5448 clause 3.3
+func deriveEapAkaPrimeKeys(ckPrime, ikPrime []byte, identity string) eapAkaPrimeKeys {
+	key := append(append([]byte{}, ikPrime...), ckPrime...)
+	mk := PRFPrime(key, []byte("EAP-AKA'"+identity), kEncrLen+kAutLen+kReLen+mskLen+emskLen)
+	var keys eapAkaPrimeKeys
+	for _, k := range []struct {
+		dst *[]byte
+		n   int
+	}{{&keys.kEncr, kEncrLen}, {&keys.kAut, kAutLen}, {&keys.kRe, kReLen}, {&keys.msk, mskLen}, {&keys.emsk, emskLen}} {
+		*k.dst, mk = mk[:k.n], mk[k.n:]
+	}
+	return keys
+}
+
+// GenerateEapAkaPrime computes an EAP-AKA' authentication vector for SQN, the serving network name
+// and the peer identity used in the MK derivation. A random RAND is drawn when rand is nil.
+func (s *Subscriber) GenerateEapAkaPrime(sqn, rand []byte, snn, identity string) (*AvEapAkaPrime, error) {
+	if err := ValidateServingNetworkName(snn); err != nil {
+		return nil, err
+	}
+	if rand == nil {
+		var err error
+		if rand, err = RandomRand(); err != nil {
+			return nil, err
+		}
+	}
+
+	autn, res, ck, ik, concealedSqn, err := s.autn(sqn, rand)
+	if err != nil {
+		return nil, err
+	}
+	ckPrime, ikPrime := CkIkPrime(ck, ik, snn, concealedSqn)
+
+	keys := deriveEapAkaPrimeKeys(ckPrime, ikPrime, identity)
+
+	av := &AvEapAkaPrime{
+		Rand:     rand,
+		Autn:     autn,
+		Xres:     res,
+		CkPrime:  ckPrime,
+		IkPrime:  ikPrime,
+		KdfInput: snn,
+		Msk:      keys.msk,
+		Emsk:     keys.emsk,
+	}
+	// KAUSF is the most significant 256 bits of EMSK, TS 33.501 clause 6.1.3.1
+	av.Kausf = keys.emsk[:32]
+	av.Kseaf = KDF(av.Kausf, FcKseaf, []byte(snn))
+	return av, nil
+}
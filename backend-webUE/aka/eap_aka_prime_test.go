@@ -0,0 +1,59 @@
+package aka
+
+import (
+	"encoding/hex"
+	"testing"
+)
+
+// TestEapAkaPrimeKeys checks test case 1 of RFC 5448 Appendix C (RFC 9048 Appendix C)
+func TestEapAkaPrimeKeys(t *testing.T) {
+	ck := unhex(t, "5349fbe098649f948f5d2e973a81c00f")
+	ik := unhex(t, "9744871ad32bf9bbd1dd5ce54e3e2e5a")
+	autn := unhex(t, "bb52e91c747ac3ab2a5c23d15ee351d5")
+
+	// The access network name of the test case stands in for the serving network name
+	ckPrime, ikPrime := CkIkPrime(ck, ik, "WLAN", autn[:6])
+	keys := deriveEapAkaPrimeKeys(ckPrime, ikPrime, "0555444333222111")
+
+	for _, check := range []struct {
+		name string
+		got  []byte
+		want string
+	}{
+		{"CK'", ckPrime, "0093962d0dd84aa5684b045c9edffa04"},
+		{"IK'", ikPrime, "ccfc230ca74fcc96c0a5d61164f5a76c"},
+		{"K_encr", keys.kEncr, "766fa0a6c317174b812d52fbcd11a179"},
+		{"K_aut", keys.kAut, "0842ea722ff6835bfa2032499fc3ec23c2f0e388b4f07543ffc677f1696d71ea"},
+		{"K_re", keys.kRe, "cf83aa8bc7e0aced892acc98e76a9b2095b558c7795c7094715cb3393aa7d17a"},
+		{"MSK", keys.msk, "67c42d9aa56c1b79e295e3459fc3d187d42be0bf818d3070e362c5e967a4d544" +
+			"e8ecfe19358ab3039aff03b7c930588c055babee58a02650b067ec4e9347c75a"},
+		{"EMSK", keys.emsk, "f861703cd775590e16c7679ea3874ada866311de290764d760cf76df647ea01c" +
+			"313f69924bdd7650ca9bac141ea075c4ef9e8029c0e290cdbad5638b63bc23fb"},
+	} {
+		if got := hex.EncodeToString(check.got); got != check.want {
+			t.Errorf("%s = %s, want %s", check.name, got, check.want)
+		}
+	}
+}
+
+func TestGenerateEapAkaPrime(t *testing.T) {
+	sub := testSubscriber(t)
+	rand := unhex(t, testRand)
+	av, err := sub.GenerateEapAkaPrime(unhex(t, testSqn), rand, testSnn, "0208930000000001@nai.5gc.mnc093.mcc208.3gppnetwork.org")
+	if err != nil {
+		t.Fatalf("GenerateEapAkaPrime: %v", err)
+	}
+	// The challenge is the one of 5G-AKA, only the keys are transformed
+	if got := hex.EncodeToString(av.Autn); got != "55f328b43577b9b94a9ffac354dfafb3" {
+		t.Errorf("AUTN = %s", got)
+	}
+	if got := hex.EncodeToString(av.Xres); got != "a54211d5e3ba50bf" {
+		t.Errorf("XRES = %s", got)
+	}
+	if av.KdfInput != testSnn || len(av.Msk) != 64 || len(av.Emsk) != 64 {
+		t.Errorf("AT_KDF_INPUT %q, MSK %d and EMSK %d octets", av.KdfInput, len(av.Msk), len(av.Emsk))
+	}
+	if hex.EncodeToString(av.Kausf) != hex.EncodeToString(av.Emsk[:32]) {
+		t.Errorf("KAUSF is not the first 256 bits of EMSK")
+	}
+}
@@ -0,0 +1,70 @@
+package aka
+
+import (
+	"backend-webUE/milenage"
+	"crypto/hmac"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// InitialSqn is the SQN stored for a freshly generated subscription
+const InitialSqn = "000000000000"
+
+// sqnModulus bounds the 48-bit SQN, which wraps around when advanced past its maximum
+const sqnModulus = uint64(1) << (8 * milenage.SqnLen)
+
+// AutsLen is the length of AUTS = SQN_MS xor AK* || MAC-S, TS 33.102 clause 6.3.3
+const AutsLen = milenage.SqnLen + milenage.MacLen
+
+// ErrMacSMismatch is returned when the MAC-S of an AUTS does not verify
+var ErrMacSMismatch = errors.New("AUTS MAC-S mismatch")
+
+// resyncAmf is the dummy AMF used to compute MAC-S, TS 33.102 clause 6.3.3
+var resyncAmf = []byte{0x00, 0x00}
+
+// SqnToUint64 converts a 6 octet SQN to an integer
+func SqnToUint64(sqn []byte) uint64 {
+	var b [8]byte
+	copy(b[8-milenage.SqnLen:], sqn)
+	return binary.BigEndian.Uint64(b[:])
+}
+
+// SqnFromUint64 converts an integer to a 6 octet SQN, modulo 2^48
+func SqnFromUint64(n uint64) []byte {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], n%sqnModulus)
+	return b[8-milenage.SqnLen:]
+}
+
+// AdvanceSqn adds delta to SQN, wrapping around at 2^48.
+//
+// SQN is handled as a flat counter, the way the free5GC UDM steps it, rather than as SEQ || IND
+// with the SEQ step of TS 33.102 Annex C.1.1. A step of 1 still increases SEQ in every IND slot,
+// so UEs verifying with or without an IND array accept it. A core stepping SEQ/IND (Open5GS adds
+// 32 with a 5-bit IND) is mirrored by advancing with that step as delta.
+func AdvanceSqn(sqn []byte, delta uint64) []byte {
+	return SqnFromUint64(SqnToUint64(sqn) + delta%sqnModulus)
+}
+
+// Resync recovers SQN_MS from the AUTS a UE sent in a synchronisation failure for RAND
+// and verifies its MAC-S (f1* with AMF 0000), TS 33.102 clause 6.3.5
+func (s *Subscriber) Resync(rand, auts []byte) ([]byte, error) {
+	if len(auts) != AutsLen {
+		return nil, fmt.Errorf("AUTS must be %d octets, got %d", AutsLen, len(auts))
+	}
+	akStar, err := milenage.F5Star(s.Opc, s.K, rand)
+	if err != nil {
+		return nil, err
+	}
+	sqnMs := sqnXorAk(auts[:milenage.SqnLen], akStar)
+
+	_, macS, err := milenage.F1(s.Opc, s.K, rand, sqnMs, resyncAmf)
+	if err != nil {
+		return nil, err
+	}
+	if !hmac.Equal(macS, auts[milenage.SqnLen:]) {
+		return nil, ErrMacSMismatch
+	}
+	return sqnMs, nil
+}
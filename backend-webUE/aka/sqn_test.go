@@ -0,0 +1,75 @@
+package aka
+
+import (
+	"backend-webUE/milenage"
+	"encoding/hex"
+	"errors"
+	"testing"
+)
+
+// testAuts computes the AUTS a UE sends for SQN_MS, TS 33.102 clause 6.3.3
+func testAuts(t *testing.T, sub *Subscriber, rand, sqnMs []byte) []byte {
+	t.Helper()
+	akStar, err := milenage.F5Star(sub.Opc, sub.K, rand)
+	if err != nil {
+		t.Fatalf("F5Star: %v", err)
+	}
+	_, macS, err := milenage.F1(sub.Opc, sub.K, rand, sqnMs, []byte{0x00, 0x00})
+	if err != nil {
+		t.Fatalf("F1: %v", err)
+	}
+	return append(sqnXorAk(sqnMs, akStar), macS...)
+}
+
+func TestResync(t *testing.T) {
+	sub := testSubscriber(t)
+	rand := unhex(t, testRand)
+	sqnMs := unhex(t, "000000001234")
+	auts := testAuts(t, sub, rand, sqnMs)
+	// SQN_MS is concealed with AK* 451e8beca43b of TS 35.208 test set 1
+	if got := hex.EncodeToString(auts[:milenage.SqnLen]); got != "451e8becb60f" {
+		t.Fatalf("concealed SQN_MS = %s", got)
+	}
+
+	got, err := sub.Resync(rand, auts)
+	if err != nil || hex.EncodeToString(got) != "000000001234" {
+		t.Errorf("Resync = %x, %v, want 000000001234", got, err)
+	}
+
+	bad := append([]byte(nil), auts...)
+	bad[len(bad)-1] ^= 0x01
+	if _, err := sub.Resync(rand, bad); !errors.Is(err, ErrMacSMismatch) {
+		t.Errorf("Resync with a bad MAC-S = %v, want ErrMacSMismatch", err)
+	}
+	// An AUTS of another challenge does not verify either
+	if _, err := sub.Resync(unhex(t, "c00d603103dcee52c4478119494202e8"), auts); !errors.Is(err, ErrMacSMismatch) {
+		t.Errorf("Resync for another RAND = %v, want ErrMacSMismatch", err)
+	}
+	if _, err := sub.Resync(rand, auts[:AutsLen-1]); err == nil {
+		t.Errorf("Resync accepted a short AUTS")
+	}
+}
+
+func TestAdvanceSqn(t *testing.T) {
+	tests := []struct {
+		sqn   string
+		delta uint64
+		want  string
+	}{
+		{"000000000000", 1, "000000000001"},
+		{"0000000000ff", 1, "000000000100"},
+		{"000000000000", 32, "000000000020"},
+		// SQN wraps around at 48 bits
+		{"ffffffffffff", 1, "000000000000"},
+		{"fffffffffffe", 5, "000000000003"},
+		{"000000000001", 1 << 48, "000000000001"},
+	}
+	for _, tt := range tests {
+		if got := hex.EncodeToString(AdvanceSqn(unhex(t, tt.sqn), tt.delta)); got != tt.want {
+			t.Errorf("AdvanceSqn(%s, %d) = %s, want %s", tt.sqn, tt.delta, got, tt.want)
+		}
+	}
+	if got := SqnToUint64(SqnFromUint64(1<<48 + 7)); got != 7 {
+		t.Errorf("SqnFromUint64(2^48 + 7) = %d, want 7", got)
+	}
+}
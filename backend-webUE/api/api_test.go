@@ -0,0 +1,609 @@
+package api_test
+
+import (
+	"backend-webUE/api"
+	"backend-webUE/config"
+	"backend-webUE/models"
+	"backend-webUE/router"
+	"backend-webUE/services"
+	"backend-webUE/storage"
+	"backend-webUE/utils"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const testJwtSecret = "test-secret"
+
+// testServer serves the full router over an in-memory store without provisioning targets
+type testServer struct {
+	t      *testing.T
+	router *gin.Engine
+}
+
+func newTestServer(t *testing.T) *testServer {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	operatorConfig, err := utils.LoadOperatorConfig("../config/ue-gen.json")
+	if err != nil {
+		t.Fatalf("LoadOperatorConfig: %v", err)
+	}
+
+	store := storage.NewMemoryStore()
+	provisioners := services.NewProvisionerRegistry()
+	operatorService := services.NewOperatorService(store.Operators, utils.NewOperator(operatorConfig))
+	ueProfileService := services.NewUeProfileService(store.UeProfiles, store.Msins, operatorService, provisioners)
+	userService := services.NewUserService(store.Users, store.Tokens)
+	exportService := services.NewExportService(ueProfileService)
+	jobService := services.NewJobService(store.Jobs, ueProfileService, 1, 2)
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	if err := jobService.Start(ctx); err != nil {
+		t.Fatalf("Start job service: %v", err)
+	}
+
+	r := router.SetupRouter(
+		api.NewUeProfileAPI(ueProfileService),
+		api.NewOperatorAPI(operatorService),
+		api.NewSuciAPI(services.NewSuciService(operatorService)),
+		api.NewAuthAPI(services.NewAuthService(ueProfileService)),
+		api.NewExportAPI(exportService),
+		api.NewImportAPI(services.NewCoreImportService(ueProfileService, operatorService), services.NewBulkImportService(ueProfileService, operatorService)),
+		api.NewJobAPI(jobService, exportService),
+		api.NewUserAPI(userService, testJwtSecret),
+		userService,
+		config.ServerConfig{},
+		testJwtSecret,
+	)
+	return &testServer{t: t, router: r}
+}
+
+// do sends a request with a JSON body, unless body is nil, and decodes a JSON response into out
+func (s *testServer) do(method, path, token string, body, out interface{}) int {
+	s.t.Helper()
+	w := s.send(method, path, token, body)
+	if out != nil {
+		if err := json.Unmarshal(w.Body.Bytes(), out); err != nil {
+			s.t.Fatalf("%s %s: decode %q: %v", method, path, w.Body.String(), err)
+		}
+	}
+	return w.Code
+}
+
+// send sends a request with a JSON body, unless body is nil, and returns the recorded response
+func (s *testServer) send(method, path, token string, body interface{}) *httptest.ResponseRecorder {
+	s.t.Helper()
+	var reader *bytes.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			s.t.Fatalf("marshal request: %v", err)
+		}
+		reader = bytes.NewReader(data)
+	} else {
+		reader = bytes.NewReader(nil)
+	}
+	req := httptest.NewRequest(method, path, reader)
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	w := httptest.NewRecorder()
+	s.router.ServeHTTP(w, req)
+	return w
+}
+
+// login registers a user and returns a token of the user
+func (s *testServer) login(username string) string {
+	s.t.Helper()
+	credentials := gin.H{"username": username, "password": "secret"}
+	if code := s.do(http.MethodPost, "/register", "", credentials, nil); code != http.StatusCreated {
+		s.t.Fatalf("register %s = %d", username, code)
+	}
+	var resp struct {
+		Token string `json:"token"`
+	}
+	if code := s.do(http.MethodPost, "/login", "", credentials, &resp); code != http.StatusOK || resp.Token == "" {
+		s.t.Fatalf("login %s = %d", username, code)
+	}
+	return resp.Token
+}
+
+func TestUserLifecycle(t *testing.T) {
+	s := newTestServer(t)
+	token := s.login("alice")
+
+	var errResp struct {
+		Error string `json:"error"`
+	}
+	if code := s.do(http.MethodPost, "/register", "", gin.H{"username": "alice", "password": "other"}, &errResp); code != http.StatusConflict {
+		t.Errorf("register taken username = %d, want 409", code)
+	}
+	if code := s.do(http.MethodPost, "/login", "", gin.H{"username": "alice", "password": "wrong"}, nil); code != http.StatusUnauthorized {
+		t.Errorf("login with wrong password = %d, want 401", code)
+	}
+
+	if code := s.do(http.MethodGet, "/ue_profiles", "", nil, nil); code != http.StatusUnauthorized {
+		t.Errorf("request without token = %d, want 401", code)
+	}
+	if code := s.do(http.MethodGet, "/ue_profiles", token, nil, nil); code != http.StatusOK {
+		t.Errorf("request with token = %d, want 200", code)
+	}
+	if code := s.do(http.MethodPost, "/logout", token, nil, nil); code != http.StatusOK {
+		t.Errorf("logout = %d, want 200", code)
+	}
+	if code := s.do(http.MethodGet, "/ue_profiles", token, nil, &errResp); code != http.StatusUnauthorized {
+		t.Errorf("request with revoked token = %d, want 401", code)
+	}
+}
+
+func TestUeProfileLifecycle(t *testing.T) {
+	s := newTestServer(t)
+	token := s.login("alice")
+
+	var generated struct {
+		UeProfiles []models.UeProfile `json:"ue_profiles"`
+	}
+	code := s.do(http.MethodPost, "/ue_profiles/generate", token, gin.H{"num_ues": 2, "scheme": "null"}, &generated)
+	if code != http.StatusCreated || len(generated.UeProfiles) != 2 {
+		t.Fatalf("generate = %d with %d profiles", code, len(generated.UeProfiles))
+	}
+	supi := generated.UeProfiles[0].Supi
+	if supi != "imsi-208930000000001" {
+		t.Errorf("first generated SUPI = %s", supi)
+	}
+
+	var list []models.UeProfile
+	if code := s.do(http.MethodGet, "/ue_profiles", token, nil, &list); code != http.StatusOK || len(list) != 2 {
+		t.Errorf("list = %d with %d profiles, want 2", code, len(list))
+	}
+	var ue models.UeProfile
+	if code := s.do(http.MethodGet, "/ue_profiles/"+supi, token, nil, &ue); code != http.StatusOK || ue.Supi != supi {
+		t.Errorf("get = %d with SUPI %s", code, ue.Supi)
+	}
+
+	// Profiles are private to their user
+	other := s.login("bob")
+	if code := s.do(http.MethodGet, "/ue_profiles/"+supi, other, nil, nil); code != http.StatusNotFound {
+		t.Errorf("get profile of another user = %d, want 404", code)
+	}
+
+	var duplicate struct {
+		Error string   `json:"error"`
+		Supis []string `json:"supis"`
+	}
+	code = s.do(http.MethodPost, "/ue_profiles", token, []models.UeProfile{ue}, &duplicate)
+	if code != http.StatusConflict || len(duplicate.Supis) != 1 || duplicate.Supis[0] != supi {
+		t.Errorf("create duplicate = %d %+v, want 409 listing %s", code, duplicate, supi)
+	}
+
+	code = s.do(http.MethodPost, "/ue_profiles/generate", token, gin.H{"num_ues": 1, "scheme": "null", "msin_start": 2}, nil)
+	if code != http.StatusConflict {
+		t.Errorf("generate into allocated range = %d, want 409", code)
+	}
+
+	if code := s.do(http.MethodPut, "/ue_profiles/"+supi, token, gin.H{"imei": "490154203237518"}, nil); code != http.StatusOK {
+		t.Errorf("update = %d, want 200", code)
+	}
+	s.do(http.MethodGet, "/ue_profiles/"+supi, token, nil, &ue)
+	if ue.Imei != "490154203237518" {
+		t.Errorf("updated IMEI = %q", ue.Imei)
+	}
+	if code := s.do(http.MethodPut, "/ue_profiles/"+supi, token, gin.H{"suci": "suci-0-208-93-0-0-0-1"}, nil); code != http.StatusBadRequest {
+		t.Errorf("update of the SUCI = %d, want 400", code)
+	}
+	if code := s.do(http.MethodPut, "/ue_profiles/"+supi, token, gin.H{"routingIndicator": "12345"}, nil); code != http.StatusBadRequest {
+		t.Errorf("update to a 5 digit routing indicator = %d, want 400", code)
+	}
+
+	if code := s.do(http.MethodDelete, "/ue_profiles/"+supi, token, nil, nil); code != http.StatusOK {
+		t.Errorf("delete = %d, want 200", code)
+	}
+	if code := s.do(http.MethodGet, "/ue_profiles/"+supi, token, nil, nil); code != http.StatusNotFound {
+		t.Errorf("get deleted profile = %d, want 404", code)
+	}
+}
+
+func TestUeProfileListPages(t *testing.T) {
+	s := newTestServer(t)
+	token := s.login("alice")
+
+	if code := s.do(http.MethodPost, "/ue_profiles/generate", token, gin.H{"num_ues": 5, "scheme": "null"}, nil); code != http.StatusCreated {
+		t.Fatalf("generate = %d", code)
+	}
+	code := s.do(http.MethodPost, "/ue_profiles/generate", token, gin.H{"num_ues": 2, "scheme": "A", "tags": []string{"lab"}}, nil)
+	if code != http.StatusCreated {
+		t.Fatalf("generate tagged = %d", code)
+	}
+
+	// Follow the cursors in descending SUPI order
+	var supis []string
+	path := "/ue_profiles?limit=3&sort=-supi"
+	for pages := 0; path != ""; pages++ {
+		if pages > 3 {
+			t.Fatalf("cursor does not advance")
+		}
+		w := s.send(http.MethodGet, path, token, nil)
+		var page []models.UeProfile
+		if err := json.Unmarshal(w.Body.Bytes(), &page); w.Code != http.StatusOK || err != nil {
+			t.Fatalf("GET %s = %d %s", path, w.Code, w.Body.String())
+		}
+		if total := w.Header().Get("X-Total-Count"); total != "7" {
+			t.Errorf("X-Total-Count = %q, want 7", total)
+		}
+		for _, ue := range page {
+			supis = append(supis, ue.Supi)
+		}
+		path = ""
+		if cursor := w.Header().Get("X-Next-Cursor"); cursor != "" {
+			path = "/ue_profiles?limit=3&sort=-supi&cursor=" + url.QueryEscape(cursor)
+		}
+	}
+	if len(supis) != 7 || supis[0] != "imsi-208930000000007" || supis[6] != "imsi-208930000000001" {
+		t.Errorf("descending SUPIs = %v", supis)
+	}
+
+	var tagged []models.UeProfile
+	w := s.send(http.MethodGet, "/ue_profiles?tag=lab&protection_scheme=A&sd=0x010203", token, nil)
+	if err := json.Unmarshal(w.Body.Bytes(), &tagged); err != nil || len(tagged) != 2 || w.Header().Get("X-Total-Count") != "2" {
+		t.Errorf("tagged list = %d %s", w.Code, w.Body.String())
+	}
+	if w.Header().Get("X-Next-Cursor") != "" {
+		t.Errorf("last page has a next cursor")
+	}
+
+	for _, query := range []string{"limit=0", "sort=imei", "sst=x", "protection_scheme=C", "created_after=yesterday", "cursor=bogus"} {
+		if code := s.do(http.MethodGet, "/ue_profiles?"+query, token, nil, nil); code != http.StatusBadRequest {
+			t.Errorf("list with %s = %d, want 400", query, code)
+		}
+	}
+}
+
+func TestStreamedExports(t *testing.T) {
+	s := newTestServer(t)
+	token := s.login("alice")
+	code := s.do(http.MethodPost, "/ue_profiles/generate", token, gin.H{"num_ues": 3, "scheme": "A", "op_type": "OP"}, nil)
+	if code != http.StatusCreated {
+		t.Fatalf("generate = %d", code)
+	}
+
+	// Compressed NDJSON, flushed after every record
+	w := s.send(http.MethodPost, "/ue_profiles/export/ndjson", token, gin.H{"gzip": true, "flush_every": 1})
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/gzip" || !w.Flushed {
+		t.Fatalf("NDJSON export = %d %q, flushed %v", w.Code, w.Header().Get("Content-Type"), w.Flushed)
+	}
+	if disposition := w.Header().Get("Content-Disposition"); !strings.Contains(disposition, "ue_profiles-profile.ndjson.gz") {
+		t.Errorf("Content-Disposition = %q", disposition)
+	}
+	gz, err := gzip.NewReader(w.Body)
+	if err != nil {
+		t.Fatalf("gzip: %v", err)
+	}
+	ndjson, err := io.ReadAll(gz)
+	if err != nil {
+		t.Fatalf("gunzip: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(ndjson)), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("NDJSON export has %d lines, want 3", len(lines))
+	}
+	var ue models.UeProfile
+	if err := json.Unmarshal([]byte(lines[2]), &ue); err != nil || ue.Supi != "imsi-208930000000003" {
+		t.Errorf("last NDJSON line = %s (%v)", lines[2], err)
+	}
+
+	w = s.send(http.MethodPost, "/ue_profiles/export/ndjson", token, gin.H{"record": "open5gs", "supis": []string{"imsi-208930000000002"}})
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"imsi":"208930000000002"`) || strings.Count(w.Body.String(), "\n") != 1 {
+		t.Errorf("Open5GS NDJSON export = %d %s", w.Code, w.Body.String())
+	}
+	if code := s.do(http.MethodPost, "/ue_profiles/export/ndjson", token, gin.H{"record": "yaml"}, nil); code != http.StatusBadRequest {
+		t.Errorf("NDJSON export of unknown record = %d, want 400", code)
+	}
+	if code := s.do(http.MethodPost, "/ue_profiles/export/csv", token, gin.H{"supis": []string{"imsi-208930000000009"}}, nil); code != http.StatusNotFound {
+		t.Errorf("CSV export of a missing SUPI = %d, want 404", code)
+	}
+
+	w = s.send(http.MethodPost, "/ue_profiles/export/csv", token, nil)
+	csvExport := w.Body.String()
+	if w.Code != http.StatusOK || strings.Count(csvExport, "\n") != 4 || !strings.HasPrefix(csvExport, "supi,key,opc,op,") {
+		t.Fatalf("CSV export = %d %s", w.Code, csvExport)
+	}
+
+	// Both exports import back into another account
+	other := s.login("bob")
+	for _, export := range []struct{ contentType, body string }{{"text/csv", csvExport}, {"application/x-ndjson", string(ndjson)}} {
+		req := httptest.NewRequest(http.MethodPost, "/ue_profiles/import?dry_run=true", strings.NewReader(export.body))
+		req.Header.Set("Content-Type", export.contentType)
+		req.Header.Set("Authorization", "Bearer "+other)
+		w := httptest.NewRecorder()
+		s.router.ServeHTTP(w, req)
+		var report services.BulkImportReport
+		if err := json.Unmarshal(w.Body.Bytes(), &report); err != nil || report.Accepted != 3 || report.Rejected != 0 {
+			t.Errorf("import of %s export = %d %s", export.contentType, w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestGenerateJob(t *testing.T) {
+	s := newTestServer(t)
+	token := s.login("alice")
+
+	var job models.Job
+	if code := s.do(http.MethodPost, "/jobs/generate", token, gin.H{"num_ues": 5, "scheme": "null", "tags": []string{"lab"}}, &job); code != http.StatusAccepted {
+		t.Fatalf("submit job = %d", code)
+	}
+	if code := s.do(http.MethodPost, "/jobs/generate", token, gin.H{"num_ues": 0}, nil); code != http.StatusBadRequest {
+		t.Errorf("submit job of 0 UEs = %d, want 400", code)
+	}
+
+	path := "/jobs/" + job.ID.Hex()
+	deadline := time.Now().Add(5 * time.Second)
+	for !job.Finished() && time.Now().Before(deadline) {
+		if code := s.do(http.MethodGet, path, token, nil, &job); code != http.StatusOK {
+			t.Fatalf("get job = %d", code)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if job.Status != models.JobSucceeded || job.Done != 5 {
+		t.Fatalf("job = %+v", job)
+	}
+
+	var jobs []models.Job
+	if code := s.do(http.MethodGet, "/jobs", token, nil, &jobs); code != http.StatusOK || len(jobs) != 1 {
+		t.Errorf("list jobs = %d, %d jobs", code, len(jobs))
+	}
+	if code := s.do(http.MethodPost, path+"/cancel", token, nil, nil); code != http.StatusConflict {
+		t.Errorf("cancel finished job = %d, want 409", code)
+	}
+	if code := s.do(http.MethodGet, path, s.login("bob"), nil, nil); code != http.StatusNotFound {
+		t.Errorf("get job of another user = %d, want 404", code)
+	}
+
+	w := s.send(http.MethodGet, path+"/export", token, nil)
+	if lines := strings.Count(w.Body.String(), "\n"); w.Code != http.StatusOK || lines != 5 {
+		t.Errorf("NDJSON export of job = %d, %d lines", w.Code, lines)
+	}
+	w = s.send(http.MethodGet, path+"/export?format=csv", token, nil)
+	if lines := strings.Count(w.Body.String(), "\n"); w.Code != http.StatusOK || lines != 6 {
+		t.Errorf("CSV export of job = %d, %d lines", w.Code, lines)
+	}
+	if code := s.do(http.MethodGet, path+"/export?format=yaml", token, nil, nil); code != http.StatusBadRequest {
+		t.Errorf("export of job as yaml = %d, want 400", code)
+	}
+}
+
+// labOperator returns a valid operator definition of PLMN 001-01
+func labOperator() gin.H {
+	return gin.H{
+		"name":            "lab",
+		"plmnid":          gin.H{"mcc": "001", "mnc": "01"},
+		"amf":             "8000",
+		"op":              "63bfa50ee6523365ff14c1f45f88737d",
+		"configuredNssai": []gin.H{{"sst": 1}},
+		"defaultNssai":    []gin.H{{"sst": 1}},
+		"profiles": []gin.H{{
+			"scheme":     2,
+			"privateKey": "F1AB1074477EBCC7F554EA1C5FC368B1616730155E0041AC447D6301975FECDA",
+			"publicKey":  "0272DA71976234CE833A6907425867B82E074D44EF907DFB4B3E21C1C2256EBCD1",
+		}},
+		"sessions":         []gin.H{{"type": "IPv4", "apn": "internet", "slice": gin.H{"sst": 1}}},
+		"gnbSearchList":    []string{"10.0.0.2"},
+		"integrity":        gin.H{"IA2": true},
+		"ciphering":        gin.H{"EA2": true},
+		"integrityMaxRate": gin.H{"uplink": "full", "downlink": "full"},
+	}
+}
+
+func TestCreateOperatorValidatesHnKeys(t *testing.T) {
+	s := newTestServer(t)
+	token := s.login("alice")
+
+	operator := labOperator()
+	if code := s.do(http.MethodPost, "/operators", token, operator, nil); code != http.StatusCreated {
+		t.Fatalf("create operator = %d", code)
+	}
+
+	// A Profile B private key beyond the curve order is rejected, not dereferenced
+	operator["profiles"] = []gin.H{{
+		"scheme":     2,
+		"privateKey": strings.Repeat("ff", 32),
+		"publicKey":  "0272DA71976234CE833A6907425867B82E074D44EF907DFB4B3E21C1C2256EBCD1",
+	}}
+	var resp struct {
+		Details []string `json:"details"`
+	}
+	if code := s.do(http.MethodPost, "/operators", token, operator, &resp); code != http.StatusBadRequest {
+		t.Fatalf("create operator with out of range key = %d, want 400", code)
+	}
+	if len(resp.Details) != 1 || !strings.HasPrefix(resp.Details[0], "profiles[0].privateKey: ") {
+		t.Errorf("validation errors = %q, want one on profiles[0].privateKey", resp.Details)
+	}
+}
+
+func TestOperatorRoutingIndicator(t *testing.T) {
+	s := newTestServer(t)
+	token := s.login("alice")
+
+	operator := labOperator()
+	operator["routingIndicator"] = "12345"
+	var resp struct {
+		Details []string `json:"details"`
+	}
+	if code := s.do(http.MethodPost, "/operators", token, operator, &resp); code != http.StatusBadRequest {
+		t.Fatalf("create operator with 5 digit routing indicator = %d, want 400", code)
+	}
+	if len(resp.Details) != 1 || !strings.HasPrefix(resp.Details[0], "routingIndicator: ") {
+		t.Errorf("validation errors = %q, want one on routingIndicator", resp.Details)
+	}
+
+	operator["routingIndicator"] = "12"
+	var created models.Operator
+	if code := s.do(http.MethodPost, "/operators", token, operator, &created); code != http.StatusCreated {
+		t.Fatalf("create operator = %d", code)
+	}
+	var generated struct {
+		UeProfiles []models.UeProfile `json:"ue_profiles"`
+	}
+	req := gin.H{"num_ues": 1, "scheme": "null", "operator_id": created.ID.Hex()}
+	if code := s.do(http.MethodPost, "/ue_profiles/generate", token, req, &generated); code != http.StatusCreated || len(generated.UeProfiles) != 1 {
+		t.Fatalf("generate = %d with %d profiles", code, len(generated.UeProfiles))
+	}
+	ue := generated.UeProfiles[0]
+	if ue.RoutingIndicator != "12" || !strings.HasPrefix(ue.Suci, "suci-0-001-01-12-0-0-") {
+		t.Errorf("generated routing indicator %q and SUCI %s, want 12", ue.RoutingIndicator, ue.Suci)
+	}
+
+	// The default operator keeps the default routing indicator
+	code := s.do(http.MethodPost, "/ue_profiles/generate", token, gin.H{"num_ues": 1, "scheme": "null"}, &generated)
+	if code != http.StatusCreated || generated.UeProfiles[0].RoutingIndicator != utils.DefaultRoutingIndicator {
+		t.Errorf("generate with the default operator = %d with %+v", code, generated.UeProfiles)
+	}
+}
+
+func TestAuthRoutes(t *testing.T) {
+	s := newTestServer(t)
+	token := s.login("alice")
+	if code := s.do(http.MethodPost, "/ue_profiles/generate", token, gin.H{"num_ues": 1, "scheme": "null"}, nil); code != http.StatusCreated {
+		t.Fatalf("generate = %d", code)
+	}
+	supi := "/ue_profiles/imsi-208930000000001"
+	unknown := "/ue_profiles/imsi-208930000000009"
+	rand := "23553cbe9637a89d218ae64dae47bf35"
+
+	tests := []struct {
+		method string
+		path   string
+		body   interface{}
+		want   int
+	}{
+		{http.MethodPost, supi + "/auth/5g-aka", gin.H{"rand": rand}, http.StatusOK},
+		{http.MethodPost, supi + "/auth/5g-aka", gin.H{"sqn": "00"}, http.StatusBadRequest},
+		{http.MethodPost, supi + "/auth/5g-aka", gin.H{"serving_network_name": "mnc093.mcc208.3gppnetwork.org"}, http.StatusBadRequest},
+		{http.MethodPost, unknown + "/auth/5g-aka", nil, http.StatusNotFound},
+		{http.MethodPost, supi + "/auth/5g-aka/verify", gin.H{"rand": rand, "res_star": strings.Repeat("00", 16)}, http.StatusOK},
+		{http.MethodPost, supi + "/auth/5g-aka/verify", gin.H{"rand": rand}, http.StatusBadRequest},
+		{http.MethodPost, supi + "/auth/5g-aka/verify", gin.H{"rand": rand, "res_star": "0g"}, http.StatusBadRequest},
+		{http.MethodPost, unknown + "/auth/5g-aka/verify", gin.H{"rand": rand, "res_star": strings.Repeat("00", 16)}, http.StatusNotFound},
+		{http.MethodPost, supi + "/auth/eap-aka-prime", gin.H{"identity": "0208930000000001@nai.5gc.mnc093.mcc208.3gppnetwork.org"}, http.StatusOK},
+		{http.MethodPost, unknown + "/auth/eap-aka-prime", nil, http.StatusNotFound},
+		{http.MethodGet, supi + "/sqn", nil, http.StatusOK},
+		{http.MethodGet, unknown + "/sqn", nil, http.StatusNotFound},
+		{http.MethodPut, supi + "/sqn", gin.H{"sqn": "0000000000ff"}, http.StatusOK},
+		{http.MethodPut, supi + "/sqn", gin.H{"sqn": "ff"}, http.StatusBadRequest},
+		{http.MethodPost, supi + "/sqn/advance", gin.H{"delta": 1}, http.StatusOK},
+		{http.MethodPost, supi + "/sqn/advance", gin.H{"delta": -1}, http.StatusBadRequest},
+		{http.MethodPost, unknown + "/sqn/advance", gin.H{"delta": 1}, http.StatusNotFound},
+		// An AUTS whose MAC-S does not verify
+		{http.MethodPost, supi + "/sqn/resync", gin.H{"rand": rand, "auts": strings.Repeat("00", 14)}, http.StatusUnprocessableEntity},
+		{http.MethodPost, supi + "/sqn/resync", gin.H{"rand": rand, "auts": "00"}, http.StatusBadRequest},
+		{http.MethodPost, supi + "/sqn/resync", gin.H{"rand": rand}, http.StatusBadRequest},
+		{http.MethodPost, unknown + "/sqn/resync", gin.H{"rand": rand, "auts": strings.Repeat("00", 14)}, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		var errResp struct {
+			Error string `json:"error"`
+		}
+		w := s.send(tt.method, tt.path, token, tt.body)
+		if w.Code != tt.want {
+			t.Errorf("%s %s %v = %d %s, want %d", tt.method, tt.path, tt.body, w.Code, w.Body.String(), tt.want)
+			continue
+		}
+		if tt.want != http.StatusOK {
+			if err := json.Unmarshal(w.Body.Bytes(), &errResp); err != nil || errResp.Error == "" {
+				t.Errorf("%s %s: error response %q", tt.method, tt.path, w.Body.String())
+			}
+		}
+		// The routes need a token
+		if code := s.do(tt.method, tt.path, "", tt.body, nil); code != http.StatusUnauthorized {
+			t.Errorf("%s %s without a token = %d", tt.method, tt.path, code)
+		}
+	}
+
+	// The reset and the advance above are stored
+	var state services.SqnState
+	if code := s.do(http.MethodGet, supi+"/sqn", token, nil, &state); code != http.StatusOK || state.Sqn != "000000000100" {
+		t.Errorf("GET sqn = %d %+v, want 000000000100", code, state)
+	}
+	// Another user does not see the UE profile
+	if code := s.do(http.MethodGet, supi+"/sqn", s.login("bob"), nil, nil); code != http.StatusNotFound {
+		t.Errorf("GET sqn of another user = %d", code)
+	}
+}
+
+func TestDeconcealSuci(t *testing.T) {
+	s := newTestServer(t)
+	token := s.login("alice")
+	var generated struct {
+		UeProfiles []models.UeProfile `json:"ue_profiles"`
+	}
+	var sucis, supis []string
+	for _, scheme := range []string{"A", "B"} {
+		if code := s.do(http.MethodPost, "/ue_profiles/generate", token, gin.H{"num_ues": 1, "scheme": scheme}, &generated); code != http.StatusCreated {
+			t.Fatalf("generate with profile %s = %d", scheme, code)
+		}
+		sucis = append(sucis, generated.UeProfiles[0].Suci)
+		supis = append(supis, generated.UeProfiles[0].Supi)
+	}
+	// withField replaces field i of suci-<type>-<mcc>-<mnc>-<ri>-<scheme>-<key id>-<output>
+	withField := func(suci string, i int, value string) string {
+		fields := strings.Split(suci, "-")
+		fields[i] = value
+		return strings.Join(fields, "-")
+	}
+	profileA, profileB := sucis[0], sucis[1]
+	// The last octet of the scheme output is part of the MAC tag
+	output, err := hex.DecodeString(profileA[strings.LastIndex(profileA, "-")+1:])
+	if err != nil {
+		t.Fatalf("scheme output of %s: %v", profileA, err)
+	}
+	output[len(output)-1] ^= 0xff
+
+	tests := []struct {
+		name string
+		suci string
+		code int
+		// The SUPI on success, the failure reason otherwise
+		want string
+	}{
+		{"profile A", profileA, http.StatusOK, supis[0]},
+		{"profile B", profileB, http.StatusOK, supis[1]},
+		{"null scheme", "suci-0-208-93-0000-0-0-0000000003", http.StatusOK, "imsi-208930000000003"},
+		{"MAC mismatch", withField(profileA, 7, hex.EncodeToString(output)), http.StatusUnprocessableEntity, "mac_mismatch"},
+		{"unknown key ID", withField(profileA, 6, "9"), http.StatusUnprocessableEntity, "unknown_key_id"},
+		{"unsupported scheme", withField(profileA, 5, "3"), http.StatusUnprocessableEntity, "bad_scheme"},
+		{"key of another scheme", withField(profileB, 5, "1"), http.StatusUnprocessableEntity, "bad_scheme"},
+		{"unknown PLMN", withField(profileA, 2, "001"), http.StatusUnprocessableEntity, "unknown_plmn"},
+		{"malformed", "suci-0-208-93", http.StatusUnprocessableEntity, "malformed_suci"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var resp struct {
+				Supi   string `json:"supi"`
+				Error  string `json:"error"`
+				Reason string `json:"reason"`
+			}
+			code := s.do(http.MethodPost, "/suci/deconceal", token, gin.H{"suci": tt.suci}, &resp)
+			got := resp.Supi
+			if code != http.StatusOK {
+				got = resp.Reason
+			}
+			if code != tt.code || got != tt.want {
+				t.Errorf("deconceal %s = %d %+v, want %d with %s", tt.suci, code, resp, tt.code, tt.want)
+			}
+			if code != http.StatusOK && resp.Error == "" {
+				t.Errorf("no error message for reason %s", resp.Reason)
+			}
+		})
+	}
+}
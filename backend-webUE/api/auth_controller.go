@@ -0,0 +1,239 @@
+package api
+
+import (
+	"backend-webUE/aka"
+	"backend-webUE/services"
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+type AuthAPI struct {
+	authService *services.AuthService
+}
+
+func NewAuthAPI(authService *services.AuthService) *AuthAPI {
+	return &AuthAPI{
+		authService: authService,
+	}
+}
+
+// Register Routes for authentication API
+func (api *AuthAPI) RegisterRoutes(router gin.IRouter) {
+	router.POST("/ue_profiles/:supi/auth/5g-aka", api.generate5GAkaVector)
+	router.POST("/ue_profiles/:supi/auth/5g-aka/verify", api.verify5GAka)
+	router.POST("/ue_profiles/:supi/auth/eap-aka-prime", api.generateEapAkaPrimeVector)
+	router.GET("/ue_profiles/:supi/sqn", api.getSqn)
+	router.PUT("/ue_profiles/:supi/sqn", api.resetSqn)
+	router.POST("/ue_profiles/:supi/sqn/advance", api.advanceSqn)
+	router.POST("/ue_profiles/:supi/sqn/resync", api.resyncSqn)
+}
+
+type Generate5GAkaVectorRequest struct {
+	// Optional, the stored SQN of the UE is advanced and used when empty
+	Sqn string `json:"sqn"`
+	// Optional, a random RAND is drawn when empty
+	Rand string `json:"rand"`
+	// Optional, defaults to the home network of the UE
+	ServingNetworkName string `json:"serving_network_name"`
+}
+
+type GenerateEapAkaPrimeVectorRequest struct {
+	Generate5GAkaVectorRequest
+	// Optional, peer identity used in the MK derivation, defaults to the SUPI
+	Identity string `json:"identity"`
+}
+
+type Verify5GAkaRequest struct {
+	Rand               string `json:"rand" binding:"required"`
+	ResStar            string `json:"res_star" binding:"required"`
+	ServingNetworkName string `json:"serving_network_name"`
+}
+
+type ResetSqnRequest struct {
+	// Optional, defaults to the initial SQN of a new subscription
+	Sqn string `json:"sqn"`
+}
+
+type AdvanceSqnRequest struct {
+	// Optional, defaults to 1
+	Delta uint64 `json:"delta"`
+}
+
+type ResyncSqnRequest struct {
+	Rand string `json:"rand" binding:"required"`
+	Auts string `json:"auts" binding:"required"`
+}
+
+// respondAuthError maps authentication service errors to HTTP responses
+func respondAuthError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, services.ErrUeProfileNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrInvalidAuthParameter):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrSqnConflict):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, aka.ErrMacSMismatch):
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
+
+// Generate a 5G-AKA authentication vector for a UE profile
+func (api *AuthAPI) generate5GAkaVector(c *gin.Context) {
+	userID, err := getUserID(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	// The body is optional
+	var req Generate5GAkaVectorRequest
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	av, err := api.authService.Generate5GAkaVector(c.Request.Context(), userID, c.Param("supi"), req.Sqn, req.Rand, req.ServingNetworkName)
+	if err != nil {
+		respondAuthError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, av)
+}
+
+// Generate an EAP-AKA' authentication vector for a UE profile
+func (api *AuthAPI) generateEapAkaPrimeVector(c *gin.Context) {
+	userID, err := getUserID(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	// The body is optional
+	var req GenerateEapAkaPrimeVectorRequest
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	av, err := api.authService.GenerateEapAkaPrimeVector(c.Request.Context(), userID, c.Param("supi"), req.Sqn, req.Rand, req.ServingNetworkName, req.Identity)
+	if err != nil {
+		respondAuthError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, av)
+}
+
+// Verify the RES* returned by a UE
+func (api *AuthAPI) verify5GAka(c *gin.Context) {
+	userID, err := getUserID(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var req Verify5GAkaRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	result, err := api.authService.Verify5GAka(c.Request.Context(), userID, c.Param("supi"), req.Rand, req.ResStar, req.ServingNetworkName)
+	if err != nil {
+		respondAuthError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+// Get the stored SQN of a UE profile
+func (api *AuthAPI) getSqn(c *gin.Context) {
+	userID, err := getUserID(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	sqn, err := api.authService.GetSqn(c.Request.Context(), userID, c.Param("supi"))
+	if err != nil {
+		respondAuthError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, sqn)
+}
+
+// Reset the stored SQN of a UE profile
+func (api *AuthAPI) resetSqn(c *gin.Context) {
+	userID, err := getUserID(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	// The body is optional
+	var req ResetSqnRequest
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	sqn, err := api.authService.ResetSqn(c.Request.Context(), userID, c.Param("supi"), req.Sqn)
+	if err != nil {
+		respondAuthError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, sqn)
+}
+
+// Advance the stored SQN of a UE profile
+func (api *AuthAPI) advanceSqn(c *gin.Context) {
+	userID, err := getUserID(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	// The body is optional
+	var req AdvanceSqnRequest
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Delta == 0 {
+		req.Delta = 1
+	}
+
+	sqn, err := api.authService.AdvanceSqn(c.Request.Context(), userID, c.Param("supi"), req.Delta)
+	if err != nil {
+		respondAuthError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, sqn)
+}
+
+// Recover and store the SQN of a UE from the AUTS of a synchronisation failure
+func (api *AuthAPI) resyncSqn(c *gin.Context) {
+	userID, err := getUserID(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var req ResyncSqnRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	sqn, err := api.authService.ResyncSqn(c.Request.Context(), userID, c.Param("supi"), req.Rand, req.Auts)
+	if err != nil {
+		respondAuthError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, sqn)
+}
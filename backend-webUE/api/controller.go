@@ -3,8 +3,14 @@ package api
 import (
 	"backend-webUE/models"
 	"backend-webUE/services"
+	"backend-webUE/storage"
+	"backend-webUE/utils"
+	"errors"
 	"fmt"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -40,10 +46,87 @@ func (api *UeProfileAPI) RegisterRoutes(router gin.IRouter) {
 	router.GET("/ue_profiles/:supi", api.getUeProfile)
 	router.PUT("/ue_profiles/:supi", api.updateUeProfile)
 	router.DELETE("/ue_profiles/:supi", api.deleteUeProfile)
+	router.POST("/ue_profiles/:supi/provision", api.provisionUeProfile)
+	router.POST("/ue_profiles/:supi/verify", api.verifyUeProfile)
+	router.GET("/provisioners", api.getProvisioners)
+}
+
+// provisionTargets reads the comma separated provisioning targets of a request, "all" for every
+// registered target. Profiles are only pushed to the targets named, none without the parameter.
+func provisionTargets(c *gin.Context) []string {
+	var targets []string
+	for _, target := range strings.Split(c.Query("targets"), ",") {
+		target = strings.TrimSpace(target)
+		if target != "" && !strings.EqualFold(target, "none") {
+			targets = append(targets, target)
+		}
+	}
+	return targets
+}
+
+// respondProvisionError maps provisioning errors to HTTP responses, it reports false for other errors
+func respondProvisionError(c *gin.Context, err error) bool {
+	switch {
+	case errors.Is(err, services.ErrUnknownProvisionTarget), errors.Is(err, services.ErrNoProvisionTarget):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrProvisionFailed), errors.Is(err, services.ErrDeprovisionFailed):
+		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+	default:
+		return false
+	}
+	return true
+}
+
+// respondDuplicateSupis answers 409 with the SUPIs the user already has, it reports false for other errors
+func respondDuplicateSupis(c *gin.Context, err error) bool {
+	var duplicates *storage.DuplicateSupisError
+	if !errors.As(err, &duplicates) {
+		return false
+	}
+	c.JSON(http.StatusConflict, gin.H{"error": err.Error(), "supis": duplicates.Supis})
+	return true
 }
 
 type GenerateUeProfilesRequest struct {
 	NumUes int `json:"num_ues"`
+	// Operator to generate the UEs for, the default operator when empty
+	OperatorID string `json:"operator_id"`
+	// Protection scheme of the SUCIs: "A" (default), "B", "null" or "mix"
+	Scheme string `json:"scheme"`
+	// Relative weight of each scheme, required when scheme is "mix"
+	SchemeWeights *utils.SchemeMix `json:"scheme_weights"`
+	// "OPC" (default) for per-UE OPc, "OP" for the operator's shared OP
+	OpType string `json:"op_type"`
+	// "5G_AKA" (default) or "EAP_AKA_PRIME"
+	AuthenticationMethod string `json:"authentication_method"`
+	// First MSIN of an explicit range of num_ues MSINs, allocated sequentially when absent
+	MsinStart *uint64 `json:"msin_start"`
+	// Tags of the generated UEs, for filtering the UE profile list
+	Tags []string `json:"tags"`
+}
+
+// schemeMix converts the requested protection scheme into weights
+func (req *GenerateUeProfilesRequest) schemeMix() (utils.SchemeMix, error) {
+	return parseSchemeMix(req.Scheme, req.SchemeWeights)
+}
+
+// parseSchemeMix converts a protection scheme of a request ("A", "B", "null" or "mix" with weights) into weights
+func parseSchemeMix(scheme string, weights *utils.SchemeMix) (utils.SchemeMix, error) {
+	switch strings.ToLower(scheme) {
+	case "", "a":
+		return utils.SchemeMix{A: 1}, nil
+	case "b":
+		return utils.SchemeMix{B: 1}, nil
+	case "null":
+		return utils.SchemeMix{Null: 1}, nil
+	case "mix":
+		if weights == nil {
+			return utils.SchemeMix{}, fmt.Errorf("scheme_weights is required when scheme is mix")
+		}
+		return *weights, nil
+	default:
+		return utils.SchemeMix{}, fmt.Errorf("scheme must be one of A, B, null or mix")
+	}
 }
 
 func (api *UeProfileAPI) generateUeProfiles(c *gin.Context) {
@@ -59,14 +142,14 @@ func (api *UeProfileAPI) generateUeProfiles(c *gin.Context) {
 		return
 	}
 
-	if req.NumUes <= 0 {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "num_ues must be greater than 0"})
+	params, ok := generateParams(c, &req)
+	if !ok {
 		return
 	}
 
-	ueProfiles, err := api.ueProfileService.GenerateUeProfiles(c.Request.Context(), userID, req.NumUes)
+	ueProfiles, err := api.ueProfileService.GenerateUeProfiles(c.Request.Context(), userID, params)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		respondGenerateError(c, err)
 		return
 	}
 
@@ -76,6 +159,74 @@ func (api *UeProfileAPI) generateUeProfiles(c *gin.Context) {
 	})
 }
 
+// generateParams validates a generation request and fills in its defaults, it responds with
+// 400 and reports false for invalid requests
+func generateParams(c *gin.Context, req *GenerateUeProfilesRequest) (services.GenerateParams, bool) {
+	if req.NumUes <= 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "num_ues must be greater than 0"})
+		return services.GenerateParams{}, false
+	}
+
+	schemes, err := req.schemeMix()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return services.GenerateParams{}, false
+	}
+
+	operatorID := primitive.NilObjectID
+	if req.OperatorID != "" {
+		operatorID, err = primitive.ObjectIDFromHex(req.OperatorID)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid operator_id"})
+			return services.GenerateParams{}, false
+		}
+	}
+
+	opType := strings.ToUpper(req.OpType)
+	if opType == "" {
+		opType = utils.OPC
+	}
+
+	authMethod := strings.ToUpper(req.AuthenticationMethod)
+	if authMethod == "" {
+		authMethod = utils.AUTH_5G_AKA
+	}
+
+	return services.GenerateParams{
+		OperatorID:           operatorID,
+		Num:                  req.NumUes,
+		Schemes:              schemes,
+		OpType:               opType,
+		AuthenticationMethod: authMethod,
+		Targets:              provisionTargets(c),
+		MsinStart:            req.MsinStart,
+		Tags:                 req.Tags,
+	}, true
+}
+
+// respondGenerateError maps the errors of a generation to HTTP responses
+func respondGenerateError(c *gin.Context, err error) {
+	if errors.Is(err, services.ErrOperatorNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if errors.Is(err, services.ErrMsinRangeAllocated) || errors.Is(err, services.ErrMsinExhausted) {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	if respondDuplicateSupis(c, err) {
+		return
+	}
+	if errors.Is(err, utils.ErrInvalidGenerateOptions) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if respondProvisionError(c, err) {
+		return
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+}
+
 // Create multiple UE profiles
 func (api *UeProfileAPI) createUeProfiles(c *gin.Context) {
 	userID, err := getUserID(c)
@@ -91,7 +242,10 @@ func (api *UeProfileAPI) createUeProfiles(c *gin.Context) {
 	}
 
 	// Insert profiles for the user
-	err = api.ueProfileService.CreateUeProfiles(c.Request.Context(), userID, ueProfiles)
+	err = api.ueProfileService.CreateUeProfiles(c.Request.Context(), userID, ueProfiles, provisionTargets(c))
+	if respondDuplicateSupis(c, err) || respondProvisionError(c, err) {
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -100,7 +254,87 @@ func (api *UeProfileAPI) createUeProfiles(c *gin.Context) {
 	c.JSON(http.StatusCreated, gin.H{"message": "UE profiles created"})
 }
 
-// Get a list of all UE profiles
+// ueProfileQuery reads the paging, sort and filter query parameters of the UE profile list
+func ueProfileQuery(c *gin.Context) (storage.UeProfileQuery, error) {
+	query := storage.UeProfileQuery{
+		SupiPrefix: c.Query("supi_prefix"),
+		SupiFrom:   c.Query("supi_from"),
+		SupiTo:     c.Query("supi_to"),
+		Mcc:        c.Query("mcc"),
+		Mnc:        c.Query("mnc"),
+		Sd:         strings.TrimPrefix(strings.ToLower(c.Query("sd")), "0x"),
+		Dnn:        c.Query("dnn"),
+		OpType:     strings.ToUpper(c.Query("op_type")),
+		Cursor:     c.Query("cursor"),
+	}
+
+	if value := c.Query("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit <= 0 {
+			return query, fmt.Errorf("limit must be a positive integer")
+		}
+		query.Limit = limit
+	}
+
+	// sort is supi or created_at, descending with a leading -
+	sortKey := c.Query("sort")
+	if strings.HasPrefix(sortKey, "-") {
+		query.Desc = true
+		sortKey = sortKey[1:]
+	}
+	switch sortKey {
+	case "", "supi":
+		query.Sort = storage.UeProfileSortSupi
+	case "created_at":
+		query.Sort = storage.UeProfileSortCreatedAt
+	default:
+		return query, fmt.Errorf("sort must be supi or created_at, optionally prefixed with -")
+	}
+
+	if value := c.Query("sst"); value != "" {
+		sst, err := strconv.Atoi(value)
+		if err != nil || sst < 0 || sst > 255 {
+			return query, fmt.Errorf("sst must be an integer from 0 to 255")
+		}
+		query.Sst = &sst
+	}
+
+	if value := c.Query("protection_scheme"); value != "" {
+		var scheme int
+		switch strings.ToLower(value) {
+		case "null", "0":
+			scheme = utils.NULL_SCHEME
+		case "a", "1":
+			scheme = utils.A_SCHEME
+		case "b", "2":
+			scheme = utils.B_SCHEME
+		default:
+			return query, fmt.Errorf("protection_scheme must be one of null, A or B")
+		}
+		query.ProtectionScheme = &scheme
+	}
+
+	// tag may be repeated or comma separated, a profile must carry every tag
+	for _, value := range c.QueryArray("tag") {
+		for _, tag := range strings.Split(value, ",") {
+			if tag = strings.TrimSpace(tag); tag != "" {
+				query.Tags = append(query.Tags, tag)
+			}
+		}
+	}
+
+	if value := c.Query("created_after"); value != "" {
+		createdAfter, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return query, fmt.Errorf("created_after must be an RFC 3339 time")
+		}
+		query.CreatedAfter = &createdAfter
+	}
+	return query, nil
+}
+
+// Get a page of the UE profiles, the number of matching profiles is in X-Total-Count and the
+// cursor of the next page in X-Next-Cursor
 func (api *UeProfileAPI) getUeProfiles(c *gin.Context) {
 	userID, err := getUserID(c)
 	if err != nil {
@@ -108,12 +342,26 @@ func (api *UeProfileAPI) getUeProfiles(c *gin.Context) {
 		return
 	}
 
-	ueProfiles, err := api.ueProfileService.GetUeProfiles(c.Request.Context(), userID)
+	query, err := ueProfileQuery(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	page, err := api.ueProfileService.FindUeProfiles(c.Request.Context(), userID, query)
+	if errors.Is(err, storage.ErrInvalidQuery) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
-	c.JSON(http.StatusOK, ueProfiles)
+	c.Header("X-Total-Count", strconv.FormatInt(page.Total, 10))
+	if page.NextCursor != "" {
+		c.Header("X-Next-Cursor", page.NextCursor)
+	}
+	c.JSON(http.StatusOK, page.UeProfiles)
 }
 
 // Get UE profile following by SUPI
@@ -174,9 +422,28 @@ func (api *UeProfileAPI) updateUeProfile(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Cannot update userId"})
 		return
 	}
+	if _, exists := updatedFields["provisioning"]; exists {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Cannot update provisioning"})
+		return
+	}
+	if _, exists := updatedFields["createdAt"]; exists {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Cannot update createdAt"})
+		return
+	}
+	if _, exists := updatedFields["suci"]; exists {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Cannot update SUCI, it is concealed from the routing indicator, protection scheme and home network key"})
+		return
+	}
 
 	// Update the UE profile
-	err = api.ueProfileService.UpdateUeProfile(c.Request.Context(), userID, supi, updatedFields)
+	err = api.ueProfileService.UpdateUeProfile(c.Request.Context(), userID, supi, updatedFields, provisionTargets(c))
+	if respondProvisionError(c, err) {
+		return
+	}
+	if errors.Is(err, services.ErrInvalidUpdate) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -195,7 +462,10 @@ func (api *UeProfileAPI) deleteUeProfile(c *gin.Context) {
 
 	supi := c.Param("supi")
 
-	err = api.ueProfileService.DeleteUeProfile(c.Request.Context(), userID, supi)
+	err = api.ueProfileService.DeleteUeProfile(c.Request.Context(), userID, supi, provisionTargets(c))
+	if respondProvisionError(c, err) {
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -203,3 +473,60 @@ func (api *UeProfileAPI) deleteUeProfile(c *gin.Context) {
 
 	c.JSON(http.StatusOK, gin.H{"message": "UE profile deleted"})
 }
+
+// Push a UE profile to the provisioning targets
+func (api *UeProfileAPI) provisionUeProfile(c *gin.Context) {
+	userID, err := getUserID(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	supi := c.Param("supi")
+	ueProfile, err := api.ueProfileService.ProvisionUeProfile(c.Request.Context(), userID, supi, provisionTargets(c))
+	if errors.Is(err, services.ErrUeProfileNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if errors.Is(err, services.ErrProvisionFailed) {
+		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error(), "provisioning": ueProfile.Provisioning})
+		return
+	}
+	if respondProvisionError(c, err) {
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"provisioning": ueProfile.Provisioning})
+}
+
+// Check a UE profile against the provisioning targets
+func (api *UeProfileAPI) verifyUeProfile(c *gin.Context) {
+	userID, err := getUserID(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	supi := c.Param("supi")
+	results, err := api.ueProfileService.VerifyUeProfile(c.Request.Context(), userID, supi, provisionTargets(c))
+	if errors.Is(err, services.ErrUeProfileNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+	if respondProvisionError(c, err) {
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"results": results})
+}
+
+// List the registered provisioning targets
+func (api *UeProfileAPI) getProvisioners(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"targets": api.ueProfileService.Provisioners().Names()})
+}
@@ -0,0 +1,285 @@
+package api
+
+import (
+	"backend-webUE/services"
+	"backend-webUE/utils"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+type ExportAPI struct {
+	exportService *services.ExportService
+}
+
+func NewExportAPI(exportService *services.ExportService) *ExportAPI {
+	return &ExportAPI{
+		exportService: exportService,
+	}
+}
+
+// Register Routes for export API
+func (api *ExportAPI) RegisterRoutes(router gin.IRouter) {
+	router.GET("/ue_profiles/:supi/export", api.exportUeProfile)
+	router.POST("/ue_profiles/export", api.exportUeProfiles)
+	router.POST("/ue_profiles/export/packetrusher", api.exportPacketRusher)
+	router.POST("/ue_profiles/export/open5gs", api.exportOpen5gs)
+	router.POST("/ue_profiles/export/ndjson", api.exportNdjson)
+	router.POST("/ue_profiles/export/csv", api.exportCsv)
+}
+
+// StreamOptions control the response of a streamed bulk export
+type StreamOptions struct {
+	// Compress the export with gzip, the file name gets a .gz suffix
+	Gzip bool `json:"gzip"`
+	// Records between flushes to the client, 100 when absent
+	FlushEvery int `json:"flush_every"`
+}
+
+type ExportUeProfilesRequest struct {
+	// UE profiles to export, all profiles of the user when empty
+	Supis []string `json:"supis"`
+	// Export format, "ueransim" (default)
+	Format string `json:"format"`
+	// "tar" (default) or "zip"
+	Archive string `json:"archive"`
+	StreamOptions
+}
+
+type ExportOpen5gsRequest struct {
+	// UE profiles to export, all profiles of the user when empty
+	Supis []string `json:"supis"`
+	StreamOptions
+}
+
+type ExportNdjsonRequest struct {
+	// UE profiles to export, all profiles of the user when empty
+	Supis []string `json:"supis"`
+	// "profile" (default) for UE profiles as read by JSON Lines imports, "open5gs" for subscribers
+	Record string `json:"record"`
+	StreamOptions
+}
+
+type ExportCsvRequest struct {
+	// UE profiles to export, all profiles of the user when empty
+	Supis []string `json:"supis"`
+	StreamOptions
+}
+
+type ExportPacketRusherRequest struct {
+	// UE profiles of the multi-UE run, all profiles of the user when empty
+	Supis []string `json:"supis"`
+	// Optional, defaults to the first gNB in the search list of the UEs
+	GnbIp string `json:"gnb_ip"`
+	// Optional, defaults to 127.0.0.1:38412
+	AmfIp   string `json:"amf_ip"`
+	AmfPort int    `json:"amf_port"`
+}
+
+// respondExportError maps export service errors to HTTP responses
+func respondExportError(c *gin.Context, err error) {
+	var missing *services.MissingUeProfilesError
+	var rangeErr *utils.PacketRusherRangeError
+	switch {
+	case errors.As(err, &missing):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error(), "missing": missing.Supis})
+	case errors.As(err, &rangeErr):
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error(), "reasons": rangeErr.Reasons})
+	case errors.Is(err, services.ErrUeProfileNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrUnknownExportFormat), errors.Is(err, services.ErrUnknownArchiveFormat):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
+
+// streamExport sends the headers of a file download and runs export on a stream to the response.
+// The status is sent before the export runs, a failure can only cut the file short.
+func streamExport(c *gin.Context, filename, contentType string, opts StreamOptions, export func(stream *services.ExportStream) error) {
+	if opts.Gzip {
+		filename += ".gz"
+		contentType = "application/gzip"
+	}
+	c.Header("Content-Type", contentType)
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	// Keep reverse proxies from buffering the flushed records
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+
+	stream := services.NewExportStream(c.Writer, opts.Gzip, opts.FlushEvery)
+	err := export(stream)
+	if err == nil {
+		err = stream.Close()
+	}
+	if err != nil {
+		log.Printf("Export of %s failed: %v", filename, err)
+		c.Abort()
+	}
+}
+
+// Export a UE profile as a configuration file
+func (api *ExportAPI) exportUeProfile(c *gin.Context) {
+	userID, err := getUserID(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	supi := c.Param("supi")
+	out, format, err := api.exportService.ExportUeProfile(c.Request.Context(), userID, supi, c.DefaultQuery("format", "ueransim"))
+	if err != nil {
+		respondExportError(c, err)
+		return
+	}
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", supi+format.Ext))
+	c.Data(http.StatusOK, format.ContentType, out)
+}
+
+// Export a set of UE profiles as a tar or zip archive with one file per UE
+func (api *ExportAPI) exportUeProfiles(c *gin.Context) {
+	userID, err := getUserID(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var req ExportUeProfilesRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.Format == "" {
+		req.Format = "ueransim"
+	}
+	req.Archive = strings.ToLower(req.Archive)
+	if req.Archive == "" {
+		req.Archive = services.ArchiveTar
+	}
+
+	ctx := c.Request.Context()
+	if err := api.exportService.CheckArchiveExport(ctx, userID, req.Supis, req.Format, req.Archive); err != nil {
+		respondExportError(c, err)
+		return
+	}
+
+	contentType := "application/x-tar"
+	if req.Archive == services.ArchiveZip {
+		contentType = "application/zip"
+	}
+	filename := "ue_profiles-" + strings.ToLower(req.Format) + "." + req.Archive
+	streamExport(c, filename, contentType, req.StreamOptions, func(stream *services.ExportStream) error {
+		return api.exportService.ExportArchive(ctx, userID, req.Supis, req.Format, req.Archive, stream)
+	})
+}
+
+// Export a set of UE profiles as one PacketRusher multi-UE config
+func (api *ExportAPI) exportPacketRusher(c *gin.Context) {
+	userID, err := getUserID(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var req ExportPacketRusherRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	out, err := api.exportService.ExportPacketRusher(c.Request.Context(), userID, req.Supis, utils.PacketRusherOptions{
+		GnbIp:   req.GnbIp,
+		AmfIp:   req.AmfIp,
+		AmfPort: req.AmfPort,
+	})
+	if err != nil {
+		respondExportError(c, err)
+		return
+	}
+	c.Header("Content-Disposition", `attachment; filename="config.yml"`)
+	c.Data(http.StatusOK, "application/yaml", out)
+}
+
+// Export a set of UE profiles as a JSON array of Open5GS subscribers for offline import
+func (api *ExportAPI) exportOpen5gs(c *gin.Context) {
+	userID, err := getUserID(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var req ExportOpen5gsRequest
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx := c.Request.Context()
+	if err := api.exportService.CheckSelection(ctx, userID, req.Supis); err != nil {
+		respondExportError(c, err)
+		return
+	}
+
+	streamExport(c, "subscribers.json", "application/json", req.StreamOptions, func(stream *services.ExportStream) error {
+		return api.exportService.ExportOpen5gs(ctx, userID, req.Supis, stream)
+	})
+}
+
+// Stream a set of UE profiles as newline delimited JSON, one profile or subscriber per line
+func (api *ExportAPI) exportNdjson(c *gin.Context) {
+	userID, err := getUserID(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var req ExportNdjsonRequest
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	req.Record = strings.ToLower(req.Record)
+	if req.Record == "" {
+		req.Record = services.NdjsonRecordProfile
+	}
+
+	ctx := c.Request.Context()
+	if err := api.exportService.CheckNdjsonExport(ctx, userID, req.Supis, req.Record); err != nil {
+		respondExportError(c, err)
+		return
+	}
+
+	streamExport(c, "ue_profiles-"+req.Record+".ndjson", "application/x-ndjson", req.StreamOptions, func(stream *services.ExportStream) error {
+		return api.exportService.ExportNdjson(ctx, userID, req.Supis, req.Record, stream)
+	})
+}
+
+// Stream a set of UE profiles as CSV in the layout of CSV imports
+func (api *ExportAPI) exportCsv(c *gin.Context) {
+	userID, err := getUserID(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var req ExportCsvRequest
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx := c.Request.Context()
+	if err := api.exportService.CheckSelection(ctx, userID, req.Supis); err != nil {
+		respondExportError(c, err)
+		return
+	}
+
+	streamExport(c, "ue_profiles.csv", "text/csv", req.StreamOptions, func(stream *services.ExportStream) error {
+		return api.exportService.ExportCsv(ctx, userID, req.Supis, stream)
+	})
+}
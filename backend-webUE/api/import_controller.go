@@ -0,0 +1,219 @@
+package api
+
+import (
+	"backend-webUE/services"
+	"backend-webUE/utils"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type ImportAPI struct {
+	coreImportService *services.CoreImportService
+	bulkImportService *services.BulkImportService
+}
+
+func NewImportAPI(coreImportService *services.CoreImportService, bulkImportService *services.BulkImportService) *ImportAPI {
+	return &ImportAPI{
+		coreImportService: coreImportService,
+		bulkImportService: bulkImportService,
+	}
+}
+
+// Register Routes for import API
+func (api *ImportAPI) RegisterRoutes(router gin.IRouter) {
+	router.POST("/ue_profiles/import", api.importFile)
+	router.POST("/ue_profiles/import/core", api.importFromCore)
+}
+
+type ImportFromCoreRequest struct {
+	// "free5gc" or "open5gs"
+	Source string `json:"source" binding:"required"`
+	// MongoDB of the core, e.g. mongodb://localhost:27017
+	MongoUri string `json:"mongo_uri" binding:"required"`
+	// Database of the core, "free5gc" or "open5gs" by default
+	Database string `json:"database"`
+	// Operator the subscribers belong to, the default operator when empty
+	OperatorID string `json:"operator_id"`
+	// Protection scheme of the computed SUCIs: "A" (default), "B", "null" or "mix"
+	Scheme        string           `json:"scheme"`
+	SchemeWeights *utils.SchemeMix `json:"scheme_weights"`
+	// Report what would be imported without inserting anything
+	DryRun bool `json:"dry_run"`
+}
+
+// Import the subscribers of a free5GC or Open5GS database as UE profiles
+func (api *ImportAPI) importFromCore(c *gin.Context) {
+	userID, err := getUserID(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var req ImportFromCoreRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	schemes, err := parseSchemeMix(req.Scheme, req.SchemeWeights)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	operatorID := primitive.NilObjectID
+	if req.OperatorID != "" {
+		operatorID, err = primitive.ObjectIDFromHex(req.OperatorID)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid operator_id"})
+			return
+		}
+	}
+	if req.Database == "" {
+		req.Database = strings.ToLower(req.Source)
+	}
+
+	report, err := api.coreImportService.ImportFromCore(c.Request.Context(), userID, services.CoreImportParams{
+		Source:     req.Source,
+		Uri:        req.MongoUri,
+		Database:   req.Database,
+		OperatorID: operatorID,
+		Schemes:    schemes,
+		DryRun:     req.DryRun,
+	})
+	if respondDuplicateSupis(c, err) {
+		return
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrUnknownImportSource), errors.Is(err, utils.ErrInvalidGenerateOptions):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		case errors.Is(err, services.ErrOperatorNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		case errors.Is(err, services.ErrImportSource):
+			c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	status := http.StatusOK
+	if !report.DryRun && len(report.Imported) > 0 {
+		status = http.StatusCreated
+	}
+	c.JSON(status, report)
+}
+
+// importFormats maps the content types of import files to their format
+var importFormats = map[string]string{
+	"text/csv":              services.ImportFormatCsv,
+	"application/csv":       services.ImportFormatCsv,
+	"application/x-ndjson":  services.ImportFormatJsonl,
+	"application/jsonl":     services.ImportFormatJsonl,
+	"application/jsonlines": services.ImportFormatJsonl,
+}
+
+// Import UE profiles from a CSV or JSON Lines file, sent as the request body or as the "file" field of a form.
+// Query parameters: format (csv or jsonl, from the content type by default), operator_id, scheme, dry_run and targets.
+func (api *ImportAPI) importFile(c *gin.Context) {
+	userID, err := getUserID(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	schemes, err := parseSchemeMix(c.Query("scheme"), nil)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	operatorID := primitive.NilObjectID
+	if value := c.Query("operator_id"); value != "" {
+		operatorID, err = primitive.ObjectIDFromHex(value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid operator_id"})
+			return
+		}
+	}
+	dryRun := false
+	if value := c.Query("dry_run"); value != "" {
+		dryRun, err = strconv.ParseBool(value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid dry_run"})
+			return
+		}
+	}
+
+	format := c.Query("format")
+	var body io.Reader = c.Request.Body
+	contentType, _, _ := mime.ParseMediaType(c.GetHeader("Content-Type"))
+	if contentType == "multipart/form-data" {
+		header, err := c.FormFile("file")
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "missing file field"})
+			return
+		}
+		file, err := header.Open()
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		defer file.Close()
+		body = file
+		contentType, _, _ = mime.ParseMediaType(header.Header.Get("Content-Type"))
+		if format == "" && strings.HasSuffix(strings.ToLower(header.Filename), ".csv") {
+			format = services.ImportFormatCsv
+		} else if format == "" && (strings.HasSuffix(strings.ToLower(header.Filename), ".jsonl") ||
+			strings.HasSuffix(strings.ToLower(header.Filename), ".ndjson")) {
+			format = services.ImportFormatJsonl
+		}
+	}
+	if format == "" {
+		format = importFormats[contentType]
+	}
+	if format == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format must be csv or jsonl"})
+		return
+	}
+
+	report, err := api.bulkImportService.Import(c.Request.Context(), userID, body, services.BulkImportParams{
+		Format:     format,
+		OperatorID: operatorID,
+		Schemes:    schemes,
+		DryRun:     dryRun,
+		Targets:    provisionTargets(c),
+	})
+	if report != nil && errors.Is(err, services.ErrProvisionFailed) {
+		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error(), "report": report})
+		return
+	}
+	if respondDuplicateSupis(c, err) {
+		return
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrUnknownImportFormat), errors.Is(err, services.ErrInvalidImportFile),
+			errors.Is(err, utils.ErrInvalidGenerateOptions):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		case errors.Is(err, services.ErrOperatorNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		default:
+			if !respondProvisionError(c, err) {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			}
+		}
+		return
+	}
+
+	status := http.StatusOK
+	if !report.DryRun && report.Accepted > 0 {
+		status = http.StatusCreated
+	}
+	c.JSON(status, report)
+}
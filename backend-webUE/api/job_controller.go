@@ -0,0 +1,192 @@
+package api
+
+import (
+	"backend-webUE/services"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type JobAPI struct {
+	jobService    *services.JobService
+	exportService *services.ExportService
+}
+
+func NewJobAPI(jobService *services.JobService, exportService *services.ExportService) *JobAPI {
+	return &JobAPI{
+		jobService:    jobService,
+		exportService: exportService,
+	}
+}
+
+// Register Routes for job API
+func (api *JobAPI) RegisterRoutes(router gin.IRouter) {
+	router.POST("/jobs/generate", api.submitGenerate)
+	router.GET("/jobs", api.getJobs)
+	router.GET("/jobs/:id", api.getJob)
+	router.POST("/jobs/:id/cancel", api.cancelJob)
+	router.GET("/jobs/:id/export", api.exportJob)
+}
+
+// respondJobError maps job service errors to HTTP responses
+func respondJobError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, services.ErrJobNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrJobFinished), errors.Is(err, services.ErrJobNotFinished):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrJobQueueFull):
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
+
+// jobID reads the job ID of the path, it responds with 400 and reports false for malformed IDs
+func jobID(c *gin.Context) (primitive.ObjectID, bool) {
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid job id"})
+		return primitive.NilObjectID, false
+	}
+	return id, true
+}
+
+// Queue a generation of UE profiles, the request is the one of POST /ue_profiles/generate
+func (api *JobAPI) submitGenerate(c *gin.Context) {
+	userID, err := getUserID(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var req GenerateUeProfilesRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	params, ok := generateParams(c, &req)
+	if !ok {
+		return
+	}
+
+	job, err := api.jobService.SubmitGenerate(c.Request.Context(), userID, params)
+	if err != nil {
+		if errors.Is(err, services.ErrJobQueueFull) {
+			respondJobError(c, err)
+			return
+		}
+		respondGenerateError(c, err)
+		return
+	}
+	c.Header("Location", "/jobs/"+job.ID.Hex())
+	c.JSON(http.StatusAccepted, job)
+}
+
+// Get the jobs of the user, newest first
+func (api *JobAPI) getJobs(c *gin.Context) {
+	userID, err := getUserID(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	jobs, err := api.jobService.List(c.Request.Context(), userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, jobs)
+}
+
+// Get the status and progress of a job
+func (api *JobAPI) getJob(c *gin.Context) {
+	userID, err := getUserID(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+	id, ok := jobID(c)
+	if !ok {
+		return
+	}
+
+	job, err := api.jobService.Get(c.Request.Context(), userID, id)
+	if err != nil {
+		respondJobError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, job)
+}
+
+// Cancel a queued or running job, the UE profiles generated so far are kept
+func (api *JobAPI) cancelJob(c *gin.Context) {
+	userID, err := getUserID(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+	id, ok := jobID(c)
+	if !ok {
+		return
+	}
+
+	job, err := api.jobService.Cancel(c.Request.Context(), userID, id)
+	if err != nil {
+		respondJobError(c, err)
+		return
+	}
+	c.JSON(http.StatusAccepted, job)
+}
+
+// Stream the UE profiles generated by a finished job as NDJSON (default) or CSV.
+// Query parameters: format=ndjson|csv, record=profile|open5gs for NDJSON, gzip and flush_every.
+func (api *JobAPI) exportJob(c *gin.Context) {
+	userID, err := getUserID(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+	id, ok := jobID(c)
+	if !ok {
+		return
+	}
+
+	opts := StreamOptions{Gzip: c.Query("gzip") == "true"}
+	if value := c.Query("flush_every"); value != "" {
+		if opts.FlushEvery, err = strconv.Atoi(value); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "flush_every must be an integer"})
+			return
+		}
+	}
+	format := strings.ToLower(c.DefaultQuery("format", "ndjson"))
+	record := strings.ToLower(c.DefaultQuery("record", services.NdjsonRecordProfile))
+
+	ctx := c.Request.Context()
+	job, err := api.jobService.Results(ctx, userID, id)
+	if err != nil {
+		respondJobError(c, err)
+		return
+	}
+
+	switch format {
+	case "ndjson":
+		if err := api.exportService.CheckNdjsonExport(ctx, userID, nil, record); err != nil {
+			respondExportError(c, err)
+			return
+		}
+		streamExport(c, job.ResultTag+"-"+record+".ndjson", "application/x-ndjson", opts, func(stream *services.ExportStream) error {
+			return api.exportService.ExportTaggedNdjson(ctx, userID, job.ResultTag, record, stream)
+		})
+	case "csv":
+		streamExport(c, job.ResultTag+".csv", "text/csv", opts, func(stream *services.ExportStream) error {
+			return api.exportService.ExportTaggedCsv(ctx, userID, job.ResultTag, stream)
+		})
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format must be ndjson or csv"})
+	}
+}
@@ -0,0 +1,155 @@
+package api
+
+import (
+	"backend-webUE/models"
+	"backend-webUE/services"
+	"backend-webUE/utils"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+type OperatorAPI struct {
+	operatorService *services.OperatorService
+}
+
+func NewOperatorAPI(operatorService *services.OperatorService) *OperatorAPI {
+	return &OperatorAPI{
+		operatorService: operatorService,
+	}
+}
+
+// Register Routes for operator API
+func (api *OperatorAPI) RegisterRoutes(router gin.IRouter) {
+	router.POST("/operators", api.createOperator)
+	router.GET("/operators", api.getOperators)
+	router.GET("/operators/:id", api.getOperator)
+	router.PUT("/operators/:id", api.updateOperator)
+	router.DELETE("/operators/:id", api.deleteOperator)
+}
+
+// respondOperatorError maps operator service errors to HTTP responses
+func respondOperatorError(c *gin.Context, err error) {
+	var validationErr *utils.ValidationError
+	switch {
+	case errors.As(err, &validationErr):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid operator", "details": validationErr.Errors})
+	case errors.Is(err, services.ErrOperatorNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
+
+// Create an operator definition
+func (api *OperatorAPI) createOperator(c *gin.Context) {
+	userID, err := getUserID(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var operator models.Operator
+	if err := c.ShouldBindJSON(&operator); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := api.operatorService.CreateOperator(c.Request.Context(), userID, &operator); err != nil {
+		respondOperatorError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, operator)
+}
+
+// Get a list of all operators
+func (api *OperatorAPI) getOperators(c *gin.Context) {
+	userID, err := getUserID(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	operators, err := api.operatorService.GetOperators(c.Request.Context(), userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, operators)
+}
+
+// Get an operator by ID
+func (api *OperatorAPI) getOperator(c *gin.Context) {
+	userID, err := getUserID(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	operatorID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid operator id"})
+		return
+	}
+
+	operator, err := api.operatorService.GetOperator(c.Request.Context(), userID, operatorID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if operator == nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Operator not found"})
+		return
+	}
+	c.JSON(http.StatusOK, operator)
+}
+
+// Replace an operator definition
+func (api *OperatorAPI) updateOperator(c *gin.Context) {
+	userID, err := getUserID(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	operatorID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid operator id"})
+		return
+	}
+
+	var operator models.Operator
+	if err := c.ShouldBindJSON(&operator); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := api.operatorService.UpdateOperator(c.Request.Context(), userID, operatorID, &operator); err != nil {
+		respondOperatorError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, operator)
+}
+
+// Delete an operator definition
+func (api *OperatorAPI) deleteOperator(c *gin.Context) {
+	userID, err := getUserID(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	operatorID, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid operator id"})
+		return
+	}
+
+	if err := api.operatorService.DeleteOperator(c.Request.Context(), userID, operatorID); err != nil {
+		respondOperatorError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Operator deleted"})
+}
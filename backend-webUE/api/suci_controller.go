@@ -0,0 +1,71 @@
+package api
+
+import (
+	"backend-webUE/services"
+	"backend-webUE/supi-key"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+type SuciAPI struct {
+	suciService *services.SuciService
+}
+
+func NewSuciAPI(suciService *services.SuciService) *SuciAPI {
+	return &SuciAPI{
+		suciService: suciService,
+	}
+}
+
+// Register Routes for SUCI API
+func (api *SuciAPI) RegisterRoutes(router gin.IRouter) {
+	router.POST("/suci/deconceal", api.deconcealSuci)
+}
+
+type DeconcealSuciRequest struct {
+	Suci string `json:"suci" binding:"required"`
+}
+
+// Failure reasons reported by the de-concealment endpoint
+var deconcealReasons = []struct {
+	err    error
+	reason string
+}{
+	{services.ErrMalformedSuci, "malformed_suci"},
+	{services.ErrUnknownPlmn, "unknown_plmn"},
+	{services.ErrUnknownKeyId, "unknown_key_id"},
+	{supi.ErrBadScheme, "bad_scheme"},
+	{supi.ErrInvalidKey, "invalid_hn_key"},
+	{supi.ErrSchemeOutput, "malformed_scheme_output"},
+	{supi.ErrMacMismatch, "mac_mismatch"},
+}
+
+// Recover the SUPI concealed in a SUCI
+func (api *SuciAPI) deconcealSuci(c *gin.Context) {
+	userID, err := getUserID(c)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+		return
+	}
+
+	var req DeconcealSuciRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	supi, err := api.suciService.Deconceal(c.Request.Context(), userID, req.Suci)
+	if err != nil {
+		for _, r := range deconcealReasons {
+			if errors.Is(err, r.err) {
+				c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error(), "reason": r.reason})
+				return
+			}
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"supi": supi})
+}
@@ -3,7 +3,7 @@ package api
 import (
 	"backend-webUE/middleware"
 	"backend-webUE/services"
-	"fmt"
+	"errors"
 	"net/http"
 	"strings"
 	"time"
@@ -46,6 +46,10 @@ func (api *UserAPI) registerUser(c *gin.Context) {
 	}
 
 	err := api.userService.CreateUser(c.Request.Context(), req.Username, req.Password)
+	if errors.Is(err, services.ErrUsernameTaken) {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
@@ -81,7 +85,6 @@ func (api *UserAPI) loginUser(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate token"})
 		return
 	}
-	fmt.Println("Generated Token:", tokenString)
 	c.JSON(http.StatusOK, gin.H{"token": tokenString})
 }
 
@@ -131,7 +134,6 @@ func (api *UserAPI) logoutUser(c *gin.Context) {
 }
 
 func generateJWT(username, secret string) (string, error) {
-	fmt.Println("JWT Secret in generateJWT:", secret)
 	// Create the Claims
 	claims := jwt.MapClaims{
 		"username": username,
@@ -0,0 +1,86 @@
+package database
+
+import (
+	"context"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Indexes of the backend collections, created at startup
+var indexes = map[string][]mongo.IndexModel{
+	// A SUPI identifies a UE profile of a user across all the user's operators.
+	// The index also serves the queries of all profiles of a user.
+	"ue_profiles": {
+		{
+			Keys:    bson.D{{Key: "userId", Value: 1}, {Key: "supi", Value: 1}},
+			Options: options.Index().SetName("userId_supi").SetUnique(true),
+		},
+		// Pages of profiles ordered by creation
+		{
+			Keys:    bson.D{{Key: "userId", Value: 1}, {Key: "createdAt", Value: 1}, {Key: "supi", Value: 1}},
+			Options: options.Index().SetName("userId_createdAt_supi"),
+		},
+		// Results of generation jobs are selected by tag
+		{
+			Keys:    bson.D{{Key: "userId", Value: 1}, {Key: "tags", Value: 1}, {Key: "supi", Value: 1}},
+			Options: options.Index().SetName("userId_tags_supi"),
+		},
+	},
+	"operators": {
+		{
+			Keys:    bson.D{{Key: "userId", Value: 1}},
+			Options: options.Index().SetName("userId"),
+		},
+	},
+	"users": {
+		{
+			Keys:    bson.D{{Key: "username", Value: 1}},
+			Options: options.Index().SetName("username").SetUnique(true),
+		},
+	},
+	"blacklisted_tokens": {
+		{
+			Keys:    bson.D{{Key: "token", Value: 1}},
+			Options: options.Index().SetName("token").SetUnique(true),
+		},
+		// Tokens are removed once they expire
+		{
+			Keys:    bson.D{{Key: "expiresAt", Value: 1}},
+			Options: options.Index().SetName("expiresAt_ttl").SetExpireAfterSeconds(0),
+		},
+	},
+	"jobs": {
+		{
+			Keys:    bson.D{{Key: "userId", Value: 1}, {Key: "createdAt", Value: -1}},
+			Options: options.Index().SetName("userId_createdAt"),
+		},
+		// Unfinished jobs are resumed at startup
+		{
+			Keys:    bson.D{{Key: "status", Value: 1}},
+			Options: options.Index().SetName("status"),
+		},
+	},
+}
+
+// EnsureIndexes creates the indexes of the backend collections, existing indexes are kept.
+// Creating a unique index fails while the collection holds duplicates, which have to be removed first.
+// UE profiles stored before createdAt was recorded get the creation time of their ObjectID.
+func EnsureIndexes(ctx context.Context, db *mongo.Database) error {
+	_, err := db.Collection("ue_profiles").UpdateMany(ctx,
+		bson.M{"createdAt": bson.M{"$exists": false}},
+		bson.A{bson.M{"$set": bson.M{"createdAt": bson.M{"$toDate": "$_id"}}}},
+	)
+	if err != nil {
+		return fmt.Errorf("failed to set createdAt of UE profiles: %v", err)
+	}
+
+	for collection, models := range indexes {
+		if _, err := db.Collection(collection).Indexes().CreateMany(ctx, models); err != nil {
+			return fmt.Errorf("failed to create indexes of %s: %v", collection, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,49 @@
+package database
+
+import (
+	"backend-webUE/config"
+	"context"
+	"database/sql"
+	"fmt"
+	"net/url"
+
+	// database/sql drivers of the SQL storage backends
+	_ "github.com/jackc/pgx/v5/stdlib"
+	_ "modernc.org/sqlite"
+)
+
+// ConnectSQL opens the database of the SQLite or PostgreSQL storage backend
+func ConnectSQL(storageConfig config.StorageConfig) (*sql.DB, error) {
+	var db *sql.DB
+	var err error
+	switch storageConfig.Backend {
+	case config.StorageSqlite:
+		// Enforce foreign keys, wait for the write lock instead of failing right away and
+		// store times in a format that sorts by time
+		params := url.Values{}
+		params.Add("_time_format", "sqlite")
+		params.Add("_pragma", "foreign_keys(1)")
+		params.Add("_pragma", "busy_timeout(5000)")
+		params.Add("_pragma", "journal_mode(WAL)")
+		db, err = sql.Open("sqlite", "file:"+storageConfig.SqlitePath+"?"+params.Encode())
+		if err != nil {
+			return nil, fmt.Errorf("failed to open SQLite database %s: %v", storageConfig.SqlitePath, err)
+		}
+		// SQLite has a single writer, a single connection keeps transactions from failing with SQLITE_BUSY
+		db.SetMaxOpenConns(1)
+	case config.StoragePostgres:
+		db, err = sql.Open("pgx", storageConfig.PostgresUrl)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open PostgreSQL database: %v", err)
+		}
+	default:
+		return nil, fmt.Errorf("unknown SQL storage backend %q", storageConfig.Backend)
+	}
+
+	// Check the connection
+	if err := db.PingContext(context.Background()); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to connect to %s database: %v", storageConfig.Backend, err)
+	}
+	return db, nil
+}
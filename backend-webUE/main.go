@@ -4,109 +4,136 @@ import (
 	"backend-webUE/api"
 	"backend-webUE/config"
 	"backend-webUE/database"
-	"backend-webUE/models"
 	"backend-webUE/router"
 	"backend-webUE/services"
+	"backend-webUE/storage"
 	"backend-webUE/utils"
 	"context"
+	"flag"
 	"fmt"
 	"log"
+
+	"go.mongodb.org/mongo-driver/mongo"
 )
 
 func main() {
 	// Load config
-	mongoConfig, serverConfig, appConfig := config.LoadConfig()
+	mongoConfig, storageConfig, serverConfig, appConfig := config.LoadConfig()
 
-	// Connect to MongoDB
-	db, err := database.Connect(mongoConfig)
+	// Load the operator and core network config, the -config flag takes precedence over UE_GEN_CONFIG
+	operatorConfigPath := flag.String("config", appConfig.OperatorConfigPath, "path of the ue-gen.json operator configuration")
+	flag.Parse()
+	ueGenConfig, err := utils.LoadUeGenConfig(*operatorConfigPath)
 	if err != nil {
-		log.Fatalf("failed to connect to MongoDB: %v", err)
+		log.Fatalf("failed to load operator config: %v", err)
 	}
-	defer func() {
-		if err = db.Client().Disconnect(context.TODO()); err != nil {
-			panic(err)
+
+	// Connect to the storage backend
+	var store *storage.Store
+	switch storageConfig.Backend {
+	case config.StorageMongo:
+		db, err := database.Connect(mongoConfig)
+		if err != nil {
+			log.Fatalf("failed to connect to MongoDB: %v", err)
+		}
+		if err := database.EnsureIndexes(context.Background(), db); err != nil {
+			log.Fatalf("failed to create MongoDB indexes: %v", err)
+		}
+		store = storage.NewMongoStore(db)
+		defer func() {
+			if err = db.Client().Disconnect(context.TODO()); err != nil {
+				panic(err)
+			}
+		}()
+	case config.StorageSqlite, config.StoragePostgres:
+		db, err := database.ConnectSQL(storageConfig)
+		if err != nil {
+			log.Fatalf("failed to connect to storage: %v", err)
 		}
-	}()
+		dialect := storage.SQLDialect(storageConfig.Backend)
+		if err := storage.MigrateSQL(context.Background(), db, dialect); err != nil {
+			log.Fatalf("failed to migrate %s database: %v", storageConfig.Backend, err)
+		}
+		store = storage.NewSQLStore(db, dialect)
+		defer db.Close()
+	default:
+		log.Fatalf("unknown STORAGE_BACKEND %q, must be %s, %s or %s", storageConfig.Backend, config.StorageMongo, config.StorageSqlite, config.StoragePostgres)
+	}
+
+	// Core network targets UE profiles are provisioned into
+	provisioners := services.NewProvisionerRegistry()
 
-	// Initialize OperatorConfig
-	operatorConfig := &utils.OperatorConfig{
-		PlmnId: models.PlmnId{
-			Mcc: "208",
-			Mnc: "93",
-		},
-		Amf: "8000",
-		UeConfiguredNssai: []models.Snssai{
-			{
-				Sst: 1,
-				Sd:  "010203",
-			},
-		},
-		UeDefaultNssai: []models.Snssai{
-			{
-				Sst: 1,
-				Sd:  "010203",
-			},
-		},
-		Profiles: []models.Profile{
-			{
-				Scheme:     1,
-				PrivateKey: "c53c22208b61860b06c62e5406a7b330c2b577aa5558981510d128247d38bd1d",
-				PublicKey:  "5a8d38864820197c3394b92613b20b91633cbd897119273bf8e4a6f4eec0a650",
-			},
-			{
-				Scheme:     2,
-				PrivateKey: "F1AB1074477EBCC7F554EA1C5FC368B1616730155E0041AC447D6301975FECDA",
-				PublicKey:  "0272DA71976234CE833A6907425867B82E074D44EF907DFB4B3E21C1C2256EBCD1",
-			},
-		},
-		GnbSearchList: []string{"10.0.0.2"},
+	// Connect to the free5GC MongoDB UE profiles are provisioned into
+	free5gcConfig := ueGenConfig.Free5gc
+	if free5gcConfig.Enabled {
+		if storageConfig.Backend == config.StorageMongo && free5gcConfig.Name == mongoConfig.Database {
+			log.Fatalf("free5GC database %q must not be the UE profile database", free5gcConfig.Name)
+		}
+		var free5gcDb *mongo.Database
+		if !free5gcConfig.Mock {
+			free5gcDb, err = database.ConnectURI(free5gcConfig.Url, free5gcConfig.Name)
+			if err != nil {
+				log.Fatalf("failed to connect to free5GC MongoDB: %v", err)
+			}
+		}
+		provisioners.Register(services.NewFree5gcProvisioner(free5gcDb, free5gcConfig))
+	}
 
-		Sessions: []models.Sessions{
-			{
-				Type: "IPv4",
-				Apn:  "internet",
-				Slice: models.Snssai{
-					Sst: 1,
-					Sd:  "0x010203",
-				},
-			},
-		},
-		Integrity: models.Integrity{
-			IA1: true,
-			IA2: true,
-			IA3: true,
-		},
-		Ciphering: models.Ciphering{
-			EA1: true,
-			EA2: true,
-			EA3: true,
-		},
-		IntegrityMaxRate: models.IntegrityMaxRate{
-			Uplink:   "full",
-			Downlink: "full",
-		},
-		// Add other necessary configuration fields (if needed)
+	// Connect to the Open5GS MongoDB UE profiles are provisioned into
+	open5gsConfig := ueGenConfig.Open5gs
+	if open5gsConfig.Enabled {
+		if storageConfig.Backend == config.StorageMongo && open5gsConfig.Name == mongoConfig.Database {
+			log.Fatalf("Open5GS database %q must not be the UE profile database", open5gsConfig.Name)
+		}
+		var open5gsDb *mongo.Database
+		if !open5gsConfig.Mock {
+			open5gsDb, err = database.ConnectURI(open5gsConfig.Url, open5gsConfig.Name)
+			if err != nil {
+				log.Fatalf("failed to connect to Open5GS MongoDB: %v", err)
+			}
+		}
+		provisioners.Register(services.NewOpen5gsProvisioner(open5gsDb, open5gsConfig))
+	}
 
+	// UDR UE profiles are provisioned into through Nudr_DataRepository
+	nudrConfig := ueGenConfig.Nudr
+	if nudrConfig.Enabled {
+		provisioners.Register(services.NewNudrProvisioner(nudrConfig))
 	}
 
-	// Create Operator
-	operator := utils.NewOperator(operatorConfig)
+	// Create default Operator
+	operator := utils.NewOperator(ueGenConfig.Operator)
 
 	// Initialize services
-	ueProfileService := services.NewUeProfileService(db, operator)
-	userService := services.NewUserService(db)
+	operatorService := services.NewOperatorService(store.Operators, operator)
+	ueProfileService := services.NewUeProfileService(store.UeProfiles, store.Msins, operatorService, provisioners)
+	suciService := services.NewSuciService(operatorService)
+	authService := services.NewAuthService(ueProfileService)
+	exportService := services.NewExportService(ueProfileService)
+	coreImportService := services.NewCoreImportService(ueProfileService, operatorService)
+	bulkImportService := services.NewBulkImportService(ueProfileService, operatorService)
+	userService := services.NewUserService(store.Users, store.Tokens)
+	jobService := services.NewJobService(store.Jobs, ueProfileService, appConfig.JobWorkers, appConfig.JobBatchSize)
+	if err := jobService.Start(context.Background()); err != nil {
+		log.Fatalf("failed to start job workers: %v", err)
+	}
 
 	// Initialize API
 	ueProfileAPI := api.NewUeProfileAPI(ueProfileService)
+	operatorAPI := api.NewOperatorAPI(operatorService)
+	suciAPI := api.NewSuciAPI(suciService)
+	authAPI := api.NewAuthAPI(authService)
+	exportAPI := api.NewExportAPI(exportService)
+	importAPI := api.NewImportAPI(coreImportService, bulkImportService)
+	jobAPI := api.NewJobAPI(jobService, exportService)
 	userAPI := api.NewUserAPI(userService, appConfig.JWTSecret)
 
 	// Initialize router
-	router := router.SetupRouter(ueProfileAPI, userAPI, userService, serverConfig, appConfig.JWTSecret)
+	router := router.SetupRouter(ueProfileAPI, operatorAPI, suciAPI, authAPI, exportAPI, importAPI, jobAPI, userAPI, userService, serverConfig, appConfig.JWTSecret)
 
 	// Run web server
 	err = router.Run(fmt.Sprintf(":%d", serverConfig.Port))
 	if err != nil {
 		log.Fatalf("failed to run web server: %v", err)
 	}
-	fmt.Println("JWT Secret in main.go:", appConfig.JWTSecret)
 }
@@ -12,7 +12,6 @@ import (
 )
 
 func AuthMiddleware(userService *services.UserService, jwtSecret string) gin.HandlerFunc {
-	fmt.Println("JWT Secret in AuthMiddleware:", jwtSecret)
 	return func(c *gin.Context) {
 		authHeader := c.GetHeader("Authorization")
 		if authHeader == "" {
@@ -25,7 +24,6 @@ func AuthMiddleware(userService *services.UserService, jwtSecret string) gin.Han
 			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Bearer token malformed"})
 			return
 		}
-		fmt.Println("Token String in AuthMiddleware:", tokenString)
 
 		//Parse the token
 		token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
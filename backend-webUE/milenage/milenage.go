@@ -0,0 +1,156 @@
+// Package milenage implements the 3GPP MILENAGE authentication and key generation
+// functions f1, f1*, f2, f3, f4, f5 and f5* (TS 35.205, TS 35.206).
+package milenage
+
+import (
+	"crypto/aes"
+	"encoding/hex"
+	"fmt"
+)
+
+// Lengths of the MILENAGE inputs and outputs
+const (
+	KeyLen  = 16 // octets, K, OP and OPc
+	RandLen = 16 // octets
+	SqnLen  = 6  // octets
+	AmfLen  = 2  // octets
+	MacLen  = 8  // octets, MAC-A and MAC-S
+	ResLen  = 8  // octets
+	AkLen   = 6  // octets, AK and AK*
+)
+
+// Rotation (in octets) and constant (last octet) of each OUTn block, TS 35.206 clause 4.1
+const (
+	r1, r2, r3, r4, r5 = 8, 0, 4, 8, 12
+	c1, c2, c3, c4, c5 = byte(0), byte(1), byte(2), byte(4), byte(8)
+)
+
+func checkLen(name string, b []byte, n int) error {
+	if len(b) != n {
+		return fmt.Errorf("milenage: %s must be %d octets, got %d", name, n, len(b))
+	}
+	return nil
+}
+
+func xor(a, b []byte) []byte {
+	out := make([]byte, len(a))
+	for i := range a {
+		out[i] = a[i] ^ b[i]
+	}
+	return out
+}
+
+// rotate rotates a 128-bit block cyclically to the left by r octets
+func rotate(x []byte, r int) []byte {
+	out := make([]byte, len(x))
+	for i := range x {
+		out[i] = x[(i+r)%len(x)]
+	}
+	return out
+}
+
+func encrypt(k, in []byte) []byte {
+	block, _ := aes.NewCipher(k)
+	out := make([]byte, aes.BlockSize)
+	block.Encrypt(out, in)
+	return out
+}
+
+// GenerateOPc derives OPc = E_K(OP) xor OP
+func GenerateOPc(k, op []byte) ([]byte, error) {
+	if err := checkLen("K", k, KeyLen); err != nil {
+		return nil, err
+	}
+	if err := checkLen("OP", op, KeyLen); err != nil {
+		return nil, err
+	}
+	return xor(encrypt(k, op), op), nil
+}
+
+// temp computes TEMP = E_K(RAND xor OPc)
+func temp(opc, k, rand []byte) ([]byte, error) {
+	if err := checkLen("K", k, KeyLen); err != nil {
+		return nil, err
+	}
+	if err := checkLen("OPc", opc, KeyLen); err != nil {
+		return nil, err
+	}
+	if err := checkLen("RAND", rand, RandLen); err != nil {
+		return nil, err
+	}
+	return encrypt(k, xor(rand, opc)), nil
+}
+
+// out computes OUTn = E_K(rot(TEMP xor OPc, r) xor c) xor OPc for n = 2..5
+func out(opc, k, temp []byte, r int, c byte) []byte {
+	in := rotate(xor(temp, opc), r)
+	in[len(in)-1] ^= c
+	return xor(encrypt(k, in), opc)
+}
+
+// F1 computes the network authentication code MAC-A (f1) and the resynchronisation code MAC-S (f1*)
+func F1(opc, k, rand, sqn, amf []byte) (macA, macS []byte, err error) {
+	t, err := temp(opc, k, rand)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := checkLen("SQN", sqn, SqnLen); err != nil {
+		return nil, nil, err
+	}
+	if err := checkLen("AMF", amf, AmfLen); err != nil {
+		return nil, nil, err
+	}
+
+	// IN1 = SQN || AMF || SQN || AMF
+	in1 := make([]byte, 0, KeyLen)
+	in1 = append(in1, sqn...)
+	in1 = append(in1, amf...)
+	in1 = append(in1, sqn...)
+	in1 = append(in1, amf...)
+
+	in := xor(t, rotate(xor(in1, opc), r1))
+	in[len(in)-1] ^= c1
+	out1 := xor(encrypt(k, in), opc)
+	return out1[:MacLen], out1[MacLen:], nil
+}
+
+// F2345 computes the response RES (f2), the cipher key CK (f3), the integrity key IK (f4)
+// and the anonymity key AK (f5)
+func F2345(opc, k, rand []byte) (res, ck, ik, ak []byte, err error) {
+	t, err := temp(opc, k, rand)
+	if err != nil {
+		return nil, nil, nil, nil, err
+	}
+	out2 := out(opc, k, t, r2, c2)
+	res = out2[8:16]
+	ak = out2[:AkLen]
+	ck = out(opc, k, t, r3, c3)
+	ik = out(opc, k, t, r4, c4)
+	return res, ck, ik, ak, nil
+}
+
+// F5Star computes the anonymity key AK* used to conceal SQN in resynchronisation (f5*)
+func F5Star(opc, k, rand []byte) ([]byte, error) {
+	t, err := temp(opc, k, rand)
+	if err != nil {
+		return nil, err
+	}
+	return out(opc, k, t, r5, c5)[:AkLen], nil
+}
+
+// GenerateOPcHex is GenerateOPc over hex strings, as stored in UE profiles
+func GenerateOPcHex(k, op string) (string, error) {
+	kBytes, err := hex.DecodeString(k)
+	if err != nil {
+		return "", fmt.Errorf("milenage: invalid K: %v", err)
+	}
+	opBytes, err := hex.DecodeString(op)
+	if err != nil {
+		return "", fmt.Errorf("milenage: invalid OP: %v", err)
+	}
+	opc, err := GenerateOPc(kBytes, opBytes)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(opc), nil
+}
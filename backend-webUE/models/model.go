@@ -12,6 +12,14 @@ type UeProfile struct {
 
 	UserID primitive.ObjectID `json:"userId,omitempty" bson:"userId,omitempty"`
 
+	// Operator the UE was generated for, empty for the default operator
+	OperatorID primitive.ObjectID `json:"operatorId,omitempty" bson:"operatorId,omitempty"`
+
+	// Time the profile was stored, set by the storage
+	CreatedAt time.Time `json:"createdAt" bson:"createdAt"`
+	// Labels to group and filter profiles by
+	Tags []string `json:"tags,omitempty" bson:"tags,omitempty"`
+
 	// IMSI number of the UE. IMSI = [MCC|MNC|MSISDN]
 	Supi string `json:"supi" bson:"supi"`
 	Suci string `json:"suci" bson:"suci"`
@@ -33,8 +41,14 @@ type UeProfile struct {
 	// Operator code (OP or OPC) of the UE
 	Op     string `json:"op" bson:"op"`
 	OpType string `json:"opType" bson:"opType"`
+	// OPc derived from Key and Op with MILENAGE
+	Opc string `json:"opc" bson:"opc"`
 	// Authentication Management Field (AMF) value
 	Amf string `json:"amf" bson:"amf"`
+	// Last sequence number used by the home network (SQN_HE), 12 hex digits
+	Sqn string `json:"sqn" bson:"sqn"`
+	// Authentication method provisioned in the core: 5G_AKA or EAP_AKA_PRIME
+	AuthenticationMethod string `json:"authenticationMethod" bson:"authenticationMethod"`
 
 	Imei   string `json:"imei" bson:"imei"`
 	Imeisv string `json:"imeiSv" bson:"imeiSv"`
@@ -55,6 +69,52 @@ type UeProfile struct {
 	Sessions []Sessions `json:"sessions" bson:"sessions"`
 
 	IntegrityMaxRate IntegrityMaxRate `json:"integrityMaxRate" bson:"integrityMaxRate"`
+
+	// Sync status per core network target the UE has been pushed to, keyed by provisioner name
+	Provisioning map[string]ProvisionStatus `json:"provisioning,omitempty" bson:"provisioning,omitempty"`
+}
+
+// Sync status of a UE profile in one core network target
+type ProvisionStatus struct {
+	// Last push attempt
+	UpdatedAt time.Time `json:"updatedAt" bson:"updatedAt"`
+	// Last successful push
+	ProvisionedAt *time.Time `json:"provisionedAt,omitempty" bson:"provisionedAt,omitempty"`
+	// Whether the last push failed, with the reason
+	Failed bool   `json:"failed" bson:"failed"`
+	Error  string `json:"error,omitempty" bson:"error,omitempty"`
+	// Last verification against the target and whether the target matched the profile
+	VerifiedAt *time.Time `json:"verifiedAt,omitempty" bson:"verifiedAt,omitempty"`
+	InSync     bool       `json:"inSync" bson:"inSync"`
+}
+
+// Operator definition (PLMN) used to generate UE profiles
+type Operator struct {
+	ID     primitive.ObjectID `json:"id,omitempty" bson:"_id,omitempty"`
+	UserID primitive.ObjectID `json:"userId,omitempty" bson:"userId,omitempty"`
+	Name   string             `json:"name" bson:"name"`
+
+	PlmnId PlmnId `json:"plmnid" bson:"plmnid"`
+	// Authentication Management Field (AMF) value
+	Amf string `json:"amf" bson:"amf"`
+	// Operator variant configuration field shared by the UEs generated with OpType OP
+	Op string `json:"op" bson:"op"`
+	// Routing indicator of the SUCIs of generated UEs, 1 to 4 digits, "0000" when empty
+	RoutingIndicator string `json:"routingIndicator,omitempty" bson:"routingIndicator,omitempty"`
+	// First MSIN allocated to generated UEs, 0 when empty
+	MsinStart       string   `json:"msinStart,omitempty" bson:"msinStart,omitempty"`
+	ConfiguredNssai []Snssai `json:"configuredNssai" bson:"configuredNssai"`
+	DefaultNssai    []Snssai `json:"defaultNssai" bson:"defaultNssai"`
+	// Home network key profiles used for SUCI concealment
+	Profiles      []Profile  `json:"profiles" bson:"profiles"`
+	Sessions      []Sessions `json:"sessions" bson:"sessions"`
+	GnbSearchList []string   `json:"gnbSearchList" bson:"gnbSearchList"`
+
+	UacAic           UacAic           `json:"uacAic" bson:"uacAic"`
+	UacAcc           UacAcc           `json:"uacAcc" bson:"uacAcc"`
+	Integrity        Integrity        `json:"integrity" bson:"integrity"`
+	Ciphering        Ciphering        `json:"ciphering" bson:"ciphering"`
+	IntegrityMaxRate IntegrityMaxRate `json:"integrityMaxRate" bson:"integrityMaxRate"`
 }
 
 type PlmnId struct {
@@ -63,7 +123,9 @@ type PlmnId struct {
 }
 
 type Profile struct {
-	Scheme     int    `json:"scheme" bson:"scheme"`
+	Scheme int `json:"scheme" bson:"scheme"`
+	// Home network public key identifier, defaults to the scheme number
+	KeyId      int    `json:"keyId" bson:"keyId"`
 	PrivateKey string `json:"privateKey" bson:"privateKey"`
 	PublicKey  string `json:"publicKey" bson:"publicKey"`
 }
@@ -123,3 +185,61 @@ type BlacklistedToken struct {
 	Token     string             `bson:"token"`
 	ExpiresAt time.Time          `bson:"expiresAt"`
 }
+
+// Statuses of a job, queued and running jobs are unfinished
+const (
+	JobQueued    = "queued"
+	JobRunning   = "running"
+	JobSucceeded = "succeeded"
+	JobFailed    = "failed"
+	JobCancelled = "cancelled"
+)
+
+// Job is an asynchronous generation of UE profiles, run in batches by a worker
+type Job struct {
+	ID     primitive.ObjectID `json:"id,omitempty" bson:"_id,omitempty"`
+	UserID primitive.ObjectID `json:"userId,omitempty" bson:"userId,omitempty"`
+	Status string             `json:"status" bson:"status"`
+	Params GenerateJobParams  `json:"params" bson:"params"`
+
+	// UE profiles generated and stored so far, of Params.Num
+	Done int `json:"done" bson:"done"`
+	// Tag of the UE profiles generated by the job, for exporting the results
+	ResultTag string `json:"resultTag" bson:"resultTag"`
+	// Reason the job failed
+	Error string `json:"error,omitempty" bson:"error,omitempty"`
+	// Failed pushes to provisioning targets, the generated profiles are stored regardless
+	Warnings []string `json:"warnings,omitempty" bson:"warnings,omitempty"`
+
+	CreatedAt  time.Time  `json:"createdAt" bson:"createdAt"`
+	StartedAt  *time.Time `json:"startedAt,omitempty" bson:"startedAt,omitempty"`
+	FinishedAt *time.Time `json:"finishedAt,omitempty" bson:"finishedAt,omitempty"`
+}
+
+// Finished reports whether the job succeeded, failed or was cancelled
+func (j *Job) Finished() bool {
+	return j.Status != JobQueued && j.Status != JobRunning
+}
+
+// GenerateJobParams are the settings of a generation job
+type GenerateJobParams struct {
+	// Operator to generate for, empty for the default operator
+	OperatorID primitive.ObjectID `json:"operatorId,omitempty" bson:"operatorId,omitempty"`
+	Num        int                `json:"num" bson:"num"`
+	// Relative weight of each protection scheme
+	Schemes              SchemeWeights `json:"schemes" bson:"schemes"`
+	OpType               string        `json:"opType" bson:"opType"`
+	AuthenticationMethod string        `json:"authenticationMethod" bson:"authenticationMethod"`
+	// Provisioning targets, none when empty
+	Targets []string `json:"targets" bson:"targets"`
+	// First MSIN of an explicit range of Num MSINs, nil to allocate them sequentially
+	MsinStart *uint64  `json:"msinStart,omitempty" bson:"msinStart,omitempty"`
+	Tags      []string `json:"tags,omitempty" bson:"tags,omitempty"`
+}
+
+// SchemeWeights mirrors utils.SchemeMix
+type SchemeWeights struct {
+	Null int `json:"null" bson:"null"`
+	A    int `json:"A" bson:"A"`
+	B    int `json:"B" bson:"B"`
+}
@@ -11,7 +11,7 @@ import (
 	"github.com/gin-gonic/gin"
 )
 
-func SetupRouter(ueProfileAPI *api.UeProfileAPI, userAPI *api.UserAPI, userService *services.UserService, serverConfig config.ServerConfig, jwtSecret string) *gin.Engine {
+func SetupRouter(ueProfileAPI *api.UeProfileAPI, operatorAPI *api.OperatorAPI, suciAPI *api.SuciAPI, authAPI *api.AuthAPI, exportAPI *api.ExportAPI, importAPI *api.ImportAPI, jobAPI *api.JobAPI, userAPI *api.UserAPI, userService *services.UserService, serverConfig config.ServerConfig, jwtSecret string) *gin.Engine {
 
 	// Initialize router
 	router := gin.Default()
@@ -21,7 +21,7 @@ func SetupRouter(ueProfileAPI *api.UeProfileAPI, userAPI *api.UserAPI, userServi
 		AllowOrigins:     []string{"http://localhost:3000"},
 		AllowMethods:     []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
 		AllowHeaders:     []string{"Authorization", "Content-Type"},
-		ExposeHeaders:    []string{"Content-Length"},
+		ExposeHeaders:    []string{"Content-Length", "X-Total-Count", "X-Next-Cursor", "Location"},
 		AllowCredentials: true,
 		MaxAge:           12 * time.Hour,
 	}))
@@ -34,6 +34,12 @@ func SetupRouter(ueProfileAPI *api.UeProfileAPI, userAPI *api.UserAPI, userServi
 	protected.Use(middleware.AuthMiddleware(userService, jwtSecret))
 
 	ueProfileAPI.RegisterRoutes(protected)
+	operatorAPI.RegisterRoutes(protected)
+	suciAPI.RegisterRoutes(protected)
+	authAPI.RegisterRoutes(protected)
+	exportAPI.RegisterRoutes(protected)
+	importAPI.RegisterRoutes(protected)
+	jobAPI.RegisterRoutes(protected)
 
 	return router
 }
@@ -0,0 +1,407 @@
+package services
+
+import (
+	"backend-webUE/aka"
+	"backend-webUE/milenage"
+	"backend-webUE/models"
+	"backend-webUE/utils"
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var (
+	ErrUeProfileNotFound    = errors.New("UE profile not found")
+	ErrInvalidAuthParameter = errors.New("invalid authentication parameter")
+	ErrSqnConflict          = errors.New("SQN changed concurrently, retry")
+)
+
+// maxSqnUpdateAttempts bounds the compare-and-set retries of an SQN update
+const maxSqnUpdateAttempts = 5
+
+// Av5GAka is the hex encoded 5G-AKA authentication vector returned by the mock ARPF
+type Av5GAka struct {
+	Supi               string `json:"supi"`
+	ServingNetworkName string `json:"servingNetworkName"`
+	Sqn                string `json:"sqn"`
+	Rand               string `json:"rand"`
+	Autn               string `json:"autn"`
+	XresStar           string `json:"xresStar"`
+	HxresStar          string `json:"hxresStar"`
+	Kausf              string `json:"kausf"`
+	Kseaf              string `json:"kseaf"`
+	// KAMF for ABBA 0x0000
+	Kamf string `json:"kamf"`
+}
+
+// AvEapAkaPrime is the hex encoded EAP-AKA' authentication vector returned by the mock ARPF
+type AvEapAkaPrime struct {
+	Supi     string `json:"supi"`
+	Identity string `json:"identity"`
+	// AT_KDF_INPUT, the serving network name
+	KdfInput string `json:"kdfInput"`
+	Sqn      string `json:"sqn"`
+	Rand     string `json:"rand"`
+	Autn     string `json:"autn"`
+	Xres     string `json:"xres"`
+	CkPrime  string `json:"ckPrime"`
+	IkPrime  string `json:"ikPrime"`
+	Msk      string `json:"msk"`
+	Emsk     string `json:"emsk"`
+	Kausf    string `json:"kausf"`
+	Kseaf    string `json:"kseaf"`
+}
+
+// ResStarVerification is the outcome of comparing a UE's RES* with XRES*
+type ResStarVerification struct {
+	Supi     string `json:"supi"`
+	Verified bool   `json:"verified"`
+	ResStar  string `json:"resStar"`
+	XresStar string `json:"xresStar"`
+}
+
+// AuthService acts as a mock ARPF over the K, OPc and AMF stored in UE profiles
+type AuthService struct {
+	ueProfiles *UeProfileService
+}
+
+func NewAuthService(ueProfiles *UeProfileService) *AuthService {
+	return &AuthService{
+		ueProfiles: ueProfiles,
+	}
+}
+
+// decodeHexParam decodes a hex request parameter of a fixed length
+func decodeHexParam(name, value string, length int) ([]byte, error) {
+	b, err := hex.DecodeString(value)
+	if err != nil || len(b) != length {
+		return nil, fmt.Errorf("%w: %s must be %d hex digits", ErrInvalidAuthParameter, name, 2*length)
+	}
+	return b, nil
+}
+
+// profileOpc returns the OPc of a profile. Profiles created before OPc was stored keep it in Op when OpType is OPC.
+func profileOpc(ueProfile *models.UeProfile) (string, error) {
+	switch {
+	case ueProfile.Opc != "":
+		return ueProfile.Opc, nil
+	case ueProfile.OpType == utils.OPC:
+		return ueProfile.Op, nil
+	default:
+		return milenage.GenerateOPcHex(ueProfile.Key, ueProfile.Op)
+	}
+}
+
+// subscriber loads a UE profile and decodes its long term authentication data
+func (s *AuthService) subscriber(ctx context.Context, userID primitive.ObjectID, supi string) (*models.UeProfile, *aka.Subscriber, error) {
+	ueProfile, err := s.ueProfiles.GetUeProfile(ctx, userID, supi)
+	if err != nil {
+		return nil, nil, err
+	}
+	if ueProfile == nil {
+		return nil, nil, ErrUeProfileNotFound
+	}
+
+	opc, err := profileOpc(ueProfile)
+	if err != nil {
+		return nil, nil, fmt.Errorf("UE profile has no usable OP/OPc: %v", err)
+	}
+	var sub aka.Subscriber
+	if sub.K, err = hex.DecodeString(ueProfile.Key); err != nil || len(sub.K) != milenage.KeyLen {
+		return nil, nil, fmt.Errorf("UE profile has an invalid key")
+	}
+	if sub.Opc, err = hex.DecodeString(opc); err != nil || len(sub.Opc) != milenage.KeyLen {
+		return nil, nil, fmt.Errorf("UE profile has an invalid OPc")
+	}
+	if sub.Amf, err = hex.DecodeString(ueProfile.Amf); err != nil || len(sub.Amf) != milenage.AmfLen {
+		return nil, nil, fmt.Errorf("UE profile has an invalid AMF")
+	}
+	return ueProfile, &sub, nil
+}
+
+// servingNetworkName defaults to the home network of the profile
+func servingNetworkName(ueProfile *models.UeProfile, snn string) (string, error) {
+	if snn == "" {
+		return aka.ServingNetworkName(ueProfile.PlmnId.Mcc, ueProfile.PlmnId.Mnc), nil
+	}
+	if err := aka.ValidateServingNetworkName(snn); err != nil {
+		return "", fmt.Errorf("%w: %v", ErrInvalidAuthParameter, err)
+	}
+	return snn, nil
+}
+
+// storedSqn returns the SQN of a profile, profiles created before SQN tracking start from aka.InitialSqn
+func storedSqn(ueProfile *models.UeProfile) ([]byte, error) {
+	if ueProfile.Sqn == "" {
+		return hex.DecodeString(aka.InitialSqn)
+	}
+	sqn, err := hex.DecodeString(ueProfile.Sqn)
+	if err != nil || len(sqn) != milenage.SqnLen {
+		return nil, fmt.Errorf("UE profile has an invalid SQN %q", ueProfile.Sqn)
+	}
+	return sqn, nil
+}
+
+// advanceSqn atomically adds delta to the stored SQN of a profile and returns the new SQN
+func (s *AuthService) advanceSqn(ctx context.Context, userID primitive.ObjectID, ueProfile *models.UeProfile, delta uint64) ([]byte, error) {
+	for attempt := 0; attempt < maxSqnUpdateAttempts; attempt++ {
+		if attempt > 0 {
+			var err error
+			if ueProfile, err = s.ueProfiles.GetUeProfile(ctx, userID, ueProfile.Supi); err != nil {
+				return nil, err
+			}
+			if ueProfile == nil {
+				return nil, ErrUeProfileNotFound
+			}
+		}
+		sqn, err := storedSqn(ueProfile)
+		if err != nil {
+			return nil, err
+		}
+		next := aka.AdvanceSqn(sqn, delta)
+		updated, err := s.ueProfiles.repo.SetSqn(ctx, userID, ueProfile.Supi, &ueProfile.Sqn, hex.EncodeToString(next))
+		if err != nil {
+			return nil, err
+		}
+		if updated {
+			return next, nil
+		}
+	}
+	return nil, ErrSqnConflict
+}
+
+// vectorInput holds the decoded inputs of an authentication vector generation
+type vectorInput struct {
+	ueProfile *models.UeProfile
+	sub       *aka.Subscriber
+	sqn       []byte
+	// nil draws a random RAND
+	rand []byte
+	snn  string
+}
+
+// vectorInput decodes the request parameters of a vector generation and loads the subscriber.
+// An empty sqnHex advances and uses the stored SQN of the profile, an empty randHex draws a random RAND,
+// an empty snn uses the home network of the profile.
+func (s *AuthService) vectorInput(ctx context.Context, userID primitive.ObjectID, supi, sqnHex, randHex, snn string) (*vectorInput, error) {
+	var in vectorInput
+	var err error
+	if sqnHex != "" {
+		if in.sqn, err = decodeHexParam("sqn", sqnHex, milenage.SqnLen); err != nil {
+			return nil, err
+		}
+	}
+	if randHex != "" {
+		if in.rand, err = decodeHexParam("rand", randHex, milenage.RandLen); err != nil {
+			return nil, err
+		}
+	}
+
+	if in.ueProfile, in.sub, err = s.subscriber(ctx, userID, supi); err != nil {
+		return nil, err
+	}
+	if in.snn, err = servingNetworkName(in.ueProfile, snn); err != nil {
+		return nil, err
+	}
+	if in.sqn == nil {
+		if in.sqn, err = s.advanceSqn(ctx, userID, in.ueProfile, 1); err != nil {
+			return nil, err
+		}
+	}
+	return &in, nil
+}
+
+// Generate5GAkaVector computes a 5G-AKA vector for a UE profile, see vectorInput for the defaults
+func (s *AuthService) Generate5GAkaVector(ctx context.Context, userID primitive.ObjectID, supi, sqnHex, randHex, snn string) (*Av5GAka, error) {
+	in, err := s.vectorInput(ctx, userID, supi, sqnHex, randHex, snn)
+	if err != nil {
+		return nil, err
+	}
+	sqn, snn := in.sqn, in.snn
+
+	av, err := in.sub.Generate5GAka(sqn, in.rand, snn)
+	if err != nil {
+		return nil, err
+	}
+	return &Av5GAka{
+		Supi:               supi,
+		ServingNetworkName: snn,
+		Sqn:                hex.EncodeToString(sqn),
+		Rand:               hex.EncodeToString(av.Rand),
+		Autn:               hex.EncodeToString(av.Autn),
+		XresStar:           hex.EncodeToString(av.XresStar),
+		HxresStar:          hex.EncodeToString(av.HxresStar),
+		Kausf:              hex.EncodeToString(av.Kausf),
+		Kseaf:              hex.EncodeToString(av.Kseaf),
+		Kamf:               hex.EncodeToString(aka.Kamf(av.Kseaf, kamfSupi(supi), aka.DefaultAbba)),
+	}, nil
+}
+
+// kamfSupi is the SUPI input of the KAMF derivation, the IMSI or NAI without its type prefix
+func kamfSupi(supi string) string {
+	if imsi, ok := strings.CutPrefix(supi, "imsi-"); ok {
+		return imsi
+	}
+	return strings.TrimPrefix(supi, "nai-")
+}
+
+// GenerateEapAkaPrimeVector computes an EAP-AKA' vector for a UE profile, see vectorInput for the defaults.
+// An empty identity uses the SUPI of the profile in the MK derivation.
+func (s *AuthService) GenerateEapAkaPrimeVector(ctx context.Context, userID primitive.ObjectID, supi, sqnHex, randHex, snn, identity string) (*AvEapAkaPrime, error) {
+	in, err := s.vectorInput(ctx, userID, supi, sqnHex, randHex, snn)
+	if err != nil {
+		return nil, err
+	}
+	if identity == "" {
+		identity = supi
+	}
+
+	av, err := in.sub.GenerateEapAkaPrime(in.sqn, in.rand, in.snn, identity)
+	if err != nil {
+		return nil, err
+	}
+	return &AvEapAkaPrime{
+		Supi:     supi,
+		Identity: identity,
+		KdfInput: av.KdfInput,
+		Sqn:      hex.EncodeToString(in.sqn),
+		Rand:     hex.EncodeToString(av.Rand),
+		Autn:     hex.EncodeToString(av.Autn),
+		Xres:     hex.EncodeToString(av.Xres),
+		CkPrime:  hex.EncodeToString(av.CkPrime),
+		IkPrime:  hex.EncodeToString(av.IkPrime),
+		Msk:      hex.EncodeToString(av.Msk),
+		Emsk:     hex.EncodeToString(av.Emsk),
+		Kausf:    hex.EncodeToString(av.Kausf),
+		Kseaf:    hex.EncodeToString(av.Kseaf),
+	}, nil
+}
+
+// Verify5GAka checks the RES* a UE computed for RAND against the expected XRES*
+func (s *AuthService) Verify5GAka(ctx context.Context, userID primitive.ObjectID, supi, randHex, resStarHex, snn string) (*ResStarVerification, error) {
+	rand, err := decodeHexParam("rand", randHex, milenage.RandLen)
+	if err != nil {
+		return nil, err
+	}
+	resStar, err := decodeHexParam("res_star", resStarHex, 16)
+	if err != nil {
+		return nil, err
+	}
+
+	ueProfile, sub, err := s.subscriber(ctx, userID, supi)
+	if err != nil {
+		return nil, err
+	}
+	if snn, err = servingNetworkName(ueProfile, snn); err != nil {
+		return nil, err
+	}
+
+	verified, xresStar, err := sub.VerifyResStar(rand, resStar, snn)
+	if err != nil {
+		return nil, err
+	}
+	return &ResStarVerification{
+		Supi:     supi,
+		Verified: verified,
+		ResStar:  hex.EncodeToString(resStar),
+		XresStar: hex.EncodeToString(xresStar),
+	}, nil
+}
+
+// SqnState is the stored home network SQN of a UE profile
+type SqnState struct {
+	Supi string `json:"supi"`
+	Sqn  string `json:"sqn"`
+}
+
+// GetSqn returns the stored SQN of a UE profile
+func (s *AuthService) GetSqn(ctx context.Context, userID primitive.ObjectID, supi string) (*SqnState, error) {
+	ueProfile, err := s.ueProfiles.GetUeProfile(ctx, userID, supi)
+	if err != nil {
+		return nil, err
+	}
+	if ueProfile == nil {
+		return nil, ErrUeProfileNotFound
+	}
+	sqn, err := storedSqn(ueProfile)
+	if err != nil {
+		return nil, err
+	}
+	return &SqnState{Supi: supi, Sqn: hex.EncodeToString(sqn)}, nil
+}
+
+// AdvanceSqn adds delta to the stored SQN of a UE profile
+func (s *AuthService) AdvanceSqn(ctx context.Context, userID primitive.ObjectID, supi string, delta uint64) (*SqnState, error) {
+	if delta == 0 {
+		return nil, fmt.Errorf("%w: delta must be positive", ErrInvalidAuthParameter)
+	}
+	ueProfile, err := s.ueProfiles.GetUeProfile(ctx, userID, supi)
+	if err != nil {
+		return nil, err
+	}
+	if ueProfile == nil {
+		return nil, ErrUeProfileNotFound
+	}
+	sqn, err := s.advanceSqn(ctx, userID, ueProfile, delta)
+	if err != nil {
+		return nil, err
+	}
+	return &SqnState{Supi: supi, Sqn: hex.EncodeToString(sqn)}, nil
+}
+
+// ResetSqn overwrites the stored SQN of a UE profile, an empty sqnHex resets it to aka.InitialSqn
+func (s *AuthService) ResetSqn(ctx context.Context, userID primitive.ObjectID, supi, sqnHex string) (*SqnState, error) {
+	if sqnHex == "" {
+		sqnHex = aka.InitialSqn
+	}
+	sqn, err := decodeHexParam("sqn", sqnHex, milenage.SqnLen)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.storeSqn(ctx, userID, supi, sqn); err != nil {
+		return nil, err
+	}
+	return &SqnState{Supi: supi, Sqn: hex.EncodeToString(sqn)}, nil
+}
+
+// ResyncSqn recovers SQN_MS from the AUTS of a synchronisation failure and stores it as the
+// home network SQN, so the next vector uses SQN_MS + 1 (TS 33.102 clause 6.3.5)
+func (s *AuthService) ResyncSqn(ctx context.Context, userID primitive.ObjectID, supi, randHex, autsHex string) (*SqnState, error) {
+	rand, err := decodeHexParam("rand", randHex, milenage.RandLen)
+	if err != nil {
+		return nil, err
+	}
+	auts, err := decodeHexParam("auts", autsHex, aka.AutsLen)
+	if err != nil {
+		return nil, err
+	}
+
+	_, sub, err := s.subscriber(ctx, userID, supi)
+	if err != nil {
+		return nil, err
+	}
+	sqnMs, err := sub.Resync(rand, auts)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.storeSqn(ctx, userID, supi, sqnMs); err != nil {
+		return nil, err
+	}
+	return &SqnState{Supi: supi, Sqn: hex.EncodeToString(sqnMs)}, nil
+}
+
+// storeSqn unconditionally stores the SQN of a UE profile
+func (s *AuthService) storeSqn(ctx context.Context, userID primitive.ObjectID, supi string, sqn []byte) error {
+	updated, err := s.ueProfiles.repo.SetSqn(ctx, userID, supi, nil, hex.EncodeToString(sqn))
+	if err != nil {
+		return err
+	}
+	if !updated {
+		return ErrUeProfileNotFound
+	}
+	return nil
+}
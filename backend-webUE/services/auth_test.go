@@ -0,0 +1,230 @@
+package services
+
+import (
+	"backend-webUE/aka"
+	"backend-webUE/milenage"
+	"context"
+	"encoding/hex"
+	"errors"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const testAuthRand = "23553cbe9637a89d218ae64dae47bf35"
+
+// newTestAuthService returns an auth service and the SUPI of one UE profile generated for userID
+func newTestAuthService(t *testing.T, userID primitive.ObjectID) (*AuthService, string) {
+	t.Helper()
+	s, _ := newTestUeProfileService(t)
+	ueProfiles, err := s.GenerateUeProfiles(context.Background(), userID, generateParams(1))
+	if err != nil {
+		t.Fatalf("GenerateUeProfiles: %v", err)
+	}
+	return NewAuthService(s), ueProfiles[0].Supi
+}
+
+// testUsim returns the long term authentication data of a UE profile, as the USIM holds it
+func testUsim(t *testing.T, auth *AuthService, userID primitive.ObjectID, supi string) *aka.Subscriber {
+	t.Helper()
+	_, sub, err := auth.subscriber(context.Background(), userID, supi)
+	if err != nil {
+		t.Fatalf("subscriber: %v", err)
+	}
+	return sub
+}
+
+// testUsimResStar computes the RES* the USIM returns for RAND, TS 33.501 Annex A.4
+func testUsimResStar(t *testing.T, usim *aka.Subscriber, randHex, snn string) string {
+	t.Helper()
+	rand, _ := hex.DecodeString(randHex)
+	res, ck, ik, _, err := milenage.F2345(usim.Opc, usim.K, rand)
+	if err != nil {
+		t.Fatalf("F2345: %v", err)
+	}
+	return hex.EncodeToString(aka.XresStar(ck, ik, snn, rand, res))
+}
+
+// testUsimAuts computes the AUTS the USIM sends for SQN_MS, TS 33.102 clause 6.3.3
+func testUsimAuts(t *testing.T, usim *aka.Subscriber, randHex, sqnMsHex string) string {
+	t.Helper()
+	rand, _ := hex.DecodeString(randHex)
+	sqnMs, _ := hex.DecodeString(sqnMsHex)
+	akStar, err := milenage.F5Star(usim.Opc, usim.K, rand)
+	if err != nil {
+		t.Fatalf("F5Star: %v", err)
+	}
+	_, macS, err := milenage.F1(usim.Opc, usim.K, rand, sqnMs, []byte{0x00, 0x00})
+	if err != nil {
+		t.Fatalf("F1: %v", err)
+	}
+	auts := make([]byte, 0, aka.AutsLen)
+	for i := range sqnMs {
+		auts = append(auts, sqnMs[i]^akStar[i])
+	}
+	return hex.EncodeToString(append(auts, macS...))
+}
+
+func TestAuthService5GAka(t *testing.T) {
+	ctx := context.Background()
+	userID := primitive.NewObjectID()
+	auth, supi := newTestAuthService(t, userID)
+	usim := testUsim(t, auth, userID, supi)
+
+	av, err := auth.Generate5GAkaVector(ctx, userID, supi, "", testAuthRand, "")
+	if err != nil {
+		t.Fatalf("Generate5GAkaVector: %v", err)
+	}
+	if av.ServingNetworkName != "5G:mnc093.mcc208.3gppnetwork.org" || av.Rand != testAuthRand {
+		t.Errorf("vector for %s with RAND %s, want the home network and the requested RAND", av.ServingNetworkName, av.Rand)
+	}
+
+	resStar := testUsimResStar(t, usim, av.Rand, av.ServingNetworkName)
+	if resStar != av.XresStar {
+		t.Errorf("RES* of the USIM = %s, XRES* = %s", resStar, av.XresStar)
+	}
+	verification, err := auth.Verify5GAka(ctx, userID, supi, av.Rand, resStar, "")
+	if err != nil {
+		t.Fatalf("Verify5GAka: %v", err)
+	}
+	if !verification.Verified || verification.XresStar != av.XresStar {
+		t.Errorf("verification = %+v, want RES* verified", verification)
+	}
+
+	// A RES* of another serving network does not verify
+	resStar = testUsimResStar(t, usim, av.Rand, "5G:mnc001.mcc001.3gppnetwork.org")
+	if verification, err = auth.Verify5GAka(ctx, userID, supi, av.Rand, resStar, ""); err != nil || verification.Verified {
+		t.Errorf("Verify5GAka of a RES* for another network = %+v, %v, want not verified", verification, err)
+	}
+
+	if _, err := auth.Verify5GAka(ctx, userID, supi, av.Rand, resStar[2:], ""); !errors.Is(err, ErrInvalidAuthParameter) {
+		t.Errorf("Verify5GAka of a short RES* = %v, want ErrInvalidAuthParameter", err)
+	}
+	if _, err := auth.Verify5GAka(ctx, primitive.NewObjectID(), supi, av.Rand, resStar, ""); !errors.Is(err, ErrUeProfileNotFound) {
+		t.Errorf("Verify5GAka of another user = %v, want ErrUeProfileNotFound", err)
+	}
+}
+
+func TestAuthServiceVectorSqn(t *testing.T) {
+	ctx := context.Background()
+	userID := primitive.NewObjectID()
+	auth, supi := newTestAuthService(t, userID)
+
+	// Each vector advances the stored SQN by one and uses it
+	for _, want := range []string{"000000000001", "000000000002", "000000000003"} {
+		av, err := auth.Generate5GAkaVector(ctx, userID, supi, "", "", "")
+		if err != nil {
+			t.Fatalf("Generate5GAkaVector: %v", err)
+		}
+		if av.Sqn != want {
+			t.Errorf("vector SQN = %s, want %s", av.Sqn, want)
+		}
+	}
+	if _, err := auth.GenerateEapAkaPrimeVector(ctx, userID, supi, "", "", "", ""); err != nil {
+		t.Fatalf("GenerateEapAkaPrimeVector: %v", err)
+	}
+	if state, err := auth.GetSqn(ctx, userID, supi); err != nil || state.Sqn != "000000000004" {
+		t.Errorf("GetSqn = %+v, %v, want 000000000004", state, err)
+	}
+
+	// An explicit SQN is used as is and leaves the stored one alone
+	av, err := auth.Generate5GAkaVector(ctx, userID, supi, "0000000000ff", "", "")
+	if err != nil {
+		t.Fatalf("Generate5GAkaVector: %v", err)
+	}
+	if state, _ := auth.GetSqn(ctx, userID, supi); av.Sqn != "0000000000ff" || state.Sqn != "000000000004" {
+		t.Errorf("vector SQN = %s and stored SQN = %+v, want 0000000000ff and 000000000004", av.Sqn, state)
+	}
+}
+
+func TestAuthServiceSqn(t *testing.T) {
+	ctx := context.Background()
+	userID := primitive.NewObjectID()
+	auth, supi := newTestAuthService(t, userID)
+
+	state, err := auth.ResetSqn(ctx, userID, supi, "0000000000ff")
+	if err != nil {
+		t.Fatalf("ResetSqn: %v", err)
+	}
+	if state.Sqn != "0000000000ff" {
+		t.Errorf("ResetSqn = %s", state.Sqn)
+	}
+	state, err = auth.AdvanceSqn(ctx, userID, supi, 1)
+	if err != nil {
+		t.Fatalf("AdvanceSqn: %v", err)
+	}
+	if state.Sqn != "000000000100" {
+		t.Errorf("AdvanceSqn = %s, want 000000000100", state.Sqn)
+	}
+	if state, _ := auth.GetSqn(ctx, userID, supi); state == nil || state.Sqn != "000000000100" {
+		t.Errorf("GetSqn = %+v", state)
+	}
+	if _, err := auth.GetSqn(ctx, primitive.NewObjectID(), supi); !errors.Is(err, ErrUeProfileNotFound) {
+		t.Errorf("GetSqn of another user = %v, want ErrUeProfileNotFound", err)
+	}
+}
+
+func TestAuthServiceResync(t *testing.T) {
+	ctx := context.Background()
+	userID := primitive.NewObjectID()
+	auth, supi := newTestAuthService(t, userID)
+	usim := testUsim(t, auth, userID, supi)
+
+	// The USIM is ahead of the home network and rejects the vector with AUTS
+	av, err := auth.Generate5GAkaVector(ctx, userID, supi, "", testAuthRand, "")
+	if err != nil {
+		t.Fatalf("Generate5GAkaVector: %v", err)
+	}
+	sqnMs := "000000001234"
+	state, err := auth.ResyncSqn(ctx, userID, supi, av.Rand, testUsimAuts(t, usim, av.Rand, sqnMs))
+	if err != nil {
+		t.Fatalf("ResyncSqn: %v", err)
+	}
+	if state.Sqn != sqnMs {
+		t.Errorf("ResyncSqn = %s, want SQN_MS %s", state.Sqn, sqnMs)
+	}
+	if av, err = auth.Generate5GAkaVector(ctx, userID, supi, "", "", ""); err != nil {
+		t.Fatalf("Generate5GAkaVector: %v", err)
+	}
+	if av.Sqn != "000000001235" {
+		t.Errorf("vector SQN after resync = %s, want SQN_MS + 1", av.Sqn)
+	}
+}
+
+func TestAuthServiceResyncMacS(t *testing.T) {
+	ctx := context.Background()
+	userID := primitive.NewObjectID()
+	auth, supi := newTestAuthService(t, userID)
+	usim := testUsim(t, auth, userID, supi)
+	auts := testUsimAuts(t, usim, testAuthRand, "000000001234")
+	// flip inverts the octet at hex offset i of the AUTS
+	flip := func(i int) string {
+		b, _ := hex.DecodeString(auts)
+		b[i/2] ^= 0xff
+		return hex.EncodeToString(b)
+	}
+
+	tests := []struct {
+		name string
+		rand string
+		auts string
+	}{
+		{"corrupted MAC-S", testAuthRand, flip(len(auts) - 2)},
+		{"corrupted SQN_MS", testAuthRand, flip(0)},
+		{"AUTS of another RAND", "c00d603103dcee52c4478119494202e8", auts},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := auth.ResyncSqn(ctx, userID, supi, tt.rand, tt.auts); !errors.Is(err, aka.ErrMacSMismatch) {
+				t.Errorf("ResyncSqn = %v, want aka.ErrMacSMismatch", err)
+			}
+		})
+	}
+	// A rejected AUTS leaves the stored SQN alone
+	if state, err := auth.GetSqn(ctx, userID, supi); err != nil || state.Sqn != aka.InitialSqn {
+		t.Errorf("GetSqn = %+v, %v, want %s", state, err, aka.InitialSqn)
+	}
+	if _, err := auth.ResyncSqn(ctx, userID, supi, testAuthRand, auts[2:]); !errors.Is(err, ErrInvalidAuthParameter) {
+		t.Errorf("ResyncSqn of a short AUTS = %v, want ErrInvalidAuthParameter", err)
+	}
+}
@@ -0,0 +1,430 @@
+package services
+
+import (
+	"backend-webUE/aka"
+	"backend-webUE/milenage"
+	"backend-webUE/models"
+	"backend-webUE/utils"
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var (
+	ErrUnknownImportFormat = errors.New("unknown import format")
+	ErrInvalidImportFile   = errors.New("invalid import file")
+)
+
+// Formats of bulk imports
+const (
+	ImportFormatCsv   = "csv"
+	ImportFormatJsonl = "jsonl"
+)
+
+// maxImportLineSize bounds one JSON Lines row
+const maxImportLineSize = 1 << 20
+
+// Columns of CSV imports, only supi and key are required
+var importCsvColumns = []string{
+	"supi", "key", "opc", "op", "amf", "sqn", "authentication_method", "imei", "imeisv",
+	"default_slices", "configured_slices", "sessions",
+}
+
+// BulkImportParams selects how imported rows become UE profiles
+type BulkImportParams struct {
+	// ImportFormatCsv or ImportFormatJsonl
+	Format string
+	// Operator of the imported UEs, nil for the default operator. CSV rows are completed with its settings.
+	OperatorID primitive.ObjectID
+	// Protection scheme of the SUCIs computed for CSV rows
+	Schemes utils.SchemeMix
+	// Validate and report without inserting anything
+	DryRun bool
+	// Provisioning targets to push the accepted UEs to, none when empty
+	Targets []string
+}
+
+// ImportRow is the outcome of one row of a bulk import
+type ImportRow struct {
+	// Line of the row in the file, starting at 1
+	Row      int      `json:"row"`
+	Supi     string   `json:"supi,omitempty"`
+	Accepted bool     `json:"accepted"`
+	Reasons  []string `json:"reasons,omitempty"`
+}
+
+// BulkImportReport lists the outcome of a bulk import per row
+type BulkImportReport struct {
+	Format   string      `json:"format"`
+	DryRun   bool        `json:"dryRun"`
+	Accepted int         `json:"accepted"`
+	Rejected int         `json:"rejected"`
+	Rows     []ImportRow `json:"rows"`
+}
+
+// BulkImportService imports UE profiles from CSV and JSON Lines files with a validation report per row
+type BulkImportService struct {
+	ueProfiles *UeProfileService
+	operators  *OperatorService
+}
+
+func NewBulkImportService(ueProfiles *UeProfileService, operators *OperatorService) *BulkImportService {
+	return &BulkImportService{
+		ueProfiles: ueProfiles,
+		operators:  operators,
+	}
+}
+
+// importedRow is a parsed row waiting to be checked against the stored profiles
+type importedRow struct {
+	report    int
+	ueProfile models.UeProfile
+}
+
+// Import validates every row of r, inserts the valid rows whose SUPI the user has no profile for
+// and reports the outcome of each row. Provisioning failures are returned along with the report.
+func (s *BulkImportService) Import(ctx context.Context, userID primitive.ObjectID, r io.Reader, params BulkImportParams) (*BulkImportReport, error) {
+	format := strings.ToLower(params.Format)
+	if format != ImportFormatCsv && format != ImportFormatJsonl {
+		return nil, fmt.Errorf("%w %q, supported: %s, %s", ErrUnknownImportFormat, params.Format, ImportFormatCsv, ImportFormatJsonl)
+	}
+	operator, err := s.operators.Operator(ctx, userID, params.OperatorID)
+	if err != nil {
+		return nil, err
+	}
+	if format == ImportFormatCsv {
+		if err := operator.ValidateSchemeMix(params.Schemes); err != nil {
+			return nil, err
+		}
+	}
+	provisioners, err := s.ueProfiles.provisioners.Select(params.Targets)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &BulkImportReport{
+		Format: format,
+		DryRun: params.DryRun,
+		Rows:   []ImportRow{},
+	}
+	firstRow := make(map[string]int)
+	var pending []importedRow
+	var provisionErrs []error
+
+	// flush checks the pending rows against the stored profiles and inserts the new ones
+	flush := func() error {
+		if len(pending) == 0 {
+			return nil
+		}
+		supis := make([]string, len(pending))
+		for i := range pending {
+			supis[i] = pending[i].ueProfile.Supi
+		}
+		exists, err := s.ueProfiles.repo.Existing(ctx, userID, supis)
+		if err != nil {
+			return err
+		}
+
+		var fresh []models.UeProfile
+		for _, row := range pending {
+			if exists[row.ueProfile.Supi] {
+				report.Rows[row.report].Reasons = []string{"supi: already has a UE profile"}
+				continue
+			}
+			report.Rows[row.report].Accepted = true
+			fresh = append(fresh, row.ueProfile)
+		}
+		pending = pending[:0]
+		if len(fresh) == 0 || params.DryRun {
+			return nil
+		}
+		if err := s.ueProfiles.repo.Insert(ctx, fresh); err != nil {
+			return err
+		}
+		if err := s.ueProfiles.provision(ctx, userID, fresh, provisioners); err != nil {
+			if !errors.Is(err, ErrProvisionFailed) {
+				return err
+			}
+			provisionErrs = append(provisionErrs, err)
+		}
+		return nil
+	}
+
+	// add records a parsed row, valid rows are queued for insertion
+	add := func(line int, ueProfile *models.UeProfile, reasons []string) error {
+		row := ImportRow{Row: line, Reasons: reasons}
+		if ueProfile != nil {
+			row.Supi = ueProfile.Supi
+			if first, ok := firstRow[ueProfile.Supi]; ok {
+				row.Reasons = append(row.Reasons, fmt.Sprintf("supi: duplicate of row %d", first))
+			} else if ueProfile.Supi != "" {
+				firstRow[ueProfile.Supi] = line
+			}
+		}
+		report.Rows = append(report.Rows, row)
+		if len(row.Reasons) > 0 {
+			return nil
+		}
+		ueProfile.UserID = userID
+		ueProfile.OperatorID = params.OperatorID
+		pending = append(pending, importedRow{report: len(report.Rows) - 1, ueProfile: *ueProfile})
+		if len(pending) >= importBatchSize {
+			return flush()
+		}
+		return nil
+	}
+
+	if format == ImportFormatCsv {
+		err = readImportCsv(r, operator, params.Schemes, add)
+	} else {
+		err = readImportJsonl(r, add)
+	}
+	if err == nil {
+		err = flush()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for _, row := range report.Rows {
+		if row.Accepted {
+			report.Accepted++
+		} else {
+			report.Rejected++
+		}
+	}
+	return report, joinProvisionErrors(provisionErrs)
+}
+
+// validationReasons lists the invalid fields of a validation error
+func validationReasons(err error) []string {
+	var validationErr *utils.ValidationError
+	if errors.As(err, &validationErr) {
+		return validationErr.Errors
+	}
+	return []string{err.Error()}
+}
+
+// readImportCsv parses CSV rows with a header line naming importCsvColumns.
+// Rows are completed with the operator settings and their SUCI is computed with its home network key.
+func readImportCsv(r io.Reader, operator *utils.Operator, schemes utils.SchemeMix, add func(line int, ueProfile *models.UeProfile, reasons []string) error) error {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err == io.EOF {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidImportFile, err)
+	}
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		name = strings.ToLower(strings.TrimSpace(name))
+		known := false
+		for _, column := range importCsvColumns {
+			known = known || column == name
+		}
+		if !known {
+			return fmt.Errorf("%w: unknown column %q, supported: %s", ErrInvalidImportFile, name, strings.Join(importCsvColumns, ", "))
+		}
+		columns[name] = i
+	}
+	if _, ok := columns["supi"]; !ok {
+		return fmt.Errorf("%w: missing column supi", ErrInvalidImportFile)
+	}
+	if _, ok := columns["key"]; !ok {
+		return fmt.Errorf("%w: missing column key", ErrInvalidImportFile)
+	}
+
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return nil
+		}
+		line, _ := reader.FieldPos(0)
+		if err != nil {
+			var parseErr *csv.ParseError
+			if !errors.As(err, &parseErr) {
+				return fmt.Errorf("%w: %v", ErrInvalidImportFile, err)
+			}
+			// The reader cannot resynchronize after a quoting error
+			if !errors.Is(err, csv.ErrFieldCount) {
+				return fmt.Errorf("%w: %v", ErrInvalidImportFile, err)
+			}
+		}
+		if len(record) != len(header) {
+			if err := add(line, nil, []string{fmt.Sprintf("row has %d fields, header has %d", len(record), len(header))}); err != nil {
+				return err
+			}
+			continue
+		}
+		field := func(name string) string {
+			if i, ok := columns[name]; ok {
+				return strings.TrimSpace(record[i])
+			}
+			return ""
+		}
+
+		var reasons []string
+		in := utils.ImportedUe{
+			Supi:                 field("supi"),
+			Key:                  field("key"),
+			Op:                   field("op"),
+			Opc:                  field("opc"),
+			Amf:                  field("amf"),
+			Sqn:                  field("sqn"),
+			AuthenticationMethod: strings.ToUpper(field("authentication_method")),
+			Imeisv:               field("imeisv"),
+		}
+		if in.DefaultSlice, err = parseImportSlices(field("default_slices")); err != nil {
+			reasons = append(reasons, "default_slices: "+err.Error())
+		}
+		if in.ConfiguredSlice, err = parseImportSlices(field("configured_slices")); err != nil {
+			reasons = append(reasons, "configured_slices: "+err.Error())
+		}
+		if in.Sessions, err = parseImportSessions(field("sessions")); err != nil {
+			reasons = append(reasons, "sessions: "+err.Error())
+		}
+
+		ueProfile := &models.UeProfile{Supi: in.Supi}
+		if len(reasons) == 0 {
+			if imported, err := operator.ImportUe(in, schemes.Pick()); err != nil {
+				reasons = append(reasons, err.Error())
+			} else {
+				ueProfile = imported
+				if imei := field("imei"); imei != "" {
+					ueProfile.Imei = imei
+				}
+				if err := utils.ValidateUeProfile(ueProfile); err != nil {
+					reasons = append(reasons, validationReasons(err)...)
+				}
+			}
+		}
+		if err := add(line, ueProfile, reasons); err != nil {
+			return err
+		}
+	}
+}
+
+// parseImportSlices parses S-NSSAIs written as sst[:sd], separated by ;
+func parseImportSlices(value string) ([]models.Snssai, error) {
+	if value == "" {
+		return nil, nil
+	}
+	var slices []models.Snssai
+	for _, item := range strings.Split(value, ";") {
+		snssai, err := parseImportSnssai(strings.Split(strings.TrimSpace(item), ":"))
+		if err != nil {
+			return nil, err
+		}
+		slices = append(slices, snssai)
+	}
+	return slices, nil
+}
+
+func parseImportSnssai(parts []string) (models.Snssai, error) {
+	if len(parts) < 1 || len(parts) > 2 {
+		return models.Snssai{}, fmt.Errorf("S-NSSAI must be sst or sst:sd")
+	}
+	sst, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return models.Snssai{}, fmt.Errorf("invalid SST %q", parts[0])
+	}
+	snssai := models.Snssai{Sst: sst}
+	if len(parts) == 2 {
+		snssai.Sd = parts[1]
+	}
+	return snssai, nil
+}
+
+// parseImportSessions parses PDU sessions written as type:apn:sst[:sd], separated by ;
+func parseImportSessions(value string) ([]models.Sessions, error) {
+	if value == "" {
+		return nil, nil
+	}
+	var sessions []models.Sessions
+	for _, item := range strings.Split(value, ";") {
+		parts := strings.Split(strings.TrimSpace(item), ":")
+		if len(parts) < 3 {
+			return nil, fmt.Errorf("session must be type:apn:sst[:sd], got %q", item)
+		}
+		snssai, err := parseImportSnssai(parts[2:])
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, models.Sessions{Type: parts[0], Apn: parts[1], Slice: snssai})
+	}
+	return sessions, nil
+}
+
+// readImportJsonl parses one UE profile per line, in the JSON representation of the API.
+// Missing SQN, authentication method, OPc and SUCI are derived as for created profiles.
+func readImportJsonl(r io.Reader, add func(line int, ueProfile *models.UeProfile, reasons []string) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxImportLineSize)
+
+	line := 0
+	for scanner.Scan() {
+		line++
+		data := bytes.TrimSpace(scanner.Bytes())
+		if len(data) == 0 {
+			continue
+		}
+
+		var ueProfile models.UeProfile
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&ueProfile); err != nil {
+			if err := add(line, nil, []string{fmt.Sprintf("invalid JSON: %v", err)}); err != nil {
+				return err
+			}
+			continue
+		}
+		ueProfile.ID = primitive.NilObjectID
+		ueProfile.Provisioning = nil
+
+		if ueProfile.OpType == "" {
+			ueProfile.OpType = utils.OPC
+		}
+		if ueProfile.Sqn == "" {
+			ueProfile.Sqn = aka.InitialSqn
+		}
+		if ueProfile.AuthenticationMethod == "" {
+			ueProfile.AuthenticationMethod = utils.AUTH_5G_AKA
+		}
+		var reasons []string
+		if ueProfile.Opc == "" && ueProfile.Op != "" {
+			if opc, err := milenage.GenerateOPcHex(ueProfile.Key, ueProfile.Op); err == nil {
+				ueProfile.Opc = opc
+			}
+		}
+		if err := utils.ValidateUeProfile(&ueProfile); err != nil {
+			reasons = validationReasons(err)
+		} else if ueProfile.Suci == "" {
+			suci, err := utils.ConcealSupi(&ueProfile)
+			if err != nil {
+				reasons = append(reasons, fmt.Sprintf("suci: %v", err))
+			} else {
+				ueProfile.Suci = suci.String()
+			}
+		}
+		if err := add(line, &ueProfile, reasons); err != nil {
+			return err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("%w: line %d: %v", ErrInvalidImportFile, line+1, err)
+	}
+	return nil
+}
@@ -0,0 +1,173 @@
+package services
+
+import (
+	"backend-webUE/utils"
+	"context"
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// newTestBulkImportService returns a bulk import service sharing the store of the UE profile service
+func newTestBulkImportService(t *testing.T) (*BulkImportService, *UeProfileService, *fakeProvisioner) {
+	t.Helper()
+	ueProfiles, provisioner := newTestUeProfileService(t)
+	return NewBulkImportService(ueProfiles, ueProfiles.operators), ueProfiles, provisioner
+}
+
+const (
+	importTestHeader = "supi,key,opc,imei,default_slices,configured_slices,sessions\n"
+	importTestRow    = "imsi-208930000000001,465b5ce8b199b49faa5f0a2ee238a6bc,cd63cb71954a9f4e48a5994e37a02baf,490154203237518,1:010203,1:010203;2,IPv4:internet:1:010203\n"
+)
+
+func TestBulkImportCsvRejections(t *testing.T) {
+	tests := []struct {
+		name string
+		row  string
+		// Prefix of the first reason
+		want string
+	}{
+		{"IMEI check digit", strings.Replace(importTestRow, "490154203237518", "490154203237519", 1), "imei: must be 15 digits with a valid Luhn check digit"},
+		{"short key", strings.Replace(importTestRow, "465b5ce8", "465b5ce", 1), "K must be 32 hex digits"},
+		{"key not hex", strings.Replace(importTestRow, "465b5ce8", "465b5ceg", 1), "K must be 32 hex digits"},
+		{"long OPc", strings.Replace(importTestRow, "cd63cb71", "cd63cb7100", 1), "OPc must be 32 hex digits"},
+		{"SST not a number", strings.Replace(importTestRow, ",1:010203,", ",x:010203,", 1), "default_slices: invalid SST"},
+		{"SST out of range", strings.Replace(importTestRow, ";2,", ";256,", 1), "configuredSlice[1]: sst must be between 0 and 255"},
+		{"SD length", strings.Replace(importTestRow, ",1:010203,", ",1:0102,", 1), "defaultSlice[0]: sd must be 6 hex digits"},
+		{"session SD not hex", strings.Replace(importTestRow, "internet:1:010203", "internet:1:01020g", 1), "sessions[0].slice: sd must be 6 hex digits"},
+		{"session without slice", strings.Replace(importTestRow, "internet:1:010203", "internet", 1), "sessions: session must be type:apn:sst[:sd]"},
+		{"SUPI of another PLMN", strings.Replace(importTestRow, "imsi-20893", "imsi-00101", 1), "SUPI imsi-001010000000001 is not an IMSI of PLMN 208-93"},
+		{"missing fields", "imsi-208930000000001,465b5ce8b199b49faa5f0a2ee238a6bc\n", "row has 2 fields, header has 7"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, ueProfiles, _ := newTestBulkImportService(t)
+			ctx := context.Background()
+			userID := primitive.NewObjectID()
+
+			report, err := s.Import(ctx, userID, strings.NewReader(importTestHeader+tt.row), BulkImportParams{Format: ImportFormatCsv, Schemes: utils.SchemeMix{Null: 1}})
+			if err != nil {
+				t.Fatalf("Import: %v", err)
+			}
+			if report.Accepted != 0 || report.Rejected != 1 || len(report.Rows) != 1 {
+				t.Fatalf("report = %+v, want one rejected row", report)
+			}
+			row := report.Rows[0]
+			if row.Row != 2 || row.Accepted || len(row.Reasons) == 0 || !strings.HasPrefix(row.Reasons[0], tt.want) {
+				t.Errorf("row = %+v, want a reason starting with %q", row, tt.want)
+			}
+			if stored, err := ueProfiles.GetUeProfiles(ctx, userID); err != nil || len(stored) != 0 {
+				t.Errorf("stored %d UE profiles (%v), want none", len(stored), err)
+			}
+		})
+	}
+}
+
+func TestBulkImportJsonlRejections(t *testing.T) {
+	valid := `{"supi":"imsi-208930000000001","plmnid":{"mcc":"208","mnc":"93"},"key":"465b5ce8b199b49faa5f0a2ee238a6bc",` +
+		`"opc":"cd63cb71954a9f4e48a5994e37a02baf","amf":"8000","imei":"490154203237518"}`
+	tests := []struct {
+		name string
+		line string
+		want string
+	}{
+		{"IMEI check digit", strings.Replace(valid, "490154203237518", "490154203237519", 1), "imei: must be 15 digits with a valid Luhn check digit"},
+		{"short key", strings.Replace(valid, "465b5ce8", "465b5ce", 1), "key: must be 32 hex digits"},
+		{"short OPc", strings.Replace(valid, "cd63cb71", "cd63cb7", 1), "opc: must be 32 hex digits"},
+		{"SD length", strings.Replace(valid, `"amf"`, `"defaultSlice":[{"sst":1,"sd":"0102"}],"amf"`, 1), "defaultSlice[0]: sd must be 6 hex digits"},
+		{"SST out of range", strings.Replace(valid, `"amf"`, `"configuredSlice":[{"sst":300}],"amf"`, 1), "configuredSlice[0]: sst must be between 0 and 255"},
+		{"unknown field", strings.Replace(valid, `"amf"`, `"ki":"00","amf"`, 1), "invalid JSON: json: unknown field \"ki\""},
+		{"not JSON", "imsi-208930000000001", "invalid JSON"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, _, _ := newTestBulkImportService(t)
+			report, err := s.Import(context.Background(), primitive.NewObjectID(), strings.NewReader(valid+"\n"+tt.line+"\n"), BulkImportParams{Format: ImportFormatJsonl})
+			if err != nil {
+				t.Fatalf("Import: %v", err)
+			}
+			if report.Accepted != 1 || report.Rejected != 1 || len(report.Rows) != 2 || !report.Rows[0].Accepted {
+				t.Fatalf("report = %+v, want the first row accepted and the second rejected", report)
+			}
+			row := report.Rows[1]
+			if row.Row != 2 || len(row.Reasons) == 0 || !strings.HasPrefix(row.Reasons[0], tt.want) {
+				t.Errorf("row = %+v, want a reason starting with %q", row, tt.want)
+			}
+		})
+	}
+}
+
+func TestBulkImportDuplicates(t *testing.T) {
+	s, _, _ := newTestBulkImportService(t)
+	ctx := context.Background()
+	userID := primitive.NewObjectID()
+	params := BulkImportParams{Format: ImportFormatCsv, Schemes: utils.SchemeMix{Null: 1}}
+
+	if _, err := s.Import(ctx, userID, strings.NewReader(importTestHeader+importTestRow), params); err != nil {
+		t.Fatalf("Import: %v", err)
+	}
+
+	second := strings.Replace(importTestRow, "000000001", "000000002", 1)
+	report, err := s.Import(ctx, userID, strings.NewReader(importTestHeader+importTestRow+second+second), params)
+	if err != nil {
+		t.Fatalf("Import: %v", err)
+	}
+	want := []struct {
+		accepted bool
+		reason   string
+	}{
+		{false, "supi: already has a UE profile"},
+		{true, ""},
+		{false, "supi: duplicate of row 3"},
+	}
+	if len(report.Rows) != len(want) || report.Accepted != 1 || report.Rejected != 2 {
+		t.Fatalf("report = %+v", report)
+	}
+	for i, w := range want {
+		row := report.Rows[i]
+		reason := strings.Join(row.Reasons, "; ")
+		if row.Row != i+2 || row.Accepted != w.accepted || reason != w.reason {
+			t.Errorf("row %d = %+v, want accepted %v with reason %q", i, row, w.accepted, w.reason)
+		}
+	}
+}
+
+func TestBulkImportDryRun(t *testing.T) {
+	s, ueProfiles, provisioner := newTestBulkImportService(t)
+	ctx := context.Background()
+	userID := primitive.NewObjectID()
+	file := importTestHeader + importTestRow + strings.Replace(importTestRow, "000000001", "000000002", 1)
+
+	for _, format := range []string{ImportFormatCsv, ImportFormatJsonl} {
+		in := file
+		if format == ImportFormatJsonl {
+			in = `{"supi":"imsi-208930000000001","plmnid":{"mcc":"208","mnc":"93"},"key":"465b5ce8b199b49faa5f0a2ee238a6bc",` +
+				`"opc":"cd63cb71954a9f4e48a5994e37a02baf","amf":"8000"}` + "\n"
+		}
+		report, err := s.Import(ctx, userID, strings.NewReader(in), BulkImportParams{Format: format, Schemes: utils.SchemeMix{Null: 1}, DryRun: true})
+		if err != nil {
+			t.Fatalf("Import of %s: %v", format, err)
+		}
+		if !report.DryRun || report.Accepted == 0 || report.Rejected != 0 {
+			t.Errorf("%s dry run report = %+v, want every row accepted", format, report)
+		}
+	}
+
+	stored, err := ueProfiles.GetUeProfiles(ctx, userID)
+	if err != nil {
+		t.Fatalf("GetUeProfiles: %v", err)
+	}
+	if len(stored) != 0 || len(provisioner.subs) != 0 {
+		t.Errorf("dry run stored %d UE profiles and provisioned %d, want none", len(stored), len(provisioner.subs))
+	}
+
+	// The same file imports once it is not a dry run
+	report, err := s.Import(ctx, userID, strings.NewReader(file), BulkImportParams{Format: ImportFormatCsv, Schemes: utils.SchemeMix{Null: 1}, Targets: []string{ProvisionTargetAll}})
+	if err != nil {
+		t.Fatalf("Import: %v", err)
+	}
+	if stored, _ = ueProfiles.GetUeProfiles(ctx, userID); report.Accepted != 2 || len(stored) != 2 || len(provisioner.subs) != 2 {
+		t.Errorf("import accepted %d rows, stored %d and provisioned %d, want 2", report.Accepted, len(stored), len(provisioner.subs))
+	}
+}
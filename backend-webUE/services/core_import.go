@@ -0,0 +1,381 @@
+package services
+
+import (
+	"backend-webUE/database"
+	"backend-webUE/models"
+	"backend-webUE/utils"
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var (
+	ErrUnknownImportSource = errors.New("unknown import source")
+	ErrImportSource        = errors.New("failed to read import source")
+)
+
+// Cores subscribers can be imported from
+const (
+	ImportSourceFree5gc = "free5gc"
+	ImportSourceOpen5gs = "open5gs"
+)
+
+// importBatchSize is the number of imported UE profiles inserted at once
+const importBatchSize = 1000
+
+// CoreImportParams selects the core database to import from and how the UE profiles are built
+type CoreImportParams struct {
+	// ImportSourceFree5gc or ImportSourceOpen5gs
+	Source string
+	// MongoDB of the core
+	Uri      string
+	Database string
+	// Operator the subscribers belong to, nil for the default operator
+	OperatorID primitive.ObjectID
+	// Protection scheme of the computed SUCIs
+	Schemes utils.SchemeMix
+	// Report what would be imported without inserting anything
+	DryRun bool
+}
+
+// ImportRejection is a subscriber of the core that could not be imported
+type ImportRejection struct {
+	Supi   string `json:"supi"`
+	Reason string `json:"reason"`
+}
+
+// CoreImportReport lists the outcome of an import per subscriber
+type CoreImportReport struct {
+	Source string `json:"source"`
+	DryRun bool   `json:"dryRun"`
+	// SUPIs imported as new UE profiles, or that would be in a dry run
+	Imported []string `json:"imported"`
+	// SUPIs that already have a UE profile of the user, left untouched
+	Conflicts []string          `json:"conflicts"`
+	Rejected  []ImportRejection `json:"rejected"`
+}
+
+// CoreImportService takes over the subscribers of free5GC and Open5GS databases as UE profiles
+type CoreImportService struct {
+	ueProfiles *UeProfileService
+	operators  *OperatorService
+}
+
+func NewCoreImportService(ueProfiles *UeProfileService, operators *OperatorService) *CoreImportService {
+	return &CoreImportService{
+		ueProfiles: ueProfiles,
+		operators:  operators,
+	}
+}
+
+// ImportFromCore reads the subscribers of a core database, rebuilds them as UE profiles of the operator
+// and inserts those whose SUPI the user has no profile for yet
+func (s *CoreImportService) ImportFromCore(ctx context.Context, userID primitive.ObjectID, params CoreImportParams) (*CoreImportReport, error) {
+	source := strings.ToLower(params.Source)
+	if source != ImportSourceFree5gc && source != ImportSourceOpen5gs {
+		return nil, fmt.Errorf("%w %q, supported: %s, %s", ErrUnknownImportSource, params.Source, ImportSourceFree5gc, ImportSourceOpen5gs)
+	}
+	operator, err := s.operators.Operator(ctx, userID, params.OperatorID)
+	if err != nil {
+		return nil, err
+	}
+	if err := operator.ValidateSchemeMix(params.Schemes); err != nil {
+		return nil, err
+	}
+
+	db, err := database.ConnectURI(params.Uri, params.Database)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrImportSource, err)
+	}
+	defer db.Client().Disconnect(context.Background())
+
+	var subscribers []utils.ImportedUe
+	var rejected []ImportRejection
+	if source == ImportSourceFree5gc {
+		subscribers, rejected, err = readFree5gcSubscribers(ctx, db, operator.Config().PlmnId)
+	} else {
+		subscribers, rejected, err = readOpen5gsSubscribers(ctx, db)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrImportSource, err)
+	}
+
+	report := &CoreImportReport{
+		Source:    source,
+		DryRun:    params.DryRun,
+		Imported:  []string{},
+		Conflicts: []string{},
+		Rejected:  append([]ImportRejection{}, rejected...),
+	}
+
+	// Rebuild the profiles, dropping subscribers that cannot be converted or appear twice
+	var ueProfiles []models.UeProfile
+	seen := make(map[string]bool, len(subscribers))
+	for _, sub := range subscribers {
+		if seen[sub.Supi] {
+			report.Rejected = append(report.Rejected, ImportRejection{Supi: sub.Supi, Reason: "duplicate subscriber in source"})
+			continue
+		}
+		seen[sub.Supi] = true
+		ueProfile, err := operator.ImportUe(sub, params.Schemes.Pick())
+		if err != nil {
+			report.Rejected = append(report.Rejected, ImportRejection{Supi: sub.Supi, Reason: err.Error()})
+			continue
+		}
+		ueProfile.UserID = userID
+		ueProfile.OperatorID = params.OperatorID
+		ueProfiles = append(ueProfiles, *ueProfile)
+	}
+
+	for start := 0; start < len(ueProfiles); start += importBatchSize {
+		batch := ueProfiles[start:min(start+importBatchSize, len(ueProfiles))]
+		supis := make([]string, len(batch))
+		for i := range batch {
+			supis[i] = batch[i].Supi
+		}
+		exists, err := s.ueProfiles.repo.Existing(ctx, userID, supis)
+		if err != nil {
+			return nil, err
+		}
+
+		var fresh []models.UeProfile
+		for _, ueProfile := range batch {
+			if exists[ueProfile.Supi] {
+				report.Conflicts = append(report.Conflicts, ueProfile.Supi)
+			} else {
+				fresh = append(fresh, ueProfile)
+			}
+		}
+		if len(fresh) > 0 && !params.DryRun {
+			if err := s.ueProfiles.repo.Insert(ctx, fresh); err != nil {
+				return nil, err
+			}
+		}
+		for _, ueProfile := range fresh {
+			report.Imported = append(report.Imported, ueProfile.Supi)
+		}
+	}
+	return report, nil
+}
+
+// snssaiOfCore converts an S-NSSAI of a core, the SD is kept as hex digits without 0x like in UE profiles
+func snssaiOfCore(sst int, sd string) models.Snssai {
+	return models.Snssai{Sst: sst, Sd: strings.ToLower(strings.TrimPrefix(sd, "0x"))}
+}
+
+// sessionTypeOfCore converts a TS 29.571 PduSessionType into the UERANSIM session type
+func sessionTypeOfCore(pduSessionType string) string {
+	switch strings.ToUpper(pduSessionType) {
+	case "IPV6":
+		return "IPv6"
+	case "IPV4V6":
+		return "IPv4v6"
+	case "ETHERNET":
+		return "Ethernet"
+	default:
+		return "IPv4"
+	}
+}
+
+// free5gcStoredAuthentication reads the authentication subscriptions of all free5GC releases:
+// the sequence number is a string up to v3.3 and a TS 29.505 SequenceNumber since
+type free5gcStoredAuthentication struct {
+	UeId                          string        `bson:"ueId"`
+	AuthenticationMethod          string        `bson:"authenticationMethod"`
+	PermanentKey                  free5gcKey    `bson:"permanentKey"`
+	SequenceNumber                bson.RawValue `bson:"sequenceNumber"`
+	AuthenticationManagementField string        `bson:"authenticationManagementField"`
+	Milenage                      struct {
+		Op free5gcOp `bson:"op"`
+	} `bson:"milenage"`
+	Opc free5gcOpc `bson:"opc"`
+}
+
+// subscription returns the stored document in the written format with the given sequence number,
+// for comparing everything but the sequence number
+func (a *free5gcStoredAuthentication) subscription(sequenceNumber *nudrSequenceNumber) *free5gcAuthenticationSubscription {
+	auth := &free5gcAuthenticationSubscription{
+		UeId:                          a.UeId,
+		AuthenticationMethod:          a.AuthenticationMethod,
+		PermanentKey:                  a.PermanentKey,
+		SequenceNumber:                sequenceNumber,
+		AuthenticationManagementField: a.AuthenticationManagementField,
+		Opc:                           a.Opc,
+	}
+	auth.Milenage.Op = a.Milenage.Op
+	return auth
+}
+
+func (a *free5gcStoredAuthentication) sqn() string {
+	if sqn, ok := a.SequenceNumber.StringValueOK(); ok {
+		return sqn
+	}
+	if doc, ok := a.SequenceNumber.DocumentOK(); ok {
+		sqn, _ := doc.Lookup("sqn").StringValueOK()
+		return sqn
+	}
+	return ""
+}
+
+// readFree5gcSubscribers reads the authentication, AM and SM subscriptions of a free5GC database.
+// The subscription data served in the PLMN of the operator is preferred when a UE has several.
+func readFree5gcSubscribers(ctx context.Context, db *mongo.Database, plmnId models.PlmnId) ([]utils.ImportedUe, []ImportRejection, error) {
+	config := utils.DefaultFree5gcDbConfig()
+	servingPlmnId := plmnId.Mcc + plmnId.Mnc
+
+	amData := make(map[string]free5gcAmData)
+	amRejected, err := eachDocument(ctx, db.Collection(config.Am), "ueId", func(raw bson.Raw) error {
+		var am free5gcAmData
+		if err := bson.Unmarshal(raw, &am); err != nil {
+			return err
+		}
+		if _, ok := amData[am.UeId]; !ok || am.ServingPlmnId == servingPlmnId {
+			amData[am.UeId] = am
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var sms []free5gcSmData
+	smRejected, err := eachDocument(ctx, db.Collection(config.Sessman), "ueId", func(raw bson.Raw) error {
+		var sm free5gcSmData
+		if err := bson.Unmarshal(raw, &sm); err != nil {
+			return err
+		}
+		sms = append(sms, sm)
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	smData := make(map[string][]free5gcSmData)
+	for _, sm := range sms {
+		if am, ok := amData[sm.UeId]; !ok || am.ServingPlmnId == sm.ServingPlmnId {
+			smData[sm.UeId] = append(smData[sm.UeId], sm)
+		}
+	}
+
+	var subscribers []utils.ImportedUe
+	rejected, err := eachDocument(ctx, db.Collection(config.Auth), "ueId", func(raw bson.Raw) error {
+		var auth free5gcStoredAuthentication
+		if err := bson.Unmarshal(raw, &auth); err != nil {
+			return err
+		}
+		sub := utils.ImportedUe{
+			Supi:                 auth.UeId,
+			Key:                  auth.PermanentKey.Value,
+			Op:                   auth.Milenage.Op.OpValue,
+			Opc:                  auth.Opc.OpcValue,
+			Amf:                  auth.AuthenticationManagementField,
+			Sqn:                  auth.sqn(),
+			AuthenticationMethod: auth.AuthenticationMethod,
+		}
+		if am, ok := amData[auth.UeId]; ok {
+			for _, snssai := range am.Nssai.DefaultSingleNssais {
+				sub.DefaultSlice = append(sub.DefaultSlice, snssaiOfCore(snssai.Sst, snssai.Sd))
+			}
+			for _, snssai := range am.Nssai.SingleNssais {
+				sub.ConfiguredSlice = append(sub.ConfiguredSlice, snssaiOfCore(snssai.Sst, snssai.Sd))
+			}
+		}
+		for _, sm := range smData[auth.UeId] {
+			dnns := make([]string, 0, len(sm.DnnConfigurations))
+			for dnn := range sm.DnnConfigurations {
+				dnns = append(dnns, dnn)
+			}
+			sort.Strings(dnns)
+			for _, dnn := range dnns {
+				sub.Sessions = append(sub.Sessions, models.Sessions{
+					Type:  sessionTypeOfCore(sm.DnnConfigurations[dnn].PduSessionTypes.DefaultSessionType),
+					Apn:   dnn,
+					Slice: snssaiOfCore(sm.SingleNssai.Sst, sm.SingleNssai.Sd),
+				})
+			}
+		}
+		subscribers = append(subscribers, sub)
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	rejected = append(rejected, amRejected...)
+	return subscribers, append(rejected, smRejected...), nil
+}
+
+// readOpen5gsSubscribers reads the subscribers collection of an Open5GS database
+func readOpen5gsSubscribers(ctx context.Context, db *mongo.Database) ([]utils.ImportedUe, []ImportRejection, error) {
+	var subscribers []utils.ImportedUe
+	rejected, err := eachDocument(ctx, db.Collection("subscribers"), "imsi", func(raw bson.Raw) error {
+		var doc open5gsSubscriber
+		if err := bson.Unmarshal(raw, &doc); err != nil {
+			return err
+		}
+		sub := utils.ImportedUe{
+			Supi:   "imsi-" + doc.Imsi,
+			Key:    doc.Security.K,
+			Op:     doc.Security.Op,
+			Opc:    doc.Security.Opc,
+			Amf:    doc.Security.Amf,
+			Sqn:    fmt.Sprintf("%012x", doc.Security.Sqn),
+			Imeisv: doc.Imeisv,
+		}
+		for _, slice := range doc.Slice {
+			snssai := snssaiOfCore(slice.Sst, slice.Sd)
+			if slice.DefaultIndicator {
+				sub.DefaultSlice = append(sub.DefaultSlice, snssai)
+			}
+			sub.ConfiguredSlice = append(sub.ConfiguredSlice, snssai)
+			for _, session := range slice.Session {
+				sessionType := "IPv4"
+				switch session.Type {
+				case open5gsSessionIPv6:
+					sessionType = "IPv6"
+				case open5gsSessionIPv4v6:
+					sessionType = "IPv4v6"
+				}
+				sub.Sessions = append(sub.Sessions, models.Sessions{Type: sessionType, Apn: session.Name, Slice: snssai})
+			}
+		}
+		subscribers = append(subscribers, sub)
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	for i := range rejected {
+		if rejected[i].Supi != "" {
+			rejected[i].Supi = "imsi-" + rejected[i].Supi
+		}
+	}
+	return subscribers, rejected, nil
+}
+
+// eachDocument passes the documents of a collection one by one to decode.
+// Documents decode fails on are returned as rejections, identified by their idKey field.
+func eachDocument(ctx context.Context, collection *mongo.Collection, idKey string, decode func(raw bson.Raw) error) ([]ImportRejection, error) {
+	cursor, err := collection.Find(ctx, bson.M{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %v", collection.Name(), err)
+	}
+	defer cursor.Close(ctx)
+
+	var rejected []ImportRejection
+	for cursor.Next(ctx) {
+		if err := decode(cursor.Current); err != nil {
+			id, _ := cursor.Current.Lookup(idKey).StringValueOK()
+			rejected = append(rejected, ImportRejection{Supi: id, Reason: fmt.Sprintf("invalid document in %s: %v", collection.Name(), err)})
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read %s: %v", collection.Name(), err)
+	}
+	return rejected, nil
+}
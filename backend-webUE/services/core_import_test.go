@@ -0,0 +1,235 @@
+package services
+
+import (
+	"backend-webUE/models"
+	"backend-webUE/utils"
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestFree5gcStoredAuthenticationSqn(t *testing.T) {
+	tests := []struct {
+		name           string
+		sequenceNumber interface{}
+		want           string
+	}{
+		{"string before v3.4", "0000000000e0", "0000000000e0"},
+		{"SequenceNumber since v3.4", bson.D{{Key: "sqnScheme", Value: "NON_TIME_BASED"}, {Key: "sqn", Value: "0000000000e0"}}, "0000000000e0"},
+		{"SequenceNumber without sqn", bson.D{{Key: "sqnScheme", Value: "NON_TIME_BASED"}}, ""},
+		{"other type", int32(224), ""},
+		{"missing", nil, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			doc := bson.D{{Key: "ueId", Value: "imsi-208930000000001"}}
+			if tt.sequenceNumber != nil {
+				doc = append(doc, bson.E{Key: "sequenceNumber", Value: tt.sequenceNumber})
+			}
+			data, err := bson.Marshal(doc)
+			if err != nil {
+				t.Fatalf("marshal: %v", err)
+			}
+			var auth free5gcStoredAuthentication
+			if err := bson.Unmarshal(data, &auth); err != nil {
+				t.Fatalf("unmarshal: %v", err)
+			}
+			if got := auth.sqn(); got != tt.want {
+				t.Errorf("sqn = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// importedTestUe is the subscription of testUeProfile read back from a core
+func importedTestUe() utils.ImportedUe {
+	return utils.ImportedUe{
+		Supi:                 "imsi-208930000000001",
+		Key:                  "465b5ce8b199b49faa5f0a2ee238a6bc",
+		Opc:                  "cd63cb71954a9f4e48a5994e37a02baf",
+		Amf:                  "8000",
+		Sqn:                  "000000000020",
+		AuthenticationMethod: utils.AUTH_5G_AKA,
+		DefaultSlice:         []models.Snssai{{Sst: 1, Sd: "010203"}},
+		ConfiguredSlice:      []models.Snssai{{Sst: 1, Sd: "010203"}, {Sst: 2}},
+		Sessions: []models.Sessions{
+			{Type: "IPv4", Apn: "internet", Slice: models.Snssai{Sst: 1, Sd: "010203"}},
+			{Type: "IPv4v6", Apn: "ims", Slice: models.Snssai{Sst: 2}},
+		},
+	}
+}
+
+func TestImportUeOpAndOpc(t *testing.T) {
+	s, _ := newTestUeProfileService(t)
+	operator, err := s.operators.Operator(context.Background(), primitive.NewObjectID(), primitive.NilObjectID)
+	if err != nil {
+		t.Fatalf("Operator: %v", err)
+	}
+	// OP and OPc of TS 35.208 test set 1, the K of importedTestUe
+	const op, opc = "cdc202d5123e20f62b6d676ac72cb318", "cd63cb71954a9f4e48a5994e37a02baf"
+	tests := []struct {
+		name       string
+		op, opc    string
+		wantOpType string
+		wantErr    string
+	}{
+		{"OPc only", "", opc, utils.OPC, ""},
+		{"OP only", op, "", utils.OP, ""},
+		{"OP and its OPc", op, strings.ToUpper(opc), utils.OP, ""},
+		{"OP and another OPc", op, "00" + opc[2:], "", "OPc 0063cb71954a9f4e48a5994e37a02baf does not match the OPc " + opc + " derived from OP"},
+		{"neither", "", "", "", "subscription has neither OP nor OPc"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			in := importedTestUe()
+			in.Op, in.Opc = tt.op, tt.opc
+			ueProfile, err := operator.ImportUe(in, utils.NULL_SCHEME)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Errorf("ImportUe = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ImportUe: %v", err)
+			}
+			wantOp := op
+			if tt.wantOpType == utils.OPC {
+				wantOp = opc
+			}
+			if ueProfile.OpType != tt.wantOpType || ueProfile.Op != wantOp || ueProfile.Opc != opc {
+				t.Errorf("imported %s OP %s OPc %s, want %s OP %s OPc %s", ueProfile.OpType, ueProfile.Op, ueProfile.Opc, tt.wantOpType, wantOp, opc)
+			}
+		})
+	}
+}
+
+func TestReadFree5gcSubscribers(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	config := utils.DefaultFree5gcDbConfig()
+	plmnId := models.PlmnId{Mcc: "208", Mnc: "93"}
+
+	mt.Run("both formats", func(mt *mtest.T) {
+		// Stored in the current format
+		current := testUeProfile()
+		auth, am, _, smData := free5gcDocuments(current)
+
+		// Stored before v3.4 with the SQN as a string, also subscribed in a visited PLMN
+		legacy := testUeProfile()
+		legacy.Supi, legacy.Opc, legacy.Sqn = "imsi-208930000000002", "", "0000000000e0"
+		legacy.AuthenticationMethod = utils.AUTH_EAP_AKA_PRIME
+		legacy.Sessions = legacy.Sessions[:1]
+		legacyAuth, legacyAm, _, legacySmData := free5gcDocuments(legacy)
+		legacyAuthDoc := free5gcDoc(mt.T, legacyAuth)
+		for i := range legacyAuthDoc {
+			if legacyAuthDoc[i].Key == "sequenceNumber" {
+				legacyAuthDoc[i].Value = legacy.Sqn
+			}
+		}
+		roamingAm := *legacyAm
+		roamingAm.ServingPlmnId = "00101"
+		roamingAm.Nssai.DefaultSingleNssais = []free5gcSnssai{{Sst: 3}}
+		roamingSm := legacySmData[0]
+		roamingSm.ServingPlmnId, roamingSm.SingleNssai = "00101", free5gcSnssai{Sst: 3}
+
+		ns := func(collection string) string { return mt.DB.Name() + "." + collection }
+		mt.AddMockResponses(
+			// The AM data of the visited PLMN comes first, the one of the operator's PLMN wins
+			mtest.CreateCursorResponse(0, ns(config.Am), mtest.FirstBatch,
+				free5gcDoc(mt.T, am), free5gcDoc(mt.T, &roamingAm), free5gcDoc(mt.T, legacyAm)),
+			mtest.CreateCursorResponse(0, ns(config.Sessman), mtest.FirstBatch,
+				free5gcDoc(mt.T, smData[0]), free5gcDoc(mt.T, smData[1]), free5gcDoc(mt.T, roamingSm), free5gcDoc(mt.T, legacySmData[0]),
+				bson.D{{Key: "ueId", Value: "imsi-208930000000003"}, {Key: "dnnConfigurations", Value: "internet"}}),
+			mtest.CreateCursorResponse(0, ns(config.Auth), mtest.FirstBatch,
+				free5gcDoc(mt.T, auth), legacyAuthDoc,
+				bson.D{{Key: "ueId", Value: "imsi-208930000000003"}, {Key: "permanentKey", Value: "465b5ce8b199b49faa5f0a2ee238a6bc"}}),
+		)
+
+		subscribers, rejected, err := readFree5gcSubscribers(context.Background(), mt.DB, plmnId)
+		if err != nil {
+			mt.Fatalf("readFree5gcSubscribers: %v", err)
+		}
+
+		wantLegacy := importedTestUe()
+		wantLegacy.Supi, wantLegacy.Op, wantLegacy.Opc = legacy.Supi, legacy.Op, ""
+		wantLegacy.Sqn, wantLegacy.AuthenticationMethod = legacy.Sqn, utils.AUTH_EAP_AKA_PRIME
+		wantLegacy.Sessions = wantLegacy.Sessions[:1]
+		want := []utils.ImportedUe{importedTestUe(), wantLegacy}
+		if !reflect.DeepEqual(subscribers, want) {
+			mt.Errorf("subscribers = %+v, want %+v", subscribers, want)
+		}
+
+		if len(rejected) != 2 {
+			mt.Fatalf("rejected = %+v, want the invalid authentication and SM documents", rejected)
+		}
+		for _, rejection := range rejected {
+			if rejection.Supi != "imsi-208930000000003" {
+				mt.Errorf("rejected %s: %s", rejection.Supi, rejection.Reason)
+			}
+		}
+	})
+
+	mt.Run("fails on a read error", func(mt *mtest.T) {
+		mt.AddMockResponses(mtest.CreateCommandErrorResponse(mtest.CommandError{Code: 13, Message: "unauthorized"}))
+		if _, _, err := readFree5gcSubscribers(context.Background(), mt.DB, plmnId); err == nil {
+			mt.Errorf("readFree5gcSubscribers succeeded")
+		}
+	})
+}
+
+func TestReadOpen5gsSubscribers(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	mt.Run("reads subscribers", func(mt *mtest.T) {
+		stored, err := open5gsSubscriberOf(testUeProfile())
+		if err != nil {
+			mt.Fatalf("open5gsSubscriberOf: %v", err)
+		}
+		withImeisv := testUeProfile()
+		withImeisv.Supi, withImeisv.Op, withImeisv.Opc = "imsi-208930000000002", "cdc202d5123e20f62b6d676ac72cb318", ""
+		withImeisv.Sqn, withImeisv.DefaultSlice = "FFFFFFFFFFE0", nil
+		second, err := open5gsSubscriberOf(withImeisv)
+		if err != nil {
+			mt.Fatalf("open5gsSubscriberOf: %v", err)
+		}
+		second.Imeisv = "4370816125816151"
+
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, mt.DB.Name()+".subscribers", mtest.FirstBatch,
+			free5gcDoc(mt.T, stored), free5gcDoc(mt.T, second),
+			bson.D{{Key: "imsi", Value: "208930000000003"}, {Key: "security", Value: "465b5ce8b199b49faa5f0a2ee238a6bc"}},
+		))
+
+		subscribers, rejected, err := readOpen5gsSubscribers(context.Background(), mt.DB)
+		if err != nil {
+			mt.Fatalf("readOpen5gsSubscribers: %v", err)
+		}
+
+		first := importedTestUe()
+		first.AuthenticationMethod = ""
+		wantSecond := importedTestUe()
+		wantSecond.Supi, wantSecond.Op, wantSecond.Opc = withImeisv.Supi, withImeisv.Op, ""
+		wantSecond.Sqn, wantSecond.Imeisv, wantSecond.AuthenticationMethod = "ffffffffffe0", second.Imeisv, ""
+		// Without default slices the first slice is the default one
+		wantSecond.DefaultSlice = []models.Snssai{{Sst: 1, Sd: "010203"}}
+		want := []utils.ImportedUe{first, wantSecond}
+		if !reflect.DeepEqual(subscribers, want) {
+			mt.Errorf("subscribers = %+v, want %+v", subscribers, want)
+		}
+
+		if len(rejected) != 1 || rejected[0].Supi != "imsi-208930000000003" {
+			mt.Errorf("rejected = %+v, want imsi-208930000000003", rejected)
+		}
+	})
+
+	mt.Run("fails on a read error", func(mt *mtest.T) {
+		mt.AddMockResponses(mtest.CreateCommandErrorResponse(mtest.CommandError{Code: 13, Message: "unauthorized"}))
+		if _, _, err := readOpen5gsSubscribers(context.Background(), mt.DB); err == nil {
+			mt.Errorf("readOpen5gsSubscribers succeeded")
+		}
+	})
+}
@@ -0,0 +1,284 @@
+package services
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"backend-webUE/models"
+	"backend-webUE/utils"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var (
+	ErrUnknownExportFormat  = errors.New("unknown export format")
+	ErrUnknownArchiveFormat = errors.New("unknown archive format")
+)
+
+// MissingUeProfilesError lists the selected SUPIs that have no UE profile
+type MissingUeProfilesError struct {
+	Supis []string
+}
+
+func (e *MissingUeProfilesError) Error() string {
+	return fmt.Sprintf("UE profiles not found: %s", strings.Join(e.Supis, ", "))
+}
+
+func (e *MissingUeProfilesError) Unwrap() error {
+	return ErrUeProfileNotFound
+}
+
+// ExportFormat renders a single UE profile as a simulator or core configuration file
+type ExportFormat struct {
+	Name string
+	// File extension of one exported profile, including the dot
+	Ext         string
+	ContentType string
+	Render      func(ue *models.UeProfile) ([]byte, error)
+}
+
+var exportFormats = map[string]*ExportFormat{
+	"ueransim": {
+		Name:        "ueransim",
+		Ext:         ".yaml",
+		ContentType: "application/yaml",
+		Render:      utils.UeransimConfig,
+	},
+	"packetrusher": {
+		Name:        "packetrusher",
+		Ext:         ".yml",
+		ContentType: "application/yaml",
+		Render:      utils.PacketRusherUeConfig,
+	},
+	"open5gs": {
+		Name:        "open5gs",
+		Ext:         ".json",
+		ContentType: "application/json",
+		Render:      Open5gsSubscriberJSON,
+	},
+}
+
+// Archive formats of bulk exports
+const (
+	ArchiveTar = "tar"
+	ArchiveZip = "zip"
+)
+
+// archiveWriter adds one file per exported UE to an archive
+type archiveWriter interface {
+	add(name string, data []byte) error
+	Flush() error
+	Close() error
+}
+
+type tarArchive struct {
+	w *tar.Writer
+}
+
+func (a *tarArchive) add(name string, data []byte) error {
+	header := &tar.Header{
+		Name:    name,
+		Mode:    0644,
+		Size:    int64(len(data)),
+		ModTime: time.Now(),
+	}
+	if err := a.w.WriteHeader(header); err != nil {
+		return err
+	}
+	_, err := a.w.Write(data)
+	return err
+}
+
+func (a *tarArchive) Flush() error {
+	return a.w.Flush()
+}
+
+func (a *tarArchive) Close() error {
+	return a.w.Close()
+}
+
+type zipArchive struct {
+	w *zip.Writer
+}
+
+func (a *zipArchive) add(name string, data []byte) error {
+	f, err := a.w.CreateHeader(&zip.FileHeader{
+		Name:     name,
+		Method:   zip.Deflate,
+		Modified: time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	return err
+}
+
+func (a *zipArchive) Flush() error {
+	return a.w.Flush()
+}
+
+func (a *zipArchive) Close() error {
+	return a.w.Close()
+}
+
+// ExportService renders UE profiles in the formats of UE simulators and cores
+type ExportService struct {
+	ueProfiles *UeProfileService
+}
+
+func NewExportService(ueProfiles *UeProfileService) *ExportService {
+	return &ExportService{
+		ueProfiles: ueProfiles,
+	}
+}
+
+// Format looks up an export format by name
+func (s *ExportService) Format(name string) (*ExportFormat, error) {
+	format, ok := exportFormats[strings.ToLower(name)]
+	if !ok {
+		names := make([]string, 0, len(exportFormats))
+		for n := range exportFormats {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("%w %q, supported: %s", ErrUnknownExportFormat, name, strings.Join(names, ", "))
+	}
+	return format, nil
+}
+
+// ExportUeProfile renders one UE profile in the given format
+func (s *ExportService) ExportUeProfile(ctx context.Context, userID primitive.ObjectID, supi, formatName string) ([]byte, *ExportFormat, error) {
+	format, err := s.Format(formatName)
+	if err != nil {
+		return nil, nil, err
+	}
+	ueProfile, err := s.ueProfiles.GetUeProfile(ctx, userID, supi)
+	if err != nil {
+		return nil, nil, err
+	}
+	if ueProfile == nil {
+		return nil, nil, ErrUeProfileNotFound
+	}
+	out, err := format.Render(ueProfile)
+	if err != nil {
+		return nil, nil, err
+	}
+	return out, format, nil
+}
+
+// CheckArchiveExport validates a bulk export before anything is streamed:
+// the format and archive must be known and every selected SUPI must exist
+func (s *ExportService) CheckArchiveExport(ctx context.Context, userID primitive.ObjectID, supis []string, formatName, archive string) error {
+	if _, err := s.Format(formatName); err != nil {
+		return err
+	}
+	if archive != ArchiveTar && archive != ArchiveZip {
+		return fmt.Errorf("%w %q, supported: %s, %s", ErrUnknownArchiveFormat, archive, ArchiveTar, ArchiveZip)
+	}
+	return s.CheckSelection(ctx, userID, supis)
+}
+
+// ExportArchive streams a tar or zip archive with one file per UE profile.
+// All profiles of the user are exported when supis is empty.
+func (s *ExportService) ExportArchive(ctx context.Context, userID primitive.ObjectID, supis []string, formatName, archive string, stream *ExportStream) error {
+	format, err := s.Format(formatName)
+	if err != nil {
+		return err
+	}
+
+	var aw archiveWriter
+	switch archive {
+	case ArchiveTar:
+		aw = &tarArchive{w: tar.NewWriter(stream)}
+	case ArchiveZip:
+		aw = &zipArchive{w: zip.NewWriter(stream)}
+	default:
+		return fmt.Errorf("%w %q", ErrUnknownArchiveFormat, archive)
+	}
+
+	err = s.ueProfiles.repo.Each(ctx, userID, supis, func(ueProfile *models.UeProfile) error {
+		out, err := format.Render(ueProfile)
+		if err != nil {
+			return err
+		}
+		if err := aw.add(ueProfile.Supi+format.Ext, out); err != nil {
+			return fmt.Errorf("failed to write %s to archive: %v", ueProfile.Supi, err)
+		}
+		return stream.EndRecord(aw.Flush)
+	})
+	if err != nil {
+		return err
+	}
+	return aw.Close()
+}
+
+// ExportPacketRusher renders the selected UE profiles as one PacketRusher multi-UE config.
+// The profiles must form a contiguous MSIN range, see utils.PacketRusherRun. They are checked as they
+// are read in SUPI order, which is MSIN order within a run, so any number of UEs fits in memory.
+func (s *ExportService) ExportPacketRusher(ctx context.Context, userID primitive.ObjectID, supis []string, opts utils.PacketRusherOptions) ([]byte, error) {
+	if err := s.CheckSelection(ctx, userID, supis); err != nil {
+		return nil, err
+	}
+
+	var run utils.PacketRusherRun
+	err := s.ueProfiles.repo.Each(ctx, userID, supis, func(ueProfile *models.UeProfile) error {
+		run.Add(ueProfile)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return run.Config(opts)
+}
+
+// CheckSelection checks that every selected SUPI has a UE profile of the user
+func (s *ExportService) CheckSelection(ctx context.Context, userID primitive.ObjectID, supis []string) error {
+	if len(supis) == 0 {
+		return nil
+	}
+	missing, err := s.ueProfiles.missingSupis(ctx, userID, supis)
+	if err != nil {
+		return err
+	}
+	if len(missing) > 0 {
+		return &MissingUeProfilesError{Supis: missing}
+	}
+	return nil
+}
+
+// ExportOpen5gs streams the selected UE profiles as a JSON array of Open5GS subscribers,
+// for mongoimport --jsonArray into the subscribers collection
+func (s *ExportService) ExportOpen5gs(ctx context.Context, userID primitive.ObjectID, supis []string, stream *ExportStream) error {
+	sep := "[\n"
+	err := s.ueProfiles.repo.Each(ctx, userID, supis, func(ueProfile *models.UeProfile) error {
+		out, err := Open5gsSubscriberJSON(ueProfile)
+		if err != nil {
+			return err
+		}
+		if _, err := io.WriteString(stream, sep); err != nil {
+			return err
+		}
+		if _, err := stream.Write(bytes.TrimSuffix(out, []byte("\n"))); err != nil {
+			return err
+		}
+		sep = ",\n"
+		return stream.EndRecord()
+	})
+	if err != nil {
+		return err
+	}
+	if sep == "[\n" {
+		_, err = io.WriteString(stream, "[]\n")
+	} else {
+		_, err = io.WriteString(stream, "\n]\n")
+	}
+	return err
+}
@@ -0,0 +1,224 @@
+package services
+
+import (
+	"backend-webUE/models"
+	"backend-webUE/utils"
+	"compress/gzip"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// DefaultExportFlushEvery is the number of records between flushes of an export stream
+const DefaultExportFlushEvery = 100
+
+// ExportStream writes a bulk export to a response or file as the profiles are read, optionally gzip
+// compressed. It flushes every flushEvery records, so clients receive a long export progressively
+// and nothing but the current record is held in memory.
+type ExportStream struct {
+	w          io.Writer
+	gz         *gzip.Writer
+	flushEvery int
+	records    int
+}
+
+// NewExportStream streams to w, which is flushed as well when it is an http.Flusher.
+// flushEvery <= 0 selects DefaultExportFlushEvery.
+func NewExportStream(w io.Writer, compress bool, flushEvery int) *ExportStream {
+	if flushEvery <= 0 {
+		flushEvery = DefaultExportFlushEvery
+	}
+	s := &ExportStream{w: w, flushEvery: flushEvery}
+	if compress {
+		s.gz = gzip.NewWriter(w)
+	}
+	return s
+}
+
+func (s *ExportStream) Write(p []byte) (int, error) {
+	if s.gz != nil {
+		return s.gz.Write(p)
+	}
+	return s.w.Write(p)
+}
+
+// EndRecord marks the end of a record and flushes the stream every flushEvery records.
+// buffers flush the writers an exporter layers on the stream, before the stream itself.
+func (s *ExportStream) EndRecord(buffers ...func() error) error {
+	s.records++
+	if s.records%s.flushEvery != 0 {
+		return nil
+	}
+	for _, flush := range buffers {
+		if err := flush(); err != nil {
+			return err
+		}
+	}
+	return s.Flush()
+}
+
+// Flush sends the data written so far to the client
+func (s *ExportStream) Flush() error {
+	if s.gz != nil {
+		if err := s.gz.Flush(); err != nil {
+			return err
+		}
+	}
+	if flusher, ok := s.w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+	return nil
+}
+
+// Close ends the gzip stream and flushes the rest of the export, it does not close w
+func (s *ExportStream) Close() error {
+	if s.gz != nil {
+		if err := s.gz.Close(); err != nil {
+			return err
+		}
+	}
+	if flusher, ok := s.w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+	return nil
+}
+
+// Record formats of NDJSON exports
+const (
+	NdjsonRecordProfile = "profile"
+	NdjsonRecordOpen5gs = "open5gs"
+)
+
+// CheckNdjsonExport validates an NDJSON export before anything is streamed:
+// the record format must be known and every selected SUPI must exist
+func (s *ExportService) CheckNdjsonExport(ctx context.Context, userID primitive.ObjectID, supis []string, record string) error {
+	if record != NdjsonRecordProfile && record != NdjsonRecordOpen5gs {
+		return fmt.Errorf("%w %q, supported: %s, %s", ErrUnknownExportFormat, record, NdjsonRecordProfile, NdjsonRecordOpen5gs)
+	}
+	return s.CheckSelection(ctx, userID, supis)
+}
+
+// ExportNdjson streams the selected UE profiles as one JSON document per line, all profiles of
+// the user when supis is empty. Profile records are the API representation read by JSON Lines
+// imports, open5gs records are subscribers for mongoimport.
+func (s *ExportService) ExportNdjson(ctx context.Context, userID primitive.ObjectID, supis []string, record string, stream *ExportStream) error {
+	return writeNdjson(s.selected(ctx, userID, supis), record, stream)
+}
+
+// ExportTaggedNdjson streams the UE profiles carrying the tag as NDJSON, see ExportNdjson
+func (s *ExportService) ExportTaggedNdjson(ctx context.Context, userID primitive.ObjectID, tag, record string, stream *ExportStream) error {
+	return writeNdjson(s.tagged(ctx, userID, tag), record, stream)
+}
+
+// eachUeProfile calls fn with every UE profile of an export
+type eachUeProfile func(fn func(*models.UeProfile) error) error
+
+func (s *ExportService) selected(ctx context.Context, userID primitive.ObjectID, supis []string) eachUeProfile {
+	return func(fn func(*models.UeProfile) error) error {
+		return s.ueProfiles.repo.Each(ctx, userID, supis, fn)
+	}
+}
+
+func (s *ExportService) tagged(ctx context.Context, userID primitive.ObjectID, tag string) eachUeProfile {
+	return func(fn func(*models.UeProfile) error) error {
+		return s.ueProfiles.EachTagged(ctx, userID, tag, fn)
+	}
+}
+
+func writeNdjson(each eachUeProfile, record string, stream *ExportStream) error {
+	var render func(ue *models.UeProfile) ([]byte, error)
+	switch record {
+	case NdjsonRecordProfile:
+		render = func(ue *models.UeProfile) ([]byte, error) { return json.Marshal(ue) }
+	case NdjsonRecordOpen5gs:
+		render = func(ue *models.UeProfile) ([]byte, error) {
+			sub, err := open5gsSubscriberOf(ue)
+			if err != nil {
+				return nil, err
+			}
+			return bson.MarshalExtJSON(sub, true, false)
+		}
+	default:
+		return fmt.Errorf("%w %q, supported: %s, %s", ErrUnknownExportFormat, record, NdjsonRecordProfile, NdjsonRecordOpen5gs)
+	}
+
+	return each(func(ueProfile *models.UeProfile) error {
+		out, err := render(ueProfile)
+		if err != nil {
+			return fmt.Errorf("UE profile %s: %v", ueProfile.Supi, err)
+		}
+		if _, err := stream.Write(append(out, '\n')); err != nil {
+			return err
+		}
+		return stream.EndRecord()
+	})
+}
+
+// ExportCsv streams the selected UE profiles as CSV with the columns of CSV imports, all profiles
+// of the user when supis is empty. The opc column is left empty for profiles with an operator OP.
+func (s *ExportService) ExportCsv(ctx context.Context, userID primitive.ObjectID, supis []string, stream *ExportStream) error {
+	return writeCsv(s.selected(ctx, userID, supis), stream)
+}
+
+// ExportTaggedCsv streams the UE profiles carrying the tag as CSV, see ExportCsv
+func (s *ExportService) ExportTaggedCsv(ctx context.Context, userID primitive.ObjectID, tag string, stream *ExportStream) error {
+	return writeCsv(s.tagged(ctx, userID, tag), stream)
+}
+
+func writeCsv(each eachUeProfile, stream *ExportStream) error {
+	w := csv.NewWriter(stream)
+	flush := func() error {
+		w.Flush()
+		return w.Error()
+	}
+	if err := w.Write(importCsvColumns); err != nil {
+		return err
+	}
+
+	err := each(func(ue *models.UeProfile) error {
+		op, opc := "", ue.Opc
+		if ue.OpType == utils.OP {
+			op, opc = ue.Op, ""
+		}
+		sessions := make([]string, len(ue.Sessions))
+		for i, session := range ue.Sessions {
+			sessions[i] = session.Type + ":" + session.Apn + ":" + formatCsvSnssai(session.Slice)
+		}
+		row := []string{
+			ue.Supi, ue.Key, opc, op, ue.Amf, ue.Sqn, ue.AuthenticationMethod, ue.Imei, ue.Imeisv,
+			formatCsvSlices(ue.DefaultSlice), formatCsvSlices(ue.ConfiguredSlice), strings.Join(sessions, ";"),
+		}
+		if err := w.Write(row); err != nil {
+			return err
+		}
+		return stream.EndRecord(flush)
+	})
+	if err != nil {
+		return err
+	}
+	return flush()
+}
+
+// formatCsvSnssai writes an S-NSSAI as sst[:sd], the inverse of parseImportSnssai
+func formatCsvSnssai(snssai models.Snssai) string {
+	if snssai.Sd == "" {
+		return strconv.Itoa(snssai.Sst)
+	}
+	return strconv.Itoa(snssai.Sst) + ":" + snssai.Sd
+}
+
+func formatCsvSlices(slices []models.Snssai) string {
+	items := make([]string, len(slices))
+	for i, snssai := range slices {
+		items[i] = formatCsvSnssai(snssai)
+	}
+	return strings.Join(items, ";")
+}
@@ -0,0 +1,44 @@
+package services
+
+import (
+	"backend-webUE/utils"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestExportPacketRusher(t *testing.T) {
+	imports, ueProfiles, _ := newTestBulkImportService(t)
+	s := NewExportService(ueProfiles)
+	ctx := context.Background()
+	userID := primitive.NewObjectID()
+
+	// Three UEs sharing everything but their MSIN, imported out of order
+	file := importTestHeader
+	for _, msin := range []string{"000000003", "000000001", "000000002"} {
+		file += strings.Replace(importTestRow, "000000001", msin, 1)
+	}
+	if _, err := imports.Import(ctx, userID, strings.NewReader(file), BulkImportParams{Format: ImportFormatCsv, Schemes: utils.SchemeMix{Null: 1}}); err != nil {
+		t.Fatalf("Import: %v", err)
+	}
+
+	out, err := s.ExportPacketRusher(ctx, userID, nil, utils.PacketRusherOptions{})
+	if err != nil {
+		t.Fatalf("ExportPacketRusher: %v", err)
+	}
+	if !strings.HasPrefix(string(out), "# 3 UEs from imsi-208930000000001,") || !strings.Contains(string(out), `msin: "0000000001"`) {
+		t.Errorf("config:\n%s", out)
+	}
+
+	_, err = s.ExportPacketRusher(ctx, userID, []string{"imsi-208930000000001", "imsi-208930000000003"}, utils.PacketRusherOptions{})
+	var rangeErr *utils.PacketRusherRangeError
+	if !errors.As(err, &rangeErr) || len(rangeErr.Reasons) != 1 || !strings.HasPrefix(rangeErr.Reasons[0], "MSIN gap") {
+		t.Errorf("ExportPacketRusher of a gap = %v, want a MSIN gap", err)
+	}
+	if _, err := s.ExportPacketRusher(ctx, userID, []string{"imsi-208930000000004"}, utils.PacketRusherOptions{}); !errors.Is(err, ErrUeProfileNotFound) {
+		t.Errorf("ExportPacketRusher of a missing SUPI = %v, want ErrUeProfileNotFound", err)
+	}
+}
@@ -0,0 +1,393 @@
+package services
+
+import (
+	"backend-webUE/models"
+	"backend-webUE/utils"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"reflect"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Subscription defaults free5GC's webconsole uses for new subscribers
+const (
+	free5gcUeAmbrUplink        = "1 Gbps"
+	free5gcUeAmbrDownlink      = "2 Gbps"
+	free5gcSessionAmbrUplink   = "200 Mbps"
+	free5gcSessionAmbrDownlink = "100 Mbps"
+	free5gc5qi                 = 9
+	free5gcArpPriorityLevel    = 8
+)
+
+// Documents of the free5GC UDR collections (TS 29.505 data types as stored by free5GC).
+// Except for the authentication subscription, their JSON encoding is the Nudr_DataRepository body.
+
+type free5gcKey struct {
+	Value               string `bson:"permanentKeyValue"`
+	EncryptionKey       int    `bson:"encryptionKey"`
+	EncryptionAlgorithm int    `bson:"encryptionAlgorithm"`
+}
+
+type free5gcOp struct {
+	OpValue             string `bson:"opValue"`
+	EncryptionKey       int    `bson:"encryptionKey"`
+	EncryptionAlgorithm int    `bson:"encryptionAlgorithm"`
+}
+
+type free5gcOpc struct {
+	OpcValue            string `bson:"opcValue"`
+	EncryptionKey       int    `bson:"encryptionKey"`
+	EncryptionAlgorithm int    `bson:"encryptionAlgorithm"`
+}
+
+// free5gcAuthenticationSubscription is written in the format of free5GC v3.4 and later, with a
+// TS 29.505 SequenceNumber. Earlier releases stored the SQN as a string, see free5gcStoredAuthentication.
+type free5gcAuthenticationSubscription struct {
+	UeId                          string              `bson:"ueId"`
+	AuthenticationMethod          string              `bson:"authenticationMethod"`
+	PermanentKey                  free5gcKey          `bson:"permanentKey"`
+	SequenceNumber                *nudrSequenceNumber `bson:"sequenceNumber"`
+	AuthenticationManagementField string              `bson:"authenticationManagementField"`
+	Milenage                      struct {
+		Op free5gcOp `bson:"op"`
+	} `bson:"milenage"`
+	Opc free5gcOpc `bson:"opc"`
+}
+
+type free5gcSnssai struct {
+	Sst int    `json:"sst" bson:"sst"`
+	Sd  string `json:"sd,omitempty" bson:"sd,omitempty"`
+}
+
+type free5gcAmbr struct {
+	Uplink   string `json:"uplink" bson:"uplink"`
+	Downlink string `json:"downlink" bson:"downlink"`
+}
+
+type free5gcAmData struct {
+	UeId             string      `json:"-" bson:"ueId"`
+	ServingPlmnId    string      `json:"-" bson:"servingPlmnId"`
+	SubscribedUeAmbr free5gcAmbr `json:"subscribedUeAmbr" bson:"subscribedUeAmbr"`
+	Nssai            struct {
+		DefaultSingleNssais []free5gcSnssai `json:"defaultSingleNssais" bson:"defaultSingleNssais"`
+		SingleNssais        []free5gcSnssai `json:"singleNssais" bson:"singleNssais"`
+	} `json:"nssai" bson:"nssai"`
+}
+
+type free5gcDnnInfo struct {
+	Dnn string `json:"dnn" bson:"dnn"`
+}
+
+type free5gcSnssaiInfo struct {
+	DnnInfos []free5gcDnnInfo `json:"dnnInfos" bson:"dnnInfos"`
+}
+
+type free5gcSmfSelectionData struct {
+	UeId                  string                       `json:"-" bson:"ueId"`
+	ServingPlmnId         string                       `json:"-" bson:"servingPlmnId"`
+	SubscribedSnssaiInfos map[string]free5gcSnssaiInfo `json:"subscribedSnssaiInfos" bson:"subscribedSnssaiInfos"`
+}
+
+type free5gcDnnConfiguration struct {
+	PduSessionTypes struct {
+		DefaultSessionType  string   `json:"defaultSessionType" bson:"defaultSessionType"`
+		AllowedSessionTypes []string `json:"allowedSessionTypes" bson:"allowedSessionTypes"`
+	} `json:"pduSessionTypes" bson:"pduSessionTypes"`
+	SscModes struct {
+		DefaultSscMode  string   `json:"defaultSscMode" bson:"defaultSscMode"`
+		AllowedSscModes []string `json:"allowedSscModes" bson:"allowedSscModes"`
+	} `json:"sscModes" bson:"sscModes"`
+	QosProfile struct {
+		Qi  int `json:"5qi" bson:"5qi"`
+		Arp struct {
+			PriorityLevel int    `json:"priorityLevel" bson:"priorityLevel"`
+			PreemptCap    string `json:"preemptCap" bson:"preemptCap"`
+			PreemptVuln   string `json:"preemptVuln" bson:"preemptVuln"`
+		} `json:"arp" bson:"arp"`
+		PriorityLevel int `json:"priorityLevel" bson:"priorityLevel"`
+	} `json:"5gQosProfile" bson:"5gQosProfile"`
+	SessionAmbr free5gcAmbr `json:"sessionAmbr" bson:"sessionAmbr"`
+}
+
+type free5gcSmData struct {
+	UeId              string                             `json:"-" bson:"ueId"`
+	ServingPlmnId     string                             `json:"-" bson:"servingPlmnId"`
+	SingleNssai       free5gcSnssai                      `json:"singleNssai" bson:"singleNssai"`
+	DnnConfigurations map[string]free5gcDnnConfiguration `json:"dnnConfigurations" bson:"dnnConfigurations"`
+}
+
+// free5gcSnssaiOf converts a UE profile S-NSSAI, the SD is stored without 0x as in free5GC
+func free5gcSnssaiOf(snssai models.Snssai) free5gcSnssai {
+	return free5gcSnssai{Sst: snssai.Sst, Sd: strings.ToLower(strings.TrimPrefix(snssai.Sd, "0x"))}
+}
+
+// free5gcSnssaiKey is the key of an S-NSSAI in subscribedSnssaiInfos: SST as 2 hex digits followed by SD
+func free5gcSnssaiKey(snssai free5gcSnssai) string {
+	return fmt.Sprintf("%02x%s", snssai.Sst, snssai.Sd)
+}
+
+// free5gcPduSessionType maps the UERANSIM session types onto TS 29.571 PduSessionType
+func free5gcPduSessionType(sessionType string) string {
+	switch strings.ToUpper(sessionType) {
+	case "IPV6":
+		return "IPV6"
+	case "IPV4V6":
+		return "IPV4V6"
+	case "ETHERNET":
+		return "ETHERNET"
+	default:
+		return "IPV4"
+	}
+}
+
+// Free5gcProvisioner writes UE profiles into the subscriber collections of a free5GC MongoDB
+type Free5gcProvisioner struct {
+	// nil in mock mode
+	db     *mongo.Database
+	config utils.Free5gcDbConfig
+}
+
+// NewFree5gcProvisioner creates a provisioner over a free5GC database, db may be nil when config.Mock is set
+func NewFree5gcProvisioner(db *mongo.Database, config utils.Free5gcDbConfig) *Free5gcProvisioner {
+	return &Free5gcProvisioner{
+		db:     db,
+		config: config,
+	}
+}
+
+// Name of the free5GC target
+func (p *Free5gcProvisioner) Name() string {
+	return "free5gc"
+}
+
+// free5gcDocuments maps a UE profile onto the free5GC subscriber documents
+func free5gcDocuments(ue *models.UeProfile) (*free5gcAuthenticationSubscription, *free5gcAmData, *free5gcSmfSelectionData, []free5gcSmData) {
+	servingPlmnId := ue.PlmnId.Mcc + ue.PlmnId.Mnc
+
+	auth := &free5gcAuthenticationSubscription{
+		UeId:                          ue.Supi,
+		AuthenticationMethod:          ue.AuthenticationMethod,
+		PermanentKey:                  free5gcKey{Value: ue.Key},
+		SequenceNumber:                &nudrSequenceNumber{SqnScheme: "NON_TIME_BASED", Sqn: ue.Sqn, LastIndexes: map[string]int{"ausf": 0}},
+		AuthenticationManagementField: ue.Amf,
+	}
+	if auth.AuthenticationMethod == "" {
+		auth.AuthenticationMethod = utils.AUTH_5G_AKA
+	}
+	// free5GC derives OPc from OP when opcValue is empty, prefer the stored OPc
+	if ue.Opc != "" {
+		auth.Opc.OpcValue = ue.Opc
+	} else if ue.OpType == utils.OPC {
+		auth.Opc.OpcValue = ue.Op
+	} else {
+		auth.Milenage.Op.OpValue = ue.Op
+	}
+
+	am := &free5gcAmData{
+		UeId:             ue.Supi,
+		ServingPlmnId:    servingPlmnId,
+		SubscribedUeAmbr: free5gcAmbr{Uplink: free5gcUeAmbrUplink, Downlink: free5gcUeAmbrDownlink},
+	}
+	am.Nssai.DefaultSingleNssais = []free5gcSnssai{}
+	am.Nssai.SingleNssais = []free5gcSnssai{}
+	for _, snssai := range ue.DefaultSlice {
+		am.Nssai.DefaultSingleNssais = append(am.Nssai.DefaultSingleNssais, free5gcSnssaiOf(snssai))
+	}
+	for _, snssai := range ue.ConfiguredSlice {
+		am.Nssai.SingleNssais = append(am.Nssai.SingleNssais, free5gcSnssaiOf(snssai))
+	}
+
+	// One SMF selection entry and one smData document per slice of the UE sessions
+	smfSel := &free5gcSmfSelectionData{
+		UeId:                  ue.Supi,
+		ServingPlmnId:         servingPlmnId,
+		SubscribedSnssaiInfos: map[string]free5gcSnssaiInfo{},
+	}
+	var smData []free5gcSmData
+	smDataBySlice := map[string]int{}
+	for _, session := range ue.Sessions {
+		snssai := free5gcSnssaiOf(session.Slice)
+		key := free5gcSnssaiKey(snssai)
+
+		info := smfSel.SubscribedSnssaiInfos[key]
+		info.DnnInfos = append(info.DnnInfos, free5gcDnnInfo{Dnn: session.Apn})
+		smfSel.SubscribedSnssaiInfos[key] = info
+
+		i, ok := smDataBySlice[key]
+		if !ok {
+			i = len(smData)
+			smDataBySlice[key] = i
+			smData = append(smData, free5gcSmData{
+				UeId:              ue.Supi,
+				ServingPlmnId:     servingPlmnId,
+				SingleNssai:       snssai,
+				DnnConfigurations: map[string]free5gcDnnConfiguration{},
+			})
+		}
+
+		var dnn free5gcDnnConfiguration
+		pduSessionType := free5gcPduSessionType(session.Type)
+		dnn.PduSessionTypes.DefaultSessionType = pduSessionType
+		dnn.PduSessionTypes.AllowedSessionTypes = []string{pduSessionType}
+		dnn.SscModes.DefaultSscMode = "SSC_MODE_1"
+		dnn.SscModes.AllowedSscModes = []string{"SSC_MODE_2", "SSC_MODE_3"}
+		dnn.QosProfile.Qi = free5gc5qi
+		dnn.QosProfile.Arp.PriorityLevel = free5gcArpPriorityLevel
+		dnn.QosProfile.PriorityLevel = free5gcArpPriorityLevel
+		dnn.SessionAmbr = free5gcAmbr{Uplink: free5gcSessionAmbrUplink, Downlink: free5gcSessionAmbrDownlink}
+		smData[i].DnnConfigurations[session.Apn] = dnn
+	}
+	return auth, am, smfSel, smData
+}
+
+// mockWrite logs a document instead of writing it
+func (p *Free5gcProvisioner) mockWrite(collection string, doc interface{}) {
+	out, _ := bson.MarshalExtJSON(doc, false, false)
+	log.Printf("free5GC mock: upsert into %s: %s", collection, out)
+}
+
+// upsert replaces the document matching filter, or inserts it
+func (p *Free5gcProvisioner) upsert(ctx context.Context, collection string, filter bson.M, doc interface{}) error {
+	if p.config.Mock {
+		p.mockWrite(collection, doc)
+		return nil
+	}
+	_, err := p.db.Collection(collection).ReplaceOne(ctx, filter, doc, options.Replace().SetUpsert(true))
+	if err != nil {
+		return fmt.Errorf("failed to upsert into %s: %v", collection, err)
+	}
+	return nil
+}
+
+// Provision writes the authentication, access and mobility, SMF selection and session management
+// subscription of a UE. It is idempotent: existing documents of the UE are replaced.
+func (p *Free5gcProvisioner) Provision(ctx context.Context, ue *models.UeProfile) error {
+	auth, am, smfSel, smData := free5gcDocuments(ue)
+	ueFilter := bson.M{"ueId": ue.Supi}
+	plmnFilter := bson.M{"ueId": ue.Supi, "servingPlmnId": am.ServingPlmnId}
+
+	if err := p.upsert(ctx, p.config.Auth, ueFilter, auth); err != nil {
+		return fmt.Errorf("%w %s: %v", ErrProvisionFailed, ue.Supi, err)
+	}
+	if err := p.upsert(ctx, p.config.Am, plmnFilter, am); err != nil {
+		return fmt.Errorf("%w %s: %v", ErrProvisionFailed, ue.Supi, err)
+	}
+	if err := p.upsert(ctx, p.config.Smsel, plmnFilter, smfSel); err != nil {
+		return fmt.Errorf("%w %s: %v", ErrProvisionFailed, ue.Supi, err)
+	}
+
+	// Drop the session management data of slices the UE no longer has, then upsert the others
+	var slices []bson.M
+	for _, data := range smData {
+		filter := bson.M{"ueId": ue.Supi, "servingPlmnId": am.ServingPlmnId, "singleNssai.sst": data.SingleNssai.Sst, "singleNssai.sd": data.SingleNssai.Sd}
+		if data.SingleNssai.Sd == "" {
+			filter["singleNssai.sd"] = bson.M{"$exists": false}
+		}
+		if err := p.upsert(ctx, p.config.Sessman, filter, data); err != nil {
+			return fmt.Errorf("%w %s: %v", ErrProvisionFailed, ue.Supi, err)
+		}
+		slices = append(slices, bson.M{"singleNssai.sst": data.SingleNssai.Sst, "singleNssai.sd": filter["singleNssai.sd"]})
+	}
+	if p.config.Mock {
+		return nil
+	}
+	staleFilter := bson.M{"ueId": ue.Supi, "servingPlmnId": am.ServingPlmnId}
+	if len(slices) > 0 {
+		staleFilter["$nor"] = slices
+	}
+	if _, err := p.db.Collection(p.config.Sessman).DeleteMany(ctx, staleFilter); err != nil {
+		return fmt.Errorf("%w %s: failed to delete from %s: %v", ErrProvisionFailed, ue.Supi, p.config.Sessman, err)
+	}
+	return nil
+}
+
+// Deprovision removes every free5GC subscriber document of a UE
+func (p *Free5gcProvisioner) Deprovision(ctx context.Context, ue *models.UeProfile) error {
+	supi := ue.Supi
+	for _, collection := range []string{p.config.Auth, p.config.Am, p.config.Smsel, p.config.Sessman} {
+		if p.config.Mock {
+			log.Printf("free5GC mock: delete %s from %s", supi, collection)
+			continue
+		}
+		if _, err := p.db.Collection(collection).DeleteMany(ctx, bson.M{"ueId": supi}); err != nil {
+			return fmt.Errorf("%w %s: failed to delete from %s: %v", ErrDeprovisionFailed, supi, collection, err)
+		}
+	}
+	return nil
+}
+
+// findOne decodes the document matching filter, a missing document is a mismatch
+func (p *Free5gcProvisioner) findOne(ctx context.Context, collection string, filter bson.M, doc interface{}) error {
+	err := p.db.Collection(collection).FindOne(ctx, filter).Decode(doc)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return fmt.Errorf("%w: no document in %s", ErrProvisionMismatch, collection)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %v", collection, err)
+	}
+	return nil
+}
+
+// Verify compares the free5GC subscriber documents of a UE with its profile.
+// The sequence number is not compared, free5GC advances it on every authentication.
+func (p *Free5gcProvisioner) Verify(ctx context.Context, ue *models.UeProfile) error {
+	if p.config.Mock {
+		log.Printf("free5GC mock: verify %s", ue.Supi)
+		return nil
+	}
+	auth, am, smfSel, smData := free5gcDocuments(ue)
+	ueFilter := bson.M{"ueId": ue.Supi}
+	plmnFilter := bson.M{"ueId": ue.Supi, "servingPlmnId": am.ServingPlmnId}
+
+	var storedAuth free5gcStoredAuthentication
+	if err := p.findOne(ctx, p.config.Auth, ueFilter, &storedAuth); err != nil {
+		return fmt.Errorf("%s: %w", ue.Supi, err)
+	}
+	if !reflect.DeepEqual(storedAuth.subscription(auth.SequenceNumber), auth) {
+		return fmt.Errorf("%w: %s differs in %s", ErrProvisionMismatch, ue.Supi, p.config.Auth)
+	}
+
+	var storedAm free5gcAmData
+	if err := p.findOne(ctx, p.config.Am, plmnFilter, &storedAm); err != nil {
+		return fmt.Errorf("%s: %w", ue.Supi, err)
+	}
+	if !reflect.DeepEqual(&storedAm, am) {
+		return fmt.Errorf("%w: %s differs in %s", ErrProvisionMismatch, ue.Supi, p.config.Am)
+	}
+
+	var storedSmfSel free5gcSmfSelectionData
+	if err := p.findOne(ctx, p.config.Smsel, plmnFilter, &storedSmfSel); err != nil {
+		return fmt.Errorf("%s: %w", ue.Supi, err)
+	}
+	if !reflect.DeepEqual(&storedSmfSel, smfSel) {
+		return fmt.Errorf("%w: %s differs in %s", ErrProvisionMismatch, ue.Supi, p.config.Smsel)
+	}
+
+	cursor, err := p.db.Collection(p.config.Sessman).Find(ctx, plmnFilter)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %v", p.config.Sessman, err)
+	}
+	var storedSmData []free5gcSmData
+	if err := cursor.All(ctx, &storedSmData); err != nil {
+		return fmt.Errorf("failed to read %s: %v", p.config.Sessman, err)
+	}
+	expected := make(map[string]free5gcSmData, len(smData))
+	for _, data := range smData {
+		expected[free5gcSnssaiKey(data.SingleNssai)] = data
+	}
+	if len(storedSmData) != len(expected) {
+		return fmt.Errorf("%w: %s has %d slices in %s, expected %d", ErrProvisionMismatch, ue.Supi, len(storedSmData), p.config.Sessman, len(expected))
+	}
+	for _, data := range storedSmData {
+		if want, ok := expected[free5gcSnssaiKey(data.SingleNssai)]; !ok || !reflect.DeepEqual(data, want) {
+			return fmt.Errorf("%w: %s differs in %s", ErrProvisionMismatch, ue.Supi, p.config.Sessman)
+		}
+	}
+	return nil
+}
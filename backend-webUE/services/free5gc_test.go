@@ -0,0 +1,282 @@
+package services
+
+import (
+	"backend-webUE/models"
+	"backend-webUE/utils"
+	"context"
+	"errors"
+	"reflect"
+	"sort"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestFree5gcDocuments(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(ue *models.UeProfile)
+		// Expected authentication subscription
+		wantMethod string
+		wantOpc    string
+		wantOp     string
+		// DNNs per subscribedSnssaiInfos key and per smData slice
+		wantDnns map[string][]string
+	}{
+		{
+			name:       "stored OPc",
+			modify:     func(ue *models.UeProfile) {},
+			wantMethod: utils.AUTH_5G_AKA,
+			wantOpc:    "cd63cb71954a9f4e48a5994e37a02baf",
+			wantDnns:   map[string][]string{"01010203": {"internet"}, "02": {"ims"}},
+		},
+		{
+			name:       "OPc type without stored OPc",
+			modify:     func(ue *models.UeProfile) { ue.OpType, ue.Opc = utils.OPC, "" },
+			wantMethod: utils.AUTH_5G_AKA,
+			wantOpc:    "cdc202d5123e20f62b6d676ac72cb318",
+			wantDnns:   map[string][]string{"01010203": {"internet"}, "02": {"ims"}},
+		},
+		{
+			name:       "OP type without stored OPc",
+			modify:     func(ue *models.UeProfile) { ue.Opc = "" },
+			wantMethod: utils.AUTH_5G_AKA,
+			wantOp:     "cdc202d5123e20f62b6d676ac72cb318",
+			wantDnns:   map[string][]string{"01010203": {"internet"}, "02": {"ims"}},
+		},
+		{
+			name:       "EAP-AKA'",
+			modify:     func(ue *models.UeProfile) { ue.AuthenticationMethod = utils.AUTH_EAP_AKA_PRIME },
+			wantMethod: utils.AUTH_EAP_AKA_PRIME,
+			wantOpc:    "cd63cb71954a9f4e48a5994e37a02baf",
+			wantDnns:   map[string][]string{"01010203": {"internet"}, "02": {"ims"}},
+		},
+		{
+			name:       "default authentication method",
+			modify:     func(ue *models.UeProfile) { ue.AuthenticationMethod = "" },
+			wantMethod: utils.AUTH_5G_AKA,
+			wantOpc:    "cd63cb71954a9f4e48a5994e37a02baf",
+			wantDnns:   map[string][]string{"01010203": {"internet"}, "02": {"ims"}},
+		},
+		{
+			name: "sessions sharing a slice",
+			modify: func(ue *models.UeProfile) {
+				ue.Sessions[1].Slice = models.Snssai{Sst: 1, Sd: "0x010203"}
+			},
+			wantMethod: utils.AUTH_5G_AKA,
+			wantOpc:    "cd63cb71954a9f4e48a5994e37a02baf",
+			wantDnns:   map[string][]string{"01010203": {"ims", "internet"}},
+		},
+		{
+			name:       "no sessions",
+			modify:     func(ue *models.UeProfile) { ue.Sessions = nil },
+			wantMethod: utils.AUTH_5G_AKA,
+			wantOpc:    "cd63cb71954a9f4e48a5994e37a02baf",
+			wantDnns:   map[string][]string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ue := testUeProfile()
+			tt.modify(ue)
+			auth, am, smfSel, smData := free5gcDocuments(ue)
+
+			if auth.UeId != ue.Supi || auth.AuthenticationMethod != tt.wantMethod || auth.PermanentKey.Value != ue.Key ||
+				auth.AuthenticationManagementField != "8000" || auth.Opc.OpcValue != tt.wantOpc || auth.Milenage.Op.OpValue != tt.wantOp {
+				t.Errorf("authentication subscription = %+v", auth)
+			}
+			wantSqn := &nudrSequenceNumber{SqnScheme: "NON_TIME_BASED", Sqn: "000000000020", LastIndexes: map[string]int{"ausf": 0}}
+			if !reflect.DeepEqual(auth.SequenceNumber, wantSqn) {
+				t.Errorf("sequence number = %+v, want %+v", auth.SequenceNumber, wantSqn)
+			}
+
+			if am.UeId != ue.Supi || am.ServingPlmnId != "20893" {
+				t.Errorf("AM data of %s in %s", am.UeId, am.ServingPlmnId)
+			}
+			wantDefault := []free5gcSnssai{{Sst: 1, Sd: "010203"}}
+			wantNssai := []free5gcSnssai{{Sst: 1, Sd: "010203"}, {Sst: 2}}
+			if !reflect.DeepEqual(am.Nssai.DefaultSingleNssais, wantDefault) || !reflect.DeepEqual(am.Nssai.SingleNssais, wantNssai) {
+				t.Errorf("NSSAI = %+v", am.Nssai)
+			}
+
+			infos := map[string][]string{}
+			for key, info := range smfSel.SubscribedSnssaiInfos {
+				for _, dnn := range info.DnnInfos {
+					infos[key] = append(infos[key], dnn.Dnn)
+				}
+				sort.Strings(infos[key])
+			}
+			if !reflect.DeepEqual(infos, tt.wantDnns) {
+				t.Errorf("subscribedSnssaiInfos DNNs = %v, want %v", infos, tt.wantDnns)
+			}
+
+			dnns := map[string][]string{}
+			for _, data := range smData {
+				key := free5gcSnssaiKey(data.SingleNssai)
+				if _, ok := dnns[key]; ok {
+					t.Errorf("smData has slice %s twice", key)
+				}
+				dnns[key] = []string{}
+				for dnn := range data.DnnConfigurations {
+					dnns[key] = append(dnns[key], dnn)
+				}
+				sort.Strings(dnns[key])
+			}
+			if !reflect.DeepEqual(dnns, tt.wantDnns) {
+				t.Errorf("smData DNNs = %v, want %v", dnns, tt.wantDnns)
+			}
+		})
+	}
+}
+
+func TestFree5gcPduSessionType(t *testing.T) {
+	for sessionType, want := range map[string]string{
+		"IPv4": "IPV4", "IPv6": "IPV6", "IPv4v6": "IPV4V6", "Ethernet": "ETHERNET", "": "IPV4",
+	} {
+		if got := free5gcPduSessionType(sessionType); got != want {
+			t.Errorf("free5gcPduSessionType(%q) = %s, want %s", sessionType, got, want)
+		}
+	}
+}
+
+// free5gcDoc converts a free5GC document into the form a mock server replies with
+func free5gcDoc(t *testing.T, doc interface{}) bson.D {
+	t.Helper()
+	data, err := bson.Marshal(doc)
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	var d bson.D
+	if err := bson.Unmarshal(data, &d); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	return d
+}
+
+func TestFree5gcProvision(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	config := utils.DefaultFree5gcDbConfig()
+	ue := testUeProfile()
+
+	mt.Run("upserts every document", func(mt *mtest.T) {
+		// Two replaced subscriptions, the SMF selection and the two slices inserted, no stale slice
+		mt.AddMockResponses(
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}, bson.E{Key: "nModified", Value: 1}),
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}, bson.E{Key: "nModified", Value: 1}),
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}, bson.E{Key: "nModified", Value: 0}),
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}, bson.E{Key: "nModified", Value: 0}),
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}, bson.E{Key: "nModified", Value: 0}),
+			mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 0}),
+		)
+		if err := NewFree5gcProvisioner(mt.DB, config).Provision(context.Background(), ue); err != nil {
+			mt.Fatalf("Provision: %v", err)
+		}
+
+		events := mt.GetAllStartedEvents()
+		want := []struct{ command, collection string }{
+			{"update", config.Auth}, {"update", config.Am}, {"update", config.Smsel},
+			{"update", config.Sessman}, {"update", config.Sessman}, {"delete", config.Sessman},
+		}
+		if len(events) != len(want) {
+			mt.Fatalf("%d commands, want %d", len(events), len(want))
+		}
+		for i, w := range want {
+			collection, _ := events[i].Command.Lookup(w.command).StringValueOK()
+			if events[i].CommandName != w.command || collection != w.collection {
+				mt.Errorf("command %d = %s on %s, want %s on %s", i, events[i].CommandName, collection, w.command, w.collection)
+			}
+			if w.command == "update" {
+				if upsert, _ := events[i].Command.Lookup("updates", "0", "upsert").BooleanOK(); !upsert {
+					mt.Errorf("command %d is not an upsert", i)
+				}
+			}
+		}
+
+		// The authentication subscription carries a TS 29.505 SequenceNumber
+		var auth struct {
+			UeId           string             `bson:"ueId"`
+			SequenceNumber nudrSequenceNumber `bson:"sequenceNumber"`
+		}
+		if err := events[0].Command.Lookup("updates", "0", "u").Unmarshal(&auth); err != nil {
+			mt.Fatalf("decode authentication subscription: %v", err)
+		}
+		wantSqn := nudrSequenceNumber{SqnScheme: "NON_TIME_BASED", Sqn: "000000000020", LastIndexes: map[string]int{"ausf": 0}}
+		if auth.UeId != ue.Supi || !reflect.DeepEqual(auth.SequenceNumber, wantSqn) {
+			mt.Errorf("authentication subscription = %+v", auth)
+		}
+
+		// Slices other than the two of the sessions are dropped
+		nor, ok := events[5].Command.Lookup("deletes", "0", "q", "$nor").ArrayOK()
+		if values, _ := nor.Values(); !ok || len(values) != 2 {
+			mt.Errorf("stale slice filter = %s", events[5].Command.Lookup("deletes", "0", "q"))
+		}
+	})
+
+	mt.Run("fails on a write error", func(mt *mtest.T) {
+		mt.AddMockResponses(mtest.CreateCommandErrorResponse(mtest.CommandError{Code: 13, Message: "unauthorized"}))
+		err := NewFree5gcProvisioner(mt.DB, config).Provision(context.Background(), ue)
+		if !errors.Is(err, ErrProvisionFailed) {
+			mt.Errorf("Provision = %v, want ErrProvisionFailed", err)
+		}
+	})
+}
+
+func TestFree5gcVerify(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+	config := utils.DefaultFree5gcDbConfig()
+	ue := testUeProfile()
+	auth, am, smfSel, smData := free5gcDocuments(ue)
+
+	// The core advanced the SQN of the stored subscription
+	advanced := *auth
+	advanced.SequenceNumber = &nudrSequenceNumber{SqnScheme: "NON_TIME_BASED", Sqn: "0000000000e0", LastIndexes: map[string]int{"ausf": 7}}
+	current := free5gcDoc(mt.T, &advanced)
+	// free5GC before v3.4 stored the SQN as a string
+	legacy := free5gcDoc(mt.T, &advanced)
+	for i := range legacy {
+		if legacy[i].Key == "sequenceNumber" {
+			legacy[i].Value = "0000000000e0"
+		}
+	}
+	changedKey := *auth
+	changedKey.PermanentKey.Value = "00112233445566778899aabbccddeeff"
+
+	var smDocs []bson.D
+	for _, data := range smData {
+		smDocs = append(smDocs, free5gcDoc(mt.T, data))
+	}
+
+	tests := []struct {
+		name string
+		// Stored authentication subscription, none when nil
+		auth  bson.D
+		slice []bson.D
+		want  error
+	}{
+		{"current format", current, smDocs, nil},
+		{"string sequence number", legacy, smDocs, nil},
+		{"changed key", free5gcDoc(mt.T, &changedKey), smDocs, ErrProvisionMismatch},
+		{"missing subscription", nil, smDocs, ErrProvisionMismatch},
+		{"missing slice", current, smDocs[:1], ErrProvisionMismatch},
+	}
+	for _, tt := range tests {
+		mt.Run(tt.name, func(mt *mtest.T) {
+			ns := config.Name + "." + config.Auth
+			if tt.auth == nil {
+				mt.AddMockResponses(mtest.CreateCursorResponse(0, ns, mtest.FirstBatch))
+			} else {
+				mt.AddMockResponses(
+					mtest.CreateCursorResponse(0, ns, mtest.FirstBatch, tt.auth),
+					mtest.CreateCursorResponse(0, ns, mtest.FirstBatch, free5gcDoc(mt.T, am)),
+					mtest.CreateCursorResponse(0, ns, mtest.FirstBatch, free5gcDoc(mt.T, smfSel)),
+					mtest.CreateCursorResponse(0, ns, mtest.FirstBatch, tt.slice...),
+				)
+			}
+			err := NewFree5gcProvisioner(mt.DB, config).Verify(context.Background(), ue)
+			if tt.want == nil && err != nil || tt.want != nil && !errors.Is(err, tt.want) {
+				mt.Errorf("Verify = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,343 @@
+package services
+
+import (
+	"backend-webUE/models"
+	"backend-webUE/storage"
+	"backend-webUE/utils"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var (
+	ErrJobNotFound    = errors.New("job not found")
+	ErrJobFinished    = errors.New("job already finished")
+	ErrJobNotFinished = errors.New("job not finished")
+	ErrJobQueueFull   = errors.New("job queue is full")
+)
+
+// Defaults of the job workers
+const (
+	DefaultJobWorkers   = 2
+	DefaultJobBatchSize = 1000
+	// Jobs waiting for a worker before submissions are refused
+	jobQueueSize = 1000
+	// Provisioning failures kept on a job
+	maxJobWarnings = 20
+)
+
+// jobRef identifies a queued job
+type jobRef struct {
+	userID, id primitive.ObjectID
+}
+
+// JobService runs generation jobs on a pool of workers. Every batch is stored before the next one
+// is generated and the job records its progress, so the jobs of a stopped server resume on Start.
+type JobService struct {
+	repo       storage.JobRepository
+	ueProfiles *UeProfileService
+	workers    int
+	batchSize  int
+	queue      chan jobRef
+
+	// mu orders the start of a job against its cancellation and serializes submissions,
+	// so a job is only stored once it has a place in the queue
+	mu      sync.Mutex
+	running map[primitive.ObjectID]context.CancelFunc
+}
+
+// NewJobService creates a job service, workers or batchSize <= 0 select the defaults
+func NewJobService(jobs storage.JobRepository, ueProfiles *UeProfileService, workers, batchSize int) *JobService {
+	if workers <= 0 {
+		workers = DefaultJobWorkers
+	}
+	if batchSize <= 0 {
+		batchSize = DefaultJobBatchSize
+	}
+	return &JobService{
+		repo:       jobs,
+		ueProfiles: ueProfiles,
+		workers:    workers,
+		batchSize:  batchSize,
+		queue:      make(chan jobRef, jobQueueSize),
+		running:    make(map[primitive.ObjectID]context.CancelFunc),
+	}
+}
+
+// Start launches the workers and queues the jobs left unfinished by a previous run.
+// The workers stop when ctx is done, their running jobs are resumed by the next Start.
+func (s *JobService) Start(ctx context.Context) error {
+	unfinished, err := s.repo.Unfinished(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to load unfinished jobs: %v", err)
+	}
+	for i := 0; i < s.workers; i++ {
+		go s.work(ctx)
+	}
+	go func() {
+		for _, job := range unfinished {
+			if job.Status == models.JobRunning {
+				job.Status = models.JobQueued
+				if _, err := s.repo.Replace(ctx, &job); err != nil {
+					log.Printf("Failed to requeue job %s: %v", job.ID.Hex(), err)
+					continue
+				}
+			}
+			select {
+			case s.queue <- jobRef{userID: job.UserID, id: job.ID}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return nil
+}
+
+// SubmitGenerate validates a generation and queues it as a job, the UE profiles it generates are
+// tagged with the ResultTag of the job in addition to params.Tags
+func (s *JobService) SubmitGenerate(ctx context.Context, userID primitive.ObjectID, params GenerateParams) (*models.Job, error) {
+	if _, _, err := s.ueProfiles.checkGenerate(ctx, userID, params); err != nil {
+		return nil, err
+	}
+	job := &models.Job{
+		UserID: userID,
+		Status: models.JobQueued,
+		Params: models.GenerateJobParams{
+			OperatorID:           params.OperatorID,
+			Num:                  params.Num,
+			Schemes:              models.SchemeWeights(params.Schemes),
+			OpType:               params.OpType,
+			AuthenticationMethod: params.AuthenticationMethod,
+			Targets:              params.Targets,
+			MsinStart:            params.MsinStart,
+			Tags:                 params.Tags,
+		},
+		CreatedAt: time.Now().UTC().Truncate(time.Millisecond),
+	}
+
+	// Workers only take jobs off the queue, but Start requeues unfinished jobs without the lock
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.queue) == cap(s.queue) {
+		return nil, ErrJobQueueFull
+	}
+	if err := s.repo.Create(ctx, job); err != nil {
+		return nil, fmt.Errorf("failed to create job: %v", err)
+	}
+	job.ResultTag = jobResultTag(job.ID)
+	if _, err := s.repo.Replace(ctx, job); err != nil {
+		return nil, fmt.Errorf("failed to create job: %v", err)
+	}
+
+	select {
+	case s.queue <- jobRef{userID: userID, id: job.ID}:
+	default:
+		// Filled up by the requeued jobs since the check, the refused job must not run later
+		s.fail(job, ErrJobQueueFull)
+		return nil, ErrJobQueueFull
+	}
+	return job, nil
+}
+
+// Get returns a job of the user
+func (s *JobService) Get(ctx context.Context, userID, jobID primitive.ObjectID) (*models.Job, error) {
+	job, err := s.repo.Get(ctx, userID, jobID)
+	if err != nil {
+		return nil, err
+	}
+	if job == nil {
+		return nil, ErrJobNotFound
+	}
+	return job, nil
+}
+
+// List returns the jobs of the user, newest first
+func (s *JobService) List(ctx context.Context, userID primitive.ObjectID) ([]models.Job, error) {
+	return s.repo.List(ctx, userID)
+}
+
+// Cancel stops a queued or running job. The UE profiles of finished batches are kept.
+// A running job is cancelled by its worker once the current batch ends.
+func (s *JobService) Cancel(ctx context.Context, userID, jobID primitive.ObjectID) (*models.Job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	job, err := s.Get(ctx, userID, jobID)
+	if err != nil {
+		return nil, err
+	}
+	if job.Finished() {
+		return nil, ErrJobFinished
+	}
+	if cancel, ok := s.running[jobID]; ok {
+		cancel()
+		return job, nil
+	}
+	s.finish(job, models.JobCancelled, "")
+	if err := s.save(job); err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+// work runs queued jobs until ctx is done
+func (s *JobService) work(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case ref := <-s.queue:
+			job, jobCtx := s.begin(ctx, ref)
+			if job == nil {
+				continue
+			}
+			s.run(ctx, jobCtx, job)
+			s.mu.Lock()
+			delete(s.running, job.ID)
+			s.mu.Unlock()
+		}
+	}
+}
+
+// begin marks a queued job running, it returns nil for jobs cancelled while queued
+func (s *JobService) begin(ctx context.Context, ref jobRef) (*models.Job, context.Context) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	job, err := s.repo.Get(ctx, ref.userID, ref.id)
+	if err != nil {
+		log.Printf("Failed to load job %s: %v", ref.id.Hex(), err)
+		return nil, nil
+	}
+	if job == nil || job.Status != models.JobQueued {
+		return nil, nil
+	}
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	job.Status = models.JobRunning
+	if job.ResultTag == "" {
+		job.ResultTag = jobResultTag(job.ID)
+	}
+	if job.StartedAt == nil {
+		job.StartedAt = &now
+	}
+	if err := s.save(job); err != nil {
+		log.Printf("Failed to start job %s: %v", job.ID.Hex(), err)
+		return nil, nil
+	}
+	jobCtx, cancel := context.WithCancel(ctx)
+	s.running[job.ID] = cancel
+	return job, jobCtx
+}
+
+// run generates the remaining UE profiles of a job batch by batch. ctx is the context of the
+// worker, jobCtx is cancelled as well by Cancel.
+func (s *JobService) run(ctx, jobCtx context.Context, job *models.Job) {
+	// Profiles of the batch an interrupted run stored before it could record them
+	page, err := s.ueProfiles.FindUeProfiles(jobCtx, job.UserID, storage.UeProfileQuery{Tags: []string{job.ResultTag}, Limit: 1})
+	if err != nil {
+		if jobCtx.Err() == nil {
+			s.fail(job, fmt.Errorf("failed to count generated UE profiles: %v", err))
+		} else if ctx.Err() == nil {
+			s.finish(job, models.JobCancelled, "")
+			if err := s.save(job); err != nil {
+				log.Printf("Failed to finish job %s: %v", job.ID.Hex(), err)
+			}
+		}
+		return
+	}
+	job.Done = int(page.Total)
+
+	params := job.Params
+	for job.Done < params.Num {
+		if jobCtx.Err() != nil {
+			break
+		}
+		batch := GenerateParams{
+			OperatorID:           params.OperatorID,
+			Num:                  min(s.batchSize, params.Num-job.Done),
+			Schemes:              utils.SchemeMix(params.Schemes),
+			OpType:               params.OpType,
+			AuthenticationMethod: params.AuthenticationMethod,
+			Targets:              params.Targets,
+			Tags:                 append(append([]string(nil), params.Tags...), job.ResultTag),
+		}
+		if params.MsinStart != nil {
+			msinStart := *params.MsinStart + uint64(job.Done)
+			batch.MsinStart = &msinStart
+		}
+
+		ueProfiles, err := s.ueProfiles.GenerateUeProfiles(jobCtx, job.UserID, batch)
+		job.Done += len(ueProfiles)
+		if err != nil && jobCtx.Err() == nil {
+			if !errors.Is(err, ErrProvisionFailed) {
+				s.fail(job, err)
+				return
+			}
+			if len(job.Warnings) < maxJobWarnings {
+				job.Warnings = append(job.Warnings, err.Error())
+			}
+		}
+		if err := s.save(job); err != nil {
+			log.Printf("Failed to record progress of job %s: %v", job.ID.Hex(), err)
+		}
+	}
+
+	switch {
+	case job.Done >= params.Num:
+		s.finish(job, models.JobSucceeded, "")
+	case ctx.Err() != nil:
+		// The server is stopping, the job stays running and resumes on the next Start
+		return
+	default:
+		s.finish(job, models.JobCancelled, "")
+	}
+	if err := s.save(job); err != nil {
+		log.Printf("Failed to finish job %s: %v", job.ID.Hex(), err)
+	}
+}
+
+// jobResultTag is the tag of the UE profiles generated by a job
+func jobResultTag(jobID primitive.ObjectID) string {
+	return "job-" + jobID.Hex()
+}
+
+// fail records the error that stopped a job
+func (s *JobService) fail(job *models.Job, err error) {
+	log.Printf("Job %s failed: %v", job.ID.Hex(), err)
+	s.finish(job, models.JobFailed, err.Error())
+	if err := s.save(job); err != nil {
+		log.Printf("Failed to finish job %s: %v", job.ID.Hex(), err)
+	}
+}
+
+func (s *JobService) finish(job *models.Job, status, reason string) {
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	job.Status = status
+	job.Error = reason
+	job.FinishedAt = &now
+}
+
+// save stores the state of a job, also after the request or worker context that changed it is done
+func (s *JobService) save(job *models.Job) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if _, err := s.repo.Replace(ctx, job); err != nil {
+		return fmt.Errorf("failed to save job: %v", err)
+	}
+	return nil
+}
+
+// Results returns a finished job of the user, for exporting the UE profiles it generated
+func (s *JobService) Results(ctx context.Context, userID, jobID primitive.ObjectID) (*models.Job, error) {
+	job, err := s.Get(ctx, userID, jobID)
+	if err != nil {
+		return nil, err
+	}
+	if !job.Finished() {
+		return nil, fmt.Errorf("%w: job is %s", ErrJobNotFinished, job.Status)
+	}
+	return job, nil
+}
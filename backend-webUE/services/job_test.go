@@ -0,0 +1,212 @@
+package services
+
+import (
+	"backend-webUE/models"
+	"backend-webUE/storage"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// waitForJob polls a job until it is finished
+func waitForJob(t *testing.T, s *JobService, userID, jobID primitive.ObjectID) *models.Job {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		job, err := s.Get(context.Background(), userID, jobID)
+		if err != nil {
+			t.Fatalf("Get: %v", err)
+		}
+		if job.Finished() {
+			return job
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatalf("job %s did not finish", jobID.Hex())
+	return nil
+}
+
+// taggedSupis returns the SUPIs of the UE profiles carrying the tag
+func taggedSupis(t *testing.T, s *UeProfileService, userID primitive.ObjectID, tag string) []string {
+	t.Helper()
+	var supis []string
+	err := s.EachTagged(context.Background(), userID, tag, func(ue *models.UeProfile) error {
+		supis = append(supis, ue.Supi)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("EachTagged: %v", err)
+	}
+	return supis
+}
+
+func TestJobGeneratesInBatches(t *testing.T) {
+	ueProfiles, provisioner := newTestUeProfileService(t)
+	provisioner.err = errors.New("unreachable")
+	s := NewJobService(storage.NewMemoryStore().Jobs, ueProfiles, 1, 2)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := s.Start(ctx); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	userID := primitive.NewObjectID()
+
+	params := generateParams(5)
+	params.Tags, params.Targets = []string{"lab"}, []string{ProvisionTargetAll}
+	job, err := s.SubmitGenerate(ctx, userID, params)
+	if err != nil {
+		t.Fatalf("SubmitGenerate: %v", err)
+	}
+	if job.Status != models.JobQueued || job.ResultTag == "" {
+		t.Fatalf("submitted job = %+v", job)
+	}
+
+	job = waitForJob(t, s, userID, job.ID)
+	if job.Status != models.JobSucceeded || job.Done != 5 || job.StartedAt == nil || job.FinishedAt == nil {
+		t.Fatalf("finished job = %+v", job)
+	}
+	// Failed pushes are reported for each of the three batches, the profiles are kept
+	if len(job.Warnings) != 3 {
+		t.Errorf("warnings = %q, want 3", job.Warnings)
+	}
+	if supis := taggedSupis(t, ueProfiles, userID, job.ResultTag); len(supis) != 5 {
+		t.Errorf("job generated %v, want 5 UE profiles", supis)
+	}
+	if supis := taggedSupis(t, ueProfiles, userID, "lab"); len(supis) != 5 {
+		t.Errorf("%d UE profiles tagged lab, want 5", len(supis))
+	}
+
+	if _, err := s.Cancel(ctx, userID, job.ID); !errors.Is(err, ErrJobFinished) {
+		t.Errorf("Cancel of a finished job = %v, want ErrJobFinished", err)
+	}
+	if _, err := s.Get(ctx, primitive.NewObjectID(), job.ID); !errors.Is(err, ErrJobNotFound) {
+		t.Errorf("Get by another user = %v, want ErrJobNotFound", err)
+	}
+}
+
+func TestJobCancelQueued(t *testing.T) {
+	ueProfiles, _ := newTestUeProfileService(t)
+	// Not started, jobs stay queued
+	s := NewJobService(storage.NewMemoryStore().Jobs, ueProfiles, 1, 2)
+	ctx := context.Background()
+	userID := primitive.NewObjectID()
+
+	job, err := s.SubmitGenerate(ctx, userID, generateParams(3))
+	if err != nil {
+		t.Fatalf("SubmitGenerate: %v", err)
+	}
+	job, err = s.Cancel(ctx, userID, job.ID)
+	if err != nil {
+		t.Fatalf("Cancel: %v", err)
+	}
+	if job.Status != models.JobCancelled || job.FinishedAt == nil {
+		t.Errorf("cancelled job = %+v", job)
+	}
+
+	// The worker skips the cancelled job
+	runCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	if err := s.Start(runCtx); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	if job = waitForJob(t, s, userID, job.ID); job.Status != models.JobCancelled || job.Done != 0 {
+		t.Errorf("job after start = %+v", job)
+	}
+
+	if _, err := s.SubmitGenerate(ctx, userID, GenerateParams{Num: 1, OpType: "XOR"}); err == nil {
+		t.Errorf("SubmitGenerate of invalid options succeeded")
+	}
+}
+
+func TestJobResumesAfterRestart(t *testing.T) {
+	ueProfiles, _ := newTestUeProfileService(t)
+	jobs := storage.NewMemoryStore().Jobs
+	ctx := context.Background()
+	userID := primitive.NewObjectID()
+
+	// A job interrupted after storing a batch it did not record
+	params := generateParams(5)
+	job := &models.Job{
+		UserID: userID,
+		Status: models.JobRunning,
+		Params: models.GenerateJobParams{
+			Num:                  params.Num,
+			Schemes:              models.SchemeWeights(params.Schemes),
+			OpType:               params.OpType,
+			AuthenticationMethod: params.AuthenticationMethod,
+		},
+		CreatedAt: time.Now().UTC(),
+	}
+	if err := jobs.Create(ctx, job); err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	job.ResultTag = jobResultTag(job.ID)
+	if _, err := jobs.Replace(ctx, job); err != nil {
+		t.Fatalf("Replace: %v", err)
+	}
+	params.Num, params.Tags = 2, []string{job.ResultTag}
+	if _, err := ueProfiles.GenerateUeProfiles(ctx, userID, params); err != nil {
+		t.Fatalf("GenerateUeProfiles: %v", err)
+	}
+
+	s := NewJobService(jobs, ueProfiles, 2, 2)
+	runCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	if err := s.Start(runCtx); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+	job = waitForJob(t, s, userID, job.ID)
+	if job.Status != models.JobSucceeded || job.Done != 5 {
+		t.Fatalf("resumed job = %+v", job)
+	}
+	if supis := taggedSupis(t, ueProfiles, userID, job.ResultTag); len(supis) != 5 {
+		t.Errorf("resumed job generated %v, want 5 UE profiles", supis)
+	}
+}
+
+func TestJobQueueFull(t *testing.T) {
+	ueProfiles, _ := newTestUeProfileService(t)
+	// Not started, nothing takes the jobs off the queue
+	s := NewJobService(storage.NewMemoryStore().Jobs, ueProfiles, 1, 2)
+	ctx := context.Background()
+	userID := primitive.NewObjectID()
+
+	submitters := jobQueueSize + 50
+	errs := make(chan error, submitters)
+	for i := 0; i < submitters; i++ {
+		go func() {
+			_, err := s.SubmitGenerate(ctx, userID, generateParams(1))
+			errs <- err
+		}()
+	}
+	accepted := 0
+	for i := 0; i < submitters; i++ {
+		switch err := <-errs; {
+		case err == nil:
+			accepted++
+		case !errors.Is(err, ErrJobQueueFull):
+			t.Fatalf("SubmitGenerate: %v", err)
+		}
+	}
+	if accepted != jobQueueSize {
+		t.Errorf("%d jobs accepted, want %d", accepted, jobQueueSize)
+	}
+
+	// Refused jobs are not stored to run later
+	jobs, err := s.List(ctx, userID)
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	queued := 0
+	for _, job := range jobs {
+		if job.Status == models.JobQueued {
+			queued++
+		}
+	}
+	if queued != jobQueueSize || len(jobs) != jobQueueSize {
+		t.Errorf("%d jobs stored, %d of them queued, want %d queued", len(jobs), queued, jobQueueSize)
+	}
+}
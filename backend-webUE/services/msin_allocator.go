@@ -0,0 +1,61 @@
+package services
+
+import (
+	"backend-webUE/storage"
+	"backend-webUE/utils"
+	"context"
+	"errors"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var (
+	ErrMsinRangeAllocated = errors.New("MSIN range overlaps allocated MSINs")
+	ErrMsinExhausted      = errors.New("no MSINs left in the PLMN")
+)
+
+// MsinAllocator hands out the MSINs of generated SUPIs. Each user has one high-water mark per PLMN,
+// so operators sharing a PLMN draw from the same MSINs. The high-water mark is only moved by
+// atomic updates, concurrent sequential allocations never get overlapping MSINs.
+//
+// Explicit ranges do not move the mark: they are free when the user has no UE profile in them, and
+// sequential allocations skip the MSINs whose SUPIs exist. Two generations racing for the same
+// MSINs are told apart by the unique SUPI of the UE profile storage, the later insert fails.
+type MsinAllocator struct {
+	msins storage.MsinRepository
+}
+
+func NewMsinAllocator(msins storage.MsinRepository) *MsinAllocator {
+	return &MsinAllocator{
+		msins: msins,
+	}
+}
+
+// Allocate reserves n consecutive MSINs from the high-water mark, or from the operator's
+// configured start when that is higher, and returns the first one
+func (a *MsinAllocator) Allocate(ctx context.Context, userID primitive.ObjectID, operator *utils.Operator, n int) (uint64, error) {
+	config := operator.Config()
+	count := operator.MsinCount()
+	if n <= 0 || uint64(n) > count || config.MsinStart > count-uint64(n) {
+		return 0, fmt.Errorf("%w: %d MSINs from %d", ErrMsinExhausted, n, config.MsinStart)
+	}
+
+	next, ok, err := a.msins.Advance(ctx, userID, config.PlmnId, config.MsinStart, uint64(n), count)
+	if err != nil {
+		return 0, err
+	}
+	if !ok {
+		return 0, fmt.Errorf("%w: %d MSINs requested in PLMN %s-%s", ErrMsinExhausted, n, config.PlmnId.Mcc, config.PlmnId.Mnc)
+	}
+	return next - uint64(n), nil
+}
+
+// CheckRange checks that the n MSINs from start fit into the MSIN digits of the operator
+func (a *MsinAllocator) CheckRange(operator *utils.Operator, start uint64, n int) error {
+	count := operator.MsinCount()
+	if n <= 0 || uint64(n) > count || start > count-uint64(n) {
+		return fmt.Errorf("%w: MSINs %d to %d exceed %d digits", utils.ErrInvalidGenerateOptions, start, start+uint64(n)-1, operator.MsinLen())
+	}
+	return nil
+}
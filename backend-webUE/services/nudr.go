@@ -0,0 +1,255 @@
+package services
+
+import (
+	"backend-webUE/aka"
+	"backend-webUE/milenage"
+	"backend-webUE/models"
+	"backend-webUE/utils"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"reflect"
+	"sort"
+	"time"
+)
+
+// API prefix of Nudr_DataRepository (TS 29.505)
+const nudrApiPrefix = "/nudr-dr/v1"
+
+// maxNudrResponseSize bounds the response bodies read from the UDR
+const maxNudrResponseSize = 1 << 20
+
+// TS 29.505 SequenceNumber, also the sequenceNumber of the free5GC authentication subscription documents
+type nudrSequenceNumber struct {
+	SqnScheme string `json:"sqnScheme" bson:"sqnScheme"`
+	Sqn       string `json:"sqn" bson:"sqn"`
+	// Last IND used by each AUSF instance
+	LastIndexes map[string]int `json:"lastIndexes,omitempty" bson:"lastIndexes,omitempty"`
+}
+
+// TS 29.505 AuthenticationSubscription, with the keys in the clear (no encryption key or algorithm)
+type nudrAuthenticationSubscription struct {
+	AuthenticationMethod          string              `json:"authenticationMethod"`
+	EncPermanentKey               string              `json:"encPermanentKey"`
+	SequenceNumber                *nudrSequenceNumber `json:"sequenceNumber,omitempty"`
+	AuthenticationManagementField string              `json:"authenticationManagementField"`
+	AlgorithmId                   string              `json:"algorithmId"`
+	EncOpcKey                     string              `json:"encOpcKey"`
+}
+
+// TS 29.571 ProblemDetails, as far as it is reported
+type nudrProblemDetails struct {
+	Title  string `json:"title"`
+	Status int    `json:"status"`
+	Detail string `json:"detail"`
+	Cause  string `json:"cause"`
+}
+
+// nudrResource is one subscription data resource of a UE with its expected body
+type nudrResource struct {
+	path string
+	body interface{}
+}
+
+// NudrProvisioner provisions UE profiles into a UDR through the Nudr_DataRepository API
+type NudrProvisioner struct {
+	client *http.Client
+	config utils.NudrConfig
+}
+
+// NewNudrProvisioner creates a provisioner for the UDR at config.Url
+func NewNudrProvisioner(config utils.NudrConfig) *NudrProvisioner {
+	return &NudrProvisioner{
+		client: &http.Client{Timeout: time.Duration(config.Timeout) * time.Second},
+		config: config,
+	}
+}
+
+// Name of the UDR target
+func (p *NudrProvisioner) Name() string {
+	return "udr"
+}
+
+// nudrResources maps a UE profile onto the subscription data resources of TS 29.505:
+// authentication subscription, AM data, SMF selection data and SM data
+func nudrResources(ue *models.UeProfile) ([]nudrResource, error) {
+	auth, am, smfSel, smData := free5gcDocuments(ue)
+
+	// The UDR stores OPc only, derive it when the profile has an OP
+	opc := ue.Opc
+	if opc == "" && ue.OpType == utils.OPC {
+		opc = ue.Op
+	} else if opc == "" {
+		var err error
+		if opc, err = milenage.GenerateOPcHex(ue.Key, ue.Op); err != nil {
+			return nil, fmt.Errorf("failed to derive OPc: %v", err)
+		}
+	}
+	sqn := ue.Sqn
+	if sqn == "" {
+		sqn = aka.InitialSqn
+	}
+	authSub := &nudrAuthenticationSubscription{
+		AuthenticationMethod:          auth.AuthenticationMethod,
+		EncPermanentKey:               ue.Key,
+		SequenceNumber:                &nudrSequenceNumber{SqnScheme: "NON_TIME_BASED", Sqn: sqn},
+		AuthenticationManagementField: ue.Amf,
+		AlgorithmId:                   "milenage",
+		EncOpcKey:                     opc,
+	}
+
+	if smData == nil {
+		smData = []free5gcSmData{}
+	}
+	sortNudrSmData(smData)
+
+	ueBase := "/subscription-data/" + url.PathEscape(ue.Supi)
+	plmnBase := ueBase + "/" + url.PathEscape(am.ServingPlmnId) + "/provisioned-data"
+	return []nudrResource{
+		{path: ueBase + "/authentication-data/authentication-subscription", body: authSub},
+		{path: plmnBase + "/am-data", body: am},
+		{path: plmnBase + "/smf-selection-subscription-data", body: smfSel},
+		{path: plmnBase + "/sm-data", body: smData},
+	}, nil
+}
+
+// sortNudrSmData orders SM data by S-NSSAI, the UDR may return it in any order
+func sortNudrSmData(smData []free5gcSmData) {
+	sort.Slice(smData, func(i, j int) bool {
+		return free5gcSnssaiKey(smData[i].SingleNssai) < free5gcSnssaiKey(smData[j].SingleNssai)
+	})
+}
+
+// do sends one Nudr request and returns the status code and body of the response
+func (p *NudrProvisioner) do(ctx context.Context, method, path string, body interface{}) (int, []byte, error) {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return 0, nil, fmt.Errorf("failed to encode %s: %v", path, err)
+		}
+		reader = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, p.config.Url+nudrApiPrefix+path, reader)
+	if err != nil {
+		return 0, nil, fmt.Errorf("%s %s: %v", method, path, err)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("Accept", "application/json, application/problem+json")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return 0, nil, fmt.Errorf("%s %s: %v", method, path, err)
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxNudrResponseSize))
+	if err != nil {
+		return 0, nil, fmt.Errorf("%s %s: failed to read response: %v", method, path, err)
+	}
+	return resp.StatusCode, data, nil
+}
+
+// nudrStatusError describes an unexpected response, with the ProblemDetails of the UDR when it sent any
+func nudrStatusError(method, path string, status int, body []byte) error {
+	var problem nudrProblemDetails
+	if json.Unmarshal(body, &problem) == nil && (problem.Title != "" || problem.Detail != "") {
+		return fmt.Errorf("%s %s: %d %s: %s", method, path, status, problem.Title, problem.Detail)
+	}
+	return fmt.Errorf("%s %s: %d %s", method, path, status, http.StatusText(status))
+}
+
+// Provision PUTs every subscription data resource of a UE, replacing the existing ones
+func (p *NudrProvisioner) Provision(ctx context.Context, ue *models.UeProfile) error {
+	resources, err := nudrResources(ue)
+	if err != nil {
+		return fmt.Errorf("%w %s: %v", ErrProvisionFailed, ue.Supi, err)
+	}
+	for _, r := range resources {
+		status, body, err := p.do(ctx, http.MethodPut, r.path, r.body)
+		if err != nil {
+			return fmt.Errorf("%w %s: %v", ErrProvisionFailed, ue.Supi, err)
+		}
+		if status != http.StatusOK && status != http.StatusCreated && status != http.StatusNoContent {
+			return fmt.Errorf("%w %s: %v", ErrProvisionFailed, ue.Supi, nudrStatusError(http.MethodPut, r.path, status, body))
+		}
+	}
+	return nil
+}
+
+// Deprovision DELETEs every subscription data resource of a UE, resources the UDR does not have are skipped
+func (p *NudrProvisioner) Deprovision(ctx context.Context, ue *models.UeProfile) error {
+	resources, err := nudrResources(ue)
+	if err != nil {
+		return fmt.Errorf("%w %s: %v", ErrDeprovisionFailed, ue.Supi, err)
+	}
+	for _, r := range resources {
+		status, body, err := p.do(ctx, http.MethodDelete, r.path, nil)
+		if err != nil {
+			return fmt.Errorf("%w %s: %v", ErrDeprovisionFailed, ue.Supi, err)
+		}
+		if status != http.StatusOK && status != http.StatusNoContent && status != http.StatusNotFound {
+			return fmt.Errorf("%w %s: %v", ErrDeprovisionFailed, ue.Supi, nudrStatusError(http.MethodDelete, r.path, status, body))
+		}
+	}
+	return nil
+}
+
+// Verify GETs every subscription data resource of a UE and compares it with the profile.
+// The sequence number is not compared, the UDR advances it on every authentication.
+func (p *NudrProvisioner) Verify(ctx context.Context, ue *models.UeProfile) error {
+	resources, err := nudrResources(ue)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrProvisionMismatch, err)
+	}
+	for _, r := range resources {
+		status, body, err := p.do(ctx, http.MethodGet, r.path, nil)
+		if err != nil {
+			return err
+		}
+		if status == http.StatusNotFound {
+			return fmt.Errorf("%w: %s has no %s", ErrProvisionMismatch, ue.Supi, r.path)
+		}
+		if status != http.StatusOK {
+			return nudrStatusError(http.MethodGet, r.path, status, body)
+		}
+
+		// Round trip the expected body so fields the UDR does not return compare equal
+		expected, err := json.Marshal(r.body)
+		if err != nil {
+			return fmt.Errorf("failed to encode %s: %v", r.path, err)
+		}
+		want, err := nudrDecodeAs(r.body, expected)
+		if err != nil {
+			return err
+		}
+		got, err := nudrDecodeAs(r.body, body)
+		if err != nil {
+			return fmt.Errorf("%s: %v", r.path, err)
+		}
+		switch got := got.(type) {
+		case *nudrAuthenticationSubscription:
+			got.SequenceNumber = want.(*nudrAuthenticationSubscription).SequenceNumber
+		case []free5gcSmData:
+			sortNudrSmData(got)
+		}
+		if !reflect.DeepEqual(got, want) {
+			return fmt.Errorf("%w: %s differs in %s", ErrProvisionMismatch, ue.Supi, r.path)
+		}
+	}
+	return nil
+}
+
+// nudrDecodeAs decodes a JSON body into a new value of the type of like
+func nudrDecodeAs(like interface{}, data []byte) (interface{}, error) {
+	v := reflect.New(reflect.TypeOf(like))
+	if err := json.Unmarshal(data, v.Interface()); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %v", err)
+	}
+	return v.Elem().Interface(), nil
+}
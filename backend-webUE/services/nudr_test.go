@@ -0,0 +1,287 @@
+package services
+
+import (
+	"backend-webUE/milenage"
+	"backend-webUE/models"
+	"backend-webUE/utils"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// standInUdr is an in-process UDR keeping the subscription data resources PUT into it
+type standInUdr struct {
+	mu        sync.Mutex
+	resources map[string][]byte
+	// Status of every response when set, with a ProblemDetails body
+	failWith int
+}
+
+func (u *standInUdr) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	problem := func(status int, detail string) {
+		w.Header().Set("Content-Type", "application/problem+json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(nudrProblemDetails{Title: http.StatusText(status), Status: status, Detail: detail})
+	}
+	if u.failWith != 0 {
+		problem(u.failWith, "stand-in failure")
+		return
+	}
+	if !strings.HasPrefix(r.URL.Path, nudrApiPrefix+"/subscription-data/") {
+		problem(http.StatusNotFound, "unknown resource")
+		return
+	}
+	path := strings.TrimPrefix(r.URL.Path, nudrApiPrefix)
+
+	switch r.Method {
+	case http.MethodPut:
+		if r.Header.Get("Content-Type") != "application/json" {
+			problem(http.StatusUnsupportedMediaType, "expected application/json")
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		if !json.Valid(body) {
+			problem(http.StatusBadRequest, "invalid JSON")
+			return
+		}
+		_, exists := u.resources[path]
+		u.resources[path] = body
+		if exists {
+			w.WriteHeader(http.StatusNoContent)
+		} else {
+			w.WriteHeader(http.StatusCreated)
+		}
+	case http.MethodGet:
+		body, ok := u.resources[path]
+		if !ok {
+			problem(http.StatusNotFound, "DATA_NOT_FOUND")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(body)
+	case http.MethodDelete:
+		if _, ok := u.resources[path]; !ok {
+			problem(http.StatusNotFound, "DATA_NOT_FOUND")
+			return
+		}
+		delete(u.resources, path)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		problem(http.StatusMethodNotAllowed, r.Method)
+	}
+}
+
+func (u *standInUdr) paths() []string {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	paths := make([]string, 0, len(u.resources))
+	for path := range u.resources {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+func (u *standInUdr) resource(t *testing.T, path string, v interface{}) {
+	t.Helper()
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	body, ok := u.resources[path]
+	if !ok {
+		t.Fatalf("UDR has no %s", path)
+	}
+	if err := json.Unmarshal(body, v); err != nil {
+		t.Fatalf("decode %s: %v", path, err)
+	}
+}
+
+func newStandInUdr(t *testing.T) (*standInUdr, *NudrProvisioner) {
+	udr := &standInUdr{resources: make(map[string][]byte)}
+	server := httptest.NewServer(udr)
+	t.Cleanup(server.Close)
+	return udr, NewNudrProvisioner(utils.NudrConfig{Enabled: true, Url: server.URL, Timeout: 5})
+}
+
+func testUeProfile() *models.UeProfile {
+	return &models.UeProfile{
+		Supi:                 "imsi-208930000000001",
+		PlmnId:               models.PlmnId{Mcc: "208", Mnc: "93"},
+		Key:                  "465b5ce8b199b49faa5f0a2ee238a6bc",
+		Op:                   "cdc202d5123e20f62b6d676ac72cb318",
+		OpType:               utils.OP,
+		Opc:                  "cd63cb71954a9f4e48a5994e37a02baf",
+		Amf:                  "8000",
+		Sqn:                  "000000000020",
+		AuthenticationMethod: utils.AUTH_5G_AKA,
+		DefaultSlice:         []models.Snssai{{Sst: 1, Sd: "0x010203"}},
+		ConfiguredSlice:      []models.Snssai{{Sst: 1, Sd: "0x010203"}, {Sst: 2}},
+		Sessions: []models.Sessions{
+			{Type: "IPv4", Apn: "internet", Slice: models.Snssai{Sst: 1, Sd: "0x010203"}},
+			{Type: "IPv4v6", Apn: "ims", Slice: models.Snssai{Sst: 2}},
+		},
+	}
+}
+
+func TestNudrProvision(t *testing.T) {
+	udr, p := newStandInUdr(t)
+	ue := testUeProfile()
+
+	if err := p.Provision(context.Background(), ue); err != nil {
+		t.Fatalf("Provision: %v", err)
+	}
+
+	wantPaths := []string{
+		"/subscription-data/imsi-208930000000001/20893/provisioned-data/am-data",
+		"/subscription-data/imsi-208930000000001/20893/provisioned-data/sm-data",
+		"/subscription-data/imsi-208930000000001/20893/provisioned-data/smf-selection-subscription-data",
+		"/subscription-data/imsi-208930000000001/authentication-data/authentication-subscription",
+	}
+	if got := udr.paths(); strings.Join(got, "\n") != strings.Join(wantPaths, "\n") {
+		t.Fatalf("UDR resources = %v, want %v", got, wantPaths)
+	}
+
+	var auth nudrAuthenticationSubscription
+	udr.resource(t, wantPaths[3], &auth)
+	if auth.AuthenticationMethod != "5G_AKA" || auth.EncPermanentKey != ue.Key || auth.EncOpcKey != ue.Opc ||
+		auth.AuthenticationManagementField != "8000" || auth.AlgorithmId != "milenage" {
+		t.Errorf("authentication subscription = %+v", auth)
+	}
+	if auth.SequenceNumber == nil || auth.SequenceNumber.Sqn != "000000000020" || auth.SequenceNumber.SqnScheme != "NON_TIME_BASED" {
+		t.Errorf("sequence number = %+v", auth.SequenceNumber)
+	}
+
+	var am struct {
+		Nssai struct {
+			DefaultSingleNssais []free5gcSnssai `json:"defaultSingleNssais"`
+			SingleNssais        []free5gcSnssai `json:"singleNssais"`
+		} `json:"nssai"`
+	}
+	udr.resource(t, wantPaths[0], &am)
+	if len(am.Nssai.DefaultSingleNssais) != 1 || am.Nssai.DefaultSingleNssais[0] != (free5gcSnssai{Sst: 1, Sd: "010203"}) {
+		t.Errorf("default NSSAI = %+v", am.Nssai.DefaultSingleNssais)
+	}
+	if len(am.Nssai.SingleNssais) != 2 {
+		t.Errorf("NSSAI = %+v", am.Nssai.SingleNssais)
+	}
+
+	var smfSel map[string]map[string]free5gcSnssaiInfo
+	udr.resource(t, wantPaths[2], &smfSel)
+	infos := smfSel["subscribedSnssaiInfos"]
+	if infos["01010203"].DnnInfos[0].Dnn != "internet" || infos["02"].DnnInfos[0].Dnn != "ims" {
+		t.Errorf("SMF selection data = %+v", infos)
+	}
+
+	var smData []free5gcSmData
+	udr.resource(t, wantPaths[1], &smData)
+	if len(smData) != 2 {
+		t.Fatalf("SM data has %d slices, want 2", len(smData))
+	}
+	if got := smData[1].DnnConfigurations["ims"].PduSessionTypes.DefaultSessionType; got != "IPV4V6" {
+		t.Errorf("ims PDU session type = %s, want IPV4V6", got)
+	}
+}
+
+func TestNudrProvisionDerivesOpc(t *testing.T) {
+	udr, p := newStandInUdr(t)
+	ue := testUeProfile()
+	ue.Opc = ""
+
+	if err := p.Provision(context.Background(), ue); err != nil {
+		t.Fatalf("Provision: %v", err)
+	}
+	want, err := milenage.GenerateOPcHex(ue.Key, ue.Op)
+	if err != nil {
+		t.Fatalf("GenerateOPcHex: %v", err)
+	}
+	var auth nudrAuthenticationSubscription
+	udr.resource(t, "/subscription-data/imsi-208930000000001/authentication-data/authentication-subscription", &auth)
+	if auth.EncOpcKey != want {
+		t.Errorf("encOpcKey = %s, want %s", auth.EncOpcKey, want)
+	}
+}
+
+func TestNudrVerify(t *testing.T) {
+	_, p := newStandInUdr(t)
+	ctx := context.Background()
+	ue := testUeProfile()
+
+	if err := p.Verify(ctx, ue); !errors.Is(err, ErrProvisionMismatch) {
+		t.Fatalf("Verify before Provision = %v, want ErrProvisionMismatch", err)
+	}
+	if err := p.Provision(ctx, ue); err != nil {
+		t.Fatalf("Provision: %v", err)
+	}
+	if err := p.Verify(ctx, ue); err != nil {
+		t.Fatalf("Verify: %v", err)
+	}
+
+	// The core advances the SQN, which must not count as a mismatch
+	advanced := *ue
+	advanced.Sqn = "0000000000e0"
+	if err := p.Verify(ctx, &advanced); err != nil {
+		t.Errorf("Verify with advanced SQN: %v", err)
+	}
+
+	changed := *ue
+	changed.Key = "00112233445566778899aabbccddeeff"
+	if err := p.Verify(ctx, &changed); !errors.Is(err, ErrProvisionMismatch) {
+		t.Errorf("Verify with changed key = %v, want ErrProvisionMismatch", err)
+	}
+
+	changed = *ue
+	changed.Sessions = changed.Sessions[:1]
+	if err := p.Verify(ctx, &changed); !errors.Is(err, ErrProvisionMismatch) {
+		t.Errorf("Verify with dropped session = %v, want ErrProvisionMismatch", err)
+	}
+}
+
+func TestNudrDeprovision(t *testing.T) {
+	udr, p := newStandInUdr(t)
+	ctx := context.Background()
+	ue := testUeProfile()
+
+	if err := p.Provision(ctx, ue); err != nil {
+		t.Fatalf("Provision: %v", err)
+	}
+	if err := p.Deprovision(ctx, ue); err != nil {
+		t.Fatalf("Deprovision: %v", err)
+	}
+	if paths := udr.paths(); len(paths) != 0 {
+		t.Errorf("UDR still has %v", paths)
+	}
+	// Deleting resources the UDR does not have succeeds
+	if err := p.Deprovision(ctx, ue); err != nil {
+		t.Errorf("second Deprovision: %v", err)
+	}
+}
+
+func TestNudrProblemDetails(t *testing.T) {
+	udr, p := newStandInUdr(t)
+	ctx := context.Background()
+	ue := testUeProfile()
+	udr.failWith = http.StatusInternalServerError
+
+	err := p.Provision(ctx, ue)
+	if !errors.Is(err, ErrProvisionFailed) {
+		t.Fatalf("Provision = %v, want ErrProvisionFailed", err)
+	}
+	if !strings.Contains(err.Error(), "stand-in failure") {
+		t.Errorf("Provision error %q does not carry the problem detail", err)
+	}
+	if err := p.Deprovision(ctx, ue); !errors.Is(err, ErrDeprovisionFailed) {
+		t.Errorf("Deprovision = %v, want ErrDeprovisionFailed", err)
+	}
+	if err := p.Verify(ctx, ue); err == nil || errors.Is(err, ErrProvisionMismatch) {
+		t.Errorf("Verify = %v, want a read failure", err)
+	}
+}
@@ -0,0 +1,306 @@
+package services
+
+import (
+	"backend-webUE/models"
+	"backend-webUE/utils"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Bitrate units of the Open5GS subscriber schema
+const (
+	open5gsUnitBps = iota
+	open5gsUnitKbps
+	open5gsUnitMbps
+	open5gsUnitGbps
+	open5gsUnitTbps
+)
+
+// PDU session types of the Open5GS subscriber schema
+const (
+	open5gsSessionIPv4   = 1
+	open5gsSessionIPv6   = 2
+	open5gsSessionIPv4v6 = 3
+)
+
+// Subscription defaults of the Open5GS WebUI for new subscribers
+const (
+	open5gs5qi                      = 9
+	open5gsArpPriorityLevel         = 8
+	open5gsPreEmptionDisabled       = 1
+	open5gsAccessRestrictionData    = 32
+	open5gsSubscribedRauTauTimer    = 12
+	open5gsSubscriberSchemaVersion  = 1
+	open5gsNetworkAccessModeOnlyPs  = 0
+	open5gsSubscriberStatusGranted  = 0
+	open5gsOperatorDeterminedBarred = 0
+)
+
+// Documents of the Open5GS subscribers collection (lib/dbi/subscriber.c, webui/server/models/subscriber.js)
+
+type open5gsBitrate struct {
+	Value int `bson:"value"`
+	Unit  int `bson:"unit"`
+}
+
+type open5gsAmbr struct {
+	Downlink open5gsBitrate `bson:"downlink"`
+	Uplink   open5gsBitrate `bson:"uplink"`
+}
+
+type open5gsArp struct {
+	PriorityLevel           int `bson:"priority_level"`
+	PreEmptionCapability    int `bson:"pre_emption_capability"`
+	PreEmptionVulnerability int `bson:"pre_emption_vulnerability"`
+}
+
+type open5gsQos struct {
+	Index int        `bson:"index"`
+	Arp   open5gsArp `bson:"arp"`
+}
+
+type open5gsSession struct {
+	Name    string        `bson:"name"`
+	Type    int           `bson:"type"`
+	Qos     open5gsQos    `bson:"qos"`
+	Ambr    open5gsAmbr   `bson:"ambr"`
+	PccRule []interface{} `bson:"pcc_rule"`
+}
+
+type open5gsSlice struct {
+	Sst              int              `bson:"sst"`
+	Sd               string           `bson:"sd,omitempty"`
+	DefaultIndicator bool             `bson:"default_indicator"`
+	Session          []open5gsSession `bson:"session"`
+}
+
+type open5gsSecurity struct {
+	K   string `bson:"k"`
+	Op  string `bson:"op,omitempty"`
+	Opc string `bson:"opc,omitempty"`
+	Amf string `bson:"amf"`
+	Sqn int64  `bson:"sqn"`
+}
+
+type open5gsSubscriber struct {
+	Imsi                      string          `bson:"imsi"`
+	Msisdn                    []string        `bson:"msisdn"`
+	Imeisv                    string          `bson:"imeisv,omitempty"`
+	Security                  open5gsSecurity `bson:"security"`
+	Ambr                      open5gsAmbr     `bson:"ambr"`
+	Slice                     []open5gsSlice  `bson:"slice"`
+	AccessRestrictionData     int             `bson:"access_restriction_data"`
+	SubscriberStatus          int             `bson:"subscriber_status"`
+	OperatorDeterminedBarring int             `bson:"operator_determined_barring"`
+	NetworkAccessMode         int             `bson:"network_access_mode"`
+	SubscribedRauTauTimer     int             `bson:"subscribed_rau_tau_timer"`
+	SchemaVersion             int             `bson:"schema_version"`
+}
+
+// open5gsSessionType maps the UERANSIM session types onto the Open5GS PDU session types
+func open5gsSessionType(sessionType string) int {
+	switch strings.ToUpper(sessionType) {
+	case "IPV6":
+		return open5gsSessionIPv6
+	case "IPV4V6":
+		return open5gsSessionIPv4v6
+	default:
+		return open5gsSessionIPv4
+	}
+}
+
+// open5gsSubscriberOf translates a UE profile into an Open5GS subscriber document
+func open5gsSubscriberOf(ue *models.UeProfile) (*open5gsSubscriber, error) {
+	if !strings.HasPrefix(ue.Supi, "imsi-") {
+		return nil, fmt.Errorf("Open5GS only supports IMSI SUPIs, got %s", ue.Supi)
+	}
+	sqn := int64(0)
+	if ue.Sqn != "" {
+		var err error
+		if sqn, err = strconv.ParseInt(ue.Sqn, 16, 64); err != nil {
+			return nil, fmt.Errorf("UE profile %s has an invalid SQN %q", ue.Supi, ue.Sqn)
+		}
+	}
+
+	sub := &open5gsSubscriber{
+		Imsi:   strings.TrimPrefix(ue.Supi, "imsi-"),
+		Msisdn: []string{},
+		Imeisv: ue.Imeisv,
+		Security: open5gsSecurity{
+			K:   ue.Key,
+			Amf: ue.Amf,
+			Sqn: sqn,
+		},
+		Ambr: open5gsAmbr{
+			Downlink: open5gsBitrate{Value: 1, Unit: open5gsUnitGbps},
+			Uplink:   open5gsBitrate{Value: 1, Unit: open5gsUnitGbps},
+		},
+		Slice:                     []open5gsSlice{},
+		AccessRestrictionData:     open5gsAccessRestrictionData,
+		SubscriberStatus:          open5gsSubscriberStatusGranted,
+		OperatorDeterminedBarring: open5gsOperatorDeterminedBarred,
+		NetworkAccessMode:         open5gsNetworkAccessModeOnlyPs,
+		SubscribedRauTauTimer:     open5gsSubscribedRauTauTimer,
+		SchemaVersion:             open5gsSubscriberSchemaVersion,
+	}
+	// Open5GS takes either OP or OPc, prefer the stored OPc
+	if ue.Opc != "" {
+		sub.Security.Opc = ue.Opc
+	} else if ue.OpType == utils.OPC {
+		sub.Security.Opc = ue.Op
+	} else {
+		sub.Security.Op = ue.Op
+	}
+
+	// One slice per configured S-NSSAI, carrying the sessions of that slice
+	sliceIndex := map[string]int{}
+	addSlice := func(snssai models.Snssai, defaultIndicator bool) int {
+		sd := strings.ToLower(strings.TrimPrefix(snssai.Sd, "0x"))
+		key := fmt.Sprintf("%d/%s", snssai.Sst, sd)
+		if i, ok := sliceIndex[key]; ok {
+			sub.Slice[i].DefaultIndicator = sub.Slice[i].DefaultIndicator || defaultIndicator
+			return i
+		}
+		sliceIndex[key] = len(sub.Slice)
+		sub.Slice = append(sub.Slice, open5gsSlice{
+			Sst:              snssai.Sst,
+			Sd:               sd,
+			DefaultIndicator: defaultIndicator,
+			Session:          []open5gsSession{},
+		})
+		return len(sub.Slice) - 1
+	}
+	for _, snssai := range ue.DefaultSlice {
+		addSlice(snssai, true)
+	}
+	for _, snssai := range ue.ConfiguredSlice {
+		addSlice(snssai, false)
+	}
+	for _, session := range ue.Sessions {
+		i := addSlice(session.Slice, false)
+		sub.Slice[i].Session = append(sub.Slice[i].Session, open5gsSession{
+			Name: session.Apn,
+			Type: open5gsSessionType(session.Type),
+			Qos: open5gsQos{
+				Index: open5gs5qi,
+				Arp: open5gsArp{
+					PriorityLevel:           open5gsArpPriorityLevel,
+					PreEmptionCapability:    open5gsPreEmptionDisabled,
+					PreEmptionVulnerability: open5gsPreEmptionDisabled,
+				},
+			},
+			Ambr: open5gsAmbr{
+				Downlink: open5gsBitrate{Value: 1, Unit: open5gsUnitGbps},
+				Uplink:   open5gsBitrate{Value: 1, Unit: open5gsUnitGbps},
+			},
+			PccRule: []interface{}{},
+		})
+	}
+	// Open5GS needs at least one default slice
+	if len(sub.Slice) > 0 && len(ue.DefaultSlice) == 0 {
+		sub.Slice[0].DefaultIndicator = true
+	}
+	return sub, nil
+}
+
+// Open5gsSubscriberJSON renders a UE profile as an Open5GS subscriber document in MongoDB
+// extended JSON, ready for mongoimport into the subscribers collection
+func Open5gsSubscriberJSON(ue *models.UeProfile) ([]byte, error) {
+	sub, err := open5gsSubscriberOf(ue)
+	if err != nil {
+		return nil, err
+	}
+	out, err := bson.MarshalExtJSONIndent(sub, true, false, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to render Open5GS subscriber: %v", err)
+	}
+	return append(out, '\n'), nil
+}
+
+// Open5gsProvisioner writes UE profiles into the subscribers collection of an Open5GS MongoDB
+type Open5gsProvisioner struct {
+	// nil in mock mode
+	db     *mongo.Database
+	config utils.Open5gsDbConfig
+}
+
+// NewOpen5gsProvisioner creates a provisioner over an Open5GS database, db may be nil when config.Mock is set
+func NewOpen5gsProvisioner(db *mongo.Database, config utils.Open5gsDbConfig) *Open5gsProvisioner {
+	return &Open5gsProvisioner{
+		db:     db,
+		config: config,
+	}
+}
+
+// Name of the Open5GS target
+func (p *Open5gsProvisioner) Name() string {
+	return "open5gs"
+}
+
+// Provision upserts the Open5GS subscriber of a UE, keyed by IMSI
+func (p *Open5gsProvisioner) Provision(ctx context.Context, ue *models.UeProfile) error {
+	sub, err := open5gsSubscriberOf(ue)
+	if err != nil {
+		return fmt.Errorf("%w %s: %v", ErrProvisionFailed, ue.Supi, err)
+	}
+	if p.config.Mock {
+		out, _ := bson.MarshalExtJSON(sub, false, false)
+		log.Printf("Open5GS mock: upsert into %s: %s", p.config.Collection, out)
+		return nil
+	}
+	_, err = p.db.Collection(p.config.Collection).ReplaceOne(ctx, bson.M{"imsi": sub.Imsi}, sub, options.Replace().SetUpsert(true))
+	if err != nil {
+		return fmt.Errorf("%w %s: failed to upsert into %s: %v", ErrProvisionFailed, ue.Supi, p.config.Collection, err)
+	}
+	return nil
+}
+
+// Deprovision removes the Open5GS subscriber of a UE
+func (p *Open5gsProvisioner) Deprovision(ctx context.Context, ue *models.UeProfile) error {
+	supi := ue.Supi
+	imsi := strings.TrimPrefix(supi, "imsi-")
+	if p.config.Mock {
+		log.Printf("Open5GS mock: delete %s from %s", imsi, p.config.Collection)
+		return nil
+	}
+	if _, err := p.db.Collection(p.config.Collection).DeleteOne(ctx, bson.M{"imsi": imsi}); err != nil {
+		return fmt.Errorf("%w %s: failed to delete from %s: %v", ErrDeprovisionFailed, supi, p.config.Collection, err)
+	}
+	return nil
+}
+
+// Verify compares the Open5GS subscriber of a UE with its profile.
+// The sequence number is not compared, Open5GS advances it on every authentication.
+func (p *Open5gsProvisioner) Verify(ctx context.Context, ue *models.UeProfile) error {
+	expected, err := open5gsSubscriberOf(ue)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrProvisionMismatch, err)
+	}
+	if p.config.Mock {
+		log.Printf("Open5GS mock: verify %s", expected.Imsi)
+		return nil
+	}
+
+	var stored open5gsSubscriber
+	err = p.db.Collection(p.config.Collection).FindOne(ctx, bson.M{"imsi": expected.Imsi}).Decode(&stored)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return fmt.Errorf("%w: %s not in %s", ErrProvisionMismatch, ue.Supi, p.config.Collection)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %v", p.config.Collection, err)
+	}
+	stored.Security.Sqn = expected.Security.Sqn
+	if !reflect.DeepEqual(&stored, expected) {
+		return fmt.Errorf("%w: %s differs in %s", ErrProvisionMismatch, ue.Supi, p.config.Collection)
+	}
+	return nil
+}
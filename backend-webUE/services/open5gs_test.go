@@ -0,0 +1,172 @@
+package services
+
+import (
+	"backend-webUE/models"
+	"backend-webUE/utils"
+	"reflect"
+	"testing"
+)
+
+// open5gsSliceSummary is a slice of an Open5GS subscriber with the names and types of its sessions
+type open5gsSliceSummary struct {
+	Sst              int
+	Sd               string
+	DefaultIndicator bool
+	Sessions         map[string]int
+}
+
+func TestOpen5gsSubscriberOf(t *testing.T) {
+	testSlices := []open5gsSliceSummary{
+		{Sst: 1, Sd: "010203", DefaultIndicator: true, Sessions: map[string]int{"internet": open5gsSessionIPv4}},
+		{Sst: 2, DefaultIndicator: false, Sessions: map[string]int{"ims": open5gsSessionIPv4v6}},
+	}
+	tests := []struct {
+		name    string
+		modify  func(ue *models.UeProfile)
+		wantOp  string
+		wantOpc string
+		wantSqn int64
+		// Slices in document order
+		wantSlices []open5gsSliceSummary
+	}{
+		{
+			name:       "stored OPc",
+			modify:     func(ue *models.UeProfile) {},
+			wantOpc:    "cd63cb71954a9f4e48a5994e37a02baf",
+			wantSqn:    0x20,
+			wantSlices: testSlices,
+		},
+		{
+			name:       "OPc type without stored OPc",
+			modify:     func(ue *models.UeProfile) { ue.OpType, ue.Opc = utils.OPC, "" },
+			wantOpc:    "cdc202d5123e20f62b6d676ac72cb318",
+			wantSqn:    0x20,
+			wantSlices: testSlices,
+		},
+		{
+			name:       "OP type without stored OPc",
+			modify:     func(ue *models.UeProfile) { ue.Opc = "" },
+			wantOp:     "cdc202d5123e20f62b6d676ac72cb318",
+			wantSqn:    0x20,
+			wantSlices: testSlices,
+		},
+		{
+			name:       "48-bit SQN",
+			modify:     func(ue *models.UeProfile) { ue.Sqn = "FFFFFFFFFFE0" },
+			wantOpc:    "cd63cb71954a9f4e48a5994e37a02baf",
+			wantSqn:    0xffffffffffe0,
+			wantSlices: testSlices,
+		},
+		{
+			name:       "empty SQN",
+			modify:     func(ue *models.UeProfile) { ue.Sqn = "" },
+			wantOpc:    "cd63cb71954a9f4e48a5994e37a02baf",
+			wantSlices: testSlices,
+		},
+		{
+			name: "slices merged by SST and SD",
+			modify: func(ue *models.UeProfile) {
+				ue.DefaultSlice = []models.Snssai{{Sst: 1, Sd: "0x0A0B0C"}}
+				ue.ConfiguredSlice = []models.Snssai{{Sst: 1, Sd: "0a0b0c"}, {Sst: 2}, {Sst: 1, Sd: "0x0A0B0C"}}
+				ue.Sessions = []models.Sessions{
+					{Type: "IPv4", Apn: "internet", Slice: models.Snssai{Sst: 1, Sd: "0x0a0b0c"}},
+					{Type: "IPv6", Apn: "ims", Slice: models.Snssai{Sst: 2}},
+					// Sessions of a slice the UE is not configured for add it
+					{Type: "IPv4", Apn: "iot", Slice: models.Snssai{Sst: 3}},
+				}
+			},
+			wantOpc: "cd63cb71954a9f4e48a5994e37a02baf",
+			wantSqn: 0x20,
+			wantSlices: []open5gsSliceSummary{
+				{Sst: 1, Sd: "0a0b0c", DefaultIndicator: true, Sessions: map[string]int{"internet": open5gsSessionIPv4}},
+				{Sst: 2, Sessions: map[string]int{"ims": open5gsSessionIPv6}},
+				{Sst: 3, Sessions: map[string]int{"iot": open5gsSessionIPv4}},
+			},
+		},
+		{
+			name: "configured slice marked default",
+			modify: func(ue *models.UeProfile) {
+				ue.DefaultSlice = []models.Snssai{{Sst: 2}}
+			},
+			wantOpc: "cd63cb71954a9f4e48a5994e37a02baf",
+			wantSqn: 0x20,
+			wantSlices: []open5gsSliceSummary{
+				{Sst: 2, DefaultIndicator: true, Sessions: map[string]int{"ims": open5gsSessionIPv4v6}},
+				{Sst: 1, Sd: "010203", Sessions: map[string]int{"internet": open5gsSessionIPv4}},
+			},
+		},
+		{
+			name: "first slice default without default slices",
+			modify: func(ue *models.UeProfile) {
+				ue.DefaultSlice = nil
+			},
+			wantOpc: "cd63cb71954a9f4e48a5994e37a02baf",
+			wantSqn: 0x20,
+			wantSlices: []open5gsSliceSummary{
+				{Sst: 1, Sd: "010203", DefaultIndicator: true, Sessions: map[string]int{"internet": open5gsSessionIPv4}},
+				{Sst: 2, Sessions: map[string]int{"ims": open5gsSessionIPv4v6}},
+			},
+		},
+		{
+			name: "no slices",
+			modify: func(ue *models.UeProfile) {
+				ue.DefaultSlice, ue.ConfiguredSlice, ue.Sessions = nil, nil, nil
+			},
+			wantOpc:    "cd63cb71954a9f4e48a5994e37a02baf",
+			wantSqn:    0x20,
+			wantSlices: []open5gsSliceSummary{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ue := testUeProfile()
+			tt.modify(ue)
+			sub, err := open5gsSubscriberOf(ue)
+			if err != nil {
+				t.Fatalf("open5gsSubscriberOf: %v", err)
+			}
+
+			if sub.Imsi != "208930000000001" || sub.Security.K != ue.Key || sub.Security.Amf != "8000" {
+				t.Errorf("subscriber %s with security %+v", sub.Imsi, sub.Security)
+			}
+			if sub.Security.Op != tt.wantOp || sub.Security.Opc != tt.wantOpc {
+				t.Errorf("OP %q OPc %q, want %q %q", sub.Security.Op, sub.Security.Opc, tt.wantOp, tt.wantOpc)
+			}
+			if sub.Security.Sqn != tt.wantSqn {
+				t.Errorf("SQN = %#x, want %#x", sub.Security.Sqn, tt.wantSqn)
+			}
+
+			slices := []open5gsSliceSummary{}
+			for _, slice := range sub.Slice {
+				summary := open5gsSliceSummary{Sst: slice.Sst, Sd: slice.Sd, DefaultIndicator: slice.DefaultIndicator, Sessions: map[string]int{}}
+				for _, session := range slice.Session {
+					summary.Sessions[session.Name] = session.Type
+				}
+				slices = append(slices, summary)
+			}
+			if !reflect.DeepEqual(slices, tt.wantSlices) {
+				t.Errorf("slices = %+v, want %+v", slices, tt.wantSlices)
+			}
+		})
+	}
+}
+
+func TestOpen5gsSubscriberOfErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(ue *models.UeProfile)
+	}{
+		{"NAI SUPI", func(ue *models.UeProfile) { ue.Supi = "nai-alice@example.com" }},
+		{"SUPI without type", func(ue *models.UeProfile) { ue.Supi = "208930000000001" }},
+		{"SQN not hex", func(ue *models.UeProfile) { ue.Sqn = "00000000002g" }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ue := testUeProfile()
+			tt.modify(ue)
+			if sub, err := open5gsSubscriberOf(ue); err == nil {
+				t.Errorf("open5gsSubscriberOf = %+v, want an error", sub)
+			}
+		})
+	}
+}
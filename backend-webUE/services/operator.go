@@ -0,0 +1,126 @@
+package services
+
+import (
+	"backend-webUE/models"
+	"backend-webUE/storage"
+	"backend-webUE/utils"
+	"context"
+	"errors"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var (
+	ErrOperatorNotFound = errors.New("operator not found")
+	ErrUnknownPlmn      = errors.New("no operator serves this PLMN")
+	ErrUnknownKeyId     = errors.New("unknown home network public key ID")
+)
+
+type OperatorService struct {
+	operators storage.OperatorRepository
+	// Operator loaded from ue-gen.json, used when no operator ID is given
+	defaultOperator *utils.Operator
+}
+
+func NewOperatorService(operators storage.OperatorRepository, defaultOperator *utils.Operator) *OperatorService {
+	return &OperatorService{
+		operators:       operators,
+		defaultOperator: defaultOperator,
+	}
+}
+
+// CreateOperator validates and stores a new operator definition
+func (s *OperatorService) CreateOperator(ctx context.Context, userID primitive.ObjectID, operator *models.Operator) error {
+	if err := utils.ValidateOperator(operator); err != nil {
+		return err
+	}
+	operator.ID = primitive.NewObjectID()
+	operator.UserID = userID
+
+	return s.operators.Create(ctx, operator)
+}
+
+// GetOperators retrieves all operators of a user
+func (s *OperatorService) GetOperators(ctx context.Context, userID primitive.ObjectID) ([]models.Operator, error) {
+	return s.operators.List(ctx, userID)
+}
+
+// GetOperator retrieves a specific operator by ID
+func (s *OperatorService) GetOperator(ctx context.Context, userID, operatorID primitive.ObjectID) (*models.Operator, error) {
+	return s.operators.Get(ctx, userID, operatorID)
+}
+
+// UpdateOperator replaces an operator definition. UE profiles already generated keep their settings.
+func (s *OperatorService) UpdateOperator(ctx context.Context, userID, operatorID primitive.ObjectID, operator *models.Operator) error {
+	if err := utils.ValidateOperator(operator); err != nil {
+		return err
+	}
+	operator.ID = operatorID
+	operator.UserID = userID
+
+	replaced, err := s.operators.Replace(ctx, operator)
+	if err != nil {
+		return err
+	}
+	if !replaced {
+		return ErrOperatorNotFound
+	}
+	return nil
+}
+
+// DeleteOperator deletes an operator definition
+func (s *OperatorService) DeleteOperator(ctx context.Context, userID, operatorID primitive.ObjectID) error {
+	deleted, err := s.operators.Delete(ctx, userID, operatorID)
+	if err != nil {
+		return err
+	}
+	if !deleted {
+		return ErrOperatorNotFound
+	}
+	return nil
+}
+
+// Operator returns the UE generator of an operator, or the default one for a nil ID
+func (s *OperatorService) Operator(ctx context.Context, userID, operatorID primitive.ObjectID) (*utils.Operator, error) {
+	if operatorID.IsZero() {
+		return s.defaultOperator, nil
+	}
+
+	operator, err := s.GetOperator(ctx, userID, operatorID)
+	if err != nil {
+		return nil, err
+	}
+	if operator == nil {
+		return nil, ErrOperatorNotFound
+	}
+	return utils.NewOperator(utils.NewOperatorConfig(operator)), nil
+}
+
+// HomeNetworkKey finds the home network key profile with the given key ID among the
+// default operator and the user's operators serving the PLMN
+func (s *OperatorService) HomeNetworkKey(ctx context.Context, userID primitive.ObjectID, plmnId models.PlmnId, keyId int) (*models.Profile, error) {
+	candidates := [][]models.Profile{}
+	if defaultConfig := s.defaultOperator.Config(); defaultConfig.PlmnId == plmnId {
+		candidates = append(candidates, defaultConfig.Profiles)
+	}
+
+	operators, err := s.operators.ListByPlmn(ctx, userID, plmnId)
+	if err != nil {
+		return nil, err
+	}
+	for _, operator := range operators {
+		candidates = append(candidates, operator.Profiles)
+	}
+
+	if len(candidates) == 0 {
+		return nil, ErrUnknownPlmn
+	}
+	for _, profiles := range candidates {
+		for _, profile := range profiles {
+			if profile.KeyId == keyId {
+				return &profile, nil
+			}
+		}
+	}
+	return nil, ErrUnknownKeyId
+}
@@ -0,0 +1,103 @@
+package services
+
+import (
+	"backend-webUE/models"
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+var (
+	ErrProvisionFailed        = errors.New("failed to provision UE profile")
+	ErrDeprovisionFailed      = errors.New("failed to deprovision UE profile")
+	ErrProvisionMismatch      = errors.New("provisioned subscription differs from UE profile")
+	ErrUnknownProvisionTarget = errors.New("unknown provisioning target")
+	ErrNoProvisionTarget      = errors.New("no provisioning target selected")
+)
+
+// ProvisionTargetAll selects every registered provisioning target
+const ProvisionTargetAll = "all"
+
+// maxProvisionErrors caps the failures reported by a fan-out, so a core that is down does not flood the response
+const maxProvisionErrors = 10
+
+// Provisioner pushes UE profiles into the subscriber data of a core network
+type Provisioner interface {
+	// Name of the target, used to select it and to key the sync status of profiles
+	Name() string
+	// Provision creates or replaces the subscription of a UE, errors wrap ErrProvisionFailed
+	Provision(ctx context.Context, ue *models.UeProfile) error
+	// Deprovision removes the subscription of a UE, errors wrap ErrDeprovisionFailed
+	Deprovision(ctx context.Context, ue *models.UeProfile) error
+	// Verify checks the subscription in the target against the UE profile,
+	// a missing or different subscription wraps ErrProvisionMismatch
+	Verify(ctx context.Context, ue *models.UeProfile) error
+}
+
+// ProvisionerRegistry holds the provisioners of the configured core network targets
+type ProvisionerRegistry struct {
+	provisioners map[string]Provisioner
+}
+
+func NewProvisionerRegistry(provisioners ...Provisioner) *ProvisionerRegistry {
+	r := &ProvisionerRegistry{
+		provisioners: make(map[string]Provisioner),
+	}
+	for _, p := range provisioners {
+		r.Register(p)
+	}
+	return r
+}
+
+// Register adds a provisioner, replacing one registered under the same name
+func (r *ProvisionerRegistry) Register(p Provisioner) {
+	r.provisioners[p.Name()] = p
+}
+
+// Names returns the sorted names of the registered targets
+func (r *ProvisionerRegistry) Names() []string {
+	names := make([]string, 0, len(r.provisioners))
+	for name := range r.provisioners {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Select returns the provisioners of the given targets, none when targets is empty.
+// ProvisionTargetAll selects every registered provisioner.
+func (r *ProvisionerRegistry) Select(targets []string) ([]Provisioner, error) {
+	var names []string
+	for _, target := range targets {
+		name := strings.ToLower(target)
+		if name == ProvisionTargetAll {
+			names = append(names, r.Names()...)
+			continue
+		}
+		if _, ok := r.provisioners[name]; !ok {
+			return nil, fmt.Errorf("%w %q, registered: %s", ErrUnknownProvisionTarget, target, strings.Join(r.Names(), ", "))
+		}
+		names = append(names, name)
+	}
+
+	selected := make([]Provisioner, 0, len(names))
+	seen := make(map[string]bool, len(names))
+	for _, name := range names {
+		if !seen[name] {
+			seen[name] = true
+			selected = append(selected, r.provisioners[name])
+		}
+	}
+	return selected, nil
+}
+
+// joinProvisionErrors combines the failures of a fan-out into one error, nil when there are none
+func joinProvisionErrors(errs []error) error {
+	if len(errs) <= maxProvisionErrors {
+		return errors.Join(errs...)
+	}
+	capped := append(errs[:maxProvisionErrors:maxProvisionErrors], fmt.Errorf("and %d more failures", len(errs)-maxProvisionErrors))
+	return errors.Join(capped...)
+}
@@ -0,0 +1,89 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// namedProvisioner is a fake provisioner registered under another name
+type namedProvisioner struct {
+	*fakeProvisioner
+	name string
+}
+
+func (p namedProvisioner) Name() string { return p.name }
+
+func TestProvisionerRegistrySelect(t *testing.T) {
+	r := NewProvisionerRegistry(
+		namedProvisioner{newFakeProvisioner(), "open5gs"},
+		namedProvisioner{newFakeProvisioner(), "free5gc"},
+		namedProvisioner{newFakeProvisioner(), "nudr"},
+	)
+	tests := []struct {
+		name    string
+		targets []string
+		want    []string
+	}{
+		{"nil", nil, []string{}},
+		{"empty", []string{}, []string{}},
+		{"one", []string{"nudr"}, []string{"nudr"}},
+		{"in the given order", []string{"open5gs", "free5gc"}, []string{"open5gs", "free5gc"}},
+		{"case insensitive", []string{"Free5GC"}, []string{"free5gc"}},
+		{"duplicates", []string{"nudr", "NUDR", "nudr"}, []string{"nudr"}},
+		{"all", []string{ProvisionTargetAll}, []string{"free5gc", "nudr", "open5gs"}},
+		{"all and one", []string{"open5gs", "All"}, []string{"open5gs", "free5gc", "nudr"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			selected, err := r.Select(tt.targets)
+			if err != nil {
+				t.Fatalf("Select: %v", err)
+			}
+			names := []string{}
+			for _, p := range selected {
+				names = append(names, p.Name())
+			}
+			if !reflect.DeepEqual(names, tt.want) {
+				t.Errorf("Select(%q) = %q, want %q", tt.targets, names, tt.want)
+			}
+		})
+	}
+
+	_, err := r.Select([]string{"nudr", "oai"})
+	if !errors.Is(err, ErrUnknownProvisionTarget) || !strings.Contains(err.Error(), `"oai", registered: free5gc, nudr, open5gs`) {
+		t.Errorf("Select of an unknown target = %v, want ErrUnknownProvisionTarget", err)
+	}
+	if _, err := NewProvisionerRegistry().Select([]string{ProvisionTargetAll}); err != nil {
+		t.Errorf("Select all without provisioners = %v", err)
+	}
+}
+
+func TestJoinProvisionErrors(t *testing.T) {
+	if err := joinProvisionErrors(nil); err != nil {
+		t.Errorf("joinProvisionErrors(nil) = %v", err)
+	}
+
+	for _, n := range []int{1, maxProvisionErrors, maxProvisionErrors + 5} {
+		errs := make([]error, n)
+		for i := range errs {
+			errs[i] = fmt.Errorf("%w: imsi-20893000000%04d", ErrProvisionFailed, i)
+		}
+		err := joinProvisionErrors(errs)
+		if !errors.Is(err, ErrProvisionFailed) {
+			t.Errorf("%d failures: %v does not wrap ErrProvisionFailed", n, err)
+		}
+		lines := strings.Split(err.Error(), "\n")
+		if n <= maxProvisionErrors {
+			if len(lines) != n {
+				t.Errorf("%d failures reported as %d lines", n, len(lines))
+			}
+			continue
+		}
+		if len(lines) != maxProvisionErrors+1 || lines[maxProvisionErrors] != "and 5 more failures" {
+			t.Errorf("%d failures reported as %q", n, lines)
+		}
+	}
+}
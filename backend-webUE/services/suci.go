@@ -0,0 +1,85 @@
+package services
+
+import (
+	"backend-webUE/models"
+	"backend-webUE/supi-key"
+	"backend-webUE/utils"
+	"context"
+	"errors"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var ErrMalformedSuci = errors.New("malformed SUCI")
+
+type SuciService struct {
+	operators *OperatorService
+}
+
+func NewSuciService(operators *OperatorService) *SuciService {
+	return &SuciService{
+		operators: operators,
+	}
+}
+
+// Deconceal acts as a SIDF: it recovers the SUPI of an IMSI or NAI type SUCI
+// using the home network private key of the matching operator
+func (s *SuciService) Deconceal(ctx context.Context, userID primitive.ObjectID, suciString string) (string, error) {
+	suci, err := utils.ParseSuci(suciString)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrMalformedSuci, err)
+	}
+
+	var profile string
+	switch suci.ProtectionScheme {
+	case utils.NULL_SCHEME:
+		// The output is the MSIN or username itself
+		return suci.Supi(suci.SchemeOutput), nil
+	case utils.A_SCHEME:
+		profile = "A"
+	case utils.B_SCHEME:
+		profile = "B"
+	default:
+		return "", fmt.Errorf("%w: %d", supi.ErrBadScheme, suci.ProtectionScheme)
+	}
+
+	hnKey, err := s.homeNetworkKey(ctx, userID, suci)
+	if err != nil {
+		return "", err
+	}
+	if hnKey.Scheme != suci.ProtectionScheme {
+		return "", fmt.Errorf("%w: key ID %d belongs to protection scheme %d", supi.ErrBadScheme, suci.HomeNetworkPublicKeyId, hnKey.Scheme)
+	}
+
+	if suci.SupiType == utils.IMSI_TYPE {
+		msin, err := supi.Suci2Supi(profile, hnKey.PrivateKey, suci.SchemeOutput)
+		if err != nil {
+			return "", err
+		}
+		return suci.Supi(msin), nil
+	}
+	username, err := supi.Deconceal(profile, hnKey.PrivateKey, suci.SchemeOutput)
+	if err != nil {
+		return "", err
+	}
+	return suci.Supi(string(username)), nil
+}
+
+// homeNetworkKey looks the key of the SUCI up by PLMN, derived from the realm for NAI SUCIs
+func (s *SuciService) homeNetworkKey(ctx context.Context, userID primitive.ObjectID, suci *utils.Suci) (*models.Profile, error) {
+	plmnIds := []models.PlmnId{{Mcc: suci.Mcc, Mnc: suci.Mnc}}
+	if suci.SupiType != utils.IMSI_TYPE {
+		plmnIds = utils.PlmnIdsFromRealm(suci.HomeNetworkId)
+	}
+
+	err := ErrUnknownPlmn
+	for _, plmnId := range plmnIds {
+		var hnKey *models.Profile
+		hnKey, err = s.operators.HomeNetworkKey(ctx, userID, plmnId, suci.HomeNetworkPublicKeyId)
+		if !errors.Is(err, ErrUnknownPlmn) {
+			return hnKey, err
+		}
+	}
+	return nil, err
+}
@@ -1,158 +1,556 @@
 package services
 
 import (
+	"backend-webUE/aka"
+	"backend-webUE/milenage"
 	"backend-webUE/models"
+	"backend-webUE/storage"
 	"backend-webUE/utils"
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"strings"
+	"time"
 
-	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
-	"go.mongodb.org/mongo-driver/mongo"
 )
 
+// ErrInvalidUpdate rejects UE profile updates the SUPI cannot be concealed with
+var ErrInvalidUpdate = errors.New("invalid UE profile update")
+
 type UeProfileService struct {
-	db       *mongo.Database
-	operator *utils.Operator
+	repo      storage.UeProfileRepository
+	operators *OperatorService
+	// Core network targets generated, updated and deleted profiles fan out to
+	provisioners *ProvisionerRegistry
+	// MSINs of generated SUPIs
+	msins *MsinAllocator
 }
 
-func NewUeProfileService(db *mongo.Database, operator *utils.Operator) *UeProfileService {
+func NewUeProfileService(ueProfiles storage.UeProfileRepository, msins storage.MsinRepository, operators *OperatorService, provisioners *ProvisionerRegistry) *UeProfileService {
 	return &UeProfileService{
-		db:       db,
-		operator: operator,
+		repo:         ueProfiles,
+		operators:    operators,
+		provisioners: provisioners,
+		msins:        NewMsinAllocator(msins),
 	}
 }
 
-// GenerateUeProfiles generates and inserts multiple UE profiles into the database
-func (s *UeProfileService) GenerateUeProfiles(ctx context.Context, userID primitive.ObjectID, num int) ([]models.UeProfile, error) {
-	collection := s.db.Collection("ue_profiles")
+// Provisioners returns the registry of the core network targets
+func (s *UeProfileService) Provisioners() *ProvisionerRegistry {
+	return s.provisioners
+}
 
-	var ueProfiles []models.UeProfile
-	var docs []interface{}
+// provision pushes UE profiles to the given targets and records the sync status of every push,
+// both in the database and in ueProfiles
+func (s *UeProfileService) provision(ctx context.Context, userID primitive.ObjectID, ueProfiles []models.UeProfile, provisioners []Provisioner) error {
+	if len(provisioners) == 0 || len(ueProfiles) == 0 {
+		return nil
+	}
+	var errs []error
+	updates := make([]storage.UeProfileUpdate, 0, len(ueProfiles))
+	for i := range ueProfiles {
+		ue := &ueProfiles[i]
+		if ue.Provisioning == nil {
+			ue.Provisioning = make(map[string]models.ProvisionStatus)
+		}
+		set := map[string]interface{}{}
+		for _, p := range provisioners {
+			now := time.Now()
+			status := ue.Provisioning[p.Name()]
+			status.UpdatedAt = now
+			if err := p.Provision(ctx, ue); err != nil {
+				errs = append(errs, err)
+				status.Failed, status.Error, status.InSync = true, err.Error(), false
+			} else {
+				status.Failed, status.Error, status.InSync = false, "", true
+				status.ProvisionedAt = &now
+				set["provisioning."+p.Name()+".provisionedAt"] = now
+			}
+			ue.Provisioning[p.Name()] = status
 
-	for i := 0; i < num; i++ {
-		ueProfile := s.operator.GenerateUe()
-		if ueProfile == nil {
-			// Skip invalid UE profiles
-			continue
+			field := "provisioning." + p.Name() + "."
+			set[field+"updatedAt"] = now
+			set[field+"failed"] = status.Failed
+			set[field+"error"] = status.Error
+			set[field+"inSync"] = status.InSync
 		}
-		ueProfile.UserID = userID // Assign the user ID
+		updates = append(updates, storage.UeProfileUpdate{Supi: ue.Supi, Fields: set})
+	}
 
-		ueProfiles = append(ueProfiles, *ueProfile)
-		docs = append(docs, ueProfile)
+	if _, err := s.repo.Update(ctx, userID, updates...); err != nil {
+		return fmt.Errorf("failed to record provisioning status: %v", err)
 	}
+	return joinProvisionErrors(errs)
+}
 
-	// Check if no valid UE profiles were generated
-	if len(docs) == 0 {
-		return nil, fmt.Errorf("no valid UE profiles were generated")
+// deprovision removes a UE from the given targets. Failed targets are recorded in the profile,
+// which is kept so the delete can be retried.
+func (s *UeProfileService) deprovision(ctx context.Context, userID primitive.ObjectID, ue *models.UeProfile, provisioners []Provisioner) error {
+	var errs []error
+	set := map[string]interface{}{}
+	for _, p := range provisioners {
+		if err := p.Deprovision(ctx, ue); err != nil {
+			errs = append(errs, err)
+			field := "provisioning." + p.Name() + "."
+			set[field+"updatedAt"] = time.Now()
+			set[field+"failed"] = true
+			set[field+"error"] = err.Error()
+		}
+	}
+	if len(errs) == 0 {
+		return nil
 	}
 
-	// Use InsertMany for batch insertion
-	_, err := collection.InsertMany(ctx, docs)
-	if err != nil {
-		return nil, fmt.Errorf("failed to insert UE profiles: %v", err)
+	if _, err := s.repo.Update(ctx, userID, storage.UeProfileUpdate{Supi: ue.Supi, Fields: set}); err != nil {
+		errs = append(errs, fmt.Errorf("failed to record provisioning status: %v", err))
 	}
-	return ueProfiles, nil
+	return joinProvisionErrors(errs)
 }
 
-// CreateUeProfiles inserts multiple UE profiles into the database
-func (s *UeProfileService) CreateUeProfiles(ctx context.Context, userID primitive.ObjectID, ueProfiles []models.UeProfile) error {
-	collection := s.db.Collection("ue_profiles")
+// GenerateParams selects the operator and the per-UE choices of a generation
+type GenerateParams struct {
+	// Operator to generate for, nil for the default operator
+	OperatorID primitive.ObjectID
+	Num        int
+	// Protection scheme of the generated SUCIs
+	Schemes utils.SchemeMix
+	// utils.OP for the operator's shared OP, utils.OPC for per-UE OPc
+	OpType string
+	// utils.AUTH_5G_AKA or utils.AUTH_EAP_AKA_PRIME
+	AuthenticationMethod string
+	// Provisioning targets to push the UEs to, none when empty, see ProvisionerRegistry.Select
+	Targets []string
+	// First MSIN of an explicit range of Num MSINs, nil to allocate them sequentially
+	MsinStart *uint64
+	// Tags of the generated UEs
+	Tags []string
+}
 
-	// Assign userID to each profile
-	for i := range ueProfiles {
-		ueProfiles[i].UserID = userID
+// allocateMsins reserves the MSINs of a generation. Sequential allocations skip MSINs whose
+// SUPI the user already has, e.g. from an import or an explicit range; an explicit range must be
+// entirely free and leaves the high-water mark alone.
+func (s *UeProfileService) allocateMsins(ctx context.Context, userID primitive.ObjectID, operator *utils.Operator, params GenerateParams) ([]uint64, error) {
+	msinsOf := func(first uint64, n int) ([]uint64, []string) {
+		msins := make([]uint64, n)
+		supis := make([]string, n)
+		for i := range msins {
+			msins[i] = first + uint64(i)
+			supis[i] = operator.Supi(msins[i])
+		}
+		return msins, supis
 	}
 
-	// Convert to interface slice
-	var docs []interface{}
-	for _, profile := range ueProfiles {
-		docs = append(docs, profile)
+	if params.MsinStart != nil {
+		if err := s.msins.CheckRange(operator, *params.MsinStart, params.Num); err != nil {
+			return nil, err
+		}
+		msins, supis := msinsOf(*params.MsinStart, params.Num)
+		exists, err := s.repo.Existing(ctx, userID, supis)
+		if err != nil {
+			return nil, err
+		}
+		if len(exists) > 0 {
+			return nil, fmt.Errorf("%w: %d SUPIs of MSINs %d to %d already have UE profiles", ErrMsinRangeAllocated, len(exists), msins[0], msins[len(msins)-1])
+		}
+		return msins, nil
 	}
 
-	// Use InsertMany for batch insertion
-	_, err := collection.InsertMany(ctx, docs)
+	var allocated []uint64
+	for len(allocated) < params.Num {
+		n := params.Num - len(allocated)
+		first, err := s.msins.Allocate(ctx, userID, operator, n)
+		if err != nil {
+			return nil, err
+		}
+		msins, supis := msinsOf(first, n)
+		exists, err := s.repo.Existing(ctx, userID, supis)
+		if err != nil {
+			return nil, err
+		}
+		for i, msin := range msins {
+			if !exists[supis[i]] {
+				allocated = append(allocated, msin)
+			}
+		}
+	}
+	return allocated, nil
+}
+
+// checkGenerate looks up the operator and the provisioning targets of a generation and validates its options
+func (s *UeProfileService) checkGenerate(ctx context.Context, userID primitive.ObjectID, params GenerateParams) (*utils.Operator, []Provisioner, error) {
+	operator, err := s.operators.Operator(ctx, userID, params.OperatorID)
 	if err != nil {
-		return fmt.Errorf("failed to insert UE profiles: %v", err)
+		return nil, nil, err
 	}
-	return nil
+	if err := operator.ValidateSchemeMix(params.Schemes); err != nil {
+		return nil, nil, err
+	}
+	if err := operator.ValidateOpType(params.OpType); err != nil {
+		return nil, nil, err
+	}
+	if err := utils.ValidateAuthenticationMethod(params.AuthenticationMethod); err != nil {
+		return nil, nil, err
+	}
+	provisioners, err := s.provisioners.Select(params.Targets)
+	if err != nil {
+		return nil, nil, err
+	}
+	return operator, provisioners, nil
 }
 
-func (s *UeProfileService) GetUeProfiles(ctx context.Context, userID primitive.ObjectID) ([]models.UeProfile, error) {
-	collection := s.db.Collection("ue_profiles")
+// GenerateUeProfiles generates and inserts multiple UE profiles of an operator into the database.
+// When provisioning fails the inserted profiles are returned with the ErrProvisionFailed error.
+func (s *UeProfileService) GenerateUeProfiles(ctx context.Context, userID primitive.ObjectID, params GenerateParams) ([]models.UeProfile, error) {
+	operator, provisioners, err := s.checkGenerate(ctx, userID, params)
+	if err != nil {
+		return nil, err
+	}
 
-	// Filter by UserID
-	filter := bson.M{"userId": userID}
-	cursor, err := collection.Find(ctx, filter)
+	msins, err := s.allocateMsins(ctx, userID, operator, params)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get UE profiles: %v", err)
+		return nil, err
 	}
-	defer cursor.Close(ctx)
 
 	var ueProfiles []models.UeProfile
-	if err = cursor.All(ctx, &ueProfiles); err != nil {
-		return nil, fmt.Errorf("failed to decode UE profiles: %v", err)
+
+	for _, msin := range msins {
+		ueProfile, err := operator.GenerateUe(utils.GenerateOptions{
+			Scheme:               params.Schemes.Pick(),
+			OpType:               params.OpType,
+			AuthenticationMethod: params.AuthenticationMethod,
+			Msin:                 msin,
+		})
+		if err != nil {
+			return nil, err
+		}
+		ueProfile.UserID = userID // Assign the user ID
+		ueProfile.OperatorID = params.OperatorID
+		ueProfile.Tags = append([]string(nil), params.Tags...)
+
+		ueProfiles = append(ueProfiles, *ueProfile)
+	}
+
+	if err := s.repo.Insert(ctx, ueProfiles); err != nil {
+		return nil, err
+	}
+	if err := s.provision(ctx, userID, ueProfiles, provisioners); err != nil {
+		return ueProfiles, err
 	}
 	return ueProfiles, nil
 }
 
+// CreateUeProfiles inserts multiple UE profiles into the database and pushes them to the
+// provisioning targets, none when targets is empty
+func (s *UeProfileService) CreateUeProfiles(ctx context.Context, userID primitive.ObjectID, ueProfiles []models.UeProfile, targets []string) error {
+	provisioners, err := s.provisioners.Select(targets)
+	if err != nil {
+		return err
+	}
+
+	// Assign userID to each profile and derive missing OPc and SQN values
+	for i := range ueProfiles {
+		ueProfiles[i].UserID = userID
+		ueProfiles[i].Provisioning = nil
+		ueProfiles[i].CreatedAt = time.Time{}
+		if ueProfiles[i].Sqn == "" {
+			ueProfiles[i].Sqn = aka.InitialSqn
+		}
+		if ueProfiles[i].AuthenticationMethod == "" {
+			ueProfiles[i].AuthenticationMethod = utils.AUTH_5G_AKA
+		}
+		if ueProfiles[i].Opc == "" && ueProfiles[i].Op != "" {
+			opc, err := milenage.GenerateOPcHex(ueProfiles[i].Key, ueProfiles[i].Op)
+			if err != nil {
+				return fmt.Errorf("UE profile %s: %v", ueProfiles[i].Supi, err)
+			}
+			ueProfiles[i].Opc = opc
+		}
+	}
+
+	if err := s.repo.Insert(ctx, ueProfiles); err != nil {
+		return err
+	}
+	return s.provision(ctx, userID, ueProfiles, provisioners)
+}
+
+func (s *UeProfileService) GetUeProfiles(ctx context.Context, userID primitive.ObjectID) ([]models.UeProfile, error) {
+	return s.repo.List(ctx, userID, nil)
+}
+
+// Page sizes of FindUeProfiles
+const (
+	DefaultUeProfilePageSize = 100
+	MaxUeProfilePageSize     = 1000
+)
+
+// FindUeProfiles returns a page of the UE profiles matching the query. A missing limit is
+// DefaultUeProfilePageSize, larger limits are capped at MaxUeProfilePageSize.
+func (s *UeProfileService) FindUeProfiles(ctx context.Context, userID primitive.ObjectID, query storage.UeProfileQuery) (*storage.UeProfilePage, error) {
+	if query.Limit <= 0 {
+		query.Limit = DefaultUeProfilePageSize
+	}
+	if query.Limit > MaxUeProfilePageSize {
+		query.Limit = MaxUeProfilePageSize
+	}
+	return s.repo.Find(ctx, userID, query)
+}
+
+// EachTagged calls fn with every UE profile of the user carrying the tag, in SUPI order
+func (s *UeProfileService) EachTagged(ctx context.Context, userID primitive.ObjectID, tag string, fn func(*models.UeProfile) error) error {
+	query := storage.UeProfileQuery{Tags: []string{tag}, Limit: MaxUeProfilePageSize, SkipTotal: true}
+	for {
+		page, err := s.repo.Find(ctx, userID, query)
+		if err != nil {
+			return err
+		}
+		for i := range page.UeProfiles {
+			if err := fn(&page.UeProfiles[i]); err != nil {
+				return err
+			}
+		}
+		if page.NextCursor == "" {
+			return nil
+		}
+		query.Cursor = page.NextCursor
+	}
+}
+
 // GetUeProfile retrieves a specific UE profile by SUPI
 func (s *UeProfileService) GetUeProfile(ctx context.Context, userID primitive.ObjectID, supi string) (*models.UeProfile, error) {
-	collection := s.db.Collection("ue_profiles")
+	return s.repo.Get(ctx, userID, supi)
+}
 
-	// Filter by UserID and SUPI
-	filter := bson.M{
-		"userId": userID,
-		"supi":   supi,
-	}
-	var ueProfile models.UeProfile
-	err := collection.FindOne(ctx, filter).Decode(&ueProfile)
+// UpdateUeProfile updates an existing UE profile and pushes it again to the provisioning targets,
+// none when targets is empty
+func (s *UeProfileService) UpdateUeProfile(ctx context.Context, userID primitive.ObjectID, supi string, updatedFields map[string]interface{}, targets []string) error {
+	provisioners, err := s.provisioners.Select(targets)
 	if err != nil {
-		if errors.Is(err, mongo.ErrNoDocuments) {
-			return nil, nil
+		return err
+	}
+
+	if method, ok := updatedFields["authenticationMethod"]; ok {
+		if v, _ := method.(string); utils.ValidateAuthenticationMethod(v) != nil {
+			return fmt.Errorf("authenticationMethod must be %s or %s", utils.AUTH_5G_AKA, utils.AUTH_EAP_AKA_PRIME)
 		}
-		return nil, fmt.Errorf("failed to get UE profile: %v", err)
 	}
-	return &ueProfile, nil
-}
 
-// UpdateUeProfile updates an existing UE profile
-func (s *UeProfileService) UpdateUeProfile(ctx context.Context, userID primitive.ObjectID, supi string, updatedFields map[string]interface{}) error {
-	collection := s.db.Collection("ue_profiles")
+	// Keep OPc consistent with a new K or OP
+	_, keyUpdated := updatedFields["key"]
+	_, opUpdated := updatedFields["op"]
+	_, opcUpdated := updatedFields["opc"]
+	concealmentUpdated := false
+	for _, field := range suciFields {
+		if _, ok := updatedFields[field]; ok {
+			concealmentUpdated = true
+		}
+	}
+	if (keyUpdated || opUpdated) && !opcUpdated || concealmentUpdated {
+		existing, err := s.GetUeProfile(ctx, userID, supi)
+		if err != nil {
+			return err
+		}
+		if existing == nil {
+			return fmt.Errorf("UE profile not found")
+		}
+
+		if (keyUpdated || opUpdated) && !opcUpdated {
+			key, op := existing.Key, existing.Op
+			if v, ok := updatedFields["key"].(string); ok {
+				key = v
+			}
+			if v, ok := updatedFields["op"].(string); ok {
+				op = v
+			}
+			if op != "" {
+				opc, err := milenage.GenerateOPcHex(key, op)
+				if err != nil {
+					return fmt.Errorf("failed to derive OPc: %v", err)
+				}
+				updatedFields["opc"] = opc
+			}
+		}
 
-	// Ensure UserID matches
-	filter := bson.M{
-		"userId": userID,
-		"supi":   supi,
+		// Conceal the SUPI again with the new routing indicator, scheme or home network key
+		if concealmentUpdated {
+			suci, err := concealUpdated(existing, updatedFields)
+			if err != nil {
+				return err
+			}
+			updatedFields["suci"] = suci
+		}
 	}
 
 	// Perform the update
-	result, err := collection.UpdateOne(ctx, filter, bson.M{"$set": updatedFields})
+	matched, err := s.repo.Update(ctx, userID, storage.UeProfileUpdate{Supi: supi, Fields: updatedFields})
 	if err != nil {
 		return fmt.Errorf("failed to update UE profile: %v", err)
 	}
-	if result.MatchedCount == 0 {
+	if matched == 0 {
 		return fmt.Errorf("UE profile not found")
 	}
+
+	// Re-provision the updated subscription
+	if len(provisioners) > 0 {
+		updated, err := s.GetUeProfile(ctx, userID, supi)
+		if err != nil {
+			return err
+		}
+		if updated != nil {
+			return s.provision(ctx, userID, []models.UeProfile{*updated}, provisioners)
+		}
+	}
 	return nil
 }
 
-// DeleteUeProfile deletes a UE profile
-func (s *UeProfileService) DeleteUeProfile(ctx context.Context, userID primitive.ObjectID, supi string) error {
-	collection := s.db.Collection("ue_profiles")
+// suciFields are the UE profile fields the SUCI is concealed with
+var suciFields = []string{"routingIndicator", "protectionScheme", "homeNetworkPublicKey", "homeNetworkPublicKeyId"}
 
-	// Ensure UserID matches
-	filter := bson.M{
-		"userId": userID,
-		"supi":   supi,
+// concealUpdated returns the SUCI of a UE profile with the updated fields applied
+func concealUpdated(existing *models.UeProfile, updatedFields map[string]interface{}) (string, error) {
+	fields := make(map[string]interface{}, len(suciFields))
+	for _, field := range suciFields {
+		if value, ok := updatedFields[field]; ok {
+			fields[field] = value
+		}
+	}
+	ue := *existing
+	data, err := json.Marshal(fields)
+	if err == nil {
+		err = json.Unmarshal(data, &ue)
+	}
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrInvalidUpdate, err)
+	}
+	suci, err := utils.ConcealSupi(&ue)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrInvalidUpdate, err)
+	}
+	return suci.String(), nil
+}
+
+// DeleteUeProfile removes a UE profile from the provisioning targets, none when targets is empty,
+// then deletes it. The profile is kept when a target fails.
+func (s *UeProfileService) DeleteUeProfile(ctx context.Context, userID primitive.ObjectID, supi string, targets []string) error {
+	provisioners, err := s.provisioners.Select(targets)
+	if err != nil {
+		return err
+	}
+
+	if len(provisioners) > 0 {
+		ueProfile, err := s.GetUeProfile(ctx, userID, supi)
+		if err != nil {
+			return err
+		}
+		if ueProfile == nil {
+			return fmt.Errorf("UE profile not found")
+		}
+		if err := s.deprovision(ctx, userID, ueProfile, provisioners); err != nil {
+			return err
+		}
 	}
-	result, err := collection.DeleteOne(ctx, filter)
+
+	deleted, err := s.repo.Delete(ctx, userID, supi)
 	if err != nil {
-		return fmt.Errorf("failed to delete UE profile: %v", err)
+		return err
 	}
-	if result.DeletedCount == 0 {
+	if !deleted {
 		return fmt.Errorf("UE profile not found")
 	}
 	return nil
 }
+
+// selectTargets selects the provisioners of an explicit push or check, which needs at least one target
+func (s *UeProfileService) selectTargets(targets []string) ([]Provisioner, error) {
+	provisioners, err := s.provisioners.Select(targets)
+	if err != nil {
+		return nil, err
+	}
+	if len(provisioners) == 0 {
+		return nil, fmt.Errorf("%w, use targets=%s or a list of: %s", ErrNoProvisionTarget, ProvisionTargetAll, strings.Join(s.provisioners.Names(), ", "))
+	}
+	return provisioners, nil
+}
+
+// ProvisionUeProfile pushes a stored UE profile to the provisioning targets, at least one must be
+// selected, and returns the profile with its updated sync status
+func (s *UeProfileService) ProvisionUeProfile(ctx context.Context, userID primitive.ObjectID, supi string, targets []string) (*models.UeProfile, error) {
+	provisioners, err := s.selectTargets(targets)
+	if err != nil {
+		return nil, err
+	}
+	ueProfile, err := s.GetUeProfile(ctx, userID, supi)
+	if err != nil {
+		return nil, err
+	}
+	if ueProfile == nil {
+		return nil, ErrUeProfileNotFound
+	}
+	ueProfiles := []models.UeProfile{*ueProfile}
+	err = s.provision(ctx, userID, ueProfiles, provisioners)
+	return &ueProfiles[0], err
+}
+
+// VerifyResult is the outcome of checking a UE profile against one provisioning target
+type VerifyResult struct {
+	Target string `json:"target"`
+	InSync bool   `json:"inSync"`
+	// Why the target does not match, or why it could not be checked
+	Reason string `json:"reason,omitempty"`
+}
+
+// VerifyUeProfile checks a UE profile against the provisioning targets, at least one must be selected.
+// Matches and mismatches are recorded in the sync status of the profile.
+func (s *UeProfileService) VerifyUeProfile(ctx context.Context, userID primitive.ObjectID, supi string, targets []string) ([]VerifyResult, error) {
+	provisioners, err := s.selectTargets(targets)
+	if err != nil {
+		return nil, err
+	}
+	ueProfile, err := s.GetUeProfile(ctx, userID, supi)
+	if err != nil {
+		return nil, err
+	}
+	if ueProfile == nil {
+		return nil, ErrUeProfileNotFound
+	}
+
+	results := make([]VerifyResult, 0, len(provisioners))
+	set := map[string]interface{}{}
+	for _, p := range provisioners {
+		result := VerifyResult{Target: p.Name(), InSync: true}
+		err := p.Verify(ctx, ueProfile)
+		if err != nil {
+			result.InSync, result.Reason = false, err.Error()
+		}
+		// A target that could not be read leaves the recorded status untouched
+		if err == nil || errors.Is(err, ErrProvisionMismatch) {
+			field := "provisioning." + p.Name() + "."
+			set[field+"verifiedAt"] = time.Now()
+			set[field+"inSync"] = result.InSync
+		}
+		results = append(results, result)
+	}
+
+	if len(set) > 0 {
+		if _, err := s.repo.Update(ctx, userID, storage.UeProfileUpdate{Supi: supi, Fields: set}); err != nil {
+			return nil, fmt.Errorf("failed to record provisioning status: %v", err)
+		}
+	}
+	return results, nil
+}
+
+// missingSupis returns the SUPIs that have no UE profile of the user
+func (s *UeProfileService) missingSupis(ctx context.Context, userID primitive.ObjectID, supis []string) ([]string, error) {
+	exists, err := s.repo.Existing(ctx, userID, supis)
+	if err != nil {
+		return nil, err
+	}
+	var missing []string
+	for _, supi := range supis {
+		if !exists[supi] {
+			missing = append(missing, supi)
+		}
+	}
+	return missing, nil
+}
@@ -0,0 +1,465 @@
+package services
+
+import (
+	"backend-webUE/milenage"
+	"backend-webUE/models"
+	"backend-webUE/storage"
+	"backend-webUE/utils"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// fakeProvisioner records the subscriptions it holds and fails while err is set
+type fakeProvisioner struct {
+	mu   sync.Mutex
+	subs map[string]bool
+	err  error
+}
+
+func newFakeProvisioner() *fakeProvisioner {
+	return &fakeProvisioner{subs: make(map[string]bool)}
+}
+
+func (p *fakeProvisioner) Name() string { return "fake" }
+
+func (p *fakeProvisioner) Provision(ctx context.Context, ue *models.UeProfile) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.err != nil {
+		return fmt.Errorf("%w: %v", ErrProvisionFailed, p.err)
+	}
+	p.subs[ue.Supi] = true
+	return nil
+}
+
+func (p *fakeProvisioner) Deprovision(ctx context.Context, ue *models.UeProfile) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.err != nil {
+		return fmt.Errorf("%w: %v", ErrDeprovisionFailed, p.err)
+	}
+	delete(p.subs, ue.Supi)
+	return nil
+}
+
+func (p *fakeProvisioner) Verify(ctx context.Context, ue *models.UeProfile) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.err != nil {
+		return p.err
+	}
+	if !p.subs[ue.Supi] {
+		return fmt.Errorf("%w: no subscription", ErrProvisionMismatch)
+	}
+	return nil
+}
+
+// newTestUeProfileService returns a UE profile service over an in-memory store with the default
+// operator of ue-gen.json and the fake provisioner as only target
+func newTestUeProfileService(t *testing.T) (*UeProfileService, *fakeProvisioner) {
+	t.Helper()
+	config, err := utils.LoadOperatorConfig("../config/ue-gen.json")
+	if err != nil {
+		t.Fatalf("LoadOperatorConfig: %v", err)
+	}
+	store := storage.NewMemoryStore()
+	provisioner := newFakeProvisioner()
+	operators := NewOperatorService(store.Operators, utils.NewOperator(config))
+	return NewUeProfileService(store.UeProfiles, store.Msins, operators, NewProvisionerRegistry(provisioner)), provisioner
+}
+
+func generateParams(num int) GenerateParams {
+	return GenerateParams{
+		Num:                  num,
+		Schemes:              utils.SchemeMix{Null: 1},
+		OpType:               utils.OPC,
+		AuthenticationMethod: utils.AUTH_5G_AKA,
+	}
+}
+
+func TestGenerateUeProfilesSequential(t *testing.T) {
+	s, provisioner := newTestUeProfileService(t)
+	ctx := context.Background()
+	userID := primitive.NewObjectID()
+
+	params := generateParams(3)
+	params.Targets = []string{ProvisionTargetAll}
+	first, err := s.GenerateUeProfiles(ctx, userID, params)
+	if err != nil {
+		t.Fatalf("GenerateUeProfiles: %v", err)
+	}
+	// Without targets nothing is pushed
+	second, err := s.GenerateUeProfiles(ctx, userID, generateParams(2))
+	if err != nil {
+		t.Fatalf("GenerateUeProfiles: %v", err)
+	}
+
+	want := []string{"imsi-208930000000001", "imsi-208930000000002", "imsi-208930000000003", "imsi-208930000000004", "imsi-208930000000005"}
+	for i, ue := range append(first, second...) {
+		if ue.Supi != want[i] {
+			t.Errorf("UE %d has SUPI %s, want %s", i, ue.Supi, want[i])
+		}
+		if provisioner.subs[ue.Supi] != (i < len(first)) {
+			t.Errorf("%s provisioned = %v", ue.Supi, provisioner.subs[ue.Supi])
+		}
+	}
+
+	stored, err := s.GetUeProfile(ctx, userID, "imsi-208930000000003")
+	if err != nil || stored == nil {
+		t.Fatalf("GetUeProfile = %v, %v", stored, err)
+	}
+	if status := stored.Provisioning["fake"]; !status.InSync || status.ProvisionedAt == nil {
+		t.Errorf("recorded provisioning status = %+v", status)
+	}
+	if stored, _ = s.GetUeProfile(ctx, userID, "imsi-208930000000004"); len(stored.Provisioning) != 0 {
+		t.Errorf("provisioning status without targets = %+v", stored.Provisioning)
+	}
+
+	// Every user has their own MSINs
+	other, err := s.GenerateUeProfiles(ctx, primitive.NewObjectID(), generateParams(1))
+	if err != nil {
+		t.Fatalf("GenerateUeProfiles: %v", err)
+	}
+	if other[0].Supi != want[0] {
+		t.Errorf("first SUPI of another user = %s, want %s", other[0].Supi, want[0])
+	}
+}
+
+func TestGenerateUeProfilesSkipsExistingSupis(t *testing.T) {
+	s, _ := newTestUeProfileService(t)
+	ctx := context.Background()
+	userID := primitive.NewObjectID()
+
+	imported := models.UeProfile{Supi: "imsi-208930000000002", PlmnId: models.PlmnId{Mcc: "208", Mnc: "93"}, Key: "8baf473f2f8fd09487cccbd7097c6862", Opc: "8e27b6af0e692e750f32667a3b14605d"}
+	if err := s.CreateUeProfiles(ctx, userID, []models.UeProfile{imported}, []string{}); err != nil {
+		t.Fatalf("CreateUeProfiles: %v", err)
+	}
+	ueProfiles, err := s.GenerateUeProfiles(ctx, userID, generateParams(2))
+	if err != nil {
+		t.Fatalf("GenerateUeProfiles: %v", err)
+	}
+	if ueProfiles[0].Supi != "imsi-208930000000001" || ueProfiles[1].Supi != "imsi-208930000000003" {
+		t.Errorf("generated %s and %s, want the imported SUPI skipped", ueProfiles[0].Supi, ueProfiles[1].Supi)
+	}
+}
+
+func TestGenerateUeProfilesExplicitRange(t *testing.T) {
+	s, _ := newTestUeProfileService(t)
+	ctx := context.Background()
+	userID := primitive.NewObjectID()
+
+	// generate returns the MSINs of the UEs generated from start, sequentially when start is nil
+	generate := func(start *uint64, n int) ([]string, error) {
+		params := generateParams(n)
+		params.MsinStart = start
+		ueProfiles, err := s.GenerateUeProfiles(ctx, userID, params)
+		var msins []string
+		for _, ue := range ueProfiles {
+			msins = append(msins, strings.TrimPrefix(ue.Supi, "imsi-20893"))
+		}
+		return msins, err
+	}
+	from := func(start uint64) *uint64 { return &start }
+
+	if _, err := generate(nil, 3); err != nil {
+		t.Fatalf("GenerateUeProfiles: %v", err)
+	}
+	imported := models.UeProfile{Supi: "imsi-208930000000010", PlmnId: models.PlmnId{Mcc: "208", Mnc: "93"}, Key: "8baf473f2f8fd09487cccbd7097c6862", Opc: "8e27b6af0e692e750f32667a3b14605d"}
+	if err := s.CreateUeProfiles(ctx, userID, []models.UeProfile{imported}, []string{}); err != nil {
+		t.Fatalf("CreateUeProfiles: %v", err)
+	}
+
+	steps := []struct {
+		name  string
+		start *uint64
+		n     int
+		want  []string
+		err   error
+	}{
+		{"range above the mark", from(5), 2, []string{"0000000005", "0000000006"}, nil},
+		{"range overlapping a range", from(6), 2, nil, ErrMsinRangeAllocated},
+		{"range overlapping an import", from(9), 2, nil, ErrMsinRangeAllocated},
+		// The rejected ranges claimed nothing
+		{"range below a rejected range", from(9), 1, []string{"0000000009"}, nil},
+		{"range below the mark", from(2), 1, nil, ErrMsinRangeAllocated},
+		{"range past the last MSIN", from(9999999999), 2, nil, utils.ErrInvalidGenerateOptions},
+		// Sequential allocation continues from the mark and skips the SUPIs of ranges and imports
+		{"sequential", nil, 3, []string{"0000000004", "0000000007", "0000000008"}, nil},
+		{"sequential past the import", nil, 1, []string{"0000000011"}, nil},
+	}
+	for _, step := range steps {
+		msins, err := generate(step.start, step.n)
+		if step.err != nil {
+			if !errors.Is(err, step.err) {
+				t.Errorf("%s: %v, want %v", step.name, err, step.err)
+			}
+			continue
+		}
+		if err != nil || strings.Join(msins, ",") != strings.Join(step.want, ",") {
+			t.Errorf("%s: generated %v, %v, want %v", step.name, msins, err, step.want)
+		}
+	}
+
+	// MSINs below the mark are free again once their UE profile is deleted
+	if err := s.DeleteUeProfile(ctx, userID, "imsi-208930000000002", []string{}); err != nil {
+		t.Fatalf("DeleteUeProfile: %v", err)
+	}
+	if msins, err := generate(from(2), 1); err != nil || len(msins) != 1 || msins[0] != "0000000002" {
+		t.Errorf("range of a deleted UE profile: generated %v, %v", msins, err)
+	}
+}
+
+func TestGenerateUeProfilesConcurrent(t *testing.T) {
+	s, _ := newTestUeProfileService(t)
+	ctx := context.Background()
+	userID := primitive.NewObjectID()
+
+	const workers, perWorker = 8, 5
+	var wg sync.WaitGroup
+	errs := make(chan error, workers)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := s.GenerateUeProfiles(ctx, userID, generateParams(perWorker)); err != nil {
+				errs <- err
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Errorf("GenerateUeProfiles: %v", err)
+	}
+
+	ueProfiles, err := s.GetUeProfiles(ctx, userID)
+	if err != nil {
+		t.Fatalf("GetUeProfiles: %v", err)
+	}
+	if len(ueProfiles) != workers*perWorker {
+		t.Errorf("stored %d UE profiles, want %d", len(ueProfiles), workers*perWorker)
+	}
+}
+
+func TestGenerateUeProfilesConcurrentRanges(t *testing.T) {
+	s, _ := newTestUeProfileService(t)
+	ctx := context.Background()
+	userID := primitive.NewObjectID()
+
+	// Overlapping ranges and sequential allocations racing for the same MSINs
+	const workers = 8
+	var wg sync.WaitGroup
+	errs := make(chan error, workers)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			params := generateParams(5)
+			if i%2 == 0 {
+				start := uint64(3 + i)
+				params.MsinStart = &start
+			}
+			_, err := s.GenerateUeProfiles(ctx, userID, params)
+			errs <- err
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+
+	generated := 0
+	for err := range errs {
+		var duplicates *storage.DuplicateSupisError
+		switch {
+		case err == nil:
+			generated += 5
+		case !errors.Is(err, ErrMsinRangeAllocated) && !errors.As(err, &duplicates):
+			t.Errorf("GenerateUeProfiles: %v", err)
+		}
+	}
+	ueProfiles, err := s.GetUeProfiles(ctx, userID)
+	if err != nil {
+		t.Fatalf("GetUeProfiles: %v", err)
+	}
+	supis := map[string]bool{}
+	for _, ue := range ueProfiles {
+		if supis[ue.Supi] {
+			t.Errorf("SUPI %s stored twice", ue.Supi)
+		}
+		supis[ue.Supi] = true
+	}
+	if len(ueProfiles) != generated {
+		t.Errorf("stored %d UE profiles, want the %d of the successful generations", len(ueProfiles), generated)
+	}
+}
+
+func TestCreateUeProfilesDuplicate(t *testing.T) {
+	s, _ := newTestUeProfileService(t)
+	ctx := context.Background()
+	userID := primitive.NewObjectID()
+
+	if _, err := s.GenerateUeProfiles(ctx, userID, generateParams(1)); err != nil {
+		t.Fatalf("GenerateUeProfiles: %v", err)
+	}
+	ueProfiles := []models.UeProfile{
+		{Supi: "imsi-208930000000009", Key: "8baf473f2f8fd09487cccbd7097c6862", Op: "8e27b6af0e692e750f32667a3b14605d"},
+		{Supi: "imsi-208930000000001", Key: "8baf473f2f8fd09487cccbd7097c6862", Op: "8e27b6af0e692e750f32667a3b14605d"},
+	}
+	err := s.CreateUeProfiles(ctx, userID, ueProfiles, nil)
+	var duplicates *storage.DuplicateSupisError
+	if !errors.As(err, &duplicates) || len(duplicates.Supis) != 1 || duplicates.Supis[0] != "imsi-208930000000001" {
+		t.Fatalf("CreateUeProfiles with duplicate = %v", err)
+	}
+	if ue, _ := s.GetUeProfile(ctx, userID, "imsi-208930000000009"); ue != nil {
+		t.Errorf("profile of a rejected batch was stored")
+	}
+}
+
+func TestUpdateUeProfileDerivesOpc(t *testing.T) {
+	s, provisioner := newTestUeProfileService(t)
+	ctx := context.Background()
+	userID := primitive.NewObjectID()
+
+	params := generateParams(1)
+	params.Targets = []string{ProvisionTargetAll}
+	ueProfiles, err := s.GenerateUeProfiles(ctx, userID, params)
+	if err != nil {
+		t.Fatalf("GenerateUeProfiles: %v", err)
+	}
+	supi := ueProfiles[0].Supi
+	key := "000102030405060708090a0b0c0d0e0f"
+	if err := s.UpdateUeProfile(ctx, userID, supi, map[string]interface{}{"key": key}, nil); err != nil {
+		t.Fatalf("UpdateUeProfile: %v", err)
+	}
+
+	updated, _ := s.GetUeProfile(ctx, userID, supi)
+	wantOpc, err := milenage.GenerateOPcHex(key, updated.Op)
+	if err != nil {
+		t.Fatalf("GenerateOPcHex: %v", err)
+	}
+	if updated.Key != key || updated.Opc != wantOpc {
+		t.Errorf("updated K %s OPc %s, want %s %s", updated.Key, updated.Opc, key, wantOpc)
+	}
+
+	provisioner.err = errors.New("core unreachable")
+	err = s.UpdateUeProfile(ctx, userID, supi, map[string]interface{}{"imei": "490154203237518"}, []string{ProvisionTargetAll})
+	if !errors.Is(err, ErrProvisionFailed) {
+		t.Errorf("UpdateUeProfile with failing target = %v, want ErrProvisionFailed", err)
+	}
+	updated, _ = s.GetUeProfile(ctx, userID, supi)
+	if status := updated.Provisioning["fake"]; !status.Failed || status.InSync || status.ProvisionedAt == nil {
+		t.Errorf("recorded provisioning status = %+v", status)
+	}
+
+	if err := s.UpdateUeProfile(ctx, userID, "imsi-208930000009999", map[string]interface{}{"imei": "1"}, []string{}); err == nil {
+		t.Errorf("UpdateUeProfile of unknown SUPI succeeded")
+	}
+}
+
+func TestUpdateUeProfileConcealsSupi(t *testing.T) {
+	s, _ := newTestUeProfileService(t)
+	ctx := context.Background()
+	userID := primitive.NewObjectID()
+
+	ueProfiles, err := s.GenerateUeProfiles(ctx, userID, generateParams(1))
+	if err != nil {
+		t.Fatalf("GenerateUeProfiles: %v", err)
+	}
+	supi := ueProfiles[0].Supi
+
+	// Numbers arrive as float64 from the JSON body
+	fields := map[string]interface{}{
+		"routingIndicator":       "12",
+		"protectionScheme":       float64(utils.A_SCHEME),
+		"homeNetworkPublicKey":   "5a8d38864820197c3394b92613b20b91633cbd897119273bf8e4a6f4eec0a650",
+		"homeNetworkPublicKeyId": float64(1),
+	}
+	if err := s.UpdateUeProfile(ctx, userID, supi, fields, nil); err != nil {
+		t.Fatalf("UpdateUeProfile: %v", err)
+	}
+	updated, _ := s.GetUeProfile(ctx, userID, supi)
+	suci, err := utils.ParseSuci(updated.Suci)
+	if err != nil {
+		t.Fatalf("ParseSuci(%s): %v", updated.Suci, err)
+	}
+	if suci.RoutingIndicator != "12" || suci.ProtectionScheme != utils.A_SCHEME || suci.HomeNetworkPublicKeyId != 1 {
+		t.Errorf("SUCI after update = %s", updated.Suci)
+	}
+
+	// Back to the null scheme, the SUCI carries the MSIN again
+	if err := s.UpdateUeProfile(ctx, userID, supi, map[string]interface{}{"protectionScheme": float64(utils.NULL_SCHEME)}, nil); err != nil {
+		t.Fatalf("UpdateUeProfile: %v", err)
+	}
+	updated, _ = s.GetUeProfile(ctx, userID, supi)
+	if want := "suci-0-208-93-12-0-0-" + strings.TrimPrefix(supi, "imsi-20893"); updated.Suci != want {
+		t.Errorf("SUCI after update = %s, want %s", updated.Suci, want)
+	}
+
+	for _, fields := range []map[string]interface{}{
+		{"routingIndicator": "12345"},
+		{"protectionScheme": "A"},
+		// The profile A key does not fit profile B
+		{"protectionScheme": float64(utils.B_SCHEME), "homeNetworkPublicKey": "5a8d38864820197c3394b92613b20b91633cbd897119273bf8e4a6f4eec0a650"},
+	} {
+		if err := s.UpdateUeProfile(ctx, userID, supi, fields, nil); !errors.Is(err, ErrInvalidUpdate) {
+			t.Errorf("UpdateUeProfile(%v) = %v, want ErrInvalidUpdate", fields, err)
+		}
+	}
+	if unchanged, _ := s.GetUeProfile(ctx, userID, supi); unchanged.Suci != updated.Suci || unchanged.RoutingIndicator != "12" {
+		t.Errorf("rejected updates changed the profile to %s", unchanged.Suci)
+	}
+}
+
+func TestVerifyAndDeleteUeProfile(t *testing.T) {
+	s, provisioner := newTestUeProfileService(t)
+	ctx := context.Background()
+	userID := primitive.NewObjectID()
+
+	all := []string{ProvisionTargetAll}
+	params := generateParams(1)
+	params.Targets = all
+	ueProfiles, err := s.GenerateUeProfiles(ctx, userID, params)
+	if err != nil {
+		t.Fatalf("GenerateUeProfiles: %v", err)
+	}
+	supi := ueProfiles[0].Supi
+
+	if _, err := s.VerifyUeProfile(ctx, userID, supi, nil); !errors.Is(err, ErrNoProvisionTarget) {
+		t.Errorf("VerifyUeProfile without targets = %v, want ErrNoProvisionTarget", err)
+	}
+	delete(provisioner.subs, supi)
+	results, err := s.VerifyUeProfile(ctx, userID, supi, all)
+	if err != nil {
+		t.Fatalf("VerifyUeProfile: %v", err)
+	}
+	if len(results) != 1 || results[0].InSync {
+		t.Errorf("VerifyUeProfile = %+v, want out of sync", results)
+	}
+	if stored, _ := s.GetUeProfile(ctx, userID, supi); stored.Provisioning["fake"].InSync {
+		t.Errorf("mismatch was not recorded")
+	}
+
+	provisioner.err = errors.New("core unreachable")
+	if err := s.DeleteUeProfile(ctx, userID, supi, all); !errors.Is(err, ErrDeprovisionFailed) {
+		t.Errorf("DeleteUeProfile with failing target = %v, want ErrDeprovisionFailed", err)
+	}
+	if stored, _ := s.GetUeProfile(ctx, userID, supi); stored == nil {
+		t.Fatalf("profile was deleted although deprovisioning failed")
+	}
+
+	// Without targets only the local profile is deleted, the failing target is not contacted
+	if err := s.DeleteUeProfile(ctx, userID, supi, nil); err != nil {
+		t.Fatalf("DeleteUeProfile: %v", err)
+	}
+	if stored, _ := s.GetUeProfile(ctx, userID, supi); stored != nil {
+		t.Errorf("profile still stored after delete")
+	}
+	if err := s.DeleteUeProfile(ctx, userID, supi, nil); err == nil {
+		t.Errorf("second DeleteUeProfile succeeded")
+	}
+}
@@ -2,34 +2,38 @@ package services
 
 import (
 	"backend-webUE/models"
+	"backend-webUE/storage"
 	"context"
+	"errors"
 	"fmt"
 	"time"
 
-	"go.mongodb.org/mongo-driver/bson"
-	"go.mongodb.org/mongo-driver/mongo"
 	"golang.org/x/crypto/bcrypt"
 )
 
+var ErrUsernameTaken = errors.New("username already exists")
+
 type UserService struct {
-	db *mongo.Database
+	users  storage.UserRepository
+	tokens storage.TokenRepository
 }
 
-func NewUserService(db *mongo.Database) *UserService {
-	return &UserService{db: db}
+func NewUserService(users storage.UserRepository, tokens storage.TokenRepository) *UserService {
+	return &UserService{
+		users:  users,
+		tokens: tokens,
+	}
 }
 
 // Create a new user with a hashed password
 func (s *UserService) CreateUser(ctx context.Context, username, password string) error {
-	collection := s.db.Collection("users")
-
 	//Check if user already exists
-	count, err := collection.CountDocuments(ctx, bson.M{"username": username})
+	existing, err := s.users.GetByUsername(ctx, username)
 	if err != nil {
 		return fmt.Errorf("failed to check existing users: %v", err)
 	}
-	if count > 0 {
-		return fmt.Errorf("username already exists")
+	if existing != nil {
+		return ErrUsernameTaken
 	}
 
 	//Hash the password
@@ -43,24 +47,19 @@ func (s *UserService) CreateUser(ctx context.Context, username, password string)
 		Password: string(hashedPassword),
 	}
 
-	_, err = collection.InsertOne(ctx, user)
-	if err != nil {
-		return fmt.Errorf("failed to create user: %v", err)
+	err = s.users.Create(ctx, &user)
+	if errors.Is(err, storage.ErrDuplicate) {
+		// Registered concurrently since the check above
+		return ErrUsernameTaken
 	}
-	return nil
+	return err
 }
 
 // authenticate a user and returns the user object if successful
 func (s *UserService) AuthenticateUser(ctx context.Context, username, password string) (*models.User, error) {
-	collection := s.db.Collection("users")
-
-	var user models.User
-	err := collection.FindOne(ctx, bson.M{"username": username}).Decode(&user)
-	if err != nil {
-		if err == mongo.ErrNoDocuments {
-			return nil, nil
-		}
-		return nil, fmt.Errorf("failed to find user: %v", err)
+	user, err := s.users.GetByUsername(ctx, username)
+	if err != nil || user == nil {
+		return nil, err
 	}
 
 	// Compare the plain-text password with the hashed password from the database
@@ -69,20 +68,18 @@ func (s *UserService) AuthenticateUser(ctx context.Context, username, password s
 		return nil, nil // Invalid password
 	}
 
-	return &user, nil
+	return user, nil
 }
 
 // BlacklistToken adds a token to the blacklist
 func (s *UserService) BlacklistToken(ctx context.Context, tokenString string, expiresAt time.Time) error {
-	collection := s.db.Collection("blacklisted_tokens")
-
 	blacklistedToken := models.BlacklistedToken{
 		Token:     tokenString,
 		ExpiresAt: expiresAt,
 	}
 
-	_, err := collection.InsertOne(ctx, blacklistedToken)
-	if err != nil {
+	err := s.tokens.Add(ctx, blacklistedToken)
+	if err != nil && !errors.Is(err, storage.ErrDuplicate) {
 		return err
 	}
 	return nil
@@ -90,31 +87,9 @@ func (s *UserService) BlacklistToken(ctx context.Context, tokenString string, ex
 
 // IsTokenBlacklisted checks if a token is in the blacklist
 func (s *UserService) IsTokenBlacklisted(ctx context.Context, tokenString string) (bool, error) {
-	collection := s.db.Collection("blacklisted_tokens")
-
-	// Remove expired tokens to keep the collection clean
-	_, _ = collection.DeleteMany(ctx, bson.M{
-		"expiresAt": bson.M{"$lte": time.Now()},
-	})
-
-	count, err := collection.CountDocuments(ctx, bson.M{"token": tokenString})
-	if err != nil {
-		return false, err
-	}
-	return count > 0, nil
+	return s.tokens.Contains(ctx, tokenString, time.Now())
 }
 
 func (s *UserService) GetUserByUsername(ctx context.Context, username string) (*models.User, error) {
-	collection := s.db.Collection("users")
-
-	var user models.User
-	err := collection.FindOne(ctx, bson.M{"username": username}).Decode(&user)
-	if err != nil {
-		if err == mongo.ErrNoDocuments {
-			return nil, nil // User not found
-		}
-		return nil, fmt.Errorf("failed to find user: %v", err)
-	}
-
-	return &user, nil
+	return s.users.GetByUsername(ctx, username)
 }
@@ -0,0 +1,512 @@
+package storage
+
+import (
+	"backend-webUE/models"
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// NewMemoryStore keeps the repositories in memory, for tests and throwaway instances.
+// Values are copied through their BSON encoding, as they would be by a database.
+func NewMemoryStore() *Store {
+	return &Store{
+		UeProfiles: &memoryUeProfiles{profiles: make(map[primitive.ObjectID]map[string]models.UeProfile)},
+		Users:      &memoryUsers{users: make(map[string]models.User)},
+		Tokens:     &memoryTokens{tokens: make(map[string]time.Time)},
+		Operators:  &memoryOperators{operators: make(map[primitive.ObjectID]models.Operator)},
+		Msins:      &memoryMsins{marks: make(map[string]uint64)},
+		Jobs:       &memoryJobs{jobs: make(map[primitive.ObjectID]models.Job)},
+	}
+}
+
+// copyOf copies a value through its BSON encoding
+func copyOf(dst, src interface{}) error {
+	data, err := bson.Marshal(src)
+	if err != nil {
+		return err
+	}
+	return bson.Unmarshal(data, dst)
+}
+
+type memoryUeProfiles struct {
+	mu sync.RWMutex
+	// UE profiles by user and SUPI
+	profiles map[primitive.ObjectID]map[string]models.UeProfile
+}
+
+func (r *memoryUeProfiles) Insert(ctx context.Context, ueProfiles []models.UeProfile) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// Check every profile before storing any
+	seen := make(map[primitive.ObjectID]map[string]bool)
+	var duplicates []string
+	for _, ue := range ueProfiles {
+		if seen[ue.UserID] == nil {
+			seen[ue.UserID] = make(map[string]bool)
+		}
+		if _, ok := r.profiles[ue.UserID][ue.Supi]; ok || seen[ue.UserID][ue.Supi] {
+			duplicates = append(duplicates, ue.Supi)
+		}
+		seen[ue.UserID][ue.Supi] = true
+	}
+	if len(duplicates) > 0 {
+		return &DuplicateSupisError{Supis: duplicates}
+	}
+
+	stored := make([]models.UeProfile, len(ueProfiles))
+	now := creationTime()
+	for i := range ueProfiles {
+		ueProfiles[i].ID = primitive.NewObjectID()
+		if ueProfiles[i].CreatedAt.IsZero() {
+			ueProfiles[i].CreatedAt = now
+		}
+		if err := copyOf(&stored[i], &ueProfiles[i]); err != nil {
+			return fmt.Errorf("failed to insert UE profiles: %v", err)
+		}
+	}
+	for _, ue := range stored {
+		if r.profiles[ue.UserID] == nil {
+			r.profiles[ue.UserID] = make(map[string]models.UeProfile)
+		}
+		r.profiles[ue.UserID][ue.Supi] = ue
+	}
+	return nil
+}
+
+func (r *memoryUeProfiles) Get(ctx context.Context, userID primitive.ObjectID, supi string) (*models.UeProfile, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	stored, ok := r.profiles[userID][supi]
+	if !ok {
+		return nil, nil
+	}
+	var ueProfile models.UeProfile
+	if err := copyOf(&ueProfile, &stored); err != nil {
+		return nil, fmt.Errorf("failed to get UE profile: %v", err)
+	}
+	return &ueProfile, nil
+}
+
+func (r *memoryUeProfiles) List(ctx context.Context, userID primitive.ObjectID, supis []string) ([]models.UeProfile, error) {
+	ueProfiles := []models.UeProfile{}
+	err := r.Each(ctx, userID, supis, func(ue *models.UeProfile) error {
+		ueProfiles = append(ueProfiles, *ue)
+		return nil
+	})
+	return ueProfiles, err
+}
+
+func (r *memoryUeProfiles) Find(ctx context.Context, userID primitive.ObjectID, query UeProfileQuery) (*UeProfilePage, error) {
+	after, err := query.validate()
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.RLock()
+	var selected []models.UeProfile
+	for _, ue := range r.profiles[userID] {
+		if query.matches(&ue) {
+			selected = append(selected, ue)
+		}
+	}
+	r.mu.RUnlock()
+
+	position := func(ue *models.UeProfile) *pageCursor {
+		return &pageCursor{Supi: ue.Supi, CreatedAt: ue.CreatedAt}
+	}
+	sort.Slice(selected, func(i, j int) bool { return query.less(position(&selected[i]), position(&selected[j])) })
+	start := 0
+	if after != nil {
+		start = sort.Search(len(selected), func(i int) bool { return query.less(after, position(&selected[i])) })
+	}
+	end := min(start+query.Limit+1, len(selected))
+
+	ueProfiles := make([]models.UeProfile, end-start)
+	for i := range ueProfiles {
+		if err := copyOf(&ueProfiles[i], &selected[start+i]); err != nil {
+			return nil, fmt.Errorf("failed to decode UE profile: %v", err)
+		}
+	}
+	total := int64(len(selected))
+	if query.SkipTotal {
+		total = 0
+	}
+	return query.page(ueProfiles, total), nil
+}
+
+func (r *memoryUeProfiles) Each(ctx context.Context, userID primitive.ObjectID, supis []string, fn func(*models.UeProfile) error) error {
+	r.mu.RLock()
+	var selected []models.UeProfile
+	if len(supis) == 0 {
+		for _, ue := range r.profiles[userID] {
+			selected = append(selected, ue)
+		}
+	} else {
+		for _, supi := range supis {
+			if ue, ok := r.profiles[userID][supi]; ok {
+				selected = append(selected, ue)
+			}
+		}
+	}
+	r.mu.RUnlock()
+
+	sort.Slice(selected, func(i, j int) bool { return selected[i].Supi < selected[j].Supi })
+	for i := range selected {
+		if i > 0 && selected[i].Supi == selected[i-1].Supi {
+			continue
+		}
+		var ueProfile models.UeProfile
+		if err := copyOf(&ueProfile, &selected[i]); err != nil {
+			return fmt.Errorf("failed to decode UE profile: %v", err)
+		}
+		if err := fn(&ueProfile); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *memoryUeProfiles) Existing(ctx context.Context, userID primitive.ObjectID, supis []string) (map[string]bool, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	exists := make(map[string]bool)
+	for _, supi := range supis {
+		if _, ok := r.profiles[userID][supi]; ok {
+			exists[supi] = true
+		}
+	}
+	return exists, nil
+}
+
+// setField sets the value at a dotted path of a document, creating the documents on the way
+func setField(doc bson.M, path string, value interface{}) error {
+	parts := strings.Split(path, ".")
+	for _, part := range parts[:len(parts)-1] {
+		switch child := doc[part].(type) {
+		case bson.M:
+			doc = child
+		case bson.D:
+			m := child.Map()
+			doc[part] = m
+			doc = m
+		case nil:
+			m := bson.M{}
+			doc[part] = m
+			doc = m
+		default:
+			return fmt.Errorf("%s is not a document", part)
+		}
+	}
+	doc[parts[len(parts)-1]] = value
+	return nil
+}
+
+func (r *memoryUeProfiles) Update(ctx context.Context, userID primitive.ObjectID, updates ...UeProfileUpdate) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	matched := 0
+	for _, u := range updates {
+		stored, ok := r.profiles[userID][u.Supi]
+		if !ok {
+			continue
+		}
+		matched++
+
+		var doc bson.M
+		if err := copyOf(&doc, &stored); err != nil {
+			return matched, fmt.Errorf("failed to update UE profiles: %v", err)
+		}
+		for path, value := range u.Fields {
+			if err := setField(doc, path, value); err != nil {
+				return matched, fmt.Errorf("failed to update UE profiles: %v", err)
+			}
+		}
+		var updated models.UeProfile
+		if err := copyOf(&updated, doc); err != nil {
+			return matched, fmt.Errorf("failed to update UE profiles: %v", err)
+		}
+		r.profiles[userID][u.Supi] = updated
+	}
+	return matched, nil
+}
+
+func (r *memoryUeProfiles) SetSqn(ctx context.Context, userID primitive.ObjectID, supi string, old *string, sqn string) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	stored, ok := r.profiles[userID][supi]
+	if !ok || (old != nil && stored.Sqn != *old) {
+		return false, nil
+	}
+	stored.Sqn = sqn
+	r.profiles[userID][supi] = stored
+	return true, nil
+}
+
+func (r *memoryUeProfiles) Delete(ctx context.Context, userID primitive.ObjectID, supi string) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.profiles[userID][supi]; !ok {
+		return false, nil
+	}
+	delete(r.profiles[userID], supi)
+	return true, nil
+}
+
+type memoryUsers struct {
+	mu sync.RWMutex
+	// Users by username
+	users map[string]models.User
+}
+
+func (r *memoryUsers) Create(ctx context.Context, user *models.User) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.users[user.Username]; ok {
+		return fmt.Errorf("%w: username %s", ErrDuplicate, user.Username)
+	}
+	user.ID = primitive.NewObjectID()
+	r.users[user.Username] = *user
+	return nil
+}
+
+func (r *memoryUsers) GetByUsername(ctx context.Context, username string) (*models.User, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	user, ok := r.users[username]
+	if !ok {
+		return nil, nil
+	}
+	return &user, nil
+}
+
+type memoryTokens struct {
+	mu sync.RWMutex
+	// Expiry of the revoked tokens
+	tokens map[string]time.Time
+}
+
+func (r *memoryTokens) Add(ctx context.Context, token models.BlacklistedToken) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.tokens[token.Token]; ok {
+		return fmt.Errorf("%w: token", ErrDuplicate)
+	}
+	r.tokens[token.Token] = token.ExpiresAt
+	return nil
+}
+
+func (r *memoryTokens) Contains(ctx context.Context, token string, now time.Time) (bool, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	expiresAt, ok := r.tokens[token]
+	return ok && expiresAt.After(now), nil
+}
+
+type memoryOperators struct {
+	mu sync.RWMutex
+	// Operators by ID
+	operators map[primitive.ObjectID]models.Operator
+}
+
+func (r *memoryOperators) Create(ctx context.Context, operator *models.Operator) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.operators[operator.ID]; ok {
+		return fmt.Errorf("%w: operator %s", ErrDuplicate, operator.ID.Hex())
+	}
+	var stored models.Operator
+	if err := copyOf(&stored, operator); err != nil {
+		return fmt.Errorf("failed to insert operator: %v", err)
+	}
+	r.operators[operator.ID] = stored
+	return nil
+}
+
+func (r *memoryOperators) find(match func(*models.Operator) bool) ([]models.Operator, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	operators := []models.Operator{}
+	for _, stored := range r.operators {
+		if !match(&stored) {
+			continue
+		}
+		var operator models.Operator
+		if err := copyOf(&operator, &stored); err != nil {
+			return nil, fmt.Errorf("failed to decode operators: %v", err)
+		}
+		operators = append(operators, operator)
+	}
+	// By ID, which follows the insertion order like an unsorted collection
+	sort.Slice(operators, func(i, j int) bool { return operators[i].ID.Hex() < operators[j].ID.Hex() })
+	return operators, nil
+}
+
+func (r *memoryOperators) List(ctx context.Context, userID primitive.ObjectID) ([]models.Operator, error) {
+	return r.find(func(op *models.Operator) bool {
+		return op.UserID == userID
+	})
+}
+
+func (r *memoryOperators) ListByPlmn(ctx context.Context, userID primitive.ObjectID, plmnId models.PlmnId) ([]models.Operator, error) {
+	return r.find(func(op *models.Operator) bool {
+		return op.UserID == userID && op.PlmnId == plmnId
+	})
+}
+
+func (r *memoryOperators) Get(ctx context.Context, userID, operatorID primitive.ObjectID) (*models.Operator, error) {
+	operators, err := r.find(func(op *models.Operator) bool {
+		return op.ID == operatorID && op.UserID == userID
+	})
+	if err != nil || len(operators) == 0 {
+		return nil, err
+	}
+	return &operators[0], nil
+}
+
+func (r *memoryOperators) Replace(ctx context.Context, operator *models.Operator) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if stored, ok := r.operators[operator.ID]; !ok || stored.UserID != operator.UserID {
+		return false, nil
+	}
+	var stored models.Operator
+	if err := copyOf(&stored, operator); err != nil {
+		return false, fmt.Errorf("failed to update operator: %v", err)
+	}
+	r.operators[operator.ID] = stored
+	return true, nil
+}
+
+func (r *memoryOperators) Delete(ctx context.Context, userID, operatorID primitive.ObjectID) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if stored, ok := r.operators[operatorID]; !ok || stored.UserID != userID {
+		return false, nil
+	}
+	delete(r.operators, operatorID)
+	return true, nil
+}
+
+type memoryMsins struct {
+	mu sync.Mutex
+	// High-water marks by msinAllocatorID
+	marks map[string]uint64
+}
+
+func (r *memoryMsins) Advance(ctx context.Context, userID primitive.ObjectID, plmnId models.PlmnId, start, n, limit uint64) (uint64, bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	id := msinAllocatorID(userID, plmnId)
+	next := r.marks[id]
+	if start > next {
+		next = start
+	}
+	if next+n > limit {
+		return 0, false, nil
+	}
+	r.marks[id] = next + n
+	return next + n, true, nil
+}
+
+type memoryJobs struct {
+	mu sync.RWMutex
+	// Jobs by ID
+	jobs map[primitive.ObjectID]models.Job
+}
+
+func (r *memoryJobs) Create(ctx context.Context, job *models.Job) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	job.ID = primitive.NewObjectID()
+	var stored models.Job
+	if err := copyOf(&stored, job); err != nil {
+		return fmt.Errorf("failed to insert job: %v", err)
+	}
+	r.jobs[job.ID] = stored
+	return nil
+}
+
+// find returns copies of the matching jobs ordered by creation, newest first when newest is set
+func (r *memoryJobs) find(match func(*models.Job) bool, newest bool) ([]models.Job, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	jobs := []models.Job{}
+	for _, stored := range r.jobs {
+		if !match(&stored) {
+			continue
+		}
+		var job models.Job
+		if err := copyOf(&job, &stored); err != nil {
+			return nil, fmt.Errorf("failed to decode jobs: %v", err)
+		}
+		jobs = append(jobs, job)
+	}
+	sort.Slice(jobs, func(i, j int) bool {
+		if !jobs[i].CreatedAt.Equal(jobs[j].CreatedAt) {
+			return jobs[i].CreatedAt.Before(jobs[j].CreatedAt) != newest
+		}
+		return (jobs[i].ID.Hex() < jobs[j].ID.Hex()) != newest
+	})
+	return jobs, nil
+}
+
+func (r *memoryJobs) Get(ctx context.Context, userID, jobID primitive.ObjectID) (*models.Job, error) {
+	jobs, err := r.find(func(job *models.Job) bool {
+		return job.ID == jobID && job.UserID == userID
+	}, false)
+	if err != nil || len(jobs) == 0 {
+		return nil, err
+	}
+	return &jobs[0], nil
+}
+
+func (r *memoryJobs) List(ctx context.Context, userID primitive.ObjectID) ([]models.Job, error) {
+	return r.find(func(job *models.Job) bool {
+		return job.UserID == userID
+	}, true)
+}
+
+func (r *memoryJobs) Replace(ctx context.Context, job *models.Job) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if stored, ok := r.jobs[job.ID]; !ok || stored.UserID != job.UserID {
+		return false, nil
+	}
+	var stored models.Job
+	if err := copyOf(&stored, job); err != nil {
+		return false, fmt.Errorf("failed to update job: %v", err)
+	}
+	r.jobs[job.ID] = stored
+	return true, nil
+}
+
+func (r *memoryJobs) Unfinished(ctx context.Context) ([]models.Job, error) {
+	return r.find(func(job *models.Job) bool {
+		return !job.Finished()
+	}, false)
+}
@@ -0,0 +1,513 @@
+package storage
+
+import (
+	"backend-webUE/models"
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// NewMongoStore keeps the repositories in the collections of db, see database.EnsureIndexes for their indexes
+func NewMongoStore(db *mongo.Database) *Store {
+	return &Store{
+		UeProfiles: &mongoUeProfiles{collection: db.Collection("ue_profiles")},
+		Users:      &mongoUsers{collection: db.Collection("users")},
+		Tokens:     &mongoTokens{collection: db.Collection("blacklisted_tokens")},
+		Operators:  &mongoOperators{collection: db.Collection("operators")},
+		Msins:      &mongoMsins{collection: db.Collection("msin_allocators")},
+		Jobs:       &mongoJobs{collection: db.Collection("jobs")},
+	}
+}
+
+type mongoUeProfiles struct {
+	collection *mongo.Collection
+}
+
+func ueProfileFilter(userID primitive.ObjectID, supis []string) bson.M {
+	filter := bson.M{"userId": userID}
+	if len(supis) > 0 {
+		filter["supi"] = bson.M{"$in": supis}
+	}
+	return filter
+}
+
+func (r *mongoUeProfiles) Insert(ctx context.Context, ueProfiles []models.UeProfile) error {
+	docs := make([]interface{}, 0, len(ueProfiles))
+	now := creationTime()
+	for i := range ueProfiles {
+		ueProfiles[i].ID = primitive.NewObjectID()
+		if ueProfiles[i].CreatedAt.IsZero() {
+			ueProfiles[i].CreatedAt = now
+		}
+		docs = append(docs, &ueProfiles[i])
+	}
+	_, err := r.collection.InsertMany(ctx, docs, options.InsertMany().SetOrdered(false))
+	if err == nil {
+		return nil
+	}
+
+	// The unique index of userId and supi rejected some profiles, remove the others again
+	var bulkErr mongo.BulkWriteException
+	if !errors.As(err, &bulkErr) || !mongo.IsDuplicateKeyError(err) {
+		return fmt.Errorf("failed to insert UE profiles: %v", err)
+	}
+	rejected := make(map[int]bool)
+	var supis []string
+	for _, writeErr := range bulkErr.WriteErrors {
+		rejected[writeErr.Index] = true
+		if mongo.IsDuplicateKeyError(writeErr) && writeErr.Index < len(ueProfiles) {
+			supis = append(supis, ueProfiles[writeErr.Index].Supi)
+		}
+	}
+	var inserted []primitive.ObjectID
+	for i := range ueProfiles {
+		if !rejected[i] {
+			inserted = append(inserted, ueProfiles[i].ID)
+		}
+	}
+	if len(inserted) > 0 {
+		if _, err := r.collection.DeleteMany(ctx, bson.M{"_id": bson.M{"$in": inserted}}); err != nil {
+			return fmt.Errorf("failed to remove UE profiles inserted with duplicates: %v", err)
+		}
+	}
+	return &DuplicateSupisError{Supis: supis}
+}
+
+func (r *mongoUeProfiles) Get(ctx context.Context, userID primitive.ObjectID, supi string) (*models.UeProfile, error) {
+	var ueProfile models.UeProfile
+	err := r.collection.FindOne(ctx, bson.M{"userId": userID, "supi": supi}).Decode(&ueProfile)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get UE profile: %v", err)
+	}
+	return &ueProfile, nil
+}
+
+func (r *mongoUeProfiles) find(ctx context.Context, userID primitive.ObjectID, supis []string) (*mongo.Cursor, error) {
+	cursor, err := r.collection.Find(ctx, ueProfileFilter(userID, supis), options.Find().SetSort(bson.M{"supi": 1}))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get UE profiles: %v", err)
+	}
+	return cursor, nil
+}
+
+func (r *mongoUeProfiles) List(ctx context.Context, userID primitive.ObjectID, supis []string) ([]models.UeProfile, error) {
+	cursor, err := r.find(ctx, userID, supis)
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	ueProfiles := []models.UeProfile{}
+	if err := cursor.All(ctx, &ueProfiles); err != nil {
+		return nil, fmt.Errorf("failed to decode UE profiles: %v", err)
+	}
+	return ueProfiles, nil
+}
+
+// ueProfileQueryFilter matches the profiles of the user selected by the filters of a query
+func ueProfileQueryFilter(userID primitive.ObjectID, query *UeProfileQuery) bson.M {
+	and := bson.A{bson.M{"userId": userID}}
+	if query.SupiPrefix != "" {
+		and = append(and, bson.M{"supi": primitive.Regex{Pattern: "^" + regexp.QuoteMeta(query.SupiPrefix)}})
+	}
+	if query.SupiFrom != "" {
+		and = append(and, bson.M{"supi": bson.M{"$gte": query.SupiFrom}})
+	}
+	if query.SupiTo != "" {
+		and = append(and, bson.M{"supi": bson.M{"$lte": query.SupiTo}})
+	}
+	if query.Mcc != "" {
+		and = append(and, bson.M{"plmnid.mcc": query.Mcc})
+	}
+	if query.Mnc != "" {
+		and = append(and, bson.M{"plmnid.mnc": query.Mnc})
+	}
+	if query.Sst != nil || query.Sd != "" {
+		slice := bson.M{}
+		if query.Sst != nil {
+			slice["sst"] = *query.Sst
+		}
+		if query.Sd != "" {
+			slice["sd"] = query.Sd
+		}
+		and = append(and, bson.M{"$or": bson.A{
+			bson.M{"configuredSlice": bson.M{"$elemMatch": slice}},
+			bson.M{"defaultSlice": bson.M{"$elemMatch": slice}},
+		}})
+	}
+	if query.Dnn != "" {
+		and = append(and, bson.M{"sessions.apn": query.Dnn})
+	}
+	if query.ProtectionScheme != nil {
+		and = append(and, bson.M{"protectionScheme": *query.ProtectionScheme})
+	}
+	if query.OpType != "" {
+		and = append(and, bson.M{"opType": query.OpType})
+	}
+	if len(query.Tags) > 0 {
+		and = append(and, bson.M{"tags": bson.M{"$all": query.Tags}})
+	}
+	if query.CreatedAfter != nil {
+		and = append(and, bson.M{"createdAt": bson.M{"$gt": *query.CreatedAfter}})
+	}
+	return bson.M{"$and": and}
+}
+
+func (r *mongoUeProfiles) Find(ctx context.Context, userID primitive.ObjectID, query UeProfileQuery) (*UeProfilePage, error) {
+	after, err := query.validate()
+	if err != nil {
+		return nil, err
+	}
+	filter := ueProfileQueryFilter(userID, &query)
+	var total int64
+	if !query.SkipTotal {
+		if total, err = r.collection.CountDocuments(ctx, filter); err != nil {
+			return nil, fmt.Errorf("failed to count UE profiles: %v", err)
+		}
+	}
+
+	// Ties of the sort key are ordered by SUPI, which is unique per user
+	op, dir := "$gt", 1
+	if query.Desc {
+		op, dir = "$lt", -1
+	}
+	sort := bson.D{{Key: "supi", Value: dir}}
+	if query.sortKey() == UeProfileSortCreatedAt {
+		sort = bson.D{{Key: "createdAt", Value: dir}, {Key: "supi", Value: dir}}
+	}
+	if after != nil {
+		var position bson.M
+		if query.sortKey() == UeProfileSortCreatedAt {
+			position = bson.M{"$or": bson.A{
+				bson.M{"createdAt": bson.M{op: after.CreatedAt}},
+				bson.M{"createdAt": after.CreatedAt, "supi": bson.M{op: after.Supi}},
+			}}
+		} else {
+			position = bson.M{"supi": bson.M{op: after.Supi}}
+		}
+		filter["$and"] = append(filter["$and"].(bson.A), position)
+	}
+
+	cursor, err := r.collection.Find(ctx, filter, options.Find().SetSort(sort).SetLimit(int64(query.Limit+1)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get UE profiles: %v", err)
+	}
+	defer cursor.Close(ctx)
+	ueProfiles := []models.UeProfile{}
+	if err := cursor.All(ctx, &ueProfiles); err != nil {
+		return nil, fmt.Errorf("failed to decode UE profiles: %v", err)
+	}
+	return query.page(ueProfiles, total), nil
+}
+
+func (r *mongoUeProfiles) Each(ctx context.Context, userID primitive.ObjectID, supis []string, fn func(*models.UeProfile) error) error {
+	cursor, err := r.find(ctx, userID, supis)
+	if err != nil {
+		return err
+	}
+	defer cursor.Close(ctx)
+
+	for cursor.Next(ctx) {
+		var ueProfile models.UeProfile
+		if err := cursor.Decode(&ueProfile); err != nil {
+			return fmt.Errorf("failed to decode UE profile: %v", err)
+		}
+		if err := fn(&ueProfile); err != nil {
+			return err
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		return fmt.Errorf("failed to get UE profiles: %v", err)
+	}
+	return nil
+}
+
+func (r *mongoUeProfiles) Existing(ctx context.Context, userID primitive.ObjectID, supis []string) (map[string]bool, error) {
+	exists := make(map[string]bool)
+	if len(supis) == 0 {
+		return exists, nil
+	}
+	found, err := r.collection.Distinct(ctx, "supi", ueProfileFilter(userID, supis))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get UE profiles: %v", err)
+	}
+	for _, supi := range found {
+		if v, ok := supi.(string); ok {
+			exists[v] = true
+		}
+	}
+	return exists, nil
+}
+
+func (r *mongoUeProfiles) Update(ctx context.Context, userID primitive.ObjectID, updates ...UeProfileUpdate) (int, error) {
+	if len(updates) == 0 {
+		return 0, nil
+	}
+	writes := make([]mongo.WriteModel, 0, len(updates))
+	for _, u := range updates {
+		writes = append(writes, mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"userId": userID, "supi": u.Supi}).
+			SetUpdate(bson.M{"$set": u.Fields}))
+	}
+	result, err := r.collection.BulkWrite(ctx, writes, options.BulkWrite().SetOrdered(false))
+	if err != nil {
+		return 0, fmt.Errorf("failed to update UE profiles: %v", err)
+	}
+	return int(result.MatchedCount), nil
+}
+
+func (r *mongoUeProfiles) SetSqn(ctx context.Context, userID primitive.ObjectID, supi string, old *string, sqn string) (bool, error) {
+	filter := bson.M{
+		"userId": userID,
+		"supi":   supi,
+	}
+	if old != nil && *old == "" {
+		// Profiles created before SQN tracking have no sqn field
+		filter["sqn"] = bson.M{"$in": []interface{}{"", nil}}
+	} else if old != nil {
+		filter["sqn"] = *old
+	}
+	result, err := r.collection.UpdateOne(ctx, filter, bson.M{"$set": bson.M{"sqn": sqn}})
+	if err != nil {
+		return false, fmt.Errorf("failed to update SQN: %v", err)
+	}
+	return result.MatchedCount == 1, nil
+}
+
+func (r *mongoUeProfiles) Delete(ctx context.Context, userID primitive.ObjectID, supi string) (bool, error) {
+	result, err := r.collection.DeleteOne(ctx, bson.M{"userId": userID, "supi": supi})
+	if err != nil {
+		return false, fmt.Errorf("failed to delete UE profile: %v", err)
+	}
+	return result.DeletedCount == 1, nil
+}
+
+type mongoUsers struct {
+	collection *mongo.Collection
+}
+
+func (r *mongoUsers) Create(ctx context.Context, user *models.User) error {
+	user.ID = primitive.NewObjectID()
+	_, err := r.collection.InsertOne(ctx, user)
+	if mongo.IsDuplicateKeyError(err) {
+		return fmt.Errorf("%w: username %s", ErrDuplicate, user.Username)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to create user: %v", err)
+	}
+	return nil
+}
+
+func (r *mongoUsers) GetByUsername(ctx context.Context, username string) (*models.User, error) {
+	var user models.User
+	err := r.collection.FindOne(ctx, bson.M{"username": username}).Decode(&user)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to find user: %v", err)
+	}
+	return &user, nil
+}
+
+type mongoTokens struct {
+	collection *mongo.Collection
+}
+
+func (r *mongoTokens) Add(ctx context.Context, token models.BlacklistedToken) error {
+	_, err := r.collection.InsertOne(ctx, token)
+	if mongo.IsDuplicateKeyError(err) {
+		return fmt.Errorf("%w: token", ErrDuplicate)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to blacklist token: %v", err)
+	}
+	return nil
+}
+
+// Contains also checks the expiry, the TTL index removes expired tokens only about once a minute
+func (r *mongoTokens) Contains(ctx context.Context, token string, now time.Time) (bool, error) {
+	count, err := r.collection.CountDocuments(ctx, bson.M{"token": token, "expiresAt": bson.M{"$gt": now}})
+	if err != nil {
+		return false, fmt.Errorf("failed to check blacklisted tokens: %v", err)
+	}
+	return count > 0, nil
+}
+
+type mongoOperators struct {
+	collection *mongo.Collection
+}
+
+func (r *mongoOperators) Create(ctx context.Context, operator *models.Operator) error {
+	if _, err := r.collection.InsertOne(ctx, operator); err != nil {
+		return fmt.Errorf("failed to insert operator: %v", err)
+	}
+	return nil
+}
+
+func (r *mongoOperators) find(ctx context.Context, filter bson.M) ([]models.Operator, error) {
+	cursor, err := r.collection.Find(ctx, filter)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get operators: %v", err)
+	}
+	defer cursor.Close(ctx)
+
+	operators := []models.Operator{}
+	if err = cursor.All(ctx, &operators); err != nil {
+		return nil, fmt.Errorf("failed to decode operators: %v", err)
+	}
+	return operators, nil
+}
+
+func (r *mongoOperators) List(ctx context.Context, userID primitive.ObjectID) ([]models.Operator, error) {
+	return r.find(ctx, bson.M{"userId": userID})
+}
+
+func (r *mongoOperators) ListByPlmn(ctx context.Context, userID primitive.ObjectID, plmnId models.PlmnId) ([]models.Operator, error) {
+	return r.find(ctx, bson.M{
+		"userId":     userID,
+		"plmnid.mcc": plmnId.Mcc,
+		"plmnid.mnc": plmnId.Mnc,
+	})
+}
+
+func (r *mongoOperators) Get(ctx context.Context, userID, operatorID primitive.ObjectID) (*models.Operator, error) {
+	var operator models.Operator
+	err := r.collection.FindOne(ctx, bson.M{"_id": operatorID, "userId": userID}).Decode(&operator)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get operator: %v", err)
+	}
+	return &operator, nil
+}
+
+func (r *mongoOperators) Replace(ctx context.Context, operator *models.Operator) (bool, error) {
+	result, err := r.collection.ReplaceOne(ctx, bson.M{"_id": operator.ID, "userId": operator.UserID}, operator)
+	if err != nil {
+		return false, fmt.Errorf("failed to update operator: %v", err)
+	}
+	return result.MatchedCount == 1, nil
+}
+
+func (r *mongoOperators) Delete(ctx context.Context, userID, operatorID primitive.ObjectID) (bool, error) {
+	result, err := r.collection.DeleteOne(ctx, bson.M{"_id": operatorID, "userId": userID})
+	if err != nil {
+		return false, fmt.Errorf("failed to delete operator: %v", err)
+	}
+	return result.DeletedCount == 1, nil
+}
+
+type mongoMsins struct {
+	collection *mongo.Collection
+}
+
+func msinAllocatorID(userID primitive.ObjectID, plmnId models.PlmnId) string {
+	return userID.Hex() + ":" + plmnId.Mcc + plmnId.Mnc
+}
+
+// ensure creates the high-water mark of a PLMN at 0 unless it exists
+func (r *mongoMsins) ensure(ctx context.Context, userID primitive.ObjectID, plmnId models.PlmnId) (string, error) {
+	id := msinAllocatorID(userID, plmnId)
+	_, err := r.collection.UpdateOne(ctx, bson.M{"_id": id}, bson.M{
+		"$setOnInsert": bson.M{"userId": userID, "plmnid": plmnId, "next": int64(0)},
+	}, options.Update().SetUpsert(true))
+	// A concurrent allocation created it first
+	if err != nil && !mongo.IsDuplicateKeyError(err) {
+		return "", fmt.Errorf("failed to create MSIN allocator: %v", err)
+	}
+	return id, nil
+}
+
+func (r *mongoMsins) Advance(ctx context.Context, userID primitive.ObjectID, plmnId models.PlmnId, start, n, limit uint64) (uint64, bool, error) {
+	if start+n > limit {
+		return 0, false, nil
+	}
+	id, err := r.ensure(ctx, userID, plmnId)
+	if err != nil {
+		return 0, false, err
+	}
+
+	// next = max(next, start) + n, unless that passes limit
+	filter := bson.M{"_id": id, "next": bson.M{"$lte": int64(limit - n)}}
+	update := mongo.Pipeline{{{Key: "$set", Value: bson.M{
+		"next": bson.M{"$add": bson.A{bson.M{"$max": bson.A{"$next", int64(start)}}, int64(n)}},
+	}}}}
+	var state struct {
+		Next int64 `bson:"next"`
+	}
+	err = r.collection.FindOneAndUpdate(ctx, filter, update, options.FindOneAndUpdate().SetReturnDocument(options.After)).Decode(&state)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to allocate MSINs: %v", err)
+	}
+	return uint64(state.Next), true, nil
+}
+
+type mongoJobs struct {
+	collection *mongo.Collection
+}
+
+func (r *mongoJobs) Create(ctx context.Context, job *models.Job) error {
+	job.ID = primitive.NewObjectID()
+	if _, err := r.collection.InsertOne(ctx, job); err != nil {
+		return fmt.Errorf("failed to insert job: %v", err)
+	}
+	return nil
+}
+
+func (r *mongoJobs) find(ctx context.Context, filter bson.M, sort bson.D) ([]models.Job, error) {
+	cursor, err := r.collection.Find(ctx, filter, options.Find().SetSort(sort))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get jobs: %v", err)
+	}
+	defer cursor.Close(ctx)
+
+	jobs := []models.Job{}
+	if err = cursor.All(ctx, &jobs); err != nil {
+		return nil, fmt.Errorf("failed to decode jobs: %v", err)
+	}
+	return jobs, nil
+}
+
+func (r *mongoJobs) Get(ctx context.Context, userID, jobID primitive.ObjectID) (*models.Job, error) {
+	var job models.Job
+	err := r.collection.FindOne(ctx, bson.M{"_id": jobID, "userId": userID}).Decode(&job)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get job: %v", err)
+	}
+	return &job, nil
+}
+
+func (r *mongoJobs) List(ctx context.Context, userID primitive.ObjectID) ([]models.Job, error) {
+	return r.find(ctx, bson.M{"userId": userID}, bson.D{{Key: "createdAt", Value: -1}, {Key: "_id", Value: -1}})
+}
+
+func (r *mongoJobs) Replace(ctx context.Context, job *models.Job) (bool, error) {
+	result, err := r.collection.ReplaceOne(ctx, bson.M{"_id": job.ID, "userId": job.UserID}, job)
+	if err != nil {
+		return false, fmt.Errorf("failed to update job: %v", err)
+	}
+	return result.MatchedCount == 1, nil
+}
+
+func (r *mongoJobs) Unfinished(ctx context.Context) ([]models.Job, error) {
+	filter := bson.M{"status": bson.M{"$in": bson.A{models.JobQueued, models.JobRunning}}}
+	return r.find(ctx, filter, bson.D{{Key: "createdAt", Value: 1}, {Key: "_id", Value: 1}})
+}
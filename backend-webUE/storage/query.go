@@ -0,0 +1,165 @@
+package storage
+
+import (
+	"backend-webUE/models"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ErrInvalidQuery reports a UE profile query with an unknown sort key, a bad limit or a foreign cursor
+var ErrInvalidQuery = errors.New("invalid UE profile query")
+
+// Sort keys of UE profile pages
+const (
+	UeProfileSortSupi      = "supi"
+	UeProfileSortCreatedAt = "createdAt"
+)
+
+// UeProfileQuery selects a page of the UE profiles of a user. Unset filters match every profile.
+type UeProfileQuery struct {
+	SupiPrefix string
+	// Inclusive SUPI range, either bound may be empty
+	SupiFrom, SupiTo string
+	Mcc, Mnc         string
+	// A configured or default slice with the SST and SD, the SD without 0x
+	Sst *int
+	Sd  string
+	// A session to the DNN (APN)
+	Dnn              string
+	ProtectionScheme *int
+	OpType           string
+	// Profiles carrying every one of the tags
+	Tags         []string
+	CreatedAfter *time.Time
+
+	// UeProfileSortSupi or UeProfileSortCreatedAt, ties are ordered by SUPI
+	Sort string
+	Desc bool
+	// NextCursor of the previous page, empty for the first page
+	Cursor string
+	Limit  int
+	// Leave Total at 0 instead of counting the matching profiles, e.g. when reading every page
+	SkipTotal bool
+}
+
+// UeProfilePage is a page of UE profiles and the number of profiles matching the filters
+type UeProfilePage struct {
+	UeProfiles []models.UeProfile
+	Total      int64
+	// Cursor of the following page, empty on the last page
+	NextCursor string
+}
+
+// pageCursor is the position after the last profile of a page, in the sort order it was made for
+type pageCursor struct {
+	Sort      string    `json:"o"`
+	Desc      bool      `json:"d,omitempty"`
+	Supi      string    `json:"s"`
+	CreatedAt time.Time `json:"c"`
+}
+
+func (q *UeProfileQuery) sortKey() string {
+	if q.Sort == "" {
+		return UeProfileSortSupi
+	}
+	return q.Sort
+}
+
+// validate checks the sort key and decodes the cursor, nil for the first page
+func (q *UeProfileQuery) validate() (*pageCursor, error) {
+	if q.sortKey() != UeProfileSortSupi && q.sortKey() != UeProfileSortCreatedAt {
+		return nil, fmt.Errorf("%w: unknown sort key %q", ErrInvalidQuery, q.Sort)
+	}
+	if q.Limit <= 0 {
+		return nil, fmt.Errorf("%w: page limit must be positive", ErrInvalidQuery)
+	}
+	if q.Cursor == "" {
+		return nil, nil
+	}
+	data, err := base64.RawURLEncoding.DecodeString(q.Cursor)
+	if err != nil {
+		return nil, fmt.Errorf("%w: malformed cursor", ErrInvalidQuery)
+	}
+	var cursor pageCursor
+	if err := json.Unmarshal(data, &cursor); err != nil || cursor.Sort != q.sortKey() || cursor.Desc != q.Desc {
+		return nil, fmt.Errorf("%w: cursor of another sort order", ErrInvalidQuery)
+	}
+	return &cursor, nil
+}
+
+// page trims the limit+1 profiles read for a page to the limit and makes the cursor of the next page
+func (q *UeProfileQuery) page(ueProfiles []models.UeProfile, total int64) *UeProfilePage {
+	page := &UeProfilePage{UeProfiles: ueProfiles, Total: total}
+	if len(ueProfiles) <= q.Limit {
+		return page
+	}
+	page.UeProfiles = ueProfiles[:q.Limit]
+	last := page.UeProfiles[q.Limit-1]
+	data, _ := json.Marshal(pageCursor{Sort: q.sortKey(), Desc: q.Desc, Supi: last.Supi, CreatedAt: last.CreatedAt})
+	page.NextCursor = base64.RawURLEncoding.EncodeToString(data)
+	return page
+}
+
+// matches applies the filters to a profile, for backends without a query language
+func (q *UeProfileQuery) matches(ue *models.UeProfile) bool {
+	if !strings.HasPrefix(ue.Supi, q.SupiPrefix) ||
+		(q.SupiFrom != "" && ue.Supi < q.SupiFrom) || (q.SupiTo != "" && ue.Supi > q.SupiTo) ||
+		(q.Mcc != "" && ue.PlmnId.Mcc != q.Mcc) || (q.Mnc != "" && ue.PlmnId.Mnc != q.Mnc) ||
+		(q.ProtectionScheme != nil && ue.ProtectionScheme != *q.ProtectionScheme) ||
+		(q.OpType != "" && ue.OpType != q.OpType) ||
+		(q.CreatedAfter != nil && !ue.CreatedAt.After(*q.CreatedAfter)) {
+		return false
+	}
+	if q.Sst != nil || q.Sd != "" {
+		found := false
+		for _, s := range append(append([]models.Snssai(nil), ue.ConfiguredSlice...), ue.DefaultSlice...) {
+			if (q.Sst == nil || s.Sst == *q.Sst) && (q.Sd == "" || s.Sd == q.Sd) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if q.Dnn != "" {
+		found := false
+		for _, s := range ue.Sessions {
+			if s.Apn == q.Dnn {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	for _, tag := range q.Tags {
+		found := false
+		for _, t := range ue.Tags {
+			if t == tag {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// less orders two profiles by the sort key of the query, then by SUPI
+func (q *UeProfileQuery) less(a, b *pageCursor) bool {
+	if q.sortKey() == UeProfileSortCreatedAt && !a.CreatedAt.Equal(b.CreatedAt) {
+		return a.CreatedAt.Before(b.CreatedAt) != q.Desc
+	}
+	if a.Supi == b.Supi {
+		return false
+	}
+	return (a.Supi < b.Supi) != q.Desc
+}
@@ -0,0 +1,1046 @@
+package storage
+
+import (
+	"backend-webUE/models"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// SQLDialect is the SQL flavour of a database, named like the storage backends of config.StorageConfig
+type SQLDialect string
+
+const (
+	DialectSqlite   SQLDialect = "sqlite"
+	DialectPostgres SQLDialect = "postgres"
+)
+
+// sqlBatchSize bounds the rows read at once and the parameters of an IN list
+const sqlBatchSize = 500
+
+// rebind rewrites the ? placeholders of a query into the placeholders of the dialect
+func (d SQLDialect) rebind(query string) string {
+	if d != DialectPostgres {
+		return query
+	}
+	var b strings.Builder
+	n := 0
+	for _, c := range query {
+		if c == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+		} else {
+			b.WriteRune(c)
+		}
+	}
+	return b.String()
+}
+
+func (d SQLDialect) timestampType() string {
+	if d == DialectPostgres {
+		return "TIMESTAMPTZ"
+	}
+	return "TIMESTAMP"
+}
+
+// lockRows is appended to a SELECT to lock its rows until the end of the transaction. SQLite locks the
+// whole database for writing transactions already.
+func (d SQLDialect) lockRows() string {
+	if d == DialectPostgres {
+		return " FOR UPDATE"
+	}
+	return ""
+}
+
+// isUniqueViolation reports whether a statement failed on a unique or primary key constraint
+func isUniqueViolation(err error) bool {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		return pgErr.Code == "23505"
+	}
+	var sqliteErr *sqlite.Error
+	if errors.As(err, &sqliteErr) {
+		return sqliteErr.Code() == sqlite3.SQLITE_CONSTRAINT_UNIQUE || sqliteErr.Code() == sqlite3.SQLITE_CONSTRAINT_PRIMARYKEY
+	}
+	return false
+}
+
+// placeholders returns n comma separated ? placeholders
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?, ", n), ", ")
+}
+
+// sqlQuerier is a database or a transaction
+type sqlQuerier interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+	PrepareContext(ctx context.Context, query string) (*sql.Stmt, error)
+}
+
+// sqlDB runs the queries of the repositories, written with ? placeholders
+type sqlDB struct {
+	db      *sql.DB
+	dialect SQLDialect
+}
+
+func (s *sqlDB) exec(ctx context.Context, q sqlQuerier, query string, args ...interface{}) (sql.Result, error) {
+	return q.ExecContext(ctx, s.dialect.rebind(query), args...)
+}
+
+func (s *sqlDB) queryRow(ctx context.Context, q sqlQuerier, query string, args ...interface{}) *sql.Row {
+	return q.QueryRowContext(ctx, s.dialect.rebind(query), args...)
+}
+
+// eachRow calls fn for every row of a query
+func (s *sqlDB) eachRow(ctx context.Context, q sqlQuerier, query string, args []interface{}, fn func(*sql.Rows) error) error {
+	rows, err := q.QueryContext(ctx, s.dialect.rebind(query), args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		if err := fn(rows); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// withTx runs fn in a transaction that is committed unless fn fails
+func (s *sqlDB) withTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// affected reports whether a statement changed any row
+func affected(result sql.Result, err error) (bool, error) {
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// hexOrEmpty keeps an unset ObjectID empty, like omitempty does in MongoDB
+func hexOrEmpty(id primitive.ObjectID) string {
+	if id.IsZero() {
+		return ""
+	}
+	return id.Hex()
+}
+
+func objectIDOrNil(hex string) (primitive.ObjectID, error) {
+	if hex == "" {
+		return primitive.NilObjectID, nil
+	}
+	return primitive.ObjectIDFromHex(hex)
+}
+
+// NewSQLStore keeps the repositories in a SQLite or PostgreSQL database opened with database.ConnectSQL,
+// whose schema is migrated with MigrateSQL
+func NewSQLStore(db *sql.DB, dialect SQLDialect) *Store {
+	s := &sqlDB{db: db, dialect: dialect}
+	return &Store{
+		UeProfiles: &sqlUeProfiles{s},
+		Users:      &sqlUsers{s},
+		Tokens:     &sqlTokens{s},
+		Operators:  &sqlOperators{s},
+		Msins:      &sqlMsins{s},
+		Jobs:       &sqlJobs{s},
+	}
+}
+
+type sqlUeProfiles struct {
+	*sqlDB
+}
+
+// ueProfileColumns are the columns of ue_profiles in the order of ueProfileValues and scanUeProfile
+var ueProfileColumns = []string{
+	"id", "user_id", "operator_id", "supi", "suci", "mcc", "mnc", "routing_indicator",
+	"home_network_private_key", "home_network_public_key", "home_network_public_key_id", "protection_scheme",
+	"subscription_key", "op", "op_type", "opc", "amf", "sqn", "authentication_method", "imei", "imei_sv",
+	"integrity_ia1", "integrity_ia2", "integrity_ia3", "ciphering_ea1", "ciphering_ea2", "ciphering_ea3",
+	"uac_aic_mps", "uac_aic_mcs", "uac_acc_normal_class",
+	"uac_acc_class11", "uac_acc_class12", "uac_acc_class13", "uac_acc_class14", "uac_acc_class15",
+	"integrity_max_rate_uplink", "integrity_max_rate_downlink", "created_at",
+}
+
+func ueProfileValues(ue *models.UeProfile) []interface{} {
+	return []interface{}{
+		ue.ID.Hex(), ue.UserID.Hex(), hexOrEmpty(ue.OperatorID), ue.Supi, ue.Suci, ue.PlmnId.Mcc, ue.PlmnId.Mnc, ue.RoutingIndicator,
+		ue.HomeNetworkPrivateKey, ue.HomeNetworkPublicKey, ue.HomeNetworkPublicKeyId, ue.ProtectionScheme,
+		ue.Key, ue.Op, ue.OpType, ue.Opc, ue.Amf, ue.Sqn, ue.AuthenticationMethod, ue.Imei, ue.Imeisv,
+		ue.Integrity.IA1, ue.Integrity.IA2, ue.Integrity.IA3, ue.Ciphering.EA1, ue.Ciphering.EA2, ue.Ciphering.EA3,
+		ue.UacAic.Mps, ue.UacAic.Mcs, ue.UacAcc.NormalClass,
+		ue.UacAcc.Class11, ue.UacAcc.Class12, ue.UacAcc.Class13, ue.UacAcc.Class14, ue.UacAcc.Class15,
+		ue.IntegrityMaxRate.Uplink, ue.IntegrityMaxRate.Downlink, ue.CreatedAt.UTC(),
+	}
+}
+
+func scanUeProfile(rows *sql.Rows) (models.UeProfile, error) {
+	var ue models.UeProfile
+	var id, userID, operatorID string
+	err := rows.Scan(
+		&id, &userID, &operatorID, &ue.Supi, &ue.Suci, &ue.PlmnId.Mcc, &ue.PlmnId.Mnc, &ue.RoutingIndicator,
+		&ue.HomeNetworkPrivateKey, &ue.HomeNetworkPublicKey, &ue.HomeNetworkPublicKeyId, &ue.ProtectionScheme,
+		&ue.Key, &ue.Op, &ue.OpType, &ue.Opc, &ue.Amf, &ue.Sqn, &ue.AuthenticationMethod, &ue.Imei, &ue.Imeisv,
+		&ue.Integrity.IA1, &ue.Integrity.IA2, &ue.Integrity.IA3, &ue.Ciphering.EA1, &ue.Ciphering.EA2, &ue.Ciphering.EA3,
+		&ue.UacAic.Mps, &ue.UacAic.Mcs, &ue.UacAcc.NormalClass,
+		&ue.UacAcc.Class11, &ue.UacAcc.Class12, &ue.UacAcc.Class13, &ue.UacAcc.Class14, &ue.UacAcc.Class15,
+		&ue.IntegrityMaxRate.Uplink, &ue.IntegrityMaxRate.Downlink, &ue.CreatedAt,
+	)
+	if err != nil {
+		return ue, err
+	}
+	ue.CreatedAt = ue.CreatedAt.UTC()
+	if ue.ID, err = primitive.ObjectIDFromHex(id); err != nil {
+		return ue, err
+	}
+	if ue.UserID, err = primitive.ObjectIDFromHex(userID); err != nil {
+		return ue, err
+	}
+	ue.OperatorID, err = objectIDOrNil(operatorID)
+	return ue, err
+}
+
+// ueProfileChildTables hold the nested slices of a UE profile, rewritten whenever the profile changes
+var ueProfileChildTables = []string{"ue_profile_slices", "ue_profile_sessions", "ue_profile_hn_keys", "ue_profile_gnbs", "ue_profile_tags", "ue_profile_provisioning"}
+
+// ueProfileWriter writes UE profiles with statements prepared once per transaction
+type ueProfileWriter struct {
+	insertProfile, updateProfile                  *sql.Stmt
+	slice, session, hnKey, gnb, tag, provisioning *sql.Stmt
+	deleteChildren                                []*sql.Stmt
+}
+
+func (r *sqlUeProfiles) newWriter(ctx context.Context, tx *sql.Tx) (*ueProfileWriter, error) {
+	assignments := make([]string, 0, len(ueProfileColumns)-2)
+	for _, column := range ueProfileColumns[2:] {
+		assignments = append(assignments, column+" = ?")
+	}
+	queries := map[**sql.Stmt]string{}
+	w := &ueProfileWriter{}
+	queries[&w.insertProfile] = "INSERT INTO ue_profiles (" + strings.Join(ueProfileColumns, ", ") + ") VALUES (" + placeholders(len(ueProfileColumns)) + ")"
+	queries[&w.updateProfile] = "UPDATE ue_profiles SET " + strings.Join(assignments, ", ") + " WHERE id = ?"
+	queries[&w.slice] = "INSERT INTO ue_profile_slices (ue_profile_id, kind, position, sst, sd) VALUES (?, ?, ?, ?, ?)"
+	queries[&w.session] = "INSERT INTO ue_profile_sessions (ue_profile_id, position, type, apn, sst, sd) VALUES (?, ?, ?, ?, ?, ?)"
+	queries[&w.hnKey] = "INSERT INTO ue_profile_hn_keys (ue_profile_id, position, scheme, key_id, private_key, public_key) VALUES (?, ?, ?, ?, ?, ?)"
+	queries[&w.gnb] = "INSERT INTO ue_profile_gnbs (ue_profile_id, position, address) VALUES (?, ?, ?)"
+	queries[&w.tag] = "INSERT INTO ue_profile_tags (ue_profile_id, position, tag) VALUES (?, ?, ?)"
+	queries[&w.provisioning] = "INSERT INTO ue_profile_provisioning (ue_profile_id, target, updated_at, provisioned_at, failed, error, verified_at, in_sync) VALUES (?, ?, ?, ?, ?, ?, ?, ?)"
+	w.deleteChildren = make([]*sql.Stmt, len(ueProfileChildTables))
+	for i, table := range ueProfileChildTables {
+		queries[&w.deleteChildren[i]] = "DELETE FROM " + table + " WHERE ue_profile_id = ?"
+	}
+
+	for stmt, query := range queries {
+		prepared, err := tx.PrepareContext(ctx, r.dialect.rebind(query))
+		if err != nil {
+			w.close()
+			return nil, err
+		}
+		*stmt = prepared
+	}
+	return w, nil
+}
+
+func (w *ueProfileWriter) close() {
+	stmts := append([]*sql.Stmt{w.insertProfile, w.updateProfile, w.slice, w.session, w.hnKey, w.gnb, w.tag, w.provisioning}, w.deleteChildren...)
+	for _, stmt := range stmts {
+		if stmt != nil {
+			stmt.Close()
+		}
+	}
+}
+
+// nullTime stores a missing time as NULL
+func nullTime(t *time.Time) sql.NullTime {
+	if t == nil {
+		return sql.NullTime{}
+	}
+	return sql.NullTime{Time: t.UTC(), Valid: true}
+}
+
+func (w *ueProfileWriter) insertChildren(ctx context.Context, ue *models.UeProfile) error {
+	id := ue.ID.Hex()
+	for kind, slices := range map[string][]models.Snssai{"configured": ue.ConfiguredSlice, "default": ue.DefaultSlice} {
+		for i, s := range slices {
+			if _, err := w.slice.ExecContext(ctx, id, kind, i, s.Sst, s.Sd); err != nil {
+				return err
+			}
+		}
+	}
+	for i, s := range ue.Sessions {
+		if _, err := w.session.ExecContext(ctx, id, i, s.Type, s.Apn, s.Slice.Sst, s.Slice.Sd); err != nil {
+			return err
+		}
+	}
+	for i, p := range ue.Profiles {
+		if _, err := w.hnKey.ExecContext(ctx, id, i, p.Scheme, p.KeyId, p.PrivateKey, p.PublicKey); err != nil {
+			return err
+		}
+	}
+	for i, address := range ue.GnbSearchList {
+		if _, err := w.gnb.ExecContext(ctx, id, i, address); err != nil {
+			return err
+		}
+	}
+	for i, tag := range ue.Tags {
+		if _, err := w.tag.ExecContext(ctx, id, i, tag); err != nil {
+			return err
+		}
+	}
+	for target, status := range ue.Provisioning {
+		_, err := w.provisioning.ExecContext(ctx, id, target, status.UpdatedAt.UTC(), nullTime(status.ProvisionedAt),
+			status.Failed, status.Error, nullTime(status.VerifiedAt), status.InSync)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *ueProfileWriter) insert(ctx context.Context, ue *models.UeProfile) error {
+	if _, err := w.insertProfile.ExecContext(ctx, ueProfileValues(ue)...); err != nil {
+		return err
+	}
+	return w.insertChildren(ctx, ue)
+}
+
+// update rewrites a stored UE profile with its nested slices
+func (w *ueProfileWriter) update(ctx context.Context, ue *models.UeProfile) error {
+	values := ueProfileValues(ue)
+	if _, err := w.updateProfile.ExecContext(ctx, append(values[2:], values[0])...); err != nil {
+		return err
+	}
+	for _, stmt := range w.deleteChildren {
+		if _, err := stmt.ExecContext(ctx, ue.ID.Hex()); err != nil {
+			return err
+		}
+	}
+	return w.insertChildren(ctx, ue)
+}
+
+// load reads the UE profiles selected by clause, in its order, with their nested slices. The clause
+// follows the WHERE and carries any ORDER BY and LIMIT.
+func (r *sqlUeProfiles) load(ctx context.Context, q sqlQuerier, clause string, args ...interface{}) ([]models.UeProfile, error) {
+	query := "SELECT " + strings.Join(ueProfileColumns, ", ") + " FROM ue_profiles WHERE " + clause
+	ueProfiles := []models.UeProfile{}
+	err := r.eachRow(ctx, q, query, args, func(rows *sql.Rows) error {
+		ue, err := scanUeProfile(rows)
+		ueProfiles = append(ueProfiles, ue)
+		return err
+	})
+	if err != nil || len(ueProfiles) == 0 {
+		return ueProfiles, err
+	}
+
+	byID := make(map[string]*models.UeProfile, len(ueProfiles))
+	ids := make([]interface{}, len(ueProfiles))
+	for i := range ueProfiles {
+		ids[i] = ueProfiles[i].ID.Hex()
+		byID[ueProfiles[i].ID.Hex()] = &ueProfiles[i]
+	}
+	in := " WHERE ue_profile_id IN (" + placeholders(len(ids)) + ") ORDER BY ue_profile_id"
+
+	var id string
+	err = r.eachRow(ctx, q, "SELECT ue_profile_id, kind, sst, sd FROM ue_profile_slices"+in+", kind, position", ids, func(rows *sql.Rows) error {
+		var kind string
+		var s models.Snssai
+		if err := rows.Scan(&id, &kind, &s.Sst, &s.Sd); err != nil {
+			return err
+		}
+		if ue := byID[id]; kind == "configured" {
+			ue.ConfiguredSlice = append(ue.ConfiguredSlice, s)
+		} else {
+			ue.DefaultSlice = append(ue.DefaultSlice, s)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = r.eachRow(ctx, q, "SELECT ue_profile_id, type, apn, sst, sd FROM ue_profile_sessions"+in+", position", ids, func(rows *sql.Rows) error {
+		var s models.Sessions
+		if err := rows.Scan(&id, &s.Type, &s.Apn, &s.Slice.Sst, &s.Slice.Sd); err != nil {
+			return err
+		}
+		byID[id].Sessions = append(byID[id].Sessions, s)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = r.eachRow(ctx, q, "SELECT ue_profile_id, scheme, key_id, private_key, public_key FROM ue_profile_hn_keys"+in+", position", ids, func(rows *sql.Rows) error {
+		var p models.Profile
+		if err := rows.Scan(&id, &p.Scheme, &p.KeyId, &p.PrivateKey, &p.PublicKey); err != nil {
+			return err
+		}
+		byID[id].Profiles = append(byID[id].Profiles, p)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = r.eachRow(ctx, q, "SELECT ue_profile_id, address FROM ue_profile_gnbs"+in+", position", ids, func(rows *sql.Rows) error {
+		var address string
+		if err := rows.Scan(&id, &address); err != nil {
+			return err
+		}
+		byID[id].GnbSearchList = append(byID[id].GnbSearchList, address)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = r.eachRow(ctx, q, "SELECT ue_profile_id, tag FROM ue_profile_tags"+in+", position", ids, func(rows *sql.Rows) error {
+		var tag string
+		if err := rows.Scan(&id, &tag); err != nil {
+			return err
+		}
+		byID[id].Tags = append(byID[id].Tags, tag)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	err = r.eachRow(ctx, q, "SELECT ue_profile_id, target, updated_at, provisioned_at, failed, error, verified_at, in_sync FROM ue_profile_provisioning"+in, ids, func(rows *sql.Rows) error {
+		var target string
+		var status models.ProvisionStatus
+		var provisionedAt, verifiedAt sql.NullTime
+		if err := rows.Scan(&id, &target, &status.UpdatedAt, &provisionedAt, &status.Failed, &status.Error, &verifiedAt, &status.InSync); err != nil {
+			return err
+		}
+		// Drivers return times in the local zone, MongoDB in UTC
+		status.UpdatedAt = status.UpdatedAt.UTC()
+		if provisionedAt.Valid {
+			t := provisionedAt.Time.UTC()
+			status.ProvisionedAt = &t
+		}
+		if verifiedAt.Valid {
+			t := verifiedAt.Time.UTC()
+			status.VerifiedAt = &t
+		}
+		ue := byID[id]
+		if ue.Provisioning == nil {
+			ue.Provisioning = make(map[string]models.ProvisionStatus)
+		}
+		ue.Provisioning[target] = status
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ueProfiles, nil
+}
+
+// existing returns which of the SUPIs have a UE profile of the user
+func (r *sqlUeProfiles) existing(ctx context.Context, q sqlQuerier, userID primitive.ObjectID, supis []string) (map[string]bool, error) {
+	exists := make(map[string]bool)
+	for start := 0; start < len(supis); start += sqlBatchSize {
+		chunk := supis[start:min(start+sqlBatchSize, len(supis))]
+		args := []interface{}{userID.Hex()}
+		for _, supi := range chunk {
+			args = append(args, supi)
+		}
+		query := "SELECT supi FROM ue_profiles WHERE user_id = ? AND supi IN (" + placeholders(len(chunk)) + ")"
+		err := r.eachRow(ctx, q, query, args, func(rows *sql.Rows) error {
+			var supi string
+			if err := rows.Scan(&supi); err != nil {
+				return err
+			}
+			exists[supi] = true
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return exists, nil
+}
+
+// duplicates returns the SUPIs of ueProfiles that are stored or repeated, in the order of ueProfiles
+func (r *sqlUeProfiles) duplicates(ctx context.Context, q sqlQuerier, ueProfiles []models.UeProfile) ([]string, error) {
+	supisByUser := make(map[primitive.ObjectID][]string)
+	for _, ue := range ueProfiles {
+		supisByUser[ue.UserID] = append(supisByUser[ue.UserID], ue.Supi)
+	}
+	stored := make(map[primitive.ObjectID]map[string]bool)
+	for userID, supis := range supisByUser {
+		exists, err := r.existing(ctx, q, userID, supis)
+		if err != nil {
+			return nil, err
+		}
+		stored[userID] = exists
+	}
+
+	var duplicates []string
+	seen := make(map[primitive.ObjectID]map[string]bool)
+	for _, ue := range ueProfiles {
+		if seen[ue.UserID] == nil {
+			seen[ue.UserID] = make(map[string]bool)
+		}
+		if stored[ue.UserID][ue.Supi] || seen[ue.UserID][ue.Supi] {
+			duplicates = append(duplicates, ue.Supi)
+		}
+		seen[ue.UserID][ue.Supi] = true
+	}
+	return duplicates, nil
+}
+
+func (r *sqlUeProfiles) Insert(ctx context.Context, ueProfiles []models.UeProfile) error {
+	ids := make([]primitive.ObjectID, len(ueProfiles))
+	now := creationTime()
+	err := r.withTx(ctx, func(tx *sql.Tx) error {
+		duplicates, err := r.duplicates(ctx, tx, ueProfiles)
+		if err != nil {
+			return err
+		}
+		if len(duplicates) > 0 {
+			return &DuplicateSupisError{Supis: duplicates}
+		}
+
+		w, err := r.newWriter(ctx, tx)
+		if err != nil {
+			return err
+		}
+		defer w.close()
+		for i := range ueProfiles {
+			ue := ueProfiles[i]
+			ids[i] = primitive.NewObjectID()
+			ue.ID = ids[i]
+			if ue.CreatedAt.IsZero() {
+				ue.CreatedAt = now
+			}
+			if err := w.insert(ctx, &ue); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if isUniqueViolation(err) {
+		// A concurrent insert took a SUPI after the check
+		duplicates, dupErr := r.duplicates(ctx, r.db, ueProfiles)
+		if dupErr != nil || len(duplicates) == 0 {
+			return fmt.Errorf("%w: %v", ErrDuplicateSupi, err)
+		}
+		return &DuplicateSupisError{Supis: duplicates}
+	}
+	var duplicates *DuplicateSupisError
+	if errors.As(err, &duplicates) {
+		return err
+	}
+	if err != nil {
+		return fmt.Errorf("failed to insert UE profiles: %v", err)
+	}
+	for i := range ueProfiles {
+		ueProfiles[i].ID = ids[i]
+		if ueProfiles[i].CreatedAt.IsZero() {
+			ueProfiles[i].CreatedAt = now
+		}
+	}
+	return nil
+}
+
+func (r *sqlUeProfiles) Get(ctx context.Context, userID primitive.ObjectID, supi string) (*models.UeProfile, error) {
+	ueProfiles, err := r.load(ctx, r.db, "user_id = ? AND supi = ?", userID.Hex(), supi)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get UE profile: %v", err)
+	}
+	if len(ueProfiles) == 0 {
+		return nil, nil
+	}
+	return &ueProfiles[0], nil
+}
+
+func (r *sqlUeProfiles) List(ctx context.Context, userID primitive.ObjectID, supis []string) ([]models.UeProfile, error) {
+	ueProfiles := []models.UeProfile{}
+	err := r.Each(ctx, userID, supis, func(ue *models.UeProfile) error {
+		ueProfiles = append(ueProfiles, *ue)
+		return nil
+	})
+	return ueProfiles, err
+}
+
+func (r *sqlUeProfiles) Find(ctx context.Context, userID primitive.ObjectID, query UeProfileQuery) (*UeProfilePage, error) {
+	after, err := query.validate()
+	if err != nil {
+		return nil, err
+	}
+
+	where := []string{"user_id = ?"}
+	args := []interface{}{userID.Hex()}
+	filter := func(condition string, values ...interface{}) {
+		where = append(where, condition)
+		args = append(args, values...)
+	}
+	if query.SupiPrefix != "" {
+		filter("substr(supi, 1, ?) = ?", len(query.SupiPrefix), query.SupiPrefix)
+	}
+	if query.SupiFrom != "" {
+		filter("supi >= ?", query.SupiFrom)
+	}
+	if query.SupiTo != "" {
+		filter("supi <= ?", query.SupiTo)
+	}
+	if query.Mcc != "" {
+		filter("mcc = ?", query.Mcc)
+	}
+	if query.Mnc != "" {
+		filter("mnc = ?", query.Mnc)
+	}
+	if query.Sst != nil || query.Sd != "" {
+		slice := "EXISTS (SELECT 1 FROM ue_profile_slices s WHERE s.ue_profile_id = ue_profiles.id"
+		var values []interface{}
+		if query.Sst != nil {
+			slice += " AND s.sst = ?"
+			values = append(values, *query.Sst)
+		}
+		if query.Sd != "" {
+			slice += " AND s.sd = ?"
+			values = append(values, query.Sd)
+		}
+		filter(slice+")", values...)
+	}
+	if query.Dnn != "" {
+		filter("EXISTS (SELECT 1 FROM ue_profile_sessions s WHERE s.ue_profile_id = ue_profiles.id AND s.apn = ?)", query.Dnn)
+	}
+	if query.ProtectionScheme != nil {
+		filter("protection_scheme = ?", *query.ProtectionScheme)
+	}
+	if query.OpType != "" {
+		filter("op_type = ?", query.OpType)
+	}
+	for _, tag := range query.Tags {
+		filter("EXISTS (SELECT 1 FROM ue_profile_tags t WHERE t.ue_profile_id = ue_profiles.id AND t.tag = ?)", tag)
+	}
+	if query.CreatedAfter != nil {
+		filter("created_at > ?", query.CreatedAfter.UTC())
+	}
+
+	var total int64
+	if !query.SkipTotal {
+		if err := r.queryRow(ctx, r.db, "SELECT COUNT(*) FROM ue_profiles WHERE "+strings.Join(where, " AND "), args...).Scan(&total); err != nil {
+			return nil, fmt.Errorf("failed to count UE profiles: %v", err)
+		}
+	}
+
+	// Ties of the sort key are broken by the SUPI, unique per user
+	op, dir := ">", "ASC"
+	if query.Desc {
+		op, dir = "<", "DESC"
+	}
+	order := " ORDER BY supi " + dir
+	if query.sortKey() == UeProfileSortCreatedAt {
+		order = " ORDER BY created_at " + dir + ", supi " + dir
+		if after != nil {
+			createdAt := after.CreatedAt.UTC()
+			filter("(created_at "+op+" ? OR (created_at = ? AND supi "+op+" ?))", createdAt, createdAt, after.Supi)
+		}
+	} else if after != nil {
+		filter("supi "+op+" ?", after.Supi)
+	}
+
+	clause := strings.Join(where, " AND ") + order + " LIMIT " + strconv.Itoa(query.Limit+1)
+	ueProfiles, err := r.load(ctx, r.db, clause, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find UE profiles: %v", err)
+	}
+	return query.page(ueProfiles, total), nil
+}
+
+// Each reads the profiles in batches of sqlBatchSize, no query is open while fn runs
+func (r *sqlUeProfiles) Each(ctx context.Context, userID primitive.ObjectID, supis []string, fn func(*models.UeProfile) error) error {
+	each := func(ueProfiles []models.UeProfile) error {
+		for i := range ueProfiles {
+			if err := fn(&ueProfiles[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if len(supis) == 0 {
+		// Page through the SUPIs of the user
+		last := ""
+		for {
+			batch, err := r.load(ctx, r.db, "user_id = ? AND supi > ? ORDER BY supi LIMIT "+strconv.Itoa(sqlBatchSize), userID.Hex(), last)
+			if err != nil {
+				return fmt.Errorf("failed to find UE profiles: %v", err)
+			}
+			if err := each(batch); err != nil {
+				return err
+			}
+			if len(batch) < sqlBatchSize {
+				return nil
+			}
+			last = batch[len(batch)-1].Supi
+		}
+	}
+
+	sorted := append([]string(nil), supis...)
+	sort.Strings(sorted)
+	unique := sorted[:0]
+	for i, supi := range sorted {
+		if i == 0 || supi != sorted[i-1] {
+			unique = append(unique, supi)
+		}
+	}
+	for start := 0; start < len(unique); start += sqlBatchSize {
+		chunk := unique[start:min(start+sqlBatchSize, len(unique))]
+		args := []interface{}{userID.Hex()}
+		for _, supi := range chunk {
+			args = append(args, supi)
+		}
+		batch, err := r.load(ctx, r.db, "user_id = ? AND supi IN ("+placeholders(len(chunk))+") ORDER BY supi", args...)
+		if err != nil {
+			return fmt.Errorf("failed to find UE profiles: %v", err)
+		}
+		if err := each(batch); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *sqlUeProfiles) Existing(ctx context.Context, userID primitive.ObjectID, supis []string) (map[string]bool, error) {
+	exists, err := r.existing(ctx, r.db, userID, supis)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check existing SUPIs: %v", err)
+	}
+	return exists, nil
+}
+
+// Update applies the dotted-path fields to the decoded profile, like MongoDB's $set, and
+// writes the whole profile back
+func (r *sqlUeProfiles) Update(ctx context.Context, userID primitive.ObjectID, updates ...UeProfileUpdate) (int, error) {
+	matched := 0
+	err := r.withTx(ctx, func(tx *sql.Tx) error {
+		w, err := r.newWriter(ctx, tx)
+		if err != nil {
+			return err
+		}
+		defer w.close()
+
+		for _, u := range updates {
+			stored, err := r.load(ctx, tx, "user_id = ? AND supi = ?"+r.dialect.lockRows(), userID.Hex(), u.Supi)
+			if err != nil {
+				return err
+			}
+			if len(stored) == 0 {
+				continue
+			}
+			matched++
+
+			var doc bson.M
+			if err := copyOf(&doc, &stored[0]); err != nil {
+				return err
+			}
+			for path, value := range u.Fields {
+				if err := setField(doc, path, value); err != nil {
+					return err
+				}
+			}
+			var updated models.UeProfile
+			if err := copyOf(&updated, doc); err != nil {
+				return err
+			}
+			updated.ID, updated.UserID = stored[0].ID, userID
+			if err := w.update(ctx, &updated); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if isUniqueViolation(err) {
+		return 0, fmt.Errorf("%w: %v", ErrDuplicateSupi, err)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to update UE profiles: %v", err)
+	}
+	return matched, nil
+}
+
+func (r *sqlUeProfiles) SetSqn(ctx context.Context, userID primitive.ObjectID, supi string, old *string, sqn string) (bool, error) {
+	query := "UPDATE ue_profiles SET sqn = ? WHERE user_id = ? AND supi = ?"
+	args := []interface{}{sqn, userID.Hex(), supi}
+	if old != nil {
+		query += " AND sqn = ?"
+		args = append(args, *old)
+	}
+	updated, err := affected(r.exec(ctx, r.db, query, args...))
+	if err != nil {
+		return false, fmt.Errorf("failed to store SQN: %v", err)
+	}
+	return updated, nil
+}
+
+func (r *sqlUeProfiles) Delete(ctx context.Context, userID primitive.ObjectID, supi string) (bool, error) {
+	deleted, err := affected(r.exec(ctx, r.db, "DELETE FROM ue_profiles WHERE user_id = ? AND supi = ?", userID.Hex(), supi))
+	if err != nil {
+		return false, fmt.Errorf("failed to delete UE profile: %v", err)
+	}
+	return deleted, nil
+}
+
+type sqlUsers struct {
+	*sqlDB
+}
+
+func (r *sqlUsers) Create(ctx context.Context, user *models.User) error {
+	id := primitive.NewObjectID()
+	_, err := r.exec(ctx, r.db, "INSERT INTO users (id, username, password) VALUES (?, ?, ?)", id.Hex(), user.Username, user.Password)
+	if isUniqueViolation(err) {
+		return fmt.Errorf("%w: username %s", ErrDuplicate, user.Username)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to insert user: %v", err)
+	}
+	user.ID = id
+	return nil
+}
+
+func (r *sqlUsers) GetByUsername(ctx context.Context, username string) (*models.User, error) {
+	var user models.User
+	var id string
+	err := r.queryRow(ctx, r.db, "SELECT id, username, password FROM users WHERE username = ?", username).Scan(&id, &user.Username, &user.Password)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to find user: %v", err)
+	}
+	if user.ID, err = primitive.ObjectIDFromHex(id); err != nil {
+		return nil, fmt.Errorf("failed to find user: %v", err)
+	}
+	return &user, nil
+}
+
+type sqlTokens struct {
+	*sqlDB
+}
+
+// Add also drops the expired tokens, as the TTL index does in MongoDB
+func (r *sqlTokens) Add(ctx context.Context, token models.BlacklistedToken) error {
+	if _, err := r.exec(ctx, r.db, "DELETE FROM revoked_tokens WHERE expires_at <= ?", time.Now().UTC()); err != nil {
+		return fmt.Errorf("failed to drop expired tokens: %v", err)
+	}
+	_, err := r.exec(ctx, r.db, "INSERT INTO revoked_tokens (token, expires_at) VALUES (?, ?)", token.Token, token.ExpiresAt.UTC())
+	if isUniqueViolation(err) {
+		return fmt.Errorf("%w: token", ErrDuplicate)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to revoke token: %v", err)
+	}
+	return nil
+}
+
+func (r *sqlTokens) Contains(ctx context.Context, token string, now time.Time) (bool, error) {
+	var found int
+	err := r.queryRow(ctx, r.db, "SELECT 1 FROM revoked_tokens WHERE token = ? AND expires_at > ?", token, now.UTC()).Scan(&found)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to check revoked token: %v", err)
+	}
+	return true, nil
+}
+
+type sqlOperators struct {
+	*sqlDB
+}
+
+func (r *sqlOperators) Create(ctx context.Context, operator *models.Operator) error {
+	definition, err := json.Marshal(operator)
+	if err != nil {
+		return fmt.Errorf("failed to insert operator: %v", err)
+	}
+	_, err = r.exec(ctx, r.db, "INSERT INTO operators (id, user_id, mcc, mnc, definition) VALUES (?, ?, ?, ?, ?)",
+		operator.ID.Hex(), operator.UserID.Hex(), operator.PlmnId.Mcc, operator.PlmnId.Mnc, string(definition))
+	if isUniqueViolation(err) {
+		return fmt.Errorf("%w: operator %s", ErrDuplicate, operator.ID.Hex())
+	}
+	if err != nil {
+		return fmt.Errorf("failed to insert operator: %v", err)
+	}
+	return nil
+}
+
+func (r *sqlOperators) find(ctx context.Context, where string, args ...interface{}) ([]models.Operator, error) {
+	operators := []models.Operator{}
+	err := r.eachRow(ctx, r.db, "SELECT definition FROM operators WHERE "+where+" ORDER BY id", args, func(rows *sql.Rows) error {
+		var definition string
+		if err := rows.Scan(&definition); err != nil {
+			return err
+		}
+		var operator models.Operator
+		if err := json.Unmarshal([]byte(definition), &operator); err != nil {
+			return err
+		}
+		operators = append(operators, operator)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to find operators: %v", err)
+	}
+	return operators, nil
+}
+
+func (r *sqlOperators) List(ctx context.Context, userID primitive.ObjectID) ([]models.Operator, error) {
+	return r.find(ctx, "user_id = ?", userID.Hex())
+}
+
+func (r *sqlOperators) ListByPlmn(ctx context.Context, userID primitive.ObjectID, plmnId models.PlmnId) ([]models.Operator, error) {
+	return r.find(ctx, "user_id = ? AND mcc = ? AND mnc = ?", userID.Hex(), plmnId.Mcc, plmnId.Mnc)
+}
+
+func (r *sqlOperators) Get(ctx context.Context, userID, operatorID primitive.ObjectID) (*models.Operator, error) {
+	operators, err := r.find(ctx, "id = ? AND user_id = ?", operatorID.Hex(), userID.Hex())
+	if err != nil || len(operators) == 0 {
+		return nil, err
+	}
+	return &operators[0], nil
+}
+
+func (r *sqlOperators) Replace(ctx context.Context, operator *models.Operator) (bool, error) {
+	definition, err := json.Marshal(operator)
+	if err != nil {
+		return false, fmt.Errorf("failed to update operator: %v", err)
+	}
+	replaced, err := affected(r.exec(ctx, r.db, "UPDATE operators SET mcc = ?, mnc = ?, definition = ? WHERE id = ? AND user_id = ?",
+		operator.PlmnId.Mcc, operator.PlmnId.Mnc, string(definition), operator.ID.Hex(), operator.UserID.Hex()))
+	if err != nil {
+		return false, fmt.Errorf("failed to update operator: %v", err)
+	}
+	return replaced, nil
+}
+
+func (r *sqlOperators) Delete(ctx context.Context, userID, operatorID primitive.ObjectID) (bool, error) {
+	deleted, err := affected(r.exec(ctx, r.db, "DELETE FROM operators WHERE id = ? AND user_id = ?", operatorID.Hex(), userID.Hex()))
+	if err != nil {
+		return false, fmt.Errorf("failed to delete operator: %v", err)
+	}
+	return deleted, nil
+}
+
+type sqlMsins struct {
+	*sqlDB
+}
+
+// ensure creates the high-water mark of a PLMN at 0 unless it exists
+func (r *sqlMsins) ensure(ctx context.Context, userID primitive.ObjectID, plmnId models.PlmnId) error {
+	_, err := r.exec(ctx, r.db, "INSERT INTO msin_marks (user_id, mcc, mnc, mark) VALUES (?, ?, ?, 0) ON CONFLICT (user_id, mcc, mnc) DO NOTHING",
+		userID.Hex(), plmnId.Mcc, plmnId.Mnc)
+	return err
+}
+
+func (r *sqlMsins) Advance(ctx context.Context, userID primitive.ObjectID, plmnId models.PlmnId, start, n, limit uint64) (uint64, bool, error) {
+	if err := r.ensure(ctx, userID, plmnId); err != nil {
+		return 0, false, fmt.Errorf("failed to allocate MSINs: %v", err)
+	}
+	// A single conditional update, so concurrent allocations serialize on the row
+	var mark int64
+	err := r.queryRow(ctx, r.db, `UPDATE msin_marks SET mark = (CASE WHEN mark > ? THEN mark ELSE ? END) + ?
+		WHERE user_id = ? AND mcc = ? AND mnc = ? AND (CASE WHEN mark > ? THEN mark ELSE ? END) + ? <= ?
+		RETURNING mark`,
+		int64(start), int64(start), int64(n), userID.Hex(), plmnId.Mcc, plmnId.Mnc, int64(start), int64(start), int64(n), int64(limit),
+	).Scan(&mark)
+	if err == sql.ErrNoRows {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to allocate MSINs: %v", err)
+	}
+	return uint64(mark), true, nil
+}
+
+type sqlJobs struct {
+	*sqlDB
+}
+
+func (r *sqlJobs) Create(ctx context.Context, job *models.Job) error {
+	id := primitive.NewObjectID()
+	stored := *job
+	stored.ID = id
+	state, err := json.Marshal(&stored)
+	if err != nil {
+		return fmt.Errorf("failed to insert job: %v", err)
+	}
+	_, err = r.exec(ctx, r.db, "INSERT INTO jobs (id, user_id, status, created_at, state) VALUES (?, ?, ?, ?, ?)",
+		id.Hex(), job.UserID.Hex(), job.Status, job.CreatedAt.UTC(), string(state))
+	if err != nil {
+		return fmt.Errorf("failed to insert job: %v", err)
+	}
+	job.ID = id
+	return nil
+}
+
+func (r *sqlJobs) find(ctx context.Context, clause string, args ...interface{}) ([]models.Job, error) {
+	jobs := []models.Job{}
+	err := r.eachRow(ctx, r.db, "SELECT state FROM jobs WHERE "+clause, args, func(rows *sql.Rows) error {
+		var state string
+		if err := rows.Scan(&state); err != nil {
+			return err
+		}
+		var job models.Job
+		if err := json.Unmarshal([]byte(state), &job); err != nil {
+			return err
+		}
+		jobs = append(jobs, job)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get jobs: %v", err)
+	}
+	return jobs, nil
+}
+
+func (r *sqlJobs) Get(ctx context.Context, userID, jobID primitive.ObjectID) (*models.Job, error) {
+	jobs, err := r.find(ctx, "id = ? AND user_id = ?", jobID.Hex(), userID.Hex())
+	if err != nil || len(jobs) == 0 {
+		return nil, err
+	}
+	return &jobs[0], nil
+}
+
+func (r *sqlJobs) List(ctx context.Context, userID primitive.ObjectID) ([]models.Job, error) {
+	return r.find(ctx, "user_id = ? ORDER BY created_at DESC, id DESC", userID.Hex())
+}
+
+func (r *sqlJobs) Replace(ctx context.Context, job *models.Job) (bool, error) {
+	state, err := json.Marshal(job)
+	if err != nil {
+		return false, fmt.Errorf("failed to update job: %v", err)
+	}
+	replaced, err := affected(r.exec(ctx, r.db, "UPDATE jobs SET status = ?, state = ? WHERE id = ? AND user_id = ?",
+		job.Status, string(state), job.ID.Hex(), job.UserID.Hex()))
+	if err != nil {
+		return false, fmt.Errorf("failed to update job: %v", err)
+	}
+	return replaced, nil
+}
+
+func (r *sqlJobs) Unfinished(ctx context.Context) ([]models.Job, error) {
+	return r.find(ctx, "status IN (?, ?) ORDER BY created_at, id", models.JobQueued, models.JobRunning)
+}
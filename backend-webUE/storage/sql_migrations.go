@@ -0,0 +1,212 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// sqlMigrations are the schema versions of the SQL backends, applied in order. A released migration
+// must never change, schema changes go into a new one. {{timestamp}} is replaced by the timestamp
+// type of the dialect.
+var sqlMigrations = [][]string{
+	// 1: users, revoked tokens, operators, MSIN high-water marks and UE profiles
+	{
+		`CREATE TABLE users (
+			id TEXT PRIMARY KEY,
+			username TEXT NOT NULL UNIQUE,
+			password TEXT NOT NULL
+		)`,
+		`CREATE TABLE revoked_tokens (
+			token TEXT PRIMARY KEY,
+			expires_at {{timestamp}} NOT NULL
+		)`,
+		`CREATE INDEX revoked_tokens_expires_at ON revoked_tokens (expires_at)`,
+		// The operator definition is kept as JSON, it is only ever read whole
+		`CREATE TABLE operators (
+			id TEXT PRIMARY KEY,
+			user_id TEXT NOT NULL,
+			mcc TEXT NOT NULL,
+			mnc TEXT NOT NULL,
+			definition TEXT NOT NULL
+		)`,
+		`CREATE INDEX operators_user_id_plmn ON operators (user_id, mcc, mnc)`,
+		`CREATE TABLE msin_marks (
+			user_id TEXT NOT NULL,
+			mcc TEXT NOT NULL,
+			mnc TEXT NOT NULL,
+			mark BIGINT NOT NULL DEFAULT 0,
+			PRIMARY KEY (user_id, mcc, mnc)
+		)`,
+		`CREATE TABLE ue_profiles (
+			id TEXT PRIMARY KEY,
+			user_id TEXT NOT NULL,
+			operator_id TEXT NOT NULL DEFAULT '',
+			supi TEXT NOT NULL,
+			suci TEXT NOT NULL DEFAULT '',
+			mcc TEXT NOT NULL DEFAULT '',
+			mnc TEXT NOT NULL DEFAULT '',
+			routing_indicator TEXT NOT NULL DEFAULT '',
+			home_network_private_key TEXT NOT NULL DEFAULT '',
+			home_network_public_key TEXT NOT NULL DEFAULT '',
+			home_network_public_key_id INTEGER NOT NULL DEFAULT 0,
+			protection_scheme INTEGER NOT NULL DEFAULT 0,
+			subscription_key TEXT NOT NULL DEFAULT '',
+			op TEXT NOT NULL DEFAULT '',
+			op_type TEXT NOT NULL DEFAULT '',
+			opc TEXT NOT NULL DEFAULT '',
+			amf TEXT NOT NULL DEFAULT '',
+			sqn TEXT NOT NULL DEFAULT '',
+			authentication_method TEXT NOT NULL DEFAULT '',
+			imei TEXT NOT NULL DEFAULT '',
+			imei_sv TEXT NOT NULL DEFAULT '',
+			integrity_ia1 BOOLEAN NOT NULL DEFAULT FALSE,
+			integrity_ia2 BOOLEAN NOT NULL DEFAULT FALSE,
+			integrity_ia3 BOOLEAN NOT NULL DEFAULT FALSE,
+			ciphering_ea1 BOOLEAN NOT NULL DEFAULT FALSE,
+			ciphering_ea2 BOOLEAN NOT NULL DEFAULT FALSE,
+			ciphering_ea3 BOOLEAN NOT NULL DEFAULT FALSE,
+			uac_aic_mps BOOLEAN NOT NULL DEFAULT FALSE,
+			uac_aic_mcs BOOLEAN NOT NULL DEFAULT FALSE,
+			uac_acc_normal_class INTEGER NOT NULL DEFAULT 0,
+			uac_acc_class11 BOOLEAN NOT NULL DEFAULT FALSE,
+			uac_acc_class12 BOOLEAN NOT NULL DEFAULT FALSE,
+			uac_acc_class13 BOOLEAN NOT NULL DEFAULT FALSE,
+			uac_acc_class14 BOOLEAN NOT NULL DEFAULT FALSE,
+			uac_acc_class15 BOOLEAN NOT NULL DEFAULT FALSE,
+			integrity_max_rate_uplink TEXT NOT NULL DEFAULT '',
+			integrity_max_rate_downlink TEXT NOT NULL DEFAULT '',
+			UNIQUE (user_id, supi)
+		)`,
+		// Configured and default S-NSSAIs, kind is configured or default
+		`CREATE TABLE ue_profile_slices (
+			ue_profile_id TEXT NOT NULL REFERENCES ue_profiles (id) ON DELETE CASCADE,
+			kind TEXT NOT NULL,
+			position INTEGER NOT NULL,
+			sst INTEGER NOT NULL,
+			sd TEXT NOT NULL,
+			PRIMARY KEY (ue_profile_id, kind, position)
+		)`,
+		`CREATE TABLE ue_profile_sessions (
+			ue_profile_id TEXT NOT NULL REFERENCES ue_profiles (id) ON DELETE CASCADE,
+			position INTEGER NOT NULL,
+			type TEXT NOT NULL,
+			apn TEXT NOT NULL,
+			sst INTEGER NOT NULL,
+			sd TEXT NOT NULL,
+			PRIMARY KEY (ue_profile_id, position)
+		)`,
+		// Home network key profiles for SUCI concealment
+		`CREATE TABLE ue_profile_hn_keys (
+			ue_profile_id TEXT NOT NULL REFERENCES ue_profiles (id) ON DELETE CASCADE,
+			position INTEGER NOT NULL,
+			scheme INTEGER NOT NULL,
+			key_id INTEGER NOT NULL,
+			private_key TEXT NOT NULL,
+			public_key TEXT NOT NULL,
+			PRIMARY KEY (ue_profile_id, position)
+		)`,
+		`CREATE TABLE ue_profile_gnbs (
+			ue_profile_id TEXT NOT NULL REFERENCES ue_profiles (id) ON DELETE CASCADE,
+			position INTEGER NOT NULL,
+			address TEXT NOT NULL,
+			PRIMARY KEY (ue_profile_id, position)
+		)`,
+		// Sync status per core network target
+		`CREATE TABLE ue_profile_provisioning (
+			ue_profile_id TEXT NOT NULL REFERENCES ue_profiles (id) ON DELETE CASCADE,
+			target TEXT NOT NULL,
+			updated_at {{timestamp}} NOT NULL,
+			provisioned_at {{timestamp}},
+			failed BOOLEAN NOT NULL,
+			error TEXT NOT NULL,
+			verified_at {{timestamp}},
+			in_sync BOOLEAN NOT NULL,
+			PRIMARY KEY (ue_profile_id, target)
+		)`,
+	},
+	// 2: creation time and tags of UE profiles
+	{
+		`ALTER TABLE ue_profiles ADD COLUMN created_at {{timestamp}} NOT NULL DEFAULT '1970-01-01 00:00:00+00:00'`,
+		`CREATE INDEX ue_profiles_user_id_created_at ON ue_profiles (user_id, created_at, supi)`,
+		`CREATE TABLE ue_profile_tags (
+			ue_profile_id TEXT NOT NULL REFERENCES ue_profiles (id) ON DELETE CASCADE,
+			position INTEGER NOT NULL,
+			tag TEXT NOT NULL,
+			PRIMARY KEY (ue_profile_id, position)
+		)`,
+		`CREATE INDEX ue_profile_tags_tag ON ue_profile_tags (tag, ue_profile_id)`,
+	},
+	// 3: generation jobs, the state is kept as JSON like operator definitions
+	{
+		`CREATE TABLE jobs (
+			id TEXT PRIMARY KEY,
+			user_id TEXT NOT NULL,
+			status TEXT NOT NULL,
+			created_at {{timestamp}} NOT NULL,
+			state TEXT NOT NULL
+		)`,
+		`CREATE INDEX jobs_user_id_created_at ON jobs (user_id, created_at)`,
+		`CREATE INDEX jobs_status ON jobs (status)`,
+	},
+}
+
+// migrationLockID keys the PostgreSQL advisory lock that keeps instances from migrating concurrently
+const migrationLockID = 5_140_421
+
+// MigrateSQL brings the schema of a SQL backend to the latest version, it is a no-op on an
+// up-to-date database
+func MigrateSQL(ctx context.Context, db *sql.DB, dialect SQLDialect) error {
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to migrate database: %v", err)
+	}
+	defer conn.Close()
+
+	if dialect == DialectPostgres {
+		if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", migrationLockID); err != nil {
+			return fmt.Errorf("failed to lock database for migration: %v", err)
+		}
+		defer conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1)", migrationLockID)
+	}
+
+	types := strings.NewReplacer("{{timestamp}}", dialect.timestampType())
+	_, err = conn.ExecContext(ctx, types.Replace(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		applied_at {{timestamp}} NOT NULL
+	)`))
+	if err != nil {
+		return fmt.Errorf("failed to create schema_migrations: %v", err)
+	}
+	var version int
+	if err := conn.QueryRowContext(ctx, "SELECT COALESCE(MAX(version), 0) FROM schema_migrations").Scan(&version); err != nil {
+		return fmt.Errorf("failed to read schema version: %v", err)
+	}
+	if version > len(sqlMigrations) {
+		return fmt.Errorf("database schema version %d is newer than this build (%d)", version, len(sqlMigrations))
+	}
+
+	for i := version; i < len(sqlMigrations); i++ {
+		tx, err := conn.BeginTx(ctx, nil)
+		if err != nil {
+			return fmt.Errorf("failed to apply migration %d: %v", i+1, err)
+		}
+		for _, stmt := range sqlMigrations[i] {
+			if _, err := tx.ExecContext(ctx, types.Replace(stmt)); err != nil {
+				tx.Rollback()
+				return fmt.Errorf("failed to apply migration %d: %v", i+1, err)
+			}
+		}
+		_, err = tx.ExecContext(ctx, dialect.rebind("INSERT INTO schema_migrations (version, applied_at) VALUES (?, ?)"), i+1, time.Now().UTC())
+		if err != nil {
+			tx.Rollback()
+			return fmt.Errorf("failed to apply migration %d: %v", i+1, err)
+		}
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("failed to apply migration %d: %v", i+1, err)
+		}
+	}
+	return nil
+}
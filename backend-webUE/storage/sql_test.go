@@ -0,0 +1,54 @@
+package storage
+
+import (
+	"backend-webUE/config"
+	"backend-webUE/database"
+	"context"
+	"path/filepath"
+	"testing"
+)
+
+func TestMigrateSQL(t *testing.T) {
+	ctx := context.Background()
+	storageConfig := config.StorageConfig{
+		Backend:    config.StorageSqlite,
+		SqlitePath: filepath.Join(t.TempDir(), "webue.db"),
+	}
+	db, err := database.ConnectSQL(storageConfig)
+	if err != nil {
+		t.Fatalf("ConnectSQL: %v", err)
+	}
+	defer db.Close()
+
+	// Migrating an up-to-date database changes nothing
+	for i := 0; i < 2; i++ {
+		if err := MigrateSQL(ctx, db, DialectSqlite); err != nil {
+			t.Fatalf("MigrateSQL run %d: %v", i+1, err)
+		}
+	}
+	var version int
+	if err := db.QueryRowContext(ctx, "SELECT MAX(version) FROM schema_migrations").Scan(&version); err != nil {
+		t.Fatalf("read schema version: %v", err)
+	}
+	if version != len(sqlMigrations) {
+		t.Errorf("schema version = %d, want %d", version, len(sqlMigrations))
+	}
+
+	// A database of a newer build is refused
+	if _, err := db.ExecContext(ctx, "INSERT INTO schema_migrations (version, applied_at) VALUES (?, CURRENT_TIMESTAMP)", len(sqlMigrations)+1); err != nil {
+		t.Fatalf("insert schema version: %v", err)
+	}
+	if err := MigrateSQL(ctx, db, DialectSqlite); err == nil {
+		t.Errorf("MigrateSQL of a newer schema succeeded")
+	}
+}
+
+func TestRebind(t *testing.T) {
+	query := "UPDATE t SET a = ? WHERE b = ? AND c IN (?, ?)"
+	if got := DialectSqlite.rebind(query); got != query {
+		t.Errorf("SQLite rebind = %q", got)
+	}
+	if got, want := DialectPostgres.rebind(query), "UPDATE t SET a = $1 WHERE b = $2 AND c IN ($3, $4)"; got != want {
+		t.Errorf("PostgreSQL rebind = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,135 @@
+// Package storage defines the repositories the services keep their state in, with MongoDB,
+// SQLite, PostgreSQL and in-memory implementations
+package storage
+
+import (
+	"backend-webUE/models"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+var (
+	// ErrDuplicate reports a unique key that is already taken
+	ErrDuplicate = errors.New("duplicate key")
+	// ErrDuplicateSupi reports UE profiles whose SUPI the user already has
+	ErrDuplicateSupi = errors.New("duplicate SUPI")
+)
+
+// DuplicateSupisError lists the SUPIs of new UE profiles that the user already has
+type DuplicateSupisError struct {
+	Supis []string
+}
+
+func (e *DuplicateSupisError) Error() string {
+	return fmt.Sprintf("UE profiles already exist: %s", strings.Join(e.Supis, ", "))
+}
+
+func (e *DuplicateSupisError) Unwrap() error {
+	return ErrDuplicateSupi
+}
+
+// creationTime is the CreatedAt of profiles inserted now, at the millisecond precision of MongoDB
+func creationTime() time.Time {
+	return time.Now().UTC().Truncate(time.Millisecond)
+}
+
+// UeProfileUpdate sets fields of the UE profile with the SUPI. Fields are keyed by their JSON names,
+// the fields of nested documents by their dotted path, e.g. "provisioning.udr.inSync".
+type UeProfileUpdate struct {
+	Supi   string
+	Fields map[string]interface{}
+}
+
+// UeProfileRepository stores the UE profiles of every user. A SUPI is unique per user, not per operator:
+// it is the key of a UE profile in the API, so it cannot repeat across the user's operators.
+type UeProfileRepository interface {
+	// Insert stores new UE profiles, all or none, and sets their ID and a missing CreatedAt.
+	// A *DuplicateSupisError lists the SUPIs the user already has.
+	Insert(ctx context.Context, ueProfiles []models.UeProfile) error
+	// Get returns a UE profile of the user, nil when there is none
+	Get(ctx context.Context, userID primitive.ObjectID, supi string) (*models.UeProfile, error)
+	// List returns the UE profiles of the user ordered by SUPI, restricted to supis when not empty
+	List(ctx context.Context, userID primitive.ObjectID, supis []string) ([]models.UeProfile, error)
+	// Find returns a page of the UE profiles of the user, errors of the query wrap ErrInvalidQuery
+	Find(ctx context.Context, userID primitive.ObjectID, query UeProfileQuery) (*UeProfilePage, error)
+	// Each calls fn with the UE profiles of List one at a time, it stops at the first error of fn
+	Each(ctx context.Context, userID primitive.ObjectID, supis []string, fn func(*models.UeProfile) error) error
+	// Existing returns which of the SUPIs have a UE profile of the user
+	Existing(ctx context.Context, userID primitive.ObjectID, supis []string) (map[string]bool, error)
+	// Update applies the updates to UE profiles of the user and returns how many profiles matched
+	Update(ctx context.Context, userID primitive.ObjectID, updates ...UeProfileUpdate) (int, error)
+	// SetSqn stores a new SQN if the stored one still is old, or unconditionally when old is nil.
+	// A profile without SQN matches an empty old.
+	SetSqn(ctx context.Context, userID primitive.ObjectID, supi string, old *string, sqn string) (bool, error)
+	// Delete removes a UE profile of the user and reports whether it existed
+	Delete(ctx context.Context, userID primitive.ObjectID, supi string) (bool, error)
+}
+
+// UserRepository stores the users, a username is unique
+type UserRepository interface {
+	// Create stores a new user and assigns its ID, ErrDuplicate when the username is taken
+	Create(ctx context.Context, user *models.User) error
+	// GetByUsername returns the user with the username, nil when there is none
+	GetByUsername(ctx context.Context, username string) (*models.User, error)
+}
+
+// TokenRepository stores the revoked JWTs until they expire
+type TokenRepository interface {
+	// Add revokes a token, ErrDuplicate when it already is
+	Add(ctx context.Context, token models.BlacklistedToken) error
+	// Contains reports whether a token is revoked and not yet expired at now
+	Contains(ctx context.Context, token string, now time.Time) (bool, error)
+}
+
+// OperatorRepository stores the operator definitions of every user
+type OperatorRepository interface {
+	// Create stores a new operator, its ID is assigned by the caller
+	Create(ctx context.Context, operator *models.Operator) error
+	// List returns the operators of the user
+	List(ctx context.Context, userID primitive.ObjectID) ([]models.Operator, error)
+	// ListByPlmn returns the operators of the user serving the PLMN
+	ListByPlmn(ctx context.Context, userID primitive.ObjectID, plmnId models.PlmnId) ([]models.Operator, error)
+	// Get returns an operator of the user, nil when there is none
+	Get(ctx context.Context, userID, operatorID primitive.ObjectID) (*models.Operator, error)
+	// Replace stores a new definition of the operator with the ID and user of operator, it reports whether it existed
+	Replace(ctx context.Context, operator *models.Operator) (bool, error)
+	// Delete removes an operator of the user and reports whether it existed
+	Delete(ctx context.Context, userID, operatorID primitive.ObjectID) (bool, error)
+}
+
+// MsinRepository keeps the high-water mark of the MSINs allocated sequentially per user and PLMN: every
+// MSIN below it was handed out. The mark starts at 0 and is only moved by atomic updates.
+type MsinRepository interface {
+	// Advance moves the mark to max(mark, start) + n unless that passes limit and returns the new mark,
+	// false when it would pass limit
+	Advance(ctx context.Context, userID primitive.ObjectID, plmnId models.PlmnId, start, n, limit uint64) (uint64, bool, error)
+}
+
+// JobRepository stores the generation jobs of every user
+type JobRepository interface {
+	// Create stores a new job and assigns its ID
+	Create(ctx context.Context, job *models.Job) error
+	// Get returns a job of the user, nil when there is none
+	Get(ctx context.Context, userID, jobID primitive.ObjectID) (*models.Job, error)
+	// List returns the jobs of the user, newest first
+	List(ctx context.Context, userID primitive.ObjectID) ([]models.Job, error)
+	// Replace stores the state of a job with the ID and user of job, it reports whether it existed
+	Replace(ctx context.Context, job *models.Job) (bool, error)
+	// Unfinished returns the queued and running jobs of every user, oldest first
+	Unfinished(ctx context.Context) ([]models.Job, error)
+}
+
+// Store groups the repositories of one storage backend
+type Store struct {
+	UeProfiles UeProfileRepository
+	Users      UserRepository
+	Tokens     TokenRepository
+	Operators  OperatorRepository
+	Msins      MsinRepository
+	Jobs       JobRepository
+}
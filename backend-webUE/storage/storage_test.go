@@ -0,0 +1,647 @@
+package storage
+
+import (
+	"backend-webUE/config"
+	"backend-webUE/database"
+	"backend-webUE/models"
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// forEachStore runs a test against every storage backend that works offline: the in-memory store and
+// the SQL store on SQLite, which shares its queries with PostgreSQL
+func forEachStore(t *testing.T, test func(t *testing.T, store *Store)) {
+	t.Run("memory", func(t *testing.T) {
+		test(t, NewMemoryStore())
+	})
+	t.Run("sqlite", func(t *testing.T) {
+		db, err := database.ConnectSQL(config.StorageConfig{
+			Backend:    config.StorageSqlite,
+			SqlitePath: filepath.Join(t.TempDir(), "webue.db"),
+		})
+		if err != nil {
+			t.Fatalf("ConnectSQL: %v", err)
+		}
+		defer db.Close()
+		if err := MigrateSQL(context.Background(), db, DialectSqlite); err != nil {
+			t.Fatalf("MigrateSQL: %v", err)
+		}
+		test(t, NewSQLStore(db, DialectSqlite))
+	})
+}
+
+func testUeProfiles(userID primitive.ObjectID, supis ...string) []models.UeProfile {
+	ueProfiles := make([]models.UeProfile, len(supis))
+	for i, supi := range supis {
+		ueProfiles[i] = models.UeProfile{
+			UserID:       userID,
+			Supi:         supi,
+			PlmnId:       models.PlmnId{Mcc: "208", Mnc: "93"},
+			Sqn:          "000000000020",
+			DefaultSlice: []models.Snssai{{Sst: 1, Sd: "010203"}},
+		}
+	}
+	return ueProfiles
+}
+
+func supisOf(ueProfiles []models.UeProfile) []string {
+	supis := make([]string, len(ueProfiles))
+	for i := range ueProfiles {
+		supis[i] = ueProfiles[i].Supi
+	}
+	return supis
+}
+
+func TestUeProfilesInsert(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store *Store) {
+		r := store.UeProfiles
+		ctx := context.Background()
+		alice, bob := primitive.NewObjectID(), primitive.NewObjectID()
+
+		if err := r.Insert(ctx, testUeProfiles(alice, "imsi-208930000000002", "imsi-208930000000001")); err != nil {
+			t.Fatalf("Insert: %v", err)
+		}
+		// Another user may have the same SUPI
+		if err := r.Insert(ctx, testUeProfiles(bob, "imsi-208930000000001")); err != nil {
+			t.Fatalf("Insert for another user: %v", err)
+		}
+
+		// One duplicate rejects the whole batch
+		err := r.Insert(ctx, testUeProfiles(alice, "imsi-208930000000003", "imsi-208930000000001"))
+		var duplicates *DuplicateSupisError
+		if !errors.As(err, &duplicates) || !errors.Is(err, ErrDuplicateSupi) {
+			t.Fatalf("Insert with duplicate = %v, want *DuplicateSupisError", err)
+		}
+		if !reflect.DeepEqual(duplicates.Supis, []string{"imsi-208930000000001"}) {
+			t.Errorf("duplicate SUPIs = %v", duplicates.Supis)
+		}
+		if ue, _ := r.Get(ctx, alice, "imsi-208930000000003"); ue != nil {
+			t.Errorf("profile of a rejected batch was stored")
+		}
+		err = r.Insert(ctx, testUeProfiles(alice, "imsi-208930000000004", "imsi-208930000000004"))
+		if !errors.As(err, &duplicates) {
+			t.Errorf("Insert with duplicate in batch = %v, want *DuplicateSupisError", err)
+		}
+
+		// A SUPI is unique across the operators of a user, which makes it unique per operator as well
+		other := testUeProfiles(alice, "imsi-208930000000001")
+		other[0].OperatorID = primitive.NewObjectID()
+		if err := r.Insert(ctx, other); !errors.As(err, &duplicates) {
+			t.Errorf("Insert for another operator of the user = %v, want *DuplicateSupisError", err)
+		}
+
+		list, err := r.List(ctx, alice, nil)
+		if err != nil {
+			t.Fatalf("List: %v", err)
+		}
+		if got := supisOf(list); !reflect.DeepEqual(got, []string{"imsi-208930000000001", "imsi-208930000000002"}) {
+			t.Errorf("List = %v, want both profiles ordered by SUPI", got)
+		}
+		if list[0].ID.IsZero() {
+			t.Errorf("stored profile has no ID")
+		}
+	})
+}
+
+func TestUeProfilesCopies(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store *Store) {
+		r := store.UeProfiles
+		ctx := context.Background()
+		userID := primitive.NewObjectID()
+
+		ueProfiles := testUeProfiles(userID, "imsi-208930000000001")
+		if err := r.Insert(ctx, ueProfiles); err != nil {
+			t.Fatalf("Insert: %v", err)
+		}
+		ueProfiles[0].DefaultSlice[0].Sst = 9
+
+		ue, err := r.Get(ctx, userID, "imsi-208930000000001")
+		if err != nil || ue == nil {
+			t.Fatalf("Get = %v, %v", ue, err)
+		}
+		ue.DefaultSlice[0].Sst = 8
+		again, _ := r.Get(ctx, userID, "imsi-208930000000001")
+		if again.DefaultSlice[0].Sst != 1 {
+			t.Errorf("stored slice changed to %d through a returned profile", again.DefaultSlice[0].Sst)
+		}
+	})
+}
+
+func TestUeProfilesRoundTrip(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store *Store) {
+		ctx := context.Background()
+		provisionedAt := time.Date(2024, 5, 1, 12, 0, 0, 123000000, time.UTC)
+		ue := models.UeProfile{
+			UserID:                 primitive.NewObjectID(),
+			OperatorID:             primitive.NewObjectID(),
+			CreatedAt:              provisionedAt.Add(-time.Hour),
+			Tags:                   []string{"lab", "batch-7"},
+			Supi:                   "imsi-208930000000001",
+			Suci:                   "suci-0-208-93-0000-1-1-abcdef",
+			PlmnId:                 models.PlmnId{Mcc: "208", Mnc: "93"},
+			ConfiguredSlice:        []models.Snssai{{Sst: 1, Sd: "010203"}, {Sst: 2, Sd: "112233"}},
+			DefaultSlice:           []models.Snssai{{Sst: 1, Sd: "010203"}},
+			RoutingIndicator:       "0000",
+			HomeNetworkPublicKey:   "5a8d38864820197c3394b92613b20b91633cbd897119273bf8e4a6f4eec0a650",
+			HomeNetworkPublicKeyId: 1,
+			ProtectionScheme:       1,
+			Key:                    "8baf473f2f8fd09487cccbd7097c6862",
+			Op:                     "8e27b6af0e692e750f32667a3b14605d",
+			OpType:                 "OPC",
+			Opc:                    "8e27b6af0e692e750f32667a3b14605d",
+			Amf:                    "8000",
+			Sqn:                    "000000000020",
+			AuthenticationMethod:   "5G_AKA",
+			Imei:                   "356938035643809",
+			Imeisv:                 "4370816125816151",
+			GnbSearchList:          []string{"127.0.0.1", "10.0.0.1"},
+			Integrity:              models.Integrity{IA1: true, IA2: true},
+			Ciphering:              models.Ciphering{EA3: true},
+			Profiles:               []models.Profile{{Scheme: 1, KeyId: 1, PublicKey: "5a8d"}, {Scheme: 2, KeyId: 2, PublicKey: "0272"}},
+			UacAic:                 models.UacAic{Mps: true},
+			UacAcc:                 models.UacAcc{NormalClass: 3, Class12: true},
+			Sessions: []models.Sessions{
+				{Type: "IPv4", Apn: "internet", Slice: models.Snssai{Sst: 1, Sd: "010203"}},
+				{Type: "IPv6", Apn: "ims", Slice: models.Snssai{Sst: 2, Sd: "112233"}},
+			},
+			IntegrityMaxRate: models.IntegrityMaxRate{Uplink: "full", Downlink: "64kbps"},
+			Provisioning: map[string]models.ProvisionStatus{
+				"free5gc": {UpdatedAt: provisionedAt, ProvisionedAt: &provisionedAt, InSync: true},
+				"udr":     {UpdatedAt: provisionedAt, Failed: true, Error: "unreachable"},
+			},
+		}
+		ueProfiles := []models.UeProfile{ue}
+		if err := store.UeProfiles.Insert(ctx, ueProfiles); err != nil {
+			t.Fatalf("Insert: %v", err)
+		}
+
+		stored, err := store.UeProfiles.Get(ctx, ue.UserID, ue.Supi)
+		if err != nil || stored == nil {
+			t.Fatalf("Get = %v, %v", stored, err)
+		}
+		ue.ID = ueProfiles[0].ID
+		if !reflect.DeepEqual(*stored, ue) {
+			t.Errorf("stored profile differs\ngot  %+v\nwant %+v", *stored, ue)
+		}
+	})
+}
+
+func TestUeProfilesEachPages(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store *Store) {
+		ctx := context.Background()
+		userID := primitive.NewObjectID()
+
+		// More profiles than the SQL backend reads at once
+		supis := make([]string, 2*sqlBatchSize+7)
+		for i := range supis {
+			supis[i] = fmt.Sprintf("imsi-20893%010d", len(supis)-i)
+		}
+		if err := store.UeProfiles.Insert(ctx, testUeProfiles(userID, supis...)); err != nil {
+			t.Fatalf("Insert: %v", err)
+		}
+
+		var visited []string
+		err := store.UeProfiles.Each(ctx, userID, nil, func(ue *models.UeProfile) error {
+			visited = append(visited, ue.Supi)
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("Each: %v", err)
+		}
+		if len(visited) != len(supis) || !sort.StringsAreSorted(visited) {
+			t.Errorf("Each visited %d profiles, sorted %v, want %d sorted", len(visited), sort.StringsAreSorted(visited), len(supis))
+		}
+
+		selected, err := store.UeProfiles.List(ctx, userID, supis)
+		if err != nil {
+			t.Fatalf("List: %v", err)
+		}
+		if len(selected) != len(supis) {
+			t.Errorf("List of every SUPI returned %d profiles, want %d", len(selected), len(supis))
+		}
+	})
+}
+
+// findAll follows the cursors of a query to the last page
+func findAll(t *testing.T, r UeProfileRepository, userID primitive.ObjectID, query UeProfileQuery) ([]string, int64) {
+	t.Helper()
+	var supis []string
+	var total int64
+	for pages := 0; ; pages++ {
+		if pages > 100 {
+			t.Fatalf("cursor does not advance")
+		}
+		page, err := r.Find(context.Background(), userID, query)
+		if err != nil {
+			t.Fatalf("Find(%+v): %v", query, err)
+		}
+		if len(page.UeProfiles) > query.Limit {
+			t.Fatalf("page of %d profiles exceeds the limit %d", len(page.UeProfiles), query.Limit)
+		}
+		supis = append(supis, supisOf(page.UeProfiles)...)
+		total = page.Total
+		if page.NextCursor == "" {
+			return supis, total
+		}
+		query.Cursor = page.NextCursor
+	}
+}
+
+func TestUeProfilesFind(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store *Store) {
+		r := store.UeProfiles
+		ctx := context.Background()
+		userID := primitive.NewObjectID()
+		base := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+		// SUPIs 1-9, created in reverse order, odd ones tagged and on another slice
+		var supis []string
+		for i := 1; i <= 9; i++ {
+			supis = append(supis, fmt.Sprintf("imsi-20893000000000%d", i))
+		}
+		ueProfiles := testUeProfiles(userID, supis...)
+		for i := range ueProfiles {
+			ueProfiles[i].CreatedAt = base.Add(time.Duration(9-i) * time.Minute)
+			ueProfiles[i].Sessions = []models.Sessions{{Type: "IPv4", Apn: "internet", Slice: models.Snssai{Sst: 1, Sd: "010203"}}}
+			if i%2 == 0 {
+				ueProfiles[i].Tags = []string{"odd", "lab"}
+				ueProfiles[i].ConfiguredSlice = []models.Snssai{{Sst: 2, Sd: "fffffe"}}
+				ueProfiles[i].ProtectionScheme = 1
+			}
+		}
+		// Two profiles share a creation time, ties are ordered by SUPI
+		ueProfiles[4].CreatedAt = ueProfiles[5].CreatedAt
+		if err := r.Insert(ctx, ueProfiles); err != nil {
+			t.Fatalf("Insert: %v", err)
+		}
+		other := testUeProfiles(primitive.NewObjectID(), supis[0])
+		if err := r.Insert(ctx, other); err != nil {
+			t.Fatalf("Insert: %v", err)
+		}
+		if other[0].CreatedAt.IsZero() {
+			t.Errorf("Insert left CreatedAt unset")
+		}
+
+		got, total := findAll(t, r, userID, UeProfileQuery{Limit: 4})
+		if !reflect.DeepEqual(got, supis) || total != 9 {
+			t.Errorf("SUPI order = %v (total %d), want %v", got, total, supis)
+		}
+		got, _ = findAll(t, r, userID, UeProfileQuery{Desc: true, Limit: 2})
+		if want := []string{supis[8], supis[7], supis[6], supis[5], supis[4], supis[3], supis[2], supis[1], supis[0]}; !reflect.DeepEqual(got, want) {
+			t.Errorf("descending SUPI order = %v, want %v", got, want)
+		}
+		got, _ = findAll(t, r, userID, UeProfileQuery{Sort: UeProfileSortCreatedAt, Limit: 2})
+		if want := []string{supis[8], supis[7], supis[6], supis[4], supis[5], supis[3], supis[2], supis[1], supis[0]}; !reflect.DeepEqual(got, want) {
+			t.Errorf("creation order = %v, want %v", got, want)
+		}
+		got, _ = findAll(t, r, userID, UeProfileQuery{Sort: UeProfileSortCreatedAt, Desc: true, Limit: 3})
+		if want := []string{supis[0], supis[1], supis[2], supis[3], supis[5], supis[4], supis[6], supis[7], supis[8]}; !reflect.DeepEqual(got, want) {
+			t.Errorf("reverse creation order = %v, want %v", got, want)
+		}
+
+		sst, scheme := 2, 1
+		createdAfter := base.Add(5 * time.Minute)
+		filters := []struct {
+			name  string
+			query UeProfileQuery
+			want  []string
+		}{
+			{"prefix", UeProfileQuery{SupiPrefix: "imsi-2089300000000"}, supis},
+			{"range", UeProfileQuery{SupiFrom: supis[2], SupiTo: supis[4]}, supis[2:5]},
+			{"other plmn", UeProfileQuery{Mcc: "001"}, nil},
+			{"slice", UeProfileQuery{Sst: &sst, Sd: "fffffe"}, []string{supis[0], supis[2], supis[4], supis[6], supis[8]}},
+			{"default slice", UeProfileQuery{Sd: "010203", SupiTo: supis[1]}, supis[:2]},
+			{"dnn", UeProfileQuery{Dnn: "ims"}, nil},
+			{"protection scheme", UeProfileQuery{ProtectionScheme: &scheme, SupiFrom: supis[5]}, []string{supis[6], supis[8]}},
+			{"tags", UeProfileQuery{Tags: []string{"lab", "odd"}, SupiTo: supis[3]}, []string{supis[0], supis[2]}},
+			{"missing tag", UeProfileQuery{Tags: []string{"lab", "even"}}, nil},
+			{"created after", UeProfileQuery{CreatedAfter: &createdAfter}, supis[:4]},
+		}
+		for _, f := range filters {
+			f.query.Limit = 2
+			got, total := findAll(t, r, userID, f.query)
+			if !reflect.DeepEqual(got, f.want) || total != int64(len(f.want)) {
+				t.Errorf("%s = %v (total %d), want %v", f.name, got, total, f.want)
+			}
+		}
+
+		// A cursor only continues the sort order it was made for
+		page, err := r.Find(ctx, userID, UeProfileQuery{Limit: 1})
+		if err != nil {
+			t.Fatalf("Find: %v", err)
+		}
+		for _, query := range []UeProfileQuery{
+			{Limit: 1, Cursor: page.NextCursor, Desc: true},
+			{Limit: 1, Cursor: page.NextCursor, Sort: UeProfileSortCreatedAt},
+			{Limit: 1, Cursor: "not a cursor"},
+			{Limit: 1, Sort: "imei"},
+			{Limit: 0},
+		} {
+			if _, err := r.Find(ctx, userID, query); !errors.Is(err, ErrInvalidQuery) {
+				t.Errorf("Find(%+v) = %v, want ErrInvalidQuery", query, err)
+			}
+		}
+	})
+}
+
+func TestUeProfilesUpdate(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store *Store) {
+		r := store.UeProfiles
+		ctx := context.Background()
+		userID := primitive.NewObjectID()
+		if err := r.Insert(ctx, testUeProfiles(userID, "imsi-208930000000001", "imsi-208930000000002")); err != nil {
+			t.Fatalf("Insert: %v", err)
+		}
+
+		now := time.Now().UTC().Truncate(time.Millisecond)
+		matched, err := r.Update(ctx, userID,
+			UeProfileUpdate{Supi: "imsi-208930000000001", Fields: map[string]interface{}{
+				"imei":                        "490154203237518",
+				"provisioning.udr.inSync":     true,
+				"provisioning.udr.updatedAt":  now,
+				"provisioning.free5gc.failed": true,
+				"provisioning.free5gc.error":  "unreachable",
+				"defaultSlice":                []interface{}{map[string]interface{}{"sst": 2}},
+			}},
+			UeProfileUpdate{Supi: "imsi-208930000000009", Fields: map[string]interface{}{"imei": "x"}},
+		)
+		if err != nil {
+			t.Fatalf("Update: %v", err)
+		}
+		if matched != 1 {
+			t.Errorf("Update matched %d profiles, want 1", matched)
+		}
+
+		ue, _ := r.Get(ctx, userID, "imsi-208930000000001")
+		if ue.Imei != "490154203237518" {
+			t.Errorf("imei = %q", ue.Imei)
+		}
+		if udr := ue.Provisioning["udr"]; !udr.InSync || !udr.UpdatedAt.Equal(now) {
+			t.Errorf("udr status = %+v", udr)
+		}
+		if free5gc := ue.Provisioning["free5gc"]; !free5gc.Failed || free5gc.Error != "unreachable" {
+			t.Errorf("free5gc status = %+v", free5gc)
+		}
+		if len(ue.DefaultSlice) != 1 || ue.DefaultSlice[0].Sst != 2 {
+			t.Errorf("defaultSlice = %+v", ue.DefaultSlice)
+		}
+
+		// A later update of one target keeps the other fields of the target
+		if _, err := r.Update(ctx, userID, UeProfileUpdate{Supi: "imsi-208930000000001", Fields: map[string]interface{}{
+			"provisioning.udr.inSync": false,
+		}}); err != nil {
+			t.Fatalf("Update: %v", err)
+		}
+		ue, _ = r.Get(ctx, userID, "imsi-208930000000001")
+		if udr := ue.Provisioning["udr"]; udr.InSync || !udr.UpdatedAt.Equal(now) {
+			t.Errorf("udr status after second update = %+v", udr)
+		}
+	})
+}
+
+func TestUeProfilesSetSqn(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store *Store) {
+		r := store.UeProfiles
+		ctx := context.Background()
+		userID := primitive.NewObjectID()
+		if err := r.Insert(ctx, testUeProfiles(userID, "imsi-208930000000001")); err != nil {
+			t.Fatalf("Insert: %v", err)
+		}
+
+		stale := "000000000000"
+		if ok, err := r.SetSqn(ctx, userID, "imsi-208930000000001", &stale, "000000000040"); ok || err != nil {
+			t.Errorf("SetSqn with stale SQN = %v, %v, want false", ok, err)
+		}
+		current := "000000000020"
+		if ok, err := r.SetSqn(ctx, userID, "imsi-208930000000001", &current, "000000000040"); !ok || err != nil {
+			t.Errorf("SetSqn = %v, %v, want true", ok, err)
+		}
+		if ok, err := r.SetSqn(ctx, userID, "imsi-208930000000001", nil, "000000000060"); !ok || err != nil {
+			t.Errorf("unconditional SetSqn = %v, %v, want true", ok, err)
+		}
+		ue, _ := r.Get(ctx, userID, "imsi-208930000000001")
+		if ue.Sqn != "000000000060" {
+			t.Errorf("sqn = %s, want 000000000060", ue.Sqn)
+		}
+	})
+}
+
+func TestUeProfilesEachAndDelete(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store *Store) {
+		r := store.UeProfiles
+		ctx := context.Background()
+		userID := primitive.NewObjectID()
+		if err := r.Insert(ctx, testUeProfiles(userID, "imsi-208930000000003", "imsi-208930000000001", "imsi-208930000000002")); err != nil {
+			t.Fatalf("Insert: %v", err)
+		}
+
+		var visited []string
+		err := r.Each(ctx, userID, []string{"imsi-208930000000003", "imsi-208930000000001", "imsi-208930000000009"}, func(ue *models.UeProfile) error {
+			visited = append(visited, ue.Supi)
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("Each: %v", err)
+		}
+		if !reflect.DeepEqual(visited, []string{"imsi-208930000000001", "imsi-208930000000003"}) {
+			t.Errorf("Each visited %v", visited)
+		}
+
+		stop := errors.New("stop")
+		visited = nil
+		err = r.Each(ctx, userID, nil, func(ue *models.UeProfile) error {
+			visited = append(visited, ue.Supi)
+			return stop
+		})
+		if err != stop || len(visited) != 1 {
+			t.Errorf("Each = %v after %v, want the error of fn after one profile", err, visited)
+		}
+
+		if deleted, err := r.Delete(ctx, userID, "imsi-208930000000002"); !deleted || err != nil {
+			t.Errorf("Delete = %v, %v", deleted, err)
+		}
+		if deleted, _ := r.Delete(ctx, userID, "imsi-208930000000002"); deleted {
+			t.Errorf("second Delete reported a deletion")
+		}
+		exists, _ := r.Existing(ctx, userID, []string{"imsi-208930000000001", "imsi-208930000000002"})
+		if !reflect.DeepEqual(exists, map[string]bool{"imsi-208930000000001": true}) {
+			t.Errorf("Existing = %v", exists)
+		}
+	})
+}
+
+func TestUsersAndTokens(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store *Store) {
+		ctx := context.Background()
+
+		user := models.User{Username: "alice", Password: "hash"}
+		if err := store.Users.Create(ctx, &user); err != nil {
+			t.Fatalf("Create: %v", err)
+		}
+		if user.ID.IsZero() {
+			t.Errorf("created user has no ID")
+		}
+		if err := store.Users.Create(ctx, &models.User{Username: "alice"}); !errors.Is(err, ErrDuplicate) {
+			t.Errorf("Create with taken username = %v, want ErrDuplicate", err)
+		}
+		if found, err := store.Users.GetByUsername(ctx, "alice"); err != nil || found == nil || found.ID != user.ID {
+			t.Errorf("GetByUsername = %+v, %v", found, err)
+		}
+		if found, err := store.Users.GetByUsername(ctx, "bob"); found != nil || err != nil {
+			t.Errorf("GetByUsername of unknown user = %+v, %v", found, err)
+		}
+
+		now := time.Now()
+		if err := store.Tokens.Add(ctx, models.BlacklistedToken{Token: "t1", ExpiresAt: now.Add(time.Hour)}); err != nil {
+			t.Fatalf("Add: %v", err)
+		}
+		if err := store.Tokens.Add(ctx, models.BlacklistedToken{Token: "t1", ExpiresAt: now.Add(time.Hour)}); !errors.Is(err, ErrDuplicate) {
+			t.Errorf("second Add = %v, want ErrDuplicate", err)
+		}
+		if ok, _ := store.Tokens.Contains(ctx, "t1", now); !ok {
+			t.Errorf("revoked token not found")
+		}
+		if ok, _ := store.Tokens.Contains(ctx, "t1", now.Add(2*time.Hour)); ok {
+			t.Errorf("expired token still revoked")
+		}
+	})
+}
+
+func TestOperators(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store *Store) {
+		r := store.Operators
+		ctx := context.Background()
+		alice, bob := primitive.NewObjectID(), primitive.NewObjectID()
+
+		op := models.Operator{ID: primitive.NewObjectID(), UserID: alice, Name: "a", PlmnId: models.PlmnId{Mcc: "208", Mnc: "93"}}
+		other := models.Operator{ID: primitive.NewObjectID(), UserID: alice, Name: "b", PlmnId: models.PlmnId{Mcc: "001", Mnc: "01"}}
+		for _, o := range []models.Operator{op, other} {
+			if err := r.Create(ctx, &o); err != nil {
+				t.Fatalf("Create: %v", err)
+			}
+		}
+
+		if ops, _ := r.List(ctx, alice); len(ops) != 2 {
+			t.Errorf("List = %d operators, want 2", len(ops))
+		}
+		if ops, _ := r.ListByPlmn(ctx, alice, models.PlmnId{Mcc: "208", Mnc: "93"}); len(ops) != 1 || ops[0].Name != "a" {
+			t.Errorf("ListByPlmn = %+v", ops)
+		}
+		if found, _ := r.Get(ctx, bob, op.ID); found != nil {
+			t.Errorf("Get returned the operator of another user")
+		}
+
+		op.Name = "renamed"
+		if ok, err := r.Replace(ctx, &op); !ok || err != nil {
+			t.Errorf("Replace = %v, %v", ok, err)
+		}
+		if found, _ := r.Get(ctx, alice, op.ID); found == nil || found.Name != "renamed" {
+			t.Errorf("Get after Replace = %+v", found)
+		}
+		if ok, _ := r.Delete(ctx, bob, op.ID); ok {
+			t.Errorf("Delete removed the operator of another user")
+		}
+		if ok, _ := r.Delete(ctx, alice, op.ID); !ok {
+			t.Errorf("Delete did not remove the operator")
+		}
+	})
+}
+
+func TestMsins(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store *Store) {
+		r := store.Msins
+		ctx := context.Background()
+		userID := primitive.NewObjectID()
+		plmn := models.PlmnId{Mcc: "208", Mnc: "93"}
+
+		// The configured start applies while the mark is below it
+		if next, ok, _ := r.Advance(ctx, userID, plmn, 1, 10, 1000); !ok || next != 11 {
+			t.Errorf("Advance = %d, %v, want 11", next, ok)
+		}
+		if next, ok, _ := r.Advance(ctx, userID, plmn, 1, 10, 1000); !ok || next != 21 {
+			t.Errorf("second Advance = %d, %v, want 21", next, ok)
+		}
+		// A higher start moves the mark past the MSINs below it
+		if next, ok, _ := r.Advance(ctx, userID, plmn, 500, 100, 1000); !ok || next != 600 {
+			t.Errorf("Advance from above the mark = %d, %v, want 600", next, ok)
+		}
+		if _, ok, _ := r.Advance(ctx, userID, plmn, 1, 401, 1000); ok {
+			t.Errorf("Advance past the limit succeeded")
+		}
+		if next, ok, _ := r.Advance(ctx, userID, plmn, 1, 400, 1000); !ok || next != 1000 {
+			t.Errorf("Advance up to the limit = %d, %v, want 1000", next, ok)
+		}
+		// Each PLMN has its own mark
+		if next, ok, _ := r.Advance(ctx, userID, models.PlmnId{Mcc: "001", Mnc: "01"}, 0, 1, 1000); !ok || next != 1 {
+			t.Errorf("Advance in another PLMN = %d, %v, want 1", next, ok)
+		}
+	})
+}
+
+func TestJobs(t *testing.T) {
+	forEachStore(t, func(t *testing.T, store *Store) {
+		r := store.Jobs
+		ctx := context.Background()
+		alice, bob := primitive.NewObjectID(), primitive.NewObjectID()
+		created := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+		msinStart := uint64(100)
+
+		jobs := make([]models.Job, 3)
+		for i := range jobs {
+			jobs[i] = models.Job{
+				UserID:    alice,
+				Status:    models.JobQueued,
+				Params:    models.GenerateJobParams{Num: 10 * (i + 1), Schemes: models.SchemeWeights{A: 1}, Targets: []string{}},
+				CreatedAt: created.Add(time.Duration(i) * time.Minute),
+			}
+			if err := r.Create(ctx, &jobs[i]); err != nil || jobs[i].ID.IsZero() {
+				t.Fatalf("Create = %v with ID %s", err, jobs[i].ID.Hex())
+			}
+		}
+		jobs[2].Params.MsinStart = &msinStart
+		jobs[2].Params.Targets = nil
+		jobs[2].ResultTag = "job-" + jobs[2].ID.Hex()
+
+		list, err := r.List(ctx, alice)
+		if err != nil || len(list) != 3 || list[0].ID != jobs[2].ID || list[2].ID != jobs[0].ID {
+			t.Errorf("List = %+v, %v, want newest first", list, err)
+		}
+		if found, _ := r.Get(ctx, bob, jobs[0].ID); found != nil {
+			t.Errorf("Get returned the job of another user")
+		}
+
+		// Job state round-trips, including the difference of no and all targets
+		startedAt := created.Add(time.Hour)
+		jobs[0].Status, jobs[0].Done, jobs[0].StartedAt, jobs[0].Warnings = models.JobSucceeded, 10, &startedAt, []string{"udr: unreachable"}
+		jobs[1].Status = models.JobRunning
+		for i := range jobs {
+			if ok, err := r.Replace(ctx, &jobs[i]); !ok || err != nil {
+				t.Fatalf("Replace = %v, %v", ok, err)
+			}
+		}
+		for i := range jobs {
+			found, err := r.Get(ctx, alice, jobs[i].ID)
+			if err != nil || found == nil || !reflect.DeepEqual(*found, jobs[i]) {
+				t.Errorf("Get = %+v, %v, want %+v", found, err, jobs[i])
+			}
+		}
+		other := jobs[0]
+		other.UserID = bob
+		if ok, _ := r.Replace(ctx, &other); ok {
+			t.Errorf("Replace changed the job of another user")
+		}
+
+		unfinished, err := r.Unfinished(ctx)
+		if err != nil || len(unfinished) != 2 || unfinished[0].ID != jobs[1].ID || unfinished[1].ID != jobs[2].ID {
+			t.Errorf("Unfinished = %+v, %v", unfinished, err)
+		}
+	})
+}
@@ -0,0 +1,138 @@
+package supi
+
+import (
+	"crypto/hmac"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var (
+	ErrBadScheme    = errors.New("unsupported protection scheme")
+	ErrSchemeOutput = errors.New("malformed scheme output")
+	ErrInvalidKey   = errors.New("invalid home network private key")
+	ErrMacMismatch  = errors.New("MAC tag mismatch")
+)
+
+// Length of the ephemeral public key carried in the scheme output
+const (
+	ProfileAPubKeyLen = 32 // octets
+	ProfileBPubKeyLen = 33 // octets, compressed point
+)
+
+// Suci2Supi de-conceals the scheme output of a profile A or B SUCI with the home network
+// private key (the SIDF side of TS 33.501 Annex C.3) and returns the MSIN digits
+func Suci2Supi(profile string, hnPrivKey string, schemeOutput string) (string, error) {
+	plainText, err := Deconceal(profile, hnPrivKey, schemeOutput)
+	if err != nil {
+		return "", err
+	}
+	msin, err := decodeBcd(plainText)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrSchemeOutput, err)
+	}
+	return msin, nil
+}
+
+// Deconceal verifies the MAC tag of a profile A or B scheme output and returns the decrypted scheme input
+func Deconceal(profile string, hnPrivKey string, schemeOutput string) ([]byte, error) {
+	var hn EllipticCurve
+	var pubKeyLen, macLen int
+	switch profile {
+	case "A":
+		pubKeyLen, macLen = ProfileAPubKeyLen, ProfileAMacLen
+	case "B":
+		pubKeyLen, macLen = ProfileBPubKeyLen, ProfileBMacLen
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrBadScheme, profile)
+	}
+
+	if privKey, err := hex.DecodeString(hnPrivKey); err != nil || len(privKey) != PrivateKeySize {
+		return nil, ErrInvalidKey
+	}
+	var err error
+	if profile == "A" {
+		hn, err = NewX25519(hnPrivKey)
+	} else {
+		hn, err = NewSecp256r1(hnPrivKey)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidKey, err)
+	}
+
+	output, err := hex.DecodeString(schemeOutput)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrSchemeOutput, err)
+	}
+	if len(output) <= pubKeyLen+macLen {
+		return nil, fmt.Errorf("%w: %d octets is too short for profile %s", ErrSchemeOutput, len(output), profile)
+	}
+	ephPubKey := output[:pubKeyLen]
+	cipherText := output[pubKeyLen : len(output)-macLen]
+	macTag := output[len(output)-macLen:]
+
+	sharedKey, err := hn.GenerateSharedKey(ephPubKey)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid ephemeral public key: %v", ErrSchemeOutput, err)
+	}
+	kdfKey := KDF(sharedKey, ephPubKey, ProfileAEncKeyLen, ProfileAMacKeyLen, ProfileAHashLen)
+
+	// Verify the MAC tag before decrypting
+	decryptEncKey := kdfKey[:16]
+	decryptIcb := kdfKey[16:32]
+	macKey := kdfKey[32:64]
+	expectedMacTag, err := HmacSha256(cipherText, macKey, macLen)
+	if err != nil {
+		return nil, err
+	}
+	if !hmac.Equal(macTag, expectedMacTag) {
+		return nil, ErrMacMismatch
+	}
+
+	return Aes128ctr(cipherText, decryptEncKey, decryptIcb), nil
+}
+
+// encodeBcd packs digits two per octet with swapped nibbles, padding odd lengths with 0xF (TS 24.501)
+func encodeBcd(digits string) ([]byte, error) {
+	if len(digits)%2 == 1 {
+		digits += "f"
+	}
+	out := make([]byte, len(digits)/2)
+	for i := 0; i < len(digits); i += 2 {
+		if !isDigit(digits[i]) || !(isDigit(digits[i+1]) || (i+2 == len(digits) && digits[i+1] == 'f')) {
+			return nil, fmt.Errorf("invalid digits %q", strings.TrimSuffix(digits, "f"))
+		}
+		low := digits[i] - '0'
+		high := byte(0xf)
+		if digits[i+1] != 'f' {
+			high = digits[i+1] - '0'
+		}
+		out[i/2] = high<<4 | low
+	}
+	return out, nil
+}
+
+// decodeBcd reverses encodeBcd
+func decodeBcd(bcd []byte) (string, error) {
+	var sb strings.Builder
+	for i, b := range bcd {
+		low, high := b&0x0f, b>>4
+		if low > 9 {
+			return "", fmt.Errorf("invalid BCD octet %02x", b)
+		}
+		sb.WriteByte('0' + low)
+		if high == 0xf && i == len(bcd)-1 {
+			break
+		}
+		if high > 9 {
+			return "", fmt.Errorf("invalid BCD octet %02x", b)
+		}
+		sb.WriteByte('0' + high)
+	}
+	return sb.String(), nil
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
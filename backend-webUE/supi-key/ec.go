@@ -71,7 +71,13 @@ type PrivateKey struct {
 
 func (x *X25519) GenerateKeyFromExistingPrivateKey(ExistingPrivateKey string) error {
 
-	existingPrivateKey, _ := hex.DecodeString(ExistingPrivateKey)
+	existingPrivateKey, err := hex.DecodeString(ExistingPrivateKey)
+	if err != nil {
+		return fmt.Errorf("invalid X25519 private key: %v", err)
+	}
+	if len(existingPrivateKey) != PrivateKeySize {
+		return fmt.Errorf("invalid X25519 private key length %d", len(existingPrivateKey))
+	}
 	x.privKey = existingPrivateKey
 	privateKey := new(PrivateKey)
 	copy(privateKey.b[:], existingPrivateKey[:])
@@ -120,11 +126,9 @@ func (x *X25519) GenerateSharedKey(hnPubKey []byte) ([]byte, error) {
 	/*
 	   generate_sharedkey - get the shared key
 	*/
-	var encryptSharedKey []byte
-	if encryptSharedKeyTmp, err := curve25519.X25519(x.privKey, hnPubKey); err != nil {
-		log.Printf("X25519 error: %+v", err)
-	} else {
-		encryptSharedKey = encryptSharedKeyTmp
+	encryptSharedKey, err := curve25519.X25519(x.privKey, hnPubKey)
+	if err != nil {
+		return nil, fmt.Errorf("X25519 error: %v", err)
 	}
 	return encryptSharedKey, nil
 }
@@ -167,8 +171,14 @@ func (x *Secp256r1) GenerateKeyFromExistingPrivateKey(hexPrivateKey string) erro
 	/*
 		Generate a key pair with a pre-determined private key.
 	*/
-	bytePrivKey, _ := hex.DecodeString(hexPrivateKey)
+	bytePrivKey, err := hex.DecodeString(hexPrivateKey)
+	if err != nil {
+		return fmt.Errorf("invalid secp256r1 private key: %v", err)
+	}
 	privateKey := new(big.Int).SetBytes(bytePrivKey)
+	if privateKey.Sign() == 0 || privateKey.Cmp(elliptic.P256().Params().N) >= 0 {
+		return fmt.Errorf("secp256r1 private key out of range")
+	}
 	x.privKey = new(ecdsa.PrivateKey)
 	x.privKey.PublicKey.Curve = elliptic.P256()
 	x.privKey.D = new(big.Int).Set(privateKey)
@@ -216,6 +226,9 @@ func DecompressPubkey(pubkey []byte) (*ecdsa.PublicKey, error) {
 	}
 
 	x := new(big.Int).SetBytes(pubkey[1:])
+	if x.Cmp(elliptic.P256().Params().P) >= 0 {
+		return nil, fmt.Errorf("invalid public key")
+	}
 
 	// Calculate y^2
 	yyy := new(big.Int).Mul(x, x)
@@ -257,12 +270,16 @@ func (x *Secp256r1) GenerateSharedKey(bytehnPubKey []byte) ([]byte, error) {
 	/*
 	   generate_sharedkey - get the shared key
 	*/
-	hnPubKey, _ := DecompressPubkey(bytehnPubKey)
+	hnPubKey, err := DecompressPubkey(bytehnPubKey)
+	if err != nil {
+		return nil, err
+	}
 	if err := checkOnCurve(elliptic.P256(), hnPubKey.X, hnPubKey.Y); err != nil {
 		return []byte{}, err
 	}
 	decryptSharedKeytmp, _ := elliptic.P256().ScalarMult(hnPubKey.X, hnPubKey.Y, x.privKey.D.Bytes())
-	decryptSharedKey := decryptSharedKeytmp.Bytes()
+	// The shared secret is the x-coordinate, left padded to the field size
+	decryptSharedKey := decryptSharedKeytmp.FillBytes(make([]byte, 32))
 	return decryptSharedKey, nil
 }
 
@@ -331,51 +348,82 @@ type EllipticCurve interface {
 	GetPrivKey() []byte
 }
 
-// Factory functions to create instances
-func NewX25519(loc_privKey string) EllipticCurve {
+// Factory functions to create instances, a key pair is generated when loc_privKey is empty.
+// They fail for a malformed or out of range private key.
+func NewX25519(loc_privKey string) (EllipticCurve, error) {
 	x := &X25519{}
 	if loc_privKey == "" {
-		x.GenerateKeyPair()
-	} else {
-		x.GenerateKeyFromExistingPrivateKey(loc_privKey)
+		return x, x.GenerateKeyPair()
 	}
-	return x
+	if err := x.GenerateKeyFromExistingPrivateKey(loc_privKey); err != nil {
+		return nil, err
+	}
+	return x, nil
 }
 
-func NewSecp256r1(loc_privKey string) EllipticCurve {
+func NewSecp256r1(loc_privKey string) (EllipticCurve, error) {
 	x := &Secp256r1{}
 	if loc_privKey == "" {
-		x.GenerateKeyPair()
-	} else {
-		x.GenerateKeyFromExistingPrivateKey(loc_privKey)
+		return x, x.GenerateKeyPair()
 	}
-	return x
+	if err := x.GenerateKeyFromExistingPrivateKey(loc_privKey); err != nil {
+		return nil, err
+	}
+	return x, nil
 }
 
-func encode_supi(profile string, stringHnPubKey string, ephprivKey string, msinString string) string {
-	var a EllipticCurve
-	if profile == "A" {
-		a = NewX25519(ephprivKey)
-	} else {
-		a = NewSecp256r1(ephprivKey)
+// newEphemeralKey returns the UE ephemeral key pair of a profile, generated when ephPrivKey is empty
+func newEphemeralKey(profile string, ephPrivKey string) (EllipticCurve, error) {
+	switch profile {
+	case "A":
+		x := &X25519{}
+		if ephPrivKey == "" {
+			return x, x.GenerateKeyPair()
+		}
+		return x, x.GenerateKeyFromExistingPrivateKey(ephPrivKey)
+	case "B":
+		x := &Secp256r1{}
+		if ephPrivKey == "" {
+			return x, x.GenerateKeyPair()
+		}
+		return x, x.GenerateKeyFromExistingPrivateKey(ephPrivKey)
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrBadScheme, profile)
+	}
+}
+
+// Conceal encrypts the scheme input (BCD MSIN or NAI username) with profile A or B and returns
+// the hex scheme output. A random ephemeral key is used when ephPrivKey is empty.
+func Conceal(profile string, stringHnPubKey string, ephPrivKey string, plainText []byte) (string, error) {
+	a, err := newEphemeralKey(profile, ephPrivKey)
+	if err != nil {
+		return "", err
+	}
+	hnPubKey, err := hex.DecodeString(stringHnPubKey)
+	if err != nil {
+		return "", fmt.Errorf("invalid home network public key: %v", err)
 	}
-	hnPubKey, _ := hex.DecodeString(stringHnPubKey)
-	msin, _ := hex.DecodeString(msinString)
 	pubKey := hex.EncodeToString(a.GetPubKey())
-	//fmt.Println(pubKey)
-	sharedKey, _ := a.GenerateSharedKey(hnPubKey)
-	//fmt.Printf("Shared Key: %x\n", sharedKey)
+	sharedKey, err := a.GenerateSharedKey(hnPubKey)
+	if err != nil {
+		return "", fmt.Errorf("invalid home network public key: %v", err)
+	}
 	kdf_key := KDF(sharedKey, a.GetPubKey(), ProfileAEncKeyLen, ProfileAMacKeyLen, ProfileAHashLen)
-	//fmt.Println("KDF Key: ", hex.EncodeToString(kdf_key))
-	suci_bytes, macTag_UE_bytes := protect(msin, kdf_key)
-	//publickey_hex := fmt.Sprintf("%x", publicKeyue)
-	//fmt.Println("PublickeyUE:     ",publickey_hex)
+	suci_bytes, macTag_UE_bytes := protect(plainText, kdf_key)
 	suci := hex.EncodeToString(suci_bytes)
 	macTag_UE := hex.EncodeToString(macTag_UE_bytes)
-	return pubKey + suci + macTag_UE
+	return pubKey + suci + macTag_UE, nil
 }
 
-func Supi2Suci(profile string, stringHnPubKey string, ephprivKey string, msinString string) (suci string) {
-	suci = encode_supi(profile, stringHnPubKey, ephprivKey, msinString)
-	return
+func encode_supi(profile string, stringHnPubKey string, ephprivKey string, msinString string) (string, error) {
+	msin, err := encodeBcd(msinString)
+	if err != nil {
+		return "", fmt.Errorf("invalid MSIN: %v", err)
+	}
+	return Conceal(profile, stringHnPubKey, ephprivKey, msin)
+}
+
+// Supi2Suci conceals the MSIN with profile A or B. A random ephemeral key is used when ephprivKey is empty.
+func Supi2Suci(profile string, stringHnPubKey string, ephprivKey string, msinString string) (string, error) {
+	return encode_supi(profile, stringHnPubKey, ephprivKey, msinString)
 }
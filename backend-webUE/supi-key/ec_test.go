@@ -0,0 +1,254 @@
+package supi
+
+import (
+	"encoding/hex"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// Test data of TS 33.501 Annex C.4.3 (Profile A) and C.4.4 (Profile B).
+// Both vectors conceal the IMSI-based SUPI with MSIN 001002086 (plaintext 00012080f6).
+var annexC4Vectors = []struct {
+	name         string
+	profile      string
+	hnPrivKey    string
+	hnPubKey     string
+	ephPrivKey   string
+	ephPubKey    string
+	sharedKey    string
+	msin         string
+	schemeOutput string
+}{
+	{
+		name:         "Profile A",
+		profile:      "A",
+		hnPrivKey:    "c53c22208b61860b06c62e5406a7b330c2b577aa5558981510d128247d38bd1d",
+		hnPubKey:     "5a8d38864820197c3394b92613b20b91633cbd897119273bf8e4a6f4eec0a650",
+		ephPrivKey:   "c80949f13ebe61af4ebdbd293ea4f942696b9e815d7e8f0096bbf6ed7de62256",
+		ephPubKey:    "b2e92f836055a255837debf850b528997ce0201cb82adfe4be1f587d07d8457d",
+		sharedKey:    "028ddf890ec83cdf163947ce45f6ec1a0e3070ea5fe57e2b1f05139f3e82422a",
+		msin:         "001002086",
+		schemeOutput: "b2e92f836055a255837debf850b528997ce0201cb82adfe4be1f587d07d8457dcb02352410cddd9e730ef3fa87",
+	},
+	{
+		name:         "Profile B",
+		profile:      "B",
+		hnPrivKey:    "f1ab1074477ebcc7f554ea1c5fc368b1616730155e0041ac447d6301975fecda",
+		hnPubKey:     "0272da71976234ce833a6907425867b82e074d44ef907dfb4b3e21c1c2256ebcd1",
+		ephPrivKey:   "99798858a1dc6a2c68637149a4b1dbfd1fdff5addd62a2142f06699ed7602529",
+		ephPubKey:    "039aab8376597021e855679a9778ea0b67396e68c66df32c0f41e9acca2da9b9d1",
+		sharedKey:    "6c7e6518980025b982fbb2ff746e3c2e85a196d252099a7ad23ea7b4c0959cae",
+		msin:         "001002086",
+		schemeOutput: "039aab8376597021e855679a9778ea0b67396e68c66df32c0f41e9acca2da9b9d146a33fc2716ac7dae96aa30a4d",
+	},
+}
+
+func TestAnnexC4KeyAgreement(t *testing.T) {
+	for _, tc := range annexC4Vectors {
+		t.Run(tc.name, func(t *testing.T) {
+			eph, err := newEphemeralKey(tc.profile, tc.ephPrivKey)
+			if err != nil {
+				t.Fatalf("newEphemeralKey: %v", err)
+			}
+			if got := hex.EncodeToString(eph.GetPubKey()); got != tc.ephPubKey {
+				t.Errorf("ephemeral public key = %s, want %s", got, tc.ephPubKey)
+			}
+
+			hnPubKey, _ := hex.DecodeString(tc.hnPubKey)
+			sharedKey, err := eph.GenerateSharedKey(hnPubKey)
+			if err != nil {
+				t.Fatalf("GenerateSharedKey: %v", err)
+			}
+			if got := hex.EncodeToString(sharedKey); got != tc.sharedKey {
+				t.Errorf("shared key = %s, want %s", got, tc.sharedKey)
+			}
+		})
+	}
+}
+
+func TestAnnexC4Conceal(t *testing.T) {
+	for _, tc := range annexC4Vectors {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := Supi2Suci(tc.profile, tc.hnPubKey, tc.ephPrivKey, tc.msin)
+			if err != nil {
+				t.Fatalf("Supi2Suci: %v", err)
+			}
+			if got != tc.schemeOutput {
+				t.Errorf("scheme output = %s, want %s", got, tc.schemeOutput)
+			}
+		})
+	}
+}
+
+func TestAnnexC4Deconceal(t *testing.T) {
+	for _, tc := range annexC4Vectors {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := Suci2Supi(tc.profile, tc.hnPrivKey, tc.schemeOutput)
+			if err != nil {
+				t.Fatalf("Suci2Supi: %v", err)
+			}
+			if got != tc.msin {
+				t.Errorf("MSIN = %s, want %s", got, tc.msin)
+			}
+		})
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, tc := range annexC4Vectors {
+		for _, msin := range []string{"0", "0000000001", "123456789", "9999999999"} {
+			t.Run(tc.name+"/"+msin, func(t *testing.T) {
+				output, err := Supi2Suci(tc.profile, tc.hnPubKey, "", msin)
+				if err != nil {
+					t.Fatalf("Supi2Suci: %v", err)
+				}
+				got, err := Suci2Supi(tc.profile, tc.hnPrivKey, output)
+				if err != nil {
+					t.Fatalf("Suci2Supi: %v", err)
+				}
+				if got != msin {
+					t.Errorf("MSIN = %s, want %s", got, msin)
+				}
+			})
+		}
+	}
+}
+
+func TestDeconcealErrors(t *testing.T) {
+	profileA, profileB := annexC4Vectors[0], annexC4Vectors[1]
+
+	// flipHex flips the low bit of the hex digit at index i (negative counts from the end)
+	flipHex := func(s string, i int) string {
+		if i < 0 {
+			i += len(s)
+		}
+		b := []byte(s)
+		b[i] = "1032547698badcfe"[strings.IndexByte("0123456789abcdef", b[i])]
+		return string(b)
+	}
+
+	tests := []struct {
+		name         string
+		profile      string
+		hnPrivKey    string
+		schemeOutput string
+		want         error
+	}{
+		{"unknown profile", "C", profileA.hnPrivKey, profileA.schemeOutput, ErrBadScheme},
+		{"non hex private key", "A", "zz", profileA.schemeOutput, ErrInvalidKey},
+		{"short private key", "A", profileA.hnPrivKey[:62], profileA.schemeOutput, ErrInvalidKey},
+		{"non hex output", "A", profileA.hnPrivKey, "xyz", ErrSchemeOutput},
+		{"output without ciphertext", "A", profileA.hnPrivKey, profileA.schemeOutput[:80], ErrSchemeOutput},
+		{"corrupted MAC", "A", profileA.hnPrivKey, flipHex(profileA.schemeOutput, -1), ErrMacMismatch},
+		{"corrupted ciphertext", "A", profileA.hnPrivKey, flipHex(profileA.schemeOutput, 64), ErrMacMismatch},
+		{"corrupted ephemeral key", "A", profileA.hnPrivKey, flipHex(profileA.schemeOutput, 10), ErrMacMismatch},
+		{"wrong private key", "A", profileB.hnPrivKey, profileA.schemeOutput, ErrMacMismatch},
+		{"profile B corrupted MAC", "B", profileB.hnPrivKey, flipHex(profileB.schemeOutput, -1), ErrMacMismatch},
+		{"profile B invalid point prefix", "B", profileB.hnPrivKey, "04" + profileB.schemeOutput[2:], ErrSchemeOutput},
+		{"profile A output as profile B", "B", profileB.hnPrivKey, profileA.schemeOutput, ErrSchemeOutput},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := Suci2Supi(tc.profile, tc.hnPrivKey, tc.schemeOutput)
+			if !errors.Is(err, tc.want) {
+				t.Errorf("error = %v, want %v", err, tc.want)
+			}
+		})
+	}
+}
+
+func TestConcealErrors(t *testing.T) {
+	profileA, profileB := annexC4Vectors[0], annexC4Vectors[1]
+	tests := []struct {
+		name       string
+		profile    string
+		hnPubKey   string
+		ephPrivKey string
+		msin       string
+	}{
+		{"unknown profile", "C", profileA.hnPubKey, "", "0000000001"},
+		{"non hex public key", "A", "zz", "", "0000000001"},
+		{"short profile A public key", "A", profileA.hnPubKey[:62], "", "0000000001"},
+		{"profile B public key not on curve", "B", "02" + strings.Repeat("ff", 32), "", "0000000001"},
+		{"profile B uncompressed prefix", "B", "04" + profileB.hnPubKey[2:], "", "0000000001"},
+		{"non hex ephemeral key", "A", profileA.hnPubKey, "zz", "0000000001"},
+		{"short ephemeral key", "A", profileA.hnPubKey, profileA.ephPrivKey[:60], "0000000001"},
+		{"zero profile B ephemeral key", "B", profileB.hnPubKey, strings.Repeat("0", 64), "0000000001"},
+		{"non digit MSIN", "A", profileA.hnPubKey, "", "00000000a1"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if output, err := Supi2Suci(tc.profile, tc.hnPubKey, tc.ephPrivKey, tc.msin); err == nil {
+				t.Errorf("expected an error, got scheme output %s", output)
+			}
+		})
+	}
+}
+
+func TestBcd(t *testing.T) {
+	tests := []struct {
+		digits string
+		bcd    string
+	}{
+		{"001002086", "00012080f6"},
+		{"0000000001", "0000000010"},
+		{"1", "f1"},
+	}
+	for _, tc := range tests {
+		bcd, err := encodeBcd(tc.digits)
+		if err != nil {
+			t.Fatalf("encodeBcd(%q): %v", tc.digits, err)
+		}
+		if got := hex.EncodeToString(bcd); got != tc.bcd {
+			t.Errorf("encodeBcd(%q) = %s, want %s", tc.digits, got, tc.bcd)
+		}
+		digits, err := decodeBcd(bcd)
+		if err != nil {
+			t.Fatalf("decodeBcd(%s): %v", tc.bcd, err)
+		}
+		if digits != tc.digits {
+			t.Errorf("decodeBcd(%s) = %s, want %s", tc.bcd, digits, tc.digits)
+		}
+	}
+}
+
+func FuzzSuci2Supi(f *testing.F) {
+	for _, tc := range annexC4Vectors {
+		f.Add(tc.profile, tc.hnPrivKey, tc.schemeOutput)
+	}
+	f.Add("B", annexC4Vectors[1].hnPrivKey, "02"+strings.Repeat("00", 48))
+	f.Fuzz(func(t *testing.T, profile, hnPrivKey, schemeOutput string) {
+		// Malformed keys and outputs must be reported, never panic
+		msin, err := Suci2Supi(profile, hnPrivKey, schemeOutput)
+		if err == nil && strings.Trim(msin, "0123456789") != "" {
+			t.Errorf("Suci2Supi returned non digit MSIN %q", msin)
+		}
+	})
+}
+
+func FuzzSupi2Suci(f *testing.F) {
+	for _, tc := range annexC4Vectors {
+		f.Add(tc.profile, tc.hnPubKey, tc.ephPrivKey, tc.msin)
+	}
+	f.Add("B", "03"+strings.Repeat("ff", 32), annexC4Vectors[1].ephPrivKey, "0000000001")
+	f.Fuzz(func(t *testing.T, profile, hnPubKey, ephPrivKey, msin string) {
+		_, _ = Supi2Suci(profile, hnPubKey, ephPrivKey, msin)
+	})
+}
+
+func FuzzDecompressPubkey(f *testing.F) {
+	for _, tc := range annexC4Vectors[1:] {
+		pubKey, _ := hex.DecodeString(tc.hnPubKey)
+		f.Add(pubKey)
+	}
+	f.Fuzz(func(t *testing.T, pubKey []byte) {
+		key, err := DecompressPubkey(pubKey)
+		if err != nil {
+			return
+		}
+		if err := checkOnCurve(key.Curve, key.X, key.Y); err != nil {
+			t.Errorf("decompressed point %x is not on the curve", pubKey)
+		}
+	})
+}
@@ -1,13 +1,14 @@
 package utils
 
 import (
+	"backend-webUE/aka"
+	"backend-webUE/milenage"
 	"backend-webUE/models"
-	"backend-webUE/supi-key"
-	"crypto/md5"
+	crand "crypto/rand"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"math/rand"
-	"strconv"
 	"strings"
 	"time"
 )
@@ -27,23 +28,23 @@ const (
 	OP  = "OP"
 )
 
+// authentication methods, named as in TS 29.503 AuthMethod
+const (
+	AUTH_5G_AKA        = "5G_AKA"
+	AUTH_EAP_AKA_PRIME = "EAP_AKA_PRIME"
+)
+
 func init() {
 	rand.Seed(time.Now().UnixNano())
 }
 
-var letters = []rune("abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ")
-
-func randSeq(n int) string {
-	b := make([]rune, n)
-	for i := range b {
-		b[i] = letters[rand.Intn(len(letters))]
+// randHex returns n random octets from crypto/rand, hex encoded
+func randHex(n int) string {
+	b := make([]byte, n)
+	if _, err := crand.Read(b); err != nil {
+		panic(fmt.Sprintf("crypto/rand failed: %v", err))
 	}
-	return string(b)
-}
-
-func md5Hash(text string) string {
-	hash := md5.Sum([]byte(text))
-	return hex.EncodeToString(hash[:])
+	return hex.EncodeToString(b)
 }
 
 func generateRandomMsisdn(length int) string {
@@ -59,6 +60,7 @@ func generateRandomMsisdn(length int) string {
 type OperatorConfig struct {
 	PlmnId            models.PlmnId
 	Amf               string
+	Op                string
 	UeConfiguredNssai []models.Snssai
 	UeDefaultNssai    []models.Snssai
 	Profiles          []models.Profile
@@ -69,8 +71,15 @@ type OperatorConfig struct {
 	Ciphering         models.Ciphering
 	IntegrityMaxRate  models.IntegrityMaxRate
 	GnbSearchList     []string
+	// First MSIN allocated to generated UEs
+	MsinStart uint64
+	// Routing indicator of the SUCIs of generated UEs
+	RoutingIndicator string
 }
 
+// DefaultRoutingIndicator is the routing indicator of operators that do not set one
+const DefaultRoutingIndicator = "0000"
+
 type Operator struct {
 	config *OperatorConfig
 }
@@ -81,9 +90,28 @@ func NewOperator(cfg *OperatorConfig) *Operator {
 	}
 }
 
-func (o *Operator) GenerateUe() *models.UeProfile {
-	ue := &models.UeProfile{
+// Config returns the configuration the operator generates UEs from
+func (o *Operator) Config() *OperatorConfig {
+	return o.config
+}
+
+// GenerateOptions selects the per-UE choices of GenerateUe
+type GenerateOptions struct {
+	// Protection scheme of the SUCI: NULL_SCHEME, A_SCHEME or B_SCHEME
+	Scheme int
+	// OP to use the operator's shared OP, OPC for a per-UE OPc
+	OpType string
+	// AUTH_5G_AKA or AUTH_EAP_AKA_PRIME
+	AuthenticationMethod string
+	// MSIN of the SUPI, allocated by the caller
+	Msin uint64
+}
+
+// baseUe returns a UE with the settings shared by all UEs of the operator
+func (o *Operator) baseUe() *models.UeProfile {
+	return &models.UeProfile{
 		PlmnId:           o.config.PlmnId,
+		RoutingIndicator: o.config.RoutingIndicator,
 		Amf:              o.config.Amf,
 		ConfiguredSlice:  o.config.UeConfiguredNssai,
 		DefaultSlice:     o.config.UeDefaultNssai,
@@ -96,43 +124,144 @@ func (o *Operator) GenerateUe() *models.UeProfile {
 		IntegrityMaxRate: o.config.IntegrityMaxRate,
 		GnbSearchList:    o.config.GnbSearchList,
 	}
+}
 
-	// Generate random values for the UE profile
-	profileIndx := randomProfile()
-	ue.Supi = o.randSupi()
-
-	// Call toSuci and handle errors
-	suci, err := o.toSuci(ue.Supi, profileIndx)
-	if err != nil {
-		fmt.Printf("Error generating SUCI: %v\n", err)
-		ue.Suci = ""
-	} else {
-		ue.Suci = suci
-	}
+// GenerateUe generates a UE with the given options, it fails when OPc cannot be derived or the
+// SUPI cannot be concealed
+func (o *Operator) GenerateUe(opts GenerateOptions) (*models.UeProfile, error) {
+	ue := o.baseUe()
 
+	// Generate random values for the UE profile
+	ue.Supi = o.Supi(opts.Msin)
 	ue.Key = o.randUeKey()
-	ue.Op = o.randOp()
 	ue.Imei = o.randImei()
 	ue.Imeisv = o.randImeiSv()
-	ue.KeyPair = o.getKeyPair()
-	ue.HomeNetworkPrivateKey = hex.EncodeToString(ue.KeyPair.GetPrivKey())
-	ue.HomeNetworkPublicKey = hex.EncodeToString(ue.KeyPair.GetPubKey())
-
-	// Assign OP or OPC based on random value
-	value := rand.Intn(2)
-	if value == 0 {
-		ue.OpType = OP
+
+	// The UE either shares the operator OP or gets its own OP, OPc is always derived and stored
+	ue.OpType = opts.OpType
+	if opts.OpType == OP {
+		ue.Op = o.config.Op
 	} else {
-		ue.OpType = OPC
+		ue.Op = o.randOp()
 	}
+	opc, err := milenage.GenerateOPcHex(ue.Key, ue.Op)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive OPc of %s: %v", ue.Supi, err)
+	}
+	ue.Opc = opc
+	ue.Sqn = aka.InitialSqn
+	ue.AuthenticationMethod = opts.AuthenticationMethod
 
-	// Call GenProfile to set the protection scheme and public key based on profile index
-	err = GenProfile(ue, profileIndx, o.config.Profiles)
+	// Call GenProfile to set the protection scheme and home network key of the scheme
+	if err := GenProfile(ue, opts.Scheme, o.config.Profiles); err != nil {
+		return nil, fmt.Errorf("UE %s: %v", ue.Supi, err)
+	}
+
+	// Conceal the SUPI with the operator's routing indicator and the key selected above
+	suci, err := ConcealSupi(ue)
 	if err != nil {
-		fmt.Printf("Error in GenProfile: %v\n", err)
+		return nil, fmt.Errorf("failed to generate SUCI of %s: %v", ue.Supi, err)
 	}
+	ue.Suci = suci.String()
+
+	return ue, nil
+}
 
-	return ue
+// ImportedUe is the subscription of a UE taken over from a core network
+type ImportedUe struct {
+	Supi string
+	Key  string
+	// OP of the UE, only used when Opc is empty
+	Op                   string
+	Opc                  string
+	Amf                  string
+	Sqn                  string
+	AuthenticationMethod string
+	Imeisv               string
+	// Slices and sessions of the subscription, the operator's when empty
+	DefaultSlice    []models.Snssai
+	ConfiguredSlice []models.Snssai
+	Sessions        []models.Sessions
+}
+
+// ImportUe builds a UE profile from a subscription of a core network: the operator settings complete it
+// and the SUPI is concealed with the operator's home network key of the scheme
+func (o *Operator) ImportUe(in ImportedUe, scheme int) (*models.UeProfile, error) {
+	prefix := "imsi-" + o.config.PlmnId.Mcc + o.config.PlmnId.Mnc
+	msin := strings.TrimPrefix(in.Supi, prefix)
+	if !strings.HasPrefix(in.Supi, prefix) || len(msin) < 9 || len(msin) > 10 || !digitsRegexp.MatchString(msin) {
+		return nil, fmt.Errorf("SUPI %s is not an IMSI of PLMN %s-%s", in.Supi, o.config.PlmnId.Mcc, o.config.PlmnId.Mnc)
+	}
+	if len(in.Key) != 2*milenage.KeyLen || !hexRegexp.MatchString(in.Key) {
+		return nil, fmt.Errorf("K must be %d hex digits", 2*milenage.KeyLen)
+	}
+
+	ue := o.baseUe()
+	ue.Supi = in.Supi
+	ue.Key = strings.ToLower(in.Key)
+	ue.Imei = o.randImei()
+	ue.Imeisv = in.Imeisv
+	if ue.Imeisv == "" {
+		ue.Imeisv = o.randImeiSv()
+	}
+
+	// Cores store OP, OPc or both. OP is kept when present, a UE with only an OPc is kept as such.
+	if in.Opc != "" && (len(in.Opc) != 2*milenage.KeyLen || !hexRegexp.MatchString(in.Opc)) {
+		return nil, fmt.Errorf("OPc must be %d hex digits", 2*milenage.KeyLen)
+	}
+	switch {
+	case in.Op != "":
+		opc, err := milenage.GenerateOPcHex(in.Key, in.Op)
+		if err != nil {
+			return nil, fmt.Errorf("failed to derive OPc: %v", err)
+		}
+		if in.Opc != "" && !strings.EqualFold(in.Opc, opc) {
+			return nil, fmt.Errorf("OPc %s does not match the OPc %s derived from OP", strings.ToLower(in.Opc), opc)
+		}
+		ue.OpType, ue.Op, ue.Opc = OP, strings.ToLower(in.Op), opc
+	case in.Opc != "":
+		ue.OpType, ue.Op, ue.Opc = OPC, strings.ToLower(in.Opc), strings.ToLower(in.Opc)
+	default:
+		return nil, fmt.Errorf("subscription has neither OP nor OPc")
+	}
+
+	if in.Amf != "" {
+		if len(in.Amf) != 4 || !hexRegexp.MatchString(in.Amf) {
+			return nil, fmt.Errorf("AMF must be 4 hex digits, got %q", in.Amf)
+		}
+		ue.Amf = strings.ToLower(in.Amf)
+	}
+	ue.Sqn = aka.InitialSqn
+	if in.Sqn != "" {
+		if len(in.Sqn) != 2*milenage.SqnLen || !hexRegexp.MatchString(in.Sqn) {
+			return nil, fmt.Errorf("SQN must be %d hex digits, got %q", 2*milenage.SqnLen, in.Sqn)
+		}
+		ue.Sqn = strings.ToLower(in.Sqn)
+	}
+	ue.AuthenticationMethod = AUTH_5G_AKA
+	if in.AuthenticationMethod != "" {
+		if err := ValidateAuthenticationMethod(in.AuthenticationMethod); err != nil {
+			return nil, err
+		}
+		ue.AuthenticationMethod = in.AuthenticationMethod
+	}
+
+	if len(in.DefaultSlice) > 0 || len(in.ConfiguredSlice) > 0 {
+		ue.DefaultSlice, ue.ConfiguredSlice = in.DefaultSlice, in.ConfiguredSlice
+	}
+	if len(in.Sessions) > 0 {
+		ue.Sessions = in.Sessions
+	}
+
+	if err := GenProfile(ue, scheme, o.config.Profiles); err != nil {
+		return nil, err
+	}
+	suci, err := ConcealSupi(ue)
+	if err != nil {
+		return nil, fmt.Errorf("failed to conceal SUPI: %v", err)
+	}
+	ue.Suci = suci.String()
+	return ue, nil
 }
 
 // type of scheme
@@ -142,130 +271,130 @@ const (
 	B_SCHEME    = 2
 )
 
-// GenProfile function to set the protection scheme and public key
-func GenProfile(ue *models.UeProfile, profileIndx int, profiles []models.Profile) error {
-
-	switch profileIndx {
-	case A_SCHEME:
-		ue.ProtectionScheme = A_SCHEME
-		ue.HomeNetworkPublicKey = profiles[0].PublicKey
-		ue.HomeNetworkPublicKeyId = A_SCHEME
-		ue.RoutingIndicator = "0000"
-	case B_SCHEME:
-		ue.ProtectionScheme = B_SCHEME
-		ue.HomeNetworkPublicKey = profiles[1].PublicKey
-		ue.HomeNetworkPublicKeyId = B_SCHEME
-		ue.RoutingIndicator = "0000"
-	default:
-		ue.ProtectionScheme = NULL_SCHEME
-		ue.HomeNetworkPublicKey = ""
-		ue.HomeNetworkPublicKeyId = NULL_SCHEME
-		ue.RoutingIndicator = "0000"
-	}
+var ErrInvalidGenerateOptions = errors.New("invalid generation options")
 
-	return nil
+// SchemeMix gives the relative weight of each protection scheme among generated UEs
+type SchemeMix struct {
+	Null int `json:"null"`
+	A    int `json:"A"`
+	B    int `json:"B"`
 }
 
-func randomProfile() int {
-	//profile := rand.Intn(2) + 1 //skip profile 2
-	profile := 1
-	return profile
+// Pick draws a protection scheme according to the weights
+func (m SchemeMix) Pick() int {
+	n := rand.Intn(m.Null + m.A + m.B)
+	switch {
+	case n < m.Null:
+		return NULL_SCHEME
+	case n < m.Null+m.A:
+		return A_SCHEME
+	default:
+		return B_SCHEME
+	}
 }
 
-func (o *Operator) randUeKey() string {
-	return md5Hash(randSeq(16))
+// ValidateSchemeMix checks the weights and that the operator has a key for every selected scheme
+func (o *Operator) ValidateSchemeMix(m SchemeMix) error {
+	if m.Null < 0 || m.A < 0 || m.B < 0 {
+		return fmt.Errorf("%w: weights must not be negative", ErrInvalidGenerateOptions)
+	}
+	if m.Null+m.A+m.B == 0 {
+		return fmt.Errorf("%w: at least one weight must be positive", ErrInvalidGenerateOptions)
+	}
+	if m.A > 0 && hnProfile(o.config.Profiles, A_SCHEME) == nil {
+		return fmt.Errorf("%w: operator has no profile A home network key", ErrInvalidGenerateOptions)
+	}
+	if m.B > 0 && hnProfile(o.config.Profiles, B_SCHEME) == nil {
+		return fmt.Errorf("%w: operator has no profile B home network key", ErrInvalidGenerateOptions)
+	}
+	return nil
 }
 
-func (o *Operator) randOp() string {
-	return md5Hash(randSeq(16))
+// ValidateAuthenticationMethod checks that method is AUTH_5G_AKA or AUTH_EAP_AKA_PRIME
+func ValidateAuthenticationMethod(method string) error {
+	if method != AUTH_5G_AKA && method != AUTH_EAP_AKA_PRIME {
+		return fmt.Errorf("%w: authentication method must be %s or %s, got %q", ErrInvalidGenerateOptions, AUTH_5G_AKA, AUTH_EAP_AKA_PRIME, method)
+	}
+	return nil
 }
 
-func (o *Operator) randSupi() string {
-	mcc := o.config.PlmnId.Mcc
-	mnc := o.config.PlmnId.Mnc
-	mcclen := len(mcc)
-	mnclen := len(mnc)
-	msisdnlen := 15 - mcclen - mnclen
-	prefix := mcc + mnc
-	msisdn := generateRandomMsisdn(msisdnlen)
-	return "imsi-" + prefix + msisdn
+// ValidateOpType checks that the operator can generate UEs with the OP type
+func (o *Operator) ValidateOpType(opType string) error {
+	switch opType {
+	case OP:
+		if o.config.Op == "" {
+			return fmt.Errorf("%w: operator has no shared OP configured", ErrInvalidGenerateOptions)
+		}
+	case OPC:
+	default:
+		return fmt.Errorf("%w: OP type must be %s or %s, got %q", ErrInvalidGenerateOptions, OP, OPC, opType)
+	}
+	return nil
 }
 
-func (o *Operator) toSuci(supii string, profile int) (string, error) {
-	// Validate if profiles exist in the configuration
-	if len(o.config.Profiles) < profile || profile <= 0 {
-		return "", fmt.Errorf("invalid profile index: %d", profile)
+// hnProfile returns the first home network key profile of a scheme
+func hnProfile(profiles []models.Profile, scheme int) *models.Profile {
+	for i := range profiles {
+		if profiles[i].Scheme == scheme {
+			return &profiles[i]
+		}
 	}
+	return nil
+}
 
-	// Extract the profile information
-	var profileText string
-	var hnPubKey string
-	if profile == 1 {
-		profileText = "A"
-		hnPubKey = o.config.Profiles[0].PublicKey
-	} else if profile == 2 {
-		profileText = "B"
-		hnPubKey = o.config.Profiles[0].PublicKey
-	} else {
-		return "", fmt.Errorf("unsupported profile: %d", profile)
+// GenProfile function to set the protection scheme and home network key
+func GenProfile(ue *models.UeProfile, scheme int, profiles []models.Profile) error {
+	switch scheme {
+	case A_SCHEME, B_SCHEME:
+		profile := hnProfile(profiles, scheme)
+		if profile == nil {
+			return fmt.Errorf("no home network key for scheme %d", scheme)
+		}
+		ue.ProtectionScheme = scheme
+		ue.HomeNetworkPrivateKey = profile.PrivateKey
+		ue.HomeNetworkPublicKey = profile.PublicKey
+		ue.HomeNetworkPublicKeyId = profile.KeyId
+	default:
+		ue.ProtectionScheme = NULL_SCHEME
+		ue.HomeNetworkPrivateKey = ""
+		ue.HomeNetworkPublicKey = ""
+		ue.HomeNetworkPublicKeyId = NULL_SCHEME
 	}
 
-	// Generate key pair
-	var x supi.X25519
-	x.GenerateKeyPair()
-	ephprivKey := x.GetPrivKey()
-	// Validate SUPI format
-	parts := strings.Split(supii, "-")
-	if len(parts) < 2 {
-		return "", fmt.Errorf("invalid SUPI format: %s", supii)
-	}
-	prefix := parts[0]
+	return nil
+}
 
-	// Handle SUPI prefix types
-	if prefix == "suci" {
-		return supii, nil // Already a SUCI
-	} else if prefix != "imsi" {
-		return "", fmt.Errorf("unsupported SUPI prefix: %s", prefix)
-	}
+func (o *Operator) randUeKey() string {
+	return randHex(milenage.KeyLen)
+}
 
-	// Validate PLMN configuration
-	if len(o.config.PlmnId.Mcc) == 0 || len(o.config.PlmnId.Mnc) == 0 {
-		return "", fmt.Errorf("missing PLMN ID configuration")
-	}
+func (o *Operator) randOp() string {
+	return randHex(milenage.KeyLen)
+}
+
+// MsinLen is the number of MSIN digits of the operator's 15-digit IMSIs
+func (o *Operator) MsinLen() int {
+	return 15 - len(o.config.PlmnId.Mcc) - len(o.config.PlmnId.Mnc)
+}
 
-	// Extract MCC and MNC
-	mcclen := len(o.config.PlmnId.Mcc)
-	mnclen := len(o.config.PlmnId.Mnc)
-	if len(parts[1]) < mcclen+mnclen {
-		return "", fmt.Errorf("invalid SUPI structure: %s", supii)
+// MsinCount is the number of MSINs of the operator's PLMN
+func (o *Operator) MsinCount() uint64 {
+	count := uint64(1)
+	for i := 0; i < o.MsinLen(); i++ {
+		count *= 10
 	}
-	mnc := parts[1][:mcclen]
-	mcc := parts[1][mcclen : mnclen+mcclen]
-	msin := parts[1][mnclen+mcclen:]
-
-	// Generate SUCI components
-	suciTypeStr := strconv.Itoa(IMSI_TYPE)
-	schemeOutput := supi.Supi2Suci(profileText, hnPubKey, hex.EncodeToString(ephprivKey), msin)
-	routingIndicator := 0
-	routingIndicatorStr := strconv.Itoa(routingIndicator)
-	profileStr := strconv.Itoa(profile)
-
-	// Build SUCI string
-	suci := strings.Join([]string{
-		SUCI_PREFIX, suciTypeStr, mnc, mcc, routingIndicatorStr, profileStr, profileStr, hnPubKey, schemeOutput,
-	}, "-")
-
-	return suci, nil
+	return count
 }
 
-func (o *Operator) getKeyPair() (x supi.X25519) {
-	x.GenerateKeyPair()
-	return
+// Supi returns the SUPI of an MSIN within the operator's PLMN
+func (o *Operator) Supi(msin uint64) string {
+	return fmt.Sprintf("imsi-%s%s%0*d", o.config.PlmnId.Mcc, o.config.PlmnId.Mnc, o.MsinLen(), msin)
 }
 
 func (o *Operator) randImei() string {
-	// Generate a random IMEI
-	return generateRandomMsisdn(15)
+	// Generate a random IMEI with a valid check digit
+	imei := generateRandomMsisdn(14)
+	return imei + string(luhnCheckDigit(imei))
 }
 
 func (o *Operator) randImeiSv() string {
@@ -0,0 +1,484 @@
+package utils
+
+import (
+	"backend-webUE/models"
+	"backend-webUE/supi-key"
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	digitsRegexp = regexp.MustCompile(`^[0-9]+$`)
+	hexRegexp    = regexp.MustCompile(`^[0-9a-fA-F]+$`)
+)
+
+// ValidationError lists every invalid field of an operator definition or UE profile
+type ValidationError struct {
+	// What was validated, "operator" when empty
+	Subject string
+	Errors  []string
+}
+
+func (e *ValidationError) Error() string {
+	subject := e.Subject
+	if subject == "" {
+		subject = "operator"
+	}
+	return "invalid " + subject + ": " + strings.Join(e.Errors, "; ")
+}
+
+// ueGenFile mirrors the layout of config/ue-gen.json
+type ueGenFile struct {
+	Db               *Free5gcDbConfig        `json:"db"`
+	Open5gs          *Open5gsDbConfig        `json:"open5gs"`
+	Udr              *NudrConfig             `json:"udr"`
+	Ues              int                     `json:"ues"`
+	PlmnId           models.PlmnId           `json:"plmnid"`
+	Profiles         []ueGenProfile          `json:"profiles"`
+	Amf              string                  `json:"amf"`
+	Op               string                  `json:"op"`
+	MsinStart        string                  `json:"msinStart"`
+	RoutingIndicator string                  `json:"routingIndicator"`
+	ConfiguredNssai  []models.Snssai         `json:"configured-nssai"`
+	DefaultNssai     []models.Snssai         `json:"default-nssai"`
+	GnbSearchList    []string                `json:"gnbSearchList"`
+	UacAic           models.UacAic           `json:"uacAic"`
+	UacAcc           models.UacAcc           `json:"uacAcc"`
+	Sessions         []models.Sessions       `json:"sessions"`
+	Integrity        models.Integrity        `json:"integrity"`
+	Ciphering        models.Ciphering        `json:"ciphering"`
+	IntegrityMaxRate models.IntegrityMaxRate `json:"integrityMaxRate"`
+}
+
+// Free5gcDbConfig is the "db" section of ue-gen.json: the free5GC MongoDB and the
+// subscriber collections UE profiles are provisioned into
+type Free5gcDbConfig struct {
+	// Provision UE profiles into free5GC on create, update and delete
+	Enabled bool   `json:"enabled"`
+	Url     string `json:"url"`
+	Name    string `json:"name"`
+	// Authentication subscription collection
+	Auth string `json:"auth"`
+	// Access and mobility subscription collection
+	Am string `json:"am"`
+	// SMF selection subscription collection
+	Smsel string `json:"smsel"`
+	// Session management subscription collection
+	Sessman string `json:"sessman"`
+	// Log the free5GC documents instead of writing them
+	Mock bool `json:"mock"`
+}
+
+// Collection names of a default free5GC deployment
+var defaultFree5gcDbConfig = Free5gcDbConfig{
+	Url:     "mongodb://localhost:27017",
+	Name:    "free5gc",
+	Auth:    "subscriptionData.authenticationData.authenticationSubscription",
+	Am:      "subscriptionData.provisionedData.amData",
+	Smsel:   "subscriptionData.provisionedData.smfSelectionSubscriptionData",
+	Sessman: "subscriptionData.provisionedData.smData",
+}
+
+// DefaultFree5gcDbConfig returns the database and collection names of a default free5GC deployment
+func DefaultFree5gcDbConfig() Free5gcDbConfig {
+	return defaultFree5gcDbConfig
+}
+
+// free5gcDbConfigOf fills in the free5GC defaults of the "db" section
+func free5gcDbConfigOf(section *Free5gcDbConfig) Free5gcDbConfig {
+	config := defaultFree5gcDbConfig
+	if section == nil {
+		return config
+	}
+	config.Enabled, config.Mock = section.Enabled, section.Mock
+	if section.Url != "" {
+		config.Url = section.Url
+	}
+	if section.Name != "" {
+		config.Name = section.Name
+	}
+	if section.Auth != "" {
+		config.Auth = section.Auth
+	}
+	if section.Am != "" {
+		config.Am = section.Am
+	}
+	if section.Smsel != "" {
+		config.Smsel = section.Smsel
+	}
+	if section.Sessman != "" {
+		config.Sessman = section.Sessman
+	}
+	return config
+}
+
+// Open5gsDbConfig is the "open5gs" section of ue-gen.json: the Open5GS MongoDB UE profiles are provisioned into
+type Open5gsDbConfig struct {
+	// Provision UE profiles into Open5GS on create, update and delete
+	Enabled    bool   `json:"enabled"`
+	Url        string `json:"url"`
+	Name       string `json:"name"`
+	Collection string `json:"collection"`
+	// Log the Open5GS documents instead of writing them
+	Mock bool `json:"mock"`
+}
+
+// open5gsDbConfigOf fills in the Open5GS defaults of the "open5gs" section
+func open5gsDbConfigOf(section *Open5gsDbConfig) Open5gsDbConfig {
+	config := Open5gsDbConfig{
+		Url:        "mongodb://localhost:27017",
+		Name:       "open5gs",
+		Collection: "subscribers",
+	}
+	if section == nil {
+		return config
+	}
+	config.Enabled, config.Mock = section.Enabled, section.Mock
+	if section.Url != "" {
+		config.Url = section.Url
+	}
+	if section.Name != "" {
+		config.Name = section.Name
+	}
+	if section.Collection != "" {
+		config.Collection = section.Collection
+	}
+	return config
+}
+
+// NudrConfig is the "udr" section of ue-gen.json: a UDR UE profiles are provisioned into
+// through its Nudr_DataRepository API
+type NudrConfig struct {
+	// Provision UE profiles into the UDR on create, update and delete
+	Enabled bool `json:"enabled"`
+	// API root of the UDR, without the nudr-dr/v1 prefix
+	Url string `json:"url"`
+	// Request timeout in seconds
+	Timeout int `json:"timeout"`
+}
+
+// nudrConfigOf fills in the free5GC UDR defaults of the "udr" section
+func nudrConfigOf(section *NudrConfig) NudrConfig {
+	config := NudrConfig{
+		Url:     "http://127.0.0.4:8000",
+		Timeout: 5,
+	}
+	if section == nil {
+		return config
+	}
+	config.Enabled = section.Enabled
+	if section.Url != "" {
+		config.Url = strings.TrimSuffix(section.Url, "/")
+	}
+	if section.Timeout > 0 {
+		config.Timeout = section.Timeout
+	}
+	return config
+}
+
+type ueGenProfile struct {
+	Scheme     string `json:"scheme"`
+	KeyId      int    `json:"keyid"`
+	PrivateKey string `json:"prvkey"`
+	PublicKey  string `json:"pubkey"`
+}
+
+// UeGenConfig is the content of an ue-gen.json file: the default operator and the core network
+// targets UE profiles are provisioned into
+type UeGenConfig struct {
+	Operator *OperatorConfig
+	// "db" section, empty fields take the free5GC defaults
+	Free5gc Free5gcDbConfig
+	// "open5gs" section, empty fields take the Open5GS defaults
+	Open5gs Open5gsDbConfig
+	// "udr" section, empty fields take the free5GC UDR defaults
+	Nudr NudrConfig
+}
+
+// LoadOperatorConfig reads the default operator of an ue-gen.json file
+func LoadOperatorConfig(path string) (*OperatorConfig, error) {
+	config, err := LoadUeGenConfig(path)
+	if err != nil {
+		return nil, err
+	}
+	return config.Operator, nil
+}
+
+// LoadUeGenConfig reads an ue-gen.json file, validates the operator and maps it onto an OperatorConfig
+func LoadUeGenConfig(path string) (*UeGenConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read operator config: %v", err)
+	}
+
+	var file ueGenFile
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&file); err != nil {
+		return nil, fmt.Errorf("failed to parse operator config %s: %v", path, err)
+	}
+
+	var errs []string
+	if file.Ues < 0 {
+		errs = append(errs, fmt.Sprintf("ues: must not be negative, got %d", file.Ues))
+	}
+
+	op := models.Operator{
+		Name:             "default",
+		PlmnId:           file.PlmnId,
+		Amf:              file.Amf,
+		Op:               file.Op,
+		MsinStart:        file.MsinStart,
+		RoutingIndicator: file.RoutingIndicator,
+		ConfiguredNssai:  file.ConfiguredNssai,
+		DefaultNssai:     file.DefaultNssai,
+		Sessions:         file.Sessions,
+		GnbSearchList:    file.GnbSearchList,
+		UacAic:           file.UacAic,
+		UacAcc:           file.UacAcc,
+		Integrity:        file.Integrity,
+		Ciphering:        file.Ciphering,
+		IntegrityMaxRate: file.IntegrityMaxRate,
+	}
+	for i, p := range file.Profiles {
+		scheme, err := strconv.Atoi(p.Scheme)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("profiles[%d].scheme: must be a number, got %q", i, p.Scheme))
+			continue
+		}
+		op.Profiles = append(op.Profiles, models.Profile{
+			Scheme:     scheme,
+			KeyId:      p.KeyId,
+			PrivateKey: p.PrivateKey,
+			PublicKey:  p.PublicKey,
+		})
+	}
+
+	if err := ValidateOperator(&op); err != nil {
+		errs = append(errs, err.(*ValidationError).Errors...)
+	}
+	if len(errs) > 0 {
+		return nil, fmt.Errorf("invalid operator config %s:\n  %s", path, strings.Join(errs, "\n  "))
+	}
+	return &UeGenConfig{
+		Operator: NewOperatorConfig(&op),
+		Free5gc:  free5gcDbConfigOf(file.Db),
+		Open5gs:  open5gsDbConfigOf(file.Open5gs),
+		Nudr:     nudrConfigOf(file.Udr),
+	}, nil
+}
+
+// NewOperatorConfig builds the generator configuration of an operator definition
+func NewOperatorConfig(op *models.Operator) *OperatorConfig {
+	// Validated by ValidateOperator, an empty start allocates from 0
+	msinStart, _ := strconv.ParseUint(op.MsinStart, 10, 64)
+	routingIndicator := op.RoutingIndicator
+	if routingIndicator == "" {
+		routingIndicator = DefaultRoutingIndicator
+	}
+	return &OperatorConfig{
+		MsinStart:         msinStart,
+		RoutingIndicator:  routingIndicator,
+		PlmnId:            op.PlmnId,
+		Amf:               op.Amf,
+		Op:                op.Op,
+		UeConfiguredNssai: op.ConfiguredNssai,
+		UeDefaultNssai:    op.DefaultNssai,
+		Profiles:          op.Profiles,
+		Sessions:          op.Sessions,
+		UacAic:            op.UacAic,
+		UacAcc:            op.UacAcc,
+		Integrity:         op.Integrity,
+		Ciphering:         op.Ciphering,
+		IntegrityMaxRate:  op.IntegrityMaxRate,
+		GnbSearchList:     op.GnbSearchList,
+	}
+}
+
+// ValidateOperator checks every field of an operator definition.
+// Hex values and slice differentiators are normalized in place.
+func ValidateOperator(op *models.Operator) error {
+	var errs []string
+	addErr := func(field, format string, args ...interface{}) {
+		errs = append(errs, field+": "+fmt.Sprintf(format, args...))
+	}
+
+	// PLMN
+	if len(op.PlmnId.Mcc) != 3 || !digitsRegexp.MatchString(op.PlmnId.Mcc) {
+		addErr("plmnid.mcc", "must be 3 digits, got %q", op.PlmnId.Mcc)
+	}
+	if (len(op.PlmnId.Mnc) != 2 && len(op.PlmnId.Mnc) != 3) || !digitsRegexp.MatchString(op.PlmnId.Mnc) {
+		addErr("plmnid.mnc", "must be 2 or 3 digits, got %q", op.PlmnId.Mnc)
+	}
+
+	// Home network key profiles
+	if len(op.Profiles) == 0 {
+		addErr("profiles", "at least one home network key profile is required")
+	}
+	seenKeyIds := make(map[int]bool)
+	for i := range op.Profiles {
+		p := &op.Profiles[i]
+		field := fmt.Sprintf("profiles[%d]", i)
+		if p.Scheme != A_SCHEME && p.Scheme != B_SCHEME {
+			addErr(field+".scheme", "must be %d (profile A) or %d (profile B), got %d", A_SCHEME, B_SCHEME, p.Scheme)
+			continue
+		}
+		if p.KeyId == 0 {
+			p.KeyId = p.Scheme
+		}
+		if p.KeyId < 0 || p.KeyId > 255 {
+			addErr(field+".keyId", "must be between 1 and 255, got %d", p.KeyId)
+		}
+		if seenKeyIds[p.KeyId] {
+			addErr(field+".keyId", "duplicate key ID %d", p.KeyId)
+		}
+		seenKeyIds[p.KeyId] = true
+
+		if !validateHnKeyPair(field, p.Scheme, p.PrivateKey, p.PublicKey, addErr) {
+			continue
+		}
+		p.PrivateKey = strings.ToLower(p.PrivateKey)
+		p.PublicKey = strings.ToLower(p.PublicKey)
+	}
+
+	if len(op.Amf) != 4 || !hexRegexp.MatchString(op.Amf) {
+		addErr("amf", "must be 4 hex digits, got %q", op.Amf)
+	}
+	op.Amf = strings.ToLower(op.Amf)
+
+	if op.Op != "" && (len(op.Op) != 32 || !hexRegexp.MatchString(op.Op)) {
+		addErr("op", "must be empty or 32 hex digits, got %q", op.Op)
+	}
+	op.Op = strings.ToLower(op.Op)
+
+	// MSINs have 15 digits less the PLMN, 9 or 10
+	msinLen := 15 - len(op.PlmnId.Mcc) - len(op.PlmnId.Mnc)
+	if op.MsinStart != "" && (len(op.MsinStart) > msinLen || !digitsRegexp.MatchString(op.MsinStart)) {
+		addErr("msinStart", "must be at most %d digits, got %q", msinLen, op.MsinStart)
+	}
+
+	if op.RoutingIndicator != "" && (len(op.RoutingIndicator) > 4 || !digitsRegexp.MatchString(op.RoutingIndicator)) {
+		addErr("routingIndicator", "must be empty or 1 to 4 digits, got %q", op.RoutingIndicator)
+	}
+
+	// Slices
+	validateNssai("configuredNssai", op.ConfiguredNssai, addErr)
+	validateNssai("defaultNssai", op.DefaultNssai, addErr)
+	if len(op.ConfiguredNssai) == 0 {
+		addErr("configuredNssai", "at least one slice is required")
+	}
+
+	if len(op.GnbSearchList) == 0 {
+		addErr("gnbSearchList", "at least one gNB address is required")
+	}
+	for i, addr := range op.GnbSearchList {
+		if net.ParseIP(addr) == nil {
+			addErr(fmt.Sprintf("gnbSearchList[%d]", i), "invalid IP address %q", addr)
+		}
+	}
+
+	if op.UacAcc.NormalClass < 0 || op.UacAcc.NormalClass > 9 {
+		addErr("uacAcc.normalClass", "must be between 0 and 9, got %d", op.UacAcc.NormalClass)
+	}
+
+	// Sessions
+	for i := range op.Sessions {
+		s := &op.Sessions[i]
+		field := fmt.Sprintf("sessions[%d]", i)
+		switch s.Type {
+		case "IPv4", "IPv6", "IPv4v6":
+		default:
+			addErr(field+".type", "must be IPv4, IPv6 or IPv4v6, got %q", s.Type)
+		}
+		if s.Apn == "" {
+			addErr(field+".apn", "must not be empty")
+		}
+		if err := normalizeSnssai(&s.Slice); err != nil {
+			addErr(field+".slice", "%v", err)
+		}
+	}
+
+	if !op.Integrity.IA1 && !op.Integrity.IA2 && !op.Integrity.IA3 {
+		addErr("integrity", "at least one integrity algorithm must be enabled")
+	}
+
+	if !validIntegrityRate(op.IntegrityMaxRate.Uplink) {
+		addErr("integrityMaxRate.uplink", "must be \"full\" or \"64kbps\", got %q", op.IntegrityMaxRate.Uplink)
+	}
+	if !validIntegrityRate(op.IntegrityMaxRate.Downlink) {
+		addErr("integrityMaxRate.downlink", "must be \"full\" or \"64kbps\", got %q", op.IntegrityMaxRate.Downlink)
+	}
+
+	if len(errs) > 0 {
+		return &ValidationError{Errors: errs}
+	}
+	return nil
+}
+
+// validateHnKeyPair checks the key encoding and that the public key belongs to the private key,
+// it reports the failures on the privateKey and publicKey fields of the profile
+func validateHnKeyPair(field string, scheme int, privateKey, publicKey string, addErr func(field, format string, args ...interface{})) bool {
+	if len(privateKey) != 64 || !hexRegexp.MatchString(privateKey) {
+		addErr(field+".privateKey", "must be 64 hex digits")
+		return false
+	}
+
+	var curve supi.EllipticCurve
+	var err error
+	switch scheme {
+	case A_SCHEME:
+		if len(publicKey) != 64 || !hexRegexp.MatchString(publicKey) {
+			addErr(field+".publicKey", "must be 64 hex digits for profile A")
+			return false
+		}
+		curve, err = supi.NewX25519(privateKey)
+	case B_SCHEME:
+		if len(publicKey) != 66 || !hexRegexp.MatchString(publicKey) {
+			addErr(field+".publicKey", "must be a 66 hex digit compressed point for profile B")
+			return false
+		}
+		curve, err = supi.NewSecp256r1(privateKey)
+	}
+	if err != nil {
+		addErr(field+".privateKey", "%v", err)
+		return false
+	}
+
+	if hex.EncodeToString(curve.GetPubKey()) != strings.ToLower(publicKey) {
+		addErr(field+".publicKey", "does not match the private key")
+		return false
+	}
+	return true
+}
+
+func validateNssai(field string, nssai []models.Snssai, addErr func(field, format string, args ...interface{})) {
+	for i := range nssai {
+		if err := normalizeSnssai(&nssai[i]); err != nil {
+			addErr(fmt.Sprintf("%s[%d]", field, i), "%v", err)
+		}
+	}
+}
+
+func validIntegrityRate(rate string) bool {
+	return rate == "full" || rate == "64kbps"
+}
+
+// normalizeSnssai validates a slice and strips the optional 0x prefix of the SD
+func normalizeSnssai(snssai *models.Snssai) error {
+	if snssai.Sst < 0 || snssai.Sst > 255 {
+		return fmt.Errorf("sst must be between 0 and 255, got %d", snssai.Sst)
+	}
+	sd := strings.TrimPrefix(strings.ToLower(snssai.Sd), "0x")
+	if sd != "" && (len(sd) != 6 || !hexRegexp.MatchString(sd)) {
+		return fmt.Errorf("sd must be 6 hex digits, got %q", snssai.Sd)
+	}
+	snssai.Sd = sd
+	return nil
+}
@@ -0,0 +1,100 @@
+package utils
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeUeGenConfig writes the example ue-gen.json with its top-level fields changed, nil values drop a field
+func writeUeGenConfig(t *testing.T, changes map[string]interface{}) string {
+	t.Helper()
+	data, err := os.ReadFile("../config/ue-gen.json")
+	if err != nil {
+		t.Fatalf("read example config: %v", err)
+	}
+	var file map[string]interface{}
+	if err := json.Unmarshal(data, &file); err != nil {
+		t.Fatalf("parse example config: %v", err)
+	}
+	for key, value := range changes {
+		if value == nil {
+			delete(file, key)
+		} else {
+			file[key] = value
+		}
+	}
+	if data, err = json.Marshal(file); err != nil {
+		t.Fatalf("marshal config: %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "ue-gen.json")
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatalf("write config: %v", err)
+	}
+	return path
+}
+
+func TestLoadUeGenConfigDefaults(t *testing.T) {
+	config, err := LoadUeGenConfig(writeUeGenConfig(t, map[string]interface{}{"db": nil, "open5gs": nil, "udr": nil}))
+	if err != nil {
+		t.Fatalf("LoadUeGenConfig: %v", err)
+	}
+	if config.Operator == nil || config.Operator.PlmnId.Mcc != "208" || config.Operator.RoutingIndicator != DefaultRoutingIndicator {
+		t.Errorf("operator = %+v", config.Operator)
+	}
+	if config.Free5gc != DefaultFree5gcDbConfig() {
+		t.Errorf("free5GC config = %+v, want the defaults", config.Free5gc)
+	}
+	if want := (Open5gsDbConfig{Url: "mongodb://localhost:27017", Name: "open5gs", Collection: "subscribers"}); config.Open5gs != want {
+		t.Errorf("Open5GS config = %+v, want %+v", config.Open5gs, want)
+	}
+	if want := (NudrConfig{Url: "http://127.0.0.4:8000", Timeout: 5}); config.Nudr != want {
+		t.Errorf("UDR config = %+v, want %+v", config.Nudr, want)
+	}
+}
+
+func TestLoadUeGenConfigSections(t *testing.T) {
+	config, err := LoadUeGenConfig(writeUeGenConfig(t, map[string]interface{}{
+		"db":      map[string]interface{}{"enabled": true, "name": "core", "am": "am"},
+		"open5gs": map[string]interface{}{"mock": true, "collection": "subs"},
+		"udr":     map[string]interface{}{"enabled": true, "url": "http://udr:8000/"},
+	}))
+	if err != nil {
+		t.Fatalf("LoadUeGenConfig: %v", err)
+	}
+
+	free5gc := DefaultFree5gcDbConfig()
+	free5gc.Enabled, free5gc.Name, free5gc.Am = true, "core", "am"
+	if config.Free5gc != free5gc {
+		t.Errorf("free5GC config = %+v, want %+v", config.Free5gc, free5gc)
+	}
+	if want := (Open5gsDbConfig{Url: "mongodb://localhost:27017", Name: "open5gs", Collection: "subs", Mock: true}); config.Open5gs != want {
+		t.Errorf("Open5GS config = %+v, want %+v", config.Open5gs, want)
+	}
+	if want := (NudrConfig{Enabled: true, Url: "http://udr:8000", Timeout: 5}); config.Nudr != want {
+		t.Errorf("UDR config = %+v, want %+v", config.Nudr, want)
+	}
+}
+
+func TestLoadUeGenConfigErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		changes map[string]interface{}
+		want    string
+	}{
+		{"unknown field", map[string]interface{}{"udm": map[string]interface{}{}}, `unknown field "udm"`},
+		{"unknown section field", map[string]interface{}{"udr": map[string]interface{}{"uri": "http://udr"}}, `unknown field "uri"`},
+		{"invalid operator", map[string]interface{}{"amf": "80"}, "amf: must be 4 hex digits"},
+		{"invalid routing indicator", map[string]interface{}{"routingIndicator": "12345"}, "routingIndicator: must be empty or 1 to 4 digits"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := LoadUeGenConfig(writeUeGenConfig(t, tt.changes))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("LoadUeGenConfig = %v, want an error containing %q", err, tt.want)
+			}
+		})
+	}
+}
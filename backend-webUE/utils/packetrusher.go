@@ -0,0 +1,327 @@
+package utils
+
+import (
+	"backend-webUE/models"
+	"bytes"
+	"fmt"
+	"math/big"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// PacketRusherRangeError explains why a set of UE profiles cannot be written as one
+// PacketRusher multi-UE config, which derives every UE from the first MSIN and shares all other values
+type PacketRusherRangeError struct {
+	Reasons []string
+}
+
+func (e *PacketRusherRangeError) Error() string {
+	reasons := e.Reasons
+	if len(reasons) > maxRangeReasons {
+		reasons = append(reasons[:maxRangeReasons:maxRangeReasons], fmt.Sprintf("and %d more", len(e.Reasons)-maxRangeReasons))
+	}
+	return "UE profiles cannot be expressed as a PacketRusher MSIN range: " + strings.Join(reasons, "; ")
+}
+
+// maxRangeReasons bounds the reasons listed in the message of a PacketRusherRangeError
+const maxRangeReasons = 10
+
+// PacketRusherOptions holds the network side of a PacketRusher config, which UE profiles do not carry
+type PacketRusherOptions struct {
+	// gNB N2/N3 address, defaults to the first gNB in the UE search list
+	GnbIp   string
+	AmfIp   string
+	AmfPort int
+}
+
+type packetRusherSnssai struct {
+	Sst int    `yaml:"sst"`
+	Sd  string `yaml:"sd"`
+}
+
+type packetRusherPlmn struct {
+	Mcc string `yaml:"mcc"`
+	Mnc string `yaml:"mnc"`
+}
+
+type packetRusherIf struct {
+	Ip   string `yaml:"ip"`
+	Port int    `yaml:"port"`
+}
+
+// packetRusherConfig is the layout of PacketRusher's config/config.yml
+type packetRusherConfig struct {
+	GNodeB struct {
+		ControlIf packetRusherIf `yaml:"controlif"`
+		DataIf    packetRusherIf `yaml:"dataif"`
+		PlmnList  struct {
+			Mcc   string `yaml:"mcc"`
+			Mnc   string `yaml:"mnc"`
+			Tac   string `yaml:"tac"`
+			GnbId string `yaml:"gnbid"`
+		} `yaml:"plmnlist"`
+		SliceSupportList packetRusherSnssai `yaml:"slicesupportlist"`
+	} `yaml:"gnodeb"`
+	Ue struct {
+		Msin                   string             `yaml:"msin"`
+		Key                    string             `yaml:"key"`
+		Opc                    string             `yaml:"opc"`
+		Amf                    string             `yaml:"amf"`
+		Sqn                    string             `yaml:"sqn"`
+		Dnn                    string             `yaml:"dnn"`
+		RoutingIndicator       string             `yaml:"routingindicator"`
+		Hplmn                  packetRusherPlmn   `yaml:"hplmn"`
+		Snssai                 packetRusherSnssai `yaml:"snssai"`
+		ProtectionScheme       int                `yaml:"protectionScheme"`
+		HomeNetworkPublicKey   string             `yaml:"homeNetworkPublicKey"`
+		HomeNetworkPublicKeyId int                `yaml:"homeNetworkPublicKeyID"`
+		Integrity              struct {
+			Nia0 bool `yaml:"nia0"`
+			Nia1 bool `yaml:"nia1"`
+			Nia2 bool `yaml:"nia2"`
+			Nia3 bool `yaml:"nia3"`
+		} `yaml:"integrity"`
+		Ciphering struct {
+			Nea0 bool `yaml:"nea0"`
+			Nea1 bool `yaml:"nea1"`
+			Nea2 bool `yaml:"nea2"`
+			Nea3 bool `yaml:"nea3"`
+		} `yaml:"ciphering"`
+	} `yaml:"ue"`
+	AmfIf []packetRusherIf `yaml:"amfif"`
+	Logs  struct {
+		Level int `yaml:"level"`
+	} `yaml:"logs"`
+}
+
+// Msin returns the MSIN of an IMSI type UE profile
+func Msin(ue *models.UeProfile) (string, error) {
+	prefix := "imsi-" + ue.PlmnId.Mcc + ue.PlmnId.Mnc
+	msin := strings.TrimPrefix(ue.Supi, prefix)
+	if ue.PlmnId.Mcc == "" || ue.PlmnId.Mnc == "" || msin == ue.Supi || msin == "" || !digitsRegexp.MatchString(msin) {
+		return "", fmt.Errorf("SUPI %s is not an IMSI of PLMN %s-%s", ue.Supi, ue.PlmnId.Mcc, ue.PlmnId.Mnc)
+	}
+	return msin, nil
+}
+
+// ueOpc is the OPc PacketRusher needs, profiles created before OPc was stored keep it in Op when OpType is OPC
+func ueOpc(ue *models.UeProfile) string {
+	if ue.Opc == "" && ue.OpType == OPC {
+		return ue.Op
+	}
+	return ue.Opc
+}
+
+// ueDnnAndSlice picks the DNN and S-NSSAI of the first PDU session, or the default slice
+func ueDnnAndSlice(ue *models.UeProfile) (string, models.Snssai) {
+	if len(ue.Sessions) > 0 {
+		return ue.Sessions[0].Apn, ue.Sessions[0].Slice
+	}
+	if len(ue.DefaultSlice) > 0 {
+		return "", ue.DefaultSlice[0]
+	}
+	return "", models.Snssai{}
+}
+
+// packetRusherShared lists the values PacketRusher applies to every UE of a multi-UE run
+func packetRusherShared(ue *models.UeProfile) map[string]string {
+	dnn, slice := ueDnnAndSlice(ue)
+	return map[string]string{
+		"PLMN":                 ue.PlmnId.Mcc + "-" + ue.PlmnId.Mnc,
+		"key":                  ue.Key,
+		"OPc":                  ueOpc(ue),
+		"AMF":                  ue.Amf,
+		"SQN":                  ue.Sqn,
+		"routing indicator":    ue.RoutingIndicator,
+		"protection scheme":    fmt.Sprint(ue.ProtectionScheme),
+		"home network key":     fmt.Sprintf("%d/%s", ue.HomeNetworkPublicKeyId, ue.HomeNetworkPublicKey),
+		"DNN and S-NSSAI":      fmt.Sprintf("%s/%d/%s", dnn, slice.Sst, slice.Sd),
+		"integrity algorithms": fmt.Sprintf("%+v", ue.Integrity),
+		"ciphering algorithms": fmt.Sprintf("%+v", ue.Ciphering),
+	}
+}
+
+// PacketRusherRun checks UE profiles added one at a time in MSIN order against the first one:
+// contiguous MSINs of the same length and identical shared values. Only the first profile is kept,
+// the config of a run does not depend on the others.
+type PacketRusherRun struct {
+	first    *models.UeProfile
+	shared   map[string]string
+	msinLen  int
+	prev     *big.Int
+	prevSupi string
+	n        int
+	reasons  []string
+}
+
+// Add checks the next UE profile of the run
+func (r *PacketRusherRun) Add(ue *models.UeProfile) {
+	msin, err := Msin(ue)
+	if err != nil {
+		r.reasons = append(r.reasons, err.Error())
+		return
+	}
+	n, _ := new(big.Int).SetString(msin, 10)
+	if r.first == nil {
+		first := *ue
+		r.first, r.shared, r.msinLen = &first, packetRusherShared(ue), len(msin)
+		r.prev, r.prevSupi, r.n = n, ue.Supi, 1
+		return
+	}
+	if len(msin) != r.msinLen {
+		r.reasons = append(r.reasons, fmt.Sprintf("MSIN of %s has a different length than %s", ue.Supi, r.first.Supi))
+		return
+	}
+
+	if next := new(big.Int).Add(r.prev, big.NewInt(1)); next.Cmp(n) != 0 {
+		if r.prev.Cmp(n) == 0 {
+			r.reasons = append(r.reasons, fmt.Sprintf("duplicate SUPI %s", ue.Supi))
+		} else {
+			r.reasons = append(r.reasons, fmt.Sprintf("MSIN gap between %s and %s", r.prevSupi, ue.Supi))
+		}
+	}
+	shared := packetRusherShared(ue)
+	var differing []string
+	for field, value := range r.shared {
+		if shared[field] != value {
+			differing = append(differing, field)
+		}
+	}
+	if len(differing) > 0 {
+		sort.Strings(differing)
+		r.reasons = append(r.reasons, fmt.Sprintf("%s differs from %s in %s", ue.Supi, r.first.Supi, strings.Join(differing, ", ")))
+	}
+	r.prev, r.prevSupi = n, ue.Supi
+	r.n++
+}
+
+// Err returns a *PacketRusherRangeError when the added profiles do not form one run
+func (r *PacketRusherRun) Err() error {
+	if len(r.reasons) > 0 {
+		return &PacketRusherRangeError{Reasons: r.reasons}
+	}
+	if r.n == 0 {
+		return &PacketRusherRangeError{Reasons: []string{"no UE profiles selected"}}
+	}
+	return nil
+}
+
+// Config renders the run as a PacketRusher config.yml, see PacketRusherConfig
+func (r *PacketRusherRun) Config(opts PacketRusherOptions) ([]byte, error) {
+	if err := r.Err(); err != nil {
+		return nil, err
+	}
+	return renderPacketRusher(r.first, r.n, opts)
+}
+
+// PacketRusherRange checks that UE profiles form one PacketRusher multi-UE run, see PacketRusherRun.
+// It returns the profiles sorted by MSIN, the first one being the base UE of the config.
+func PacketRusherRange(ues []models.UeProfile) ([]models.UeProfile, error) {
+	type rangeUe struct {
+		ue   models.UeProfile
+		msin *big.Int
+	}
+	var run PacketRusherRun
+	sorted := make([]rangeUe, 0, len(ues))
+	for i := range ues {
+		msin, err := Msin(&ues[i])
+		if err != nil {
+			run.Add(&ues[i])
+			continue
+		}
+		n, _ := new(big.Int).SetString(msin, 10)
+		sorted = append(sorted, rangeUe{ue: ues[i], msin: n})
+	}
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].msin.Cmp(sorted[j].msin) < 0 })
+
+	out := make([]models.UeProfile, len(sorted))
+	for i := range sorted {
+		out[i] = sorted[i].ue
+		run.Add(&out[i])
+	}
+	if err := run.Err(); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// PacketRusherConfig renders UE profiles as a PacketRusher config.yml whose MSIN is the first of the range.
+// The profiles must pass PacketRusherRange, the number of UEs is passed to packetrusher multi-ue -n.
+func PacketRusherConfig(ues []models.UeProfile, opts PacketRusherOptions) ([]byte, error) {
+	ues, err := PacketRusherRange(ues)
+	if err != nil {
+		return nil, err
+	}
+	return renderPacketRusher(&ues[0], len(ues), opts)
+}
+
+// renderPacketRusher writes the config of a run of n UEs starting at ue
+func renderPacketRusher(ue *models.UeProfile, n int, opts PacketRusherOptions) ([]byte, error) {
+	var c packetRusherConfig
+	gnbIp := opts.GnbIp
+	if gnbIp == "" && len(ue.GnbSearchList) > 0 {
+		gnbIp = ue.GnbSearchList[0]
+	}
+	if gnbIp == "" {
+		gnbIp = "127.0.0.1"
+	}
+	dnn, slice := ueDnnAndSlice(ue)
+	sd := strings.TrimPrefix(slice.Sd, "0x")
+
+	c.GNodeB.ControlIf = packetRusherIf{Ip: gnbIp, Port: 9487}
+	c.GNodeB.DataIf = packetRusherIf{Ip: gnbIp, Port: 2152}
+	c.GNodeB.PlmnList.Mcc = ue.PlmnId.Mcc
+	c.GNodeB.PlmnList.Mnc = ue.PlmnId.Mnc
+	c.GNodeB.PlmnList.Tac = "000001"
+	c.GNodeB.PlmnList.GnbId = "000008"
+	c.GNodeB.SliceSupportList = packetRusherSnssai{Sst: slice.Sst, Sd: sd}
+
+	c.Ue.Msin, _ = Msin(ue)
+	c.Ue.Key = ue.Key
+	c.Ue.Opc = ueOpc(ue)
+	c.Ue.Amf = ue.Amf
+	c.Ue.Sqn = ue.Sqn
+	c.Ue.Dnn = dnn
+	c.Ue.RoutingIndicator = ue.RoutingIndicator
+	if c.Ue.RoutingIndicator == "" {
+		c.Ue.RoutingIndicator = DefaultRoutingIndicator
+	}
+	c.Ue.Hplmn = packetRusherPlmn{Mcc: ue.PlmnId.Mcc, Mnc: ue.PlmnId.Mnc}
+	c.Ue.Snssai = packetRusherSnssai{Sst: slice.Sst, Sd: sd}
+	c.Ue.ProtectionScheme = ue.ProtectionScheme
+	c.Ue.HomeNetworkPublicKey = ue.HomeNetworkPublicKey
+	c.Ue.HomeNetworkPublicKeyId = ue.HomeNetworkPublicKeyId
+	c.Ue.Integrity.Nia1, c.Ue.Integrity.Nia2, c.Ue.Integrity.Nia3 = ue.Integrity.IA1, ue.Integrity.IA2, ue.Integrity.IA3
+	// NEA0 is mandatory for every UE, TS 33.501 clause 5.11.1.1
+	c.Ue.Ciphering.Nea0 = true
+	c.Ue.Ciphering.Nea1, c.Ue.Ciphering.Nea2, c.Ue.Ciphering.Nea3 = ue.Ciphering.EA1, ue.Ciphering.EA2, ue.Ciphering.EA3
+
+	amfIp, amfPort := opts.AmfIp, opts.AmfPort
+	if amfIp == "" {
+		amfIp = "127.0.0.1"
+	}
+	if amfPort == 0 {
+		amfPort = 38412
+	}
+	c.AmfIf = []packetRusherIf{{Ip: amfIp, Port: amfPort}}
+	c.Logs.Level = 4
+
+	var out bytes.Buffer
+	fmt.Fprintf(&out, "# %d UEs from %s, run with: packetrusher multi-ue -n %d\n", n, ue.Supi, n)
+	encoder := yaml.NewEncoder(&out)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(&c); err != nil {
+		return nil, fmt.Errorf("failed to render PacketRusher config: %v", err)
+	}
+	if err := encoder.Close(); err != nil {
+		return nil, fmt.Errorf("failed to render PacketRusher config: %v", err)
+	}
+	return out.Bytes(), nil
+}
+
+// PacketRusherUeConfig renders a single UE profile as a PacketRusher config
+func PacketRusherUeConfig(ue *models.UeProfile) ([]byte, error) {
+	return PacketRusherConfig([]models.UeProfile{*ue}, PacketRusherOptions{})
+}
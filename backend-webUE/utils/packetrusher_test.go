@@ -0,0 +1,96 @@
+package utils
+
+import (
+	"backend-webUE/models"
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// packetRusherTestRun returns n UE profiles of one run starting at exportTestUe, in reverse MSIN order
+func packetRusherTestRun(n int) []models.UeProfile {
+	ues := make([]models.UeProfile, n)
+	for i := range ues {
+		ue := exportTestUe()
+		ue.Supi = "imsi-20893000000000" + string(rune('0'+n-i))
+		ue.Imei = ""
+		ues[i] = *ue
+	}
+	return ues
+}
+
+func TestPacketRusherConfig(t *testing.T) {
+	out, err := PacketRusherConfig(packetRusherTestRun(3), PacketRusherOptions{AmfIp: "10.100.200.16", AmfPort: 38413})
+	if err != nil {
+		t.Fatalf("PacketRusherConfig: %v", err)
+	}
+	checkGolden(t, "packetrusher-run.yml", out)
+
+	// Without options the gNB is the first of the search list and the AMF local
+	ue := exportTestUe()
+	ue.OpType, ue.Opc, ue.Sessions = OPC, "", nil
+	ue.Op = "cd63cb71954a9f4e48a5994e37a02baf"
+	if out, err = PacketRusherUeConfig(ue); err != nil {
+		t.Fatalf("PacketRusherUeConfig: %v", err)
+	}
+	checkGolden(t, "packetrusher-ue.yml", out)
+}
+
+func TestPacketRusherRun(t *testing.T) {
+	ues := packetRusherTestRun(3)
+	var run PacketRusherRun
+	for i := len(ues) - 1; i >= 0; i-- {
+		run.Add(&ues[i])
+	}
+	opts := PacketRusherOptions{GnbIp: "10.100.200.2"}
+	got, err := run.Config(opts)
+	if err != nil {
+		t.Fatalf("Config: %v", err)
+	}
+	want, err := PacketRusherConfig(ues, opts)
+	if err != nil {
+		t.Fatalf("PacketRusherConfig: %v", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("config of the run:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestPacketRusherRangeErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(ues []models.UeProfile) []models.UeProfile
+		want   []string
+	}{
+		{"no UE profiles", func(ues []models.UeProfile) []models.UeProfile { return nil }, []string{"no UE profiles selected"}},
+		{"MSIN gap", func(ues []models.UeProfile) []models.UeProfile { return []models.UeProfile{ues[0], ues[2]} },
+			[]string{"MSIN gap between imsi-208930000000001 and imsi-208930000000003"}},
+		{"duplicate SUPI", func(ues []models.UeProfile) []models.UeProfile { return append(ues, ues[0]) },
+			[]string{"duplicate SUPI imsi-208930000000003"}},
+		{"differing values", func(ues []models.UeProfile) []models.UeProfile {
+			ues[0].Key, ues[0].Sessions[0].Apn = "00112233445566778899aabbccddeeff", "iot"
+			return ues
+		}, []string{"imsi-208930000000003 differs from imsi-208930000000001 in DNN and S-NSSAI, key"}},
+		{"MSIN length", func(ues []models.UeProfile) []models.UeProfile {
+			ues[0].Supi = "imsi-2089300000000003"
+			return ues
+		}, []string{"MSIN of imsi-2089300000000003 has a different length than imsi-208930000000001"}},
+		{"SUPI of another PLMN", func(ues []models.UeProfile) []models.UeProfile {
+			ues[0].Supi = "imsi-001010000000003"
+			return ues
+		}, []string{"SUPI imsi-001010000000003 is not an IMSI of PLMN 208-93"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sorted, err := PacketRusherRange(tt.modify(packetRusherTestRun(3)))
+			var rangeErr *PacketRusherRangeError
+			if !errors.As(err, &rangeErr) {
+				t.Fatalf("PacketRusherRange = %v, %v, want a *PacketRusherRangeError", sorted, err)
+			}
+			if strings.Join(rangeErr.Reasons, "\n") != strings.Join(tt.want, "\n") {
+				t.Errorf("reasons = %q, want %q", rangeErr.Reasons, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,197 @@
+package utils
+
+import (
+	"backend-webUE/models"
+	"backend-webUE/supi-key"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var ErrInvalidSuci = errors.New("invalid SUCI")
+
+var (
+	imsiSuciRegexp = regexp.MustCompile(`^suci-0-([0-9]{3})-([0-9]{2,3})-([0-9]{1,4})-([0-9a-fA-F])-([0-9]{1,3})-(.+)$`)
+	naiSuciRegexp  = regexp.MustCompile(`^suci-([1-7])-(.+?)-([0-9]{1,4})-([0-9a-fA-F])-([0-9]{1,3})-(.+)$`)
+	realmRegexp    = regexp.MustCompile(`^5gc\.mnc([0-9]{3})\.mcc([0-9]{3})\.3gppnetwork\.org$`)
+)
+
+// Suci is the string form of a SUbscription Concealed Identifier (TS 23.003 clause 28.7.3, TS 29.503):
+//
+//	suci-0-<mcc>-<mnc>-<routing indicator>-<scheme>-<key id>-<scheme output>  for IMSI SUPIs
+//	suci-1-<home network id>-<routing indicator>-<scheme>-<key id>-<scheme output>  for NAI SUPIs
+type Suci struct {
+	SupiType int
+	Mcc      string
+	Mnc      string
+	// Realm of the NAI, only set for NAI_TYPE
+	HomeNetworkId          string
+	RoutingIndicator       string
+	ProtectionScheme       int
+	HomeNetworkPublicKeyId int
+	// Plain MSIN or username for the null scheme, hex ECIES output otherwise
+	SchemeOutput string
+}
+
+func (s *Suci) String() string {
+	var homeNetwork []string
+	if s.SupiType == IMSI_TYPE {
+		homeNetwork = []string{s.Mcc, s.Mnc}
+	} else {
+		homeNetwork = []string{s.HomeNetworkId}
+	}
+
+	parts := []string{SUCI_PREFIX, strconv.Itoa(s.SupiType)}
+	parts = append(parts, homeNetwork...)
+	parts = append(parts,
+		s.RoutingIndicator,
+		strconv.FormatInt(int64(s.ProtectionScheme), 16),
+		strconv.Itoa(s.HomeNetworkPublicKeyId),
+		s.SchemeOutput,
+	)
+	return strings.Join(parts, "-")
+}
+
+// ParseSuci parses the canonical string form of an IMSI or NAI type SUCI
+func ParseSuci(suci string) (*Suci, error) {
+	var s Suci
+	var fields []string
+	if m := imsiSuciRegexp.FindStringSubmatch(suci); m != nil {
+		s.SupiType = IMSI_TYPE
+		s.Mcc, s.Mnc = m[1], m[2]
+		fields = m[3:]
+	} else if m := naiSuciRegexp.FindStringSubmatch(suci); m != nil {
+		s.SupiType = NAI_TYPE
+		s.HomeNetworkId = m[2]
+		fields = m[3:]
+	} else {
+		return nil, fmt.Errorf("%w: %q does not match suci-<type>-<home network>-<ri>-<scheme>-<key id>-<output>", ErrInvalidSuci, suci)
+	}
+
+	s.RoutingIndicator = fields[0]
+	scheme, err := strconv.ParseInt(fields[1], 16, 0)
+	if err != nil {
+		return nil, fmt.Errorf("%w: protection scheme %q: %v", ErrInvalidSuci, fields[1], err)
+	}
+	s.ProtectionScheme = int(scheme)
+	if s.HomeNetworkPublicKeyId, err = strconv.Atoi(fields[2]); err != nil {
+		return nil, fmt.Errorf("%w: key ID %q: %v", ErrInvalidSuci, fields[2], err)
+	}
+	s.SchemeOutput = fields[3]
+
+	if s.HomeNetworkPublicKeyId > 255 {
+		return nil, fmt.Errorf("%w: key ID %d out of range", ErrInvalidSuci, s.HomeNetworkPublicKeyId)
+	}
+	if s.ProtectionScheme == NULL_SCHEME && s.HomeNetworkPublicKeyId != 0 {
+		return nil, fmt.Errorf("%w: null scheme requires key ID 0", ErrInvalidSuci)
+	}
+	if s.ProtectionScheme == NULL_SCHEME && s.SupiType == IMSI_TYPE && !digitsRegexp.MatchString(s.SchemeOutput) {
+		return nil, fmt.Errorf("%w: null scheme output must be the MSIN digits", ErrInvalidSuci)
+	}
+
+	// The ECIES output is the ephemeral public key, at least one octet of ciphertext and the MAC tag
+	var minLen int
+	switch s.ProtectionScheme {
+	case A_SCHEME:
+		minLen = supi.ProfileAPubKeyLen + 1 + supi.ProfileAMacLen
+	case B_SCHEME:
+		minLen = supi.ProfileBPubKeyLen + 1 + supi.ProfileBMacLen
+	}
+	if minLen > 0 && (len(s.SchemeOutput)%2 != 0 || len(s.SchemeOutput) < 2*minLen || !hexRegexp.MatchString(s.SchemeOutput)) {
+		return nil, fmt.Errorf("%w: protection scheme %d output must be at least %d hex octets", ErrInvalidSuci, s.ProtectionScheme, minLen)
+	}
+	return &s, nil
+}
+
+// Supi rebuilds the SUPI from the de-concealed scheme input (MSIN or NAI username)
+func (s *Suci) Supi(schemeInput string) string {
+	if s.SupiType == IMSI_TYPE {
+		return "imsi-" + s.Mcc + s.Mnc + schemeInput
+	}
+	return "nai-" + schemeInput + "@" + s.HomeNetworkId
+}
+
+// PlmnIdsFromRealm returns the PLMNs a 3GPP realm (5gc.mncXXX.mccYYY.3gppnetwork.org) may stand for.
+// A 2-digit MNC is padded with a leading zero in the realm, so both readings are returned.
+func PlmnIdsFromRealm(realm string) []models.PlmnId {
+	m := realmRegexp.FindStringSubmatch(strings.ToLower(realm))
+	if m == nil {
+		return nil
+	}
+	plmnIds := []models.PlmnId{{Mcc: m[2], Mnc: m[1]}}
+	if strings.HasPrefix(m[1], "0") {
+		plmnIds = append([]models.PlmnId{{Mcc: m[2], Mnc: m[1][1:]}}, plmnIds...)
+	}
+	return plmnIds
+}
+
+// ConcealSupi builds the SUCI of a UE profile from its SUPI, PLMN, routing indicator,
+// protection scheme and home network public key
+func ConcealSupi(ue *models.UeProfile) (*Suci, error) {
+	s := Suci{
+		RoutingIndicator:       ue.RoutingIndicator,
+		ProtectionScheme:       ue.ProtectionScheme,
+		HomeNetworkPublicKeyId: ue.HomeNetworkPublicKeyId,
+	}
+	if s.RoutingIndicator == "" {
+		s.RoutingIndicator = DefaultRoutingIndicator
+	}
+	if len(s.RoutingIndicator) > 4 || !digitsRegexp.MatchString(s.RoutingIndicator) {
+		return nil, fmt.Errorf("routing indicator must be 1 to 4 digits, got %q", ue.RoutingIndicator)
+	}
+
+	// Split the SUPI into home network and scheme input
+	var schemeInput string
+	switch {
+	case strings.HasPrefix(ue.Supi, "imsi-"):
+		imsi := strings.TrimPrefix(ue.Supi, "imsi-")
+		prefix := ue.PlmnId.Mcc + ue.PlmnId.Mnc
+		if ue.PlmnId.Mcc == "" || ue.PlmnId.Mnc == "" || !strings.HasPrefix(imsi, prefix) {
+			return nil, fmt.Errorf("SUPI %s does not belong to PLMN %s-%s", ue.Supi, ue.PlmnId.Mcc, ue.PlmnId.Mnc)
+		}
+		s.SupiType = IMSI_TYPE
+		s.Mcc, s.Mnc = ue.PlmnId.Mcc, ue.PlmnId.Mnc
+		schemeInput = imsi[len(prefix):]
+		if schemeInput == "" || !digitsRegexp.MatchString(schemeInput) {
+			return nil, fmt.Errorf("invalid MSIN in SUPI %s", ue.Supi)
+		}
+	case strings.HasPrefix(ue.Supi, "nai-"):
+		username, realm, found := strings.Cut(strings.TrimPrefix(ue.Supi, "nai-"), "@")
+		if !found || username == "" || realm == "" {
+			return nil, fmt.Errorf("invalid NAI SUPI %s", ue.Supi)
+		}
+		s.SupiType = NAI_TYPE
+		s.HomeNetworkId = realm
+		schemeInput = username
+	default:
+		return nil, fmt.Errorf("unsupported SUPI format: %s", ue.Supi)
+	}
+
+	var profile string
+	switch s.ProtectionScheme {
+	case NULL_SCHEME:
+		s.HomeNetworkPublicKeyId = 0
+		s.SchemeOutput = schemeInput
+		return &s, nil
+	case A_SCHEME:
+		profile = "A"
+	case B_SCHEME:
+		profile = "B"
+	default:
+		return nil, fmt.Errorf("unsupported protection scheme: %d", s.ProtectionScheme)
+	}
+
+	// A fresh ephemeral key pair on the curve of the profile is generated for every SUCI
+	var err error
+	if s.SupiType == IMSI_TYPE {
+		s.SchemeOutput, err = supi.Supi2Suci(profile, ue.HomeNetworkPublicKey, "", schemeInput)
+	} else {
+		s.SchemeOutput, err = supi.Conceal(profile, ue.HomeNetworkPublicKey, "", []byte(schemeInput))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to conceal SUPI: %v", err)
+	}
+	return &s, nil
+}
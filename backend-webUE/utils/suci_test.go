@@ -0,0 +1,215 @@
+package utils
+
+import (
+	"backend-webUE/models"
+	"backend-webUE/supi-key"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Home network keys of TS 33.501 Annex C.4, the ones of config/ue-gen.json
+const (
+	profileAPrivateKey = "c53c22208b61860b06c62e5406a7b330c2b577aa5558981510d128247d38bd1d"
+	profileAPublicKey  = "5a8d38864820197c3394b92613b20b91633cbd897119273bf8e4a6f4eec0a650"
+	profileBPrivateKey = "f1ab1074477ebcc7f554ea1c5fc368b1616730155e0041ac447d6301975fecda"
+	profileBPublicKey  = "0272da71976234ce833a6907425867b82e074d44ef907dfb4b3e21c1c2256ebcd1"
+)
+
+func TestSuciRoundTrip(t *testing.T) {
+	tests := []struct {
+		name             string
+		supi             string
+		routingIndicator string
+		scheme           int
+		keyId            int
+		// Canonical form up to the scheme output
+		prefix string
+	}{
+		{"null IMSI", "imsi-208930000000001", "0000", NULL_SCHEME, 0, "suci-0-208-93-0000-0-0-0000000001"},
+		{"null IMSI 3-digit MNC", "imsi-310410123456789", "12", NULL_SCHEME, 0, "suci-0-310-410-12-0-0-123456789"},
+		{"null NAI", "nai-alice@example.com", "", NULL_SCHEME, 0, "suci-1-example.com-0000-0-0-alice"},
+		{"profile A IMSI", "imsi-208930000000001", "0000", A_SCHEME, 1, "suci-0-208-93-0000-1-1-"},
+		{"profile B IMSI", "imsi-208930000000001", "1", B_SCHEME, 2, "suci-0-208-93-1-2-2-"},
+		{"profile A NAI", "nai-alice@example.com", "0000", A_SCHEME, 1, "suci-1-example.com-0000-1-1-"},
+		{"profile B NAI", "nai-alice@example.com", "0000", B_SCHEME, 255, "suci-1-example.com-0000-2-255-"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ue := &models.UeProfile{
+				Supi:                   tt.supi,
+				PlmnId:                 models.PlmnId{Mcc: "208", Mnc: "93"},
+				RoutingIndicator:       tt.routingIndicator,
+				ProtectionScheme:       tt.scheme,
+				HomeNetworkPublicKeyId: tt.keyId,
+			}
+			if strings.HasPrefix(tt.supi, "imsi-310410") {
+				ue.PlmnId = models.PlmnId{Mcc: "310", Mnc: "410"}
+			}
+			privateKey := ""
+			switch tt.scheme {
+			case A_SCHEME:
+				ue.HomeNetworkPublicKey, privateKey = profileAPublicKey, profileAPrivateKey
+			case B_SCHEME:
+				ue.HomeNetworkPublicKey, privateKey = profileBPublicKey, profileBPrivateKey
+			}
+
+			concealed, err := ConcealSupi(ue)
+			if err != nil {
+				t.Fatalf("ConcealSupi: %v", err)
+			}
+			suci := concealed.String()
+			if tt.scheme == NULL_SCHEME && suci != tt.prefix || !strings.HasPrefix(suci, tt.prefix) {
+				t.Fatalf("SUCI = %s, want %s", suci, tt.prefix)
+			}
+
+			parsed, err := ParseSuci(suci)
+			if err != nil {
+				t.Fatalf("ParseSuci(%s): %v", suci, err)
+			}
+			if *parsed != *concealed {
+				t.Errorf("ParseSuci(%s) = %+v, want %+v", suci, *parsed, *concealed)
+			}
+			if parsed.String() != suci {
+				t.Errorf("String of the parsed SUCI = %s, want %s", parsed.String(), suci)
+			}
+
+			// The SIDF recovers the SUPI with the home network private key
+			schemeInput := parsed.SchemeOutput
+			if tt.scheme != NULL_SCHEME {
+				profile := map[int]string{A_SCHEME: "A", B_SCHEME: "B"}[tt.scheme]
+				if parsed.SupiType == IMSI_TYPE {
+					schemeInput, err = supi.Suci2Supi(profile, privateKey, parsed.SchemeOutput)
+				} else {
+					var plain []byte
+					plain, err = supi.Deconceal(profile, privateKey, parsed.SchemeOutput)
+					schemeInput = string(plain)
+				}
+				if err != nil {
+					t.Fatalf("deconceal: %v", err)
+				}
+			}
+			if got := parsed.Supi(schemeInput); got != tt.supi {
+				t.Errorf("de-concealed SUPI = %s, want %s", got, tt.supi)
+			}
+		})
+	}
+}
+
+func TestParseSuciErrors(t *testing.T) {
+	profileAOutput := "b2e92f836055a255837debf850b528997ce0201cb82adfe4be1f587d07d8457dcb02352410cddd9e730ef3fa87"
+	tests := []struct {
+		name string
+		suci string
+	}{
+		{"not a SUCI", "imsi-208930000000001"},
+		{"routing indicator too long", "suci-0-208-93-12345-0-0-0000000001"},
+		{"key ID out of range", "suci-0-208-93-0000-1-256-" + profileAOutput},
+		{"null scheme with key ID", "suci-0-208-93-0000-0-1-0000000001"},
+		{"null scheme IMSI output not digits", "suci-0-208-93-0000-0-0-00000000ab"},
+		{"profile A output not hex", "suci-0-208-93-0000-1-1-" + strings.Repeat("z", len(profileAOutput))},
+		{"profile A output odd length", "suci-0-208-93-0000-1-1-" + profileAOutput[1:]},
+		{"profile A output without ciphertext", "suci-0-208-93-0000-1-1-" + profileAOutput[:2*(supi.ProfileAPubKeyLen+supi.ProfileAMacLen)]},
+		{"profile B output of profile A length", "suci-0-208-93-0000-2-2-" + profileAOutput[:2*(supi.ProfileBPubKeyLen+supi.ProfileBMacLen)]},
+		{"profile B NAI output not hex", "suci-1-example.com-0000-2-2-alice"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if s, err := ParseSuci(tt.suci); !errors.Is(err, ErrInvalidSuci) {
+				t.Errorf("ParseSuci(%s) = %+v, %v, want ErrInvalidSuci", tt.suci, s, err)
+			}
+		})
+	}
+
+	// Other protection schemes have no fixed output format
+	if _, err := ParseSuci("suci-0-208-93-0000-f-3-whatever"); err != nil {
+		t.Errorf("ParseSuci of an operator specific scheme: %v", err)
+	}
+}
+
+func TestConcealSupiErrors(t *testing.T) {
+	valid := models.UeProfile{
+		Supi:   "imsi-208930000000001",
+		PlmnId: models.PlmnId{Mcc: "208", Mnc: "93"},
+	}
+	tests := []struct {
+		name   string
+		modify func(ue *models.UeProfile)
+	}{
+		{"routing indicator too long", func(ue *models.UeProfile) { ue.RoutingIndicator = "12345" }},
+		{"routing indicator not digits", func(ue *models.UeProfile) { ue.RoutingIndicator = "12a" }},
+		{"SUPI of another PLMN", func(ue *models.UeProfile) { ue.Supi = "imsi-001010000000001" }},
+		{"NAI without realm", func(ue *models.UeProfile) { ue.Supi = "nai-alice" }},
+		{"unsupported SUPI", func(ue *models.UeProfile) { ue.Supi = "gci-0000" }},
+		{"unsupported scheme", func(ue *models.UeProfile) { ue.ProtectionScheme = 3 }},
+		{"profile A without key", func(ue *models.UeProfile) { ue.ProtectionScheme = A_SCHEME }},
+		{"profile B with profile A key", func(ue *models.UeProfile) {
+			ue.ProtectionScheme, ue.HomeNetworkPublicKey = B_SCHEME, profileAPublicKey
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ue := valid
+			tt.modify(&ue)
+			if s, err := ConcealSupi(&ue); err == nil {
+				t.Errorf("ConcealSupi = %s, want an error", s)
+			}
+		})
+	}
+}
+
+func TestConcealSupiMatchesExports(t *testing.T) {
+	for _, routingIndicator := range []string{"", "0012"} {
+		t.Run("routing indicator "+routingIndicator, func(t *testing.T) {
+			ue := exportTestUe()
+			ue.ProtectionScheme, ue.HomeNetworkPublicKey, ue.HomeNetworkPublicKeyId = NULL_SCHEME, "", 0
+			ue.RoutingIndicator = routingIndicator
+			concealed, err := ConcealSupi(ue)
+			if err != nil {
+				t.Fatalf("ConcealSupi: %v", err)
+			}
+
+			// The null scheme SUCI the UE sends with the exported configs
+			var ueransim struct {
+				Supi             string `yaml:"supi"`
+				Mcc              string `yaml:"mcc"`
+				Mnc              string `yaml:"mnc"`
+				RoutingIndicator string `yaml:"routingIndicator"`
+				ProtectionScheme int    `yaml:"protectionScheme"`
+				KeyId            int    `yaml:"homeNetworkPublicKeyId"`
+			}
+			out, err := UeransimConfig(ue)
+			if err != nil {
+				t.Fatalf("UeransimConfig: %v", err)
+			}
+			if err := yaml.Unmarshal(out, &ueransim); err != nil {
+				t.Fatalf("parse UERANSIM config: %v", err)
+			}
+			var packetRusher struct {
+				Ue struct {
+					Msin             string `yaml:"msin"`
+					RoutingIndicator string `yaml:"routingindicator"`
+				} `yaml:"ue"`
+			}
+			if out, err = PacketRusherUeConfig(ue); err != nil {
+				t.Fatalf("PacketRusherUeConfig: %v", err)
+			}
+			if err := yaml.Unmarshal(out, &packetRusher); err != nil {
+				t.Fatalf("parse PacketRusher config: %v", err)
+			}
+
+			for name, exported := range map[string]string{
+				"UERANSIM": fmt.Sprintf("suci-0-%s-%s-%s-%d-%d-%s", ueransim.Mcc, ueransim.Mnc, ueransim.RoutingIndicator,
+					ueransim.ProtectionScheme, ueransim.KeyId, strings.TrimPrefix(ueransim.Supi, "imsi-"+ueransim.Mcc+ueransim.Mnc)),
+				"PacketRusher": fmt.Sprintf("suci-0-208-93-%s-0-0-%s", packetRusher.Ue.RoutingIndicator, packetRusher.Ue.Msin),
+			} {
+				if concealed.String() != exported {
+					t.Errorf("SUCI = %s, the %s config gives %s", concealed, name, exported)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,136 @@
+package utils
+
+import (
+	"backend-webUE/milenage"
+	"backend-webUE/models"
+	"fmt"
+	"strings"
+)
+
+// luhnCheckDigit computes the Luhn check digit of a digit string
+func luhnCheckDigit(digits string) byte {
+	sum := 0
+	for i := len(digits) - 1; i >= 0; i-- {
+		d := int(digits[i] - '0')
+		// Double every second digit, starting with the rightmost one
+		if (len(digits)-1-i)%2 == 0 {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+	}
+	return byte('0' + (10-sum%10)%10)
+}
+
+// ValidImei checks that an IMEI has 15 digits and a valid Luhn check digit (TS 23.003 6.2.1)
+func ValidImei(imei string) bool {
+	if len(imei) != 15 || !digitsRegexp.MatchString(imei) {
+		return false
+	}
+	return luhnCheckDigit(imei[:14]) == imei[14]
+}
+
+// ValidateUeProfile checks the fields of a UE profile and normalizes the hex values to lower case
+// and the SDs to hex digits without 0x. All invalid fields are listed in a *ValidationError.
+func ValidateUeProfile(ue *models.UeProfile) error {
+	var errs []string
+	addErr := func(field, format string, args ...interface{}) {
+		errs = append(errs, field+": "+fmt.Sprintf(format, args...))
+	}
+	validHex := func(value string, n int) bool {
+		return len(value) == n && hexRegexp.MatchString(value)
+	}
+
+	// SUPI within the PLMN of the UE
+	mcc, mnc := ue.PlmnId.Mcc, ue.PlmnId.Mnc
+	plmnValid := true
+	if len(mcc) != 3 || !digitsRegexp.MatchString(mcc) {
+		addErr("plmnid.mcc", "must be 3 digits, got %q", mcc)
+		plmnValid = false
+	}
+	if (len(mnc) != 2 && len(mnc) != 3) || !digitsRegexp.MatchString(mnc) {
+		addErr("plmnid.mnc", "must be 2 or 3 digits, got %q", mnc)
+		plmnValid = false
+	}
+	imsi := strings.TrimPrefix(ue.Supi, "imsi-")
+	switch {
+	case !strings.HasPrefix(ue.Supi, "imsi-") || !digitsRegexp.MatchString(imsi):
+		addErr("supi", "must be imsi- followed by digits, got %q", ue.Supi)
+	case len(imsi) < 6 || len(imsi) > 15:
+		addErr("supi", "IMSI must have 6 to 15 digits, got %d", len(imsi))
+	case plmnValid && !strings.HasPrefix(imsi, mcc+mnc):
+		addErr("supi", "IMSI %s is not in PLMN %s-%s", imsi, mcc, mnc)
+	}
+
+	// Credentials
+	if !validHex(ue.Key, 2*milenage.KeyLen) {
+		addErr("key", "must be %d hex digits", 2*milenage.KeyLen)
+	}
+	switch ue.OpType {
+	case OP, OPC:
+	default:
+		addErr("opType", "must be %s or %s, got %q", OP, OPC, ue.OpType)
+	}
+	if ue.Op != "" && !validHex(ue.Op, 2*milenage.KeyLen) {
+		addErr("op", "must be %d hex digits", 2*milenage.KeyLen)
+	}
+	if !validHex(ue.Opc, 2*milenage.KeyLen) {
+		addErr("opc", "must be %d hex digits", 2*milenage.KeyLen)
+	}
+	if !validHex(ue.Amf, 4) {
+		addErr("amf", "must be 4 hex digits, got %q", ue.Amf)
+	}
+	if ue.Sqn != "" && !validHex(ue.Sqn, 2*milenage.SqnLen) {
+		addErr("sqn", "must be %d hex digits, got %q", 2*milenage.SqnLen, ue.Sqn)
+	}
+	if ue.AuthenticationMethod != "" && ValidateAuthenticationMethod(ue.AuthenticationMethod) != nil {
+		addErr("authenticationMethod", "must be %s or %s, got %q", AUTH_5G_AKA, AUTH_EAP_AKA_PRIME, ue.AuthenticationMethod)
+	}
+	ue.Key, ue.Op, ue.Opc = strings.ToLower(ue.Key), strings.ToLower(ue.Op), strings.ToLower(ue.Opc)
+	ue.Amf, ue.Sqn = strings.ToLower(ue.Amf), strings.ToLower(ue.Sqn)
+
+	// Equipment identities
+	if ue.Imei != "" && !ValidImei(ue.Imei) {
+		addErr("imei", "must be 15 digits with a valid Luhn check digit, got %q", ue.Imei)
+	}
+	if ue.Imeisv != "" && (len(ue.Imeisv) != 16 || !digitsRegexp.MatchString(ue.Imeisv)) {
+		addErr("imeiSv", "must be 16 digits, got %q", ue.Imeisv)
+	}
+
+	// SUCI protection
+	switch ue.ProtectionScheme {
+	case NULL_SCHEME:
+	case A_SCHEME, B_SCHEME:
+		if ue.HomeNetworkPublicKey == "" || !hexRegexp.MatchString(ue.HomeNetworkPublicKey) {
+			addErr("homeNetworkPublicKey", "must be hex digits for protection scheme %d", ue.ProtectionScheme)
+		}
+	default:
+		addErr("protectionScheme", "must be %d, %d or %d, got %d", NULL_SCHEME, A_SCHEME, B_SCHEME, ue.ProtectionScheme)
+	}
+
+	// Slices and sessions
+	validateNssai("configuredSlice", ue.ConfiguredSlice, addErr)
+	validateNssai("defaultSlice", ue.DefaultSlice, addErr)
+	for i := range ue.Sessions {
+		s := &ue.Sessions[i]
+		field := fmt.Sprintf("sessions[%d]", i)
+		switch s.Type {
+		case "IPv4", "IPv6", "IPv4v6":
+		default:
+			addErr(field+".type", "must be IPv4, IPv6 or IPv4v6, got %q", s.Type)
+		}
+		if s.Apn == "" {
+			addErr(field+".apn", "must not be empty")
+		}
+		if err := normalizeSnssai(&s.Slice); err != nil {
+			addErr(field+".slice", "%v", err)
+		}
+	}
+
+	if len(errs) > 0 {
+		return &ValidationError{Subject: "UE profile", Errors: errs}
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+package utils
+
+import (
+	"backend-webUE/models"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestValidImei(t *testing.T) {
+	tests := []struct {
+		imei string
+		want bool
+	}{
+		{"490154203237518", true},
+		{"356938035643809", true},
+		{"490154203237519", false},
+		{"49015420323751", false},
+		{"4901542032375180", false},
+		{"49015420323751a", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := ValidImei(tt.imei); got != tt.want {
+			t.Errorf("ValidImei(%q) = %v, want %v", tt.imei, got, tt.want)
+		}
+	}
+}
+
+// validUeProfile is a UE profile ValidateUeProfile accepts, with values it normalizes
+func validUeProfile() *models.UeProfile {
+	return &models.UeProfile{
+		Supi:                 "imsi-208930000000001",
+		PlmnId:               models.PlmnId{Mcc: "208", Mnc: "93"},
+		Key:                  "465B5CE8B199B49FAA5F0A2EE238A6BC",
+		Op:                   "",
+		OpType:               OPC,
+		Opc:                  "CD63CB71954A9F4E48A5994E37A02BAF",
+		Amf:                  "8000",
+		Sqn:                  "0000000000E0",
+		AuthenticationMethod: AUTH_5G_AKA,
+		Imei:                 "490154203237518",
+		Imeisv:               "4901542032375181",
+		DefaultSlice:         []models.Snssai{{Sst: 1, Sd: "0x0A0B0C"}},
+		ConfiguredSlice:      []models.Snssai{{Sst: 1, Sd: "0x0A0B0C"}, {Sst: 2}},
+		Sessions:             []models.Sessions{{Type: "IPv4", Apn: "internet", Slice: models.Snssai{Sst: 1, Sd: "0A0B0C"}}},
+	}
+}
+
+func TestValidateUeProfileNormalizes(t *testing.T) {
+	ue := validUeProfile()
+	if err := ValidateUeProfile(ue); err != nil {
+		t.Fatalf("ValidateUeProfile: %v", err)
+	}
+	if ue.Key != "465b5ce8b199b49faa5f0a2ee238a6bc" || ue.Opc != "cd63cb71954a9f4e48a5994e37a02baf" || ue.Sqn != "0000000000e0" {
+		t.Errorf("hex values not lower case: key %s OPc %s SQN %s", ue.Key, ue.Opc, ue.Sqn)
+	}
+	want := []models.Snssai{{Sst: 1, Sd: "0a0b0c"}, {Sst: 2}}
+	if !reflect.DeepEqual(ue.ConfiguredSlice, want) || ue.DefaultSlice[0] != want[0] || ue.Sessions[0].Slice != want[0] {
+		t.Errorf("slices = %+v %+v %+v, want SDs without 0x", ue.DefaultSlice, ue.ConfiguredSlice, ue.Sessions[0].Slice)
+	}
+}
+
+func TestValidateUeProfileErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(ue *models.UeProfile)
+		// Errors in order
+		want []string
+	}{
+		{"IMEI check digit", func(ue *models.UeProfile) { ue.Imei = "490154203237519" }, []string{"imei: must be 15 digits with a valid Luhn check digit"}},
+		{"IMEI length", func(ue *models.UeProfile) { ue.Imei = "49015420323751" }, []string{"imei: must be 15 digits"}},
+		{"IMEISV length", func(ue *models.UeProfile) { ue.Imeisv = "490154203237518" }, []string{"imeiSv: must be 16 digits"}},
+		{"short key", func(ue *models.UeProfile) { ue.Key = ue.Key[1:] }, []string{"key: must be 32 hex digits"}},
+		{"key not hex", func(ue *models.UeProfile) { ue.Key = "g" + ue.Key[1:] }, []string{"key: must be 32 hex digits"}},
+		{"long OP", func(ue *models.UeProfile) { ue.Op = ue.Opc + "00" }, []string{"op: must be 32 hex digits"}},
+		{"short OPc", func(ue *models.UeProfile) { ue.Opc = ue.Opc[:30] }, []string{"opc: must be 32 hex digits"}},
+		{"AMF", func(ue *models.UeProfile) { ue.Amf = "80000" }, []string{"amf: must be 4 hex digits"}},
+		{"SQN", func(ue *models.UeProfile) { ue.Sqn = "e0" }, []string{"sqn: must be 12 hex digits"}},
+		{"OP type", func(ue *models.UeProfile) { ue.OpType = "XOR" }, []string{"opType: must be OP or OPC"}},
+		{"authentication method", func(ue *models.UeProfile) { ue.AuthenticationMethod = "EAP_AKA" }, []string{"authenticationMethod: must be"}},
+		{"SST", func(ue *models.UeProfile) { ue.ConfiguredSlice[1].Sst = 256 }, []string{"configuredSlice[1]: sst must be between 0 and 255"}},
+		{"SD length", func(ue *models.UeProfile) { ue.DefaultSlice[0].Sd = "0x0A0B" }, []string{"defaultSlice[0]: sd must be 6 hex digits"}},
+		{"SD not hex", func(ue *models.UeProfile) { ue.Sessions[0].Slice.Sd = "0a0b0g" }, []string{"sessions[0].slice: sd must be 6 hex digits"}},
+		{"session", func(ue *models.UeProfile) { ue.Sessions[0].Type, ue.Sessions[0].Apn = "Ethernet", "" }, []string{
+			"sessions[0].type: must be IPv4, IPv6 or IPv4v6", "sessions[0].apn: must not be empty",
+		}},
+		{"SUPI of another PLMN", func(ue *models.UeProfile) { ue.Supi = "imsi-001010000000001" }, []string{"supi: IMSI 001010000000001 is not in PLMN 208-93"}},
+		{"SUPI not an IMSI", func(ue *models.UeProfile) { ue.Supi = "nai-alice@example.com" }, []string{"supi: must be imsi- followed by digits"}},
+		{"PLMN", func(ue *models.UeProfile) { ue.PlmnId.Mnc = "9" }, []string{"plmnid.mnc: must be 2 or 3 digits"}},
+		{"protection scheme", func(ue *models.UeProfile) { ue.ProtectionScheme = 3 }, []string{"protectionScheme: must be 0, 1 or 2"}},
+		{"home network key", func(ue *models.UeProfile) { ue.ProtectionScheme = A_SCHEME }, []string{"homeNetworkPublicKey: must be hex digits"}},
+		{"every invalid field", func(ue *models.UeProfile) { ue.Key, ue.Amf, ue.Imei = "", "", "1" }, []string{
+			"key: must be 32 hex digits", "amf: must be 4 hex digits", "imei: must be 15 digits",
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ue := validUeProfile()
+			tt.modify(ue)
+			err := ValidateUeProfile(ue)
+			var validationErr *ValidationError
+			if !errors.As(err, &validationErr) {
+				t.Fatalf("ValidateUeProfile = %v, want a *ValidationError", err)
+			}
+			if len(validationErr.Errors) != len(tt.want) {
+				t.Fatalf("errors = %q, want %q", validationErr.Errors, tt.want)
+			}
+			for i, want := range tt.want {
+				if !strings.HasPrefix(validationErr.Errors[i], want) {
+					t.Errorf("error %d = %q, want %q", i, validationErr.Errors[i], want)
+				}
+			}
+		})
+	}
+}
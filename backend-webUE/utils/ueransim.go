@@ -0,0 +1,154 @@
+package utils
+
+import (
+	"backend-webUE/models"
+	"bytes"
+	"fmt"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ueransimSd is a slice differentiator written as a hex integer (sd: 0x010203), as UERANSIM expects
+type ueransimSd string
+
+func (sd ueransimSd) MarshalYAML() (interface{}, error) {
+	return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!int", Value: "0x" + string(sd)}, nil
+}
+
+type ueransimSnssai struct {
+	Sst int        `yaml:"sst"`
+	Sd  ueransimSd `yaml:"sd,omitempty"`
+}
+
+type ueransimSession struct {
+	Type  string         `yaml:"type"`
+	Apn   string         `yaml:"apn"`
+	Slice ueransimSnssai `yaml:"slice"`
+}
+
+// ueransimUe is the layout of a UERANSIM UE configuration file (config/open5gs-ue.yaml)
+type ueransimUe struct {
+	Supi                   string `yaml:"supi"`
+	Mcc                    string `yaml:"mcc"`
+	Mnc                    string `yaml:"mnc"`
+	ProtectionScheme       int    `yaml:"protectionScheme"`
+	HomeNetworkPublicKey   string `yaml:"homeNetworkPublicKey"`
+	HomeNetworkPublicKeyId int    `yaml:"homeNetworkPublicKeyId"`
+	RoutingIndicator       string `yaml:"routingIndicator"`
+
+	Key    string `yaml:"key"`
+	Op     string `yaml:"op"`
+	OpType string `yaml:"opType"`
+	Amf    string `yaml:"amf"`
+	Imei   string `yaml:"imei"`
+	Imeisv string `yaml:"imeiSv"`
+
+	GnbSearchList []string `yaml:"gnbSearchList"`
+
+	UacAic struct {
+		Mps bool `yaml:"mps"`
+		Mcs bool `yaml:"mcs"`
+	} `yaml:"uacAic"`
+	UacAcc struct {
+		NormalClass int  `yaml:"normalClass"`
+		Class11     bool `yaml:"class11"`
+		Class12     bool `yaml:"class12"`
+		Class13     bool `yaml:"class13"`
+		Class14     bool `yaml:"class14"`
+		Class15     bool `yaml:"class15"`
+	} `yaml:"uacAcc"`
+
+	Sessions        []ueransimSession `yaml:"sessions"`
+	ConfiguredNssai []ueransimSnssai  `yaml:"configured-nssai"`
+	DefaultNssai    []ueransimSnssai  `yaml:"default-nssai"`
+
+	Integrity struct {
+		IA1 bool `yaml:"IA1"`
+		IA2 bool `yaml:"IA2"`
+		IA3 bool `yaml:"IA3"`
+	} `yaml:"integrity"`
+	Ciphering struct {
+		EA1 bool `yaml:"EA1"`
+		EA2 bool `yaml:"EA2"`
+		EA3 bool `yaml:"EA3"`
+	} `yaml:"ciphering"`
+	IntegrityMaxRate struct {
+		Uplink   string `yaml:"uplink"`
+		Downlink string `yaml:"downlink"`
+	} `yaml:"integrityMaxRate"`
+}
+
+func ueransimNssai(nssai []models.Snssai) []ueransimSnssai {
+	out := make([]ueransimSnssai, 0, len(nssai))
+	for _, snssai := range nssai {
+		out = append(out, ueransimSnssai{Sst: snssai.Sst, Sd: ueransimSd(strings.TrimPrefix(snssai.Sd, "0x"))})
+	}
+	return out
+}
+
+// UeransimConfig renders a UE profile as a UERANSIM ue.yaml
+func UeransimConfig(ue *models.UeProfile) ([]byte, error) {
+	c := ueransimUe{
+		Supi:                   ue.Supi,
+		Mcc:                    ue.PlmnId.Mcc,
+		Mnc:                    ue.PlmnId.Mnc,
+		ProtectionScheme:       ue.ProtectionScheme,
+		HomeNetworkPublicKey:   ue.HomeNetworkPublicKey,
+		HomeNetworkPublicKeyId: ue.HomeNetworkPublicKeyId,
+		RoutingIndicator:       ue.RoutingIndicator,
+		Key:                    ue.Key,
+		Op:                     ue.Op,
+		OpType:                 ue.OpType,
+		Amf:                    ue.Amf,
+		Imei:                   ue.Imei,
+		Imeisv:                 ue.Imeisv,
+		GnbSearchList:          ue.GnbSearchList,
+		ConfiguredNssai:        ueransimNssai(ue.ConfiguredSlice),
+		DefaultNssai:           ueransimNssai(ue.DefaultSlice),
+	}
+	// UERANSIM takes OPc in the op field when opType is OPC
+	if ue.OpType == OPC && ue.Opc != "" {
+		c.Op = ue.Opc
+	}
+	if c.HomeNetworkPublicKey == "" {
+		// Null scheme, UERANSIM still requires a 32 byte key
+		c.HomeNetworkPublicKey = strings.Repeat("0", 64)
+	}
+	if c.GnbSearchList == nil {
+		c.GnbSearchList = []string{}
+	}
+	if c.RoutingIndicator == "" {
+		c.RoutingIndicator = DefaultRoutingIndicator
+	}
+
+	c.UacAic.Mps, c.UacAic.Mcs = ue.UacAic.Mps, ue.UacAic.Mcs
+	c.UacAcc.NormalClass = ue.UacAcc.NormalClass
+	c.UacAcc.Class11, c.UacAcc.Class12, c.UacAcc.Class13 = ue.UacAcc.Class11, ue.UacAcc.Class12, ue.UacAcc.Class13
+	c.UacAcc.Class14, c.UacAcc.Class15 = ue.UacAcc.Class14, ue.UacAcc.Class15
+
+	c.Sessions = make([]ueransimSession, 0, len(ue.Sessions))
+	for _, session := range ue.Sessions {
+		c.Sessions = append(c.Sessions, ueransimSession{
+			Type:  session.Type,
+			Apn:   session.Apn,
+			Slice: ueransimNssai([]models.Snssai{session.Slice})[0],
+		})
+	}
+
+	c.Integrity.IA1, c.Integrity.IA2, c.Integrity.IA3 = ue.Integrity.IA1, ue.Integrity.IA2, ue.Integrity.IA3
+	c.Ciphering.EA1, c.Ciphering.EA2, c.Ciphering.EA3 = ue.Ciphering.EA1, ue.Ciphering.EA2, ue.Ciphering.EA3
+	c.IntegrityMaxRate.Uplink = ue.IntegrityMaxRate.Uplink
+	c.IntegrityMaxRate.Downlink = ue.IntegrityMaxRate.Downlink
+
+	var out bytes.Buffer
+	encoder := yaml.NewEncoder(&out)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(&c); err != nil {
+		return nil, fmt.Errorf("failed to render UERANSIM config: %v", err)
+	}
+	if err := encoder.Close(); err != nil {
+		return nil, fmt.Errorf("failed to render UERANSIM config: %v", err)
+	}
+	return out.Bytes(), nil
+}
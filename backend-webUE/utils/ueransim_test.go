@@ -0,0 +1,104 @@
+package utils
+
+import (
+	"backend-webUE/models"
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files of testdata")
+
+// checkGolden compares got with testdata/name, rewriting the file with -update
+func checkGolden(t *testing.T, name string, got []byte) {
+	t.Helper()
+	path := filepath.Join("testdata", name)
+	if *update {
+		if err := os.WriteFile(path, got, 0o644); err != nil {
+			t.Fatalf("update golden file: %v", err)
+		}
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("read golden file: %v", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("%s differs from the golden file:\n%s\nwant:\n%s", name, got, want)
+	}
+}
+
+// exportTestUe is a UE profile of PLMN 208-93 protected with profile A, as the exporters render it
+func exportTestUe() *models.UeProfile {
+	return &models.UeProfile{
+		Supi:                   "imsi-208930000000001",
+		Suci:                   "suci-0-208-93-0012-1-1-0000000001",
+		PlmnId:                 models.PlmnId{Mcc: "208", Mnc: "93"},
+		RoutingIndicator:       "0012",
+		ProtectionScheme:       A_SCHEME,
+		HomeNetworkPublicKey:   profileAPublicKey,
+		HomeNetworkPublicKeyId: 1,
+		Key:                    "465b5ce8b199b49faa5f0a2ee238a6bc",
+		Op:                     "cdc202d5123e20f62b6d676ac72cb318",
+		OpType:                 OPC,
+		Opc:                    "cd63cb71954a9f4e48a5994e37a02baf",
+		Amf:                    "8000",
+		Sqn:                    "000000000020",
+		AuthenticationMethod:   AUTH_5G_AKA,
+		Imei:                   "356938035643809",
+		Imeisv:                 "4370816125816151",
+		GnbSearchList:          []string{"10.100.200.1"},
+		UacAcc:                 models.UacAcc{NormalClass: 3},
+		Integrity:              models.Integrity{IA1: true, IA2: true, IA3: true},
+		Ciphering:              models.Ciphering{EA1: true, EA2: true},
+		IntegrityMaxRate:       models.IntegrityMaxRate{Uplink: "full", Downlink: "64kbps"},
+		DefaultSlice:           []models.Snssai{{Sst: 1, Sd: "010203"}},
+		ConfiguredSlice:        []models.Snssai{{Sst: 1, Sd: "010203"}, {Sst: 2}},
+		Sessions: []models.Sessions{
+			{Type: "IPv4", Apn: "internet", Slice: models.Snssai{Sst: 1, Sd: "0x010203"}},
+			{Type: "IPv4v6", Apn: "ims", Slice: models.Snssai{Sst: 2}},
+		},
+	}
+}
+
+func TestUeransimConfig(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(ue *models.UeProfile)
+		golden string
+	}{
+		{"OPc and profile A", func(ue *models.UeProfile) {}, "ueransim-opc.yaml"},
+		{"OP and null scheme", func(ue *models.UeProfile) {
+			ue.OpType, ue.ProtectionScheme, ue.HomeNetworkPublicKey, ue.HomeNetworkPublicKeyId = OP, NULL_SCHEME, "", 0
+			ue.RoutingIndicator, ue.GnbSearchList, ue.Sessions = "", nil, nil
+		}, "ueransim-op.yaml"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ue := exportTestUe()
+			tt.modify(ue)
+			out, err := UeransimConfig(ue)
+			if err != nil {
+				t.Fatalf("UeransimConfig: %v", err)
+			}
+			checkGolden(t, tt.golden, out)
+
+			// UERANSIM reads the SD as an integer
+			var parsed struct {
+				DefaultNssai []struct {
+					Sst int `yaml:"sst"`
+					Sd  int `yaml:"sd"`
+				} `yaml:"default-nssai"`
+			}
+			if err := yaml.Unmarshal(out, &parsed); err != nil {
+				t.Fatalf("parse config: %v", err)
+			}
+			if len(parsed.DefaultNssai) != 1 || parsed.DefaultNssai[0].Sd != 0x010203 {
+				t.Errorf("default NSSAI = %+v, want SD 0x010203", parsed.DefaultNssai)
+			}
+		})
+	}
+}